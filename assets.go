@@ -0,0 +1,24 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+)
+
+// The web UI and the static files it links to, built into the binary
+//
+//go:embed ui/app views/static
+var embeddedAssets embed.FS
+
+// uiAssets returns where to serve the web UI from: the assets built into the
+// binary, or assetDir when it is set. It must be laid out the same way, with
+// ui/app and views/static in it, so a patched or branded copy of the UI can
+// be deployed without rebuilding Sidecar.
+func uiAssets(assetDir string) fs.FS {
+	if assetDir != "" {
+		return os.DirFS(assetDir)
+	}
+
+	return embeddedAssets
+}
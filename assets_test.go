@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_uiAssets(t *testing.T) {
+	Convey("uiAssets()", t, func() {
+		Convey("has the UI built in", func() {
+			assets := uiAssets("")
+
+			_, err := fs.Stat(assets, "ui/app/index.html")
+			So(err, ShouldBeNil)
+			_, err = fs.Stat(assets, "views/static/Sidecar.png")
+			So(err, ShouldBeNil)
+		})
+
+		Convey("serves it from a directory when one is given", func() {
+			assetDir, err := ioutil.TempDir("", "sidecar-ui")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(assetDir)
+
+			So(os.MkdirAll(filepath.Join(assetDir, "ui", "app"), 0755), ShouldBeNil)
+			index := []byte("<html>Branded</html>")
+			So(ioutil.WriteFile(filepath.Join(assetDir, "ui", "app", "index.html"), index, 0644), ShouldBeNil)
+
+			contents, err := fs.ReadFile(uiAssets(assetDir), "ui/app/index.html")
+			So(err, ShouldBeNil)
+			So(contents, ShouldResemble, index)
+		})
+	})
+}
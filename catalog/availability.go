@@ -0,0 +1,209 @@
+package catalog
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	"github.com/armon/go-metrics"
+	"github.com/relistan/go-director"
+)
+
+const (
+	AVAILABILITY_INTERVAL = 15 * time.Second   // How often we sample service health
+	AVAILABILITY_BUCKET   = 5 * time.Minute    // Samples are summed into buckets this long
+	AVAILABILITY_HISTORY  = 7 * 24 * time.Hour // How far back we keep buckets
+)
+
+// The rolling windows we report availability over, by name
+var AvailabilityWindows = map[string]time.Duration{
+	"1h":  1 * time.Hour,
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+}
+
+// An availabilityBucket sums the instance samples taken during one bucket
+type availabilityBucket struct {
+	Start   time.Time
+	Healthy int
+	Total   int
+}
+
+// An AvailabilityTracker keeps the health samples of each service over the
+// last AVAILABILITY_HISTORY, to report what fraction of them were healthy.
+// Each sample counts the instances of a service that were alive and those
+// that should have been. Instances that are draining or in maintenance were
+// taken out on purpose and don't count against it.
+type AvailabilityTracker struct {
+	buckets map[ServiceName][]availabilityBucket
+	sync.RWMutex
+}
+
+func NewAvailabilityTracker() *AvailabilityTracker {
+	return &AvailabilityTracker{
+		buckets: make(map[ServiceName][]availabilityBucket),
+	}
+}
+
+// Record adds a sample for the named service, and drops expired buckets
+func (t *AvailabilityTracker) Record(name ServiceName, healthy int, total int, now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+
+	buckets := t.buckets[name]
+
+	start := now.Truncate(AVAILABILITY_BUCKET)
+	if len(buckets) == 0 || buckets[len(buckets)-1].Start.Before(start) {
+		buckets = append(buckets, availabilityBucket{Start: start})
+	}
+
+	buckets[len(buckets)-1].Healthy += healthy
+	buckets[len(buckets)-1].Total += total
+
+	t.buckets[name] = expireBuckets(buckets, now)
+}
+
+// Prune drops the expired buckets of every service, and forgets the services
+// that have none left, which we haven't sampled in AVAILABILITY_HISTORY
+func (t *AvailabilityTracker) Prune(now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+
+	for name, buckets := range t.buckets {
+		buckets = expireBuckets(buckets, now)
+		if len(buckets) == 0 {
+			delete(t.buckets, name)
+			continue
+		}
+		t.buckets[name] = buckets
+	}
+}
+
+// expireBuckets returns the buckets that are still inside the history
+func expireBuckets(buckets []availabilityBucket, now time.Time) []availabilityBucket {
+	cutoff := now.Add(-AVAILABILITY_HISTORY)
+	for len(buckets) > 0 && !buckets[0].Start.After(cutoff) {
+		buckets = buckets[1:]
+	}
+
+	return buckets
+}
+
+// Availability returns the percentage of healthy samples of the named
+// service over the window, and false if there were none.
+func (t *AvailabilityTracker) Availability(name ServiceName, window time.Duration, now time.Time) (float64, bool) {
+	t.RLock()
+	defer t.RUnlock()
+
+	var healthy, total int
+	cutoff := now.Add(-window)
+	for _, bucket := range t.buckets[name] {
+		if bucket.Start.Before(cutoff) {
+			continue
+		}
+		healthy += bucket.Healthy
+		total += bucket.Total
+	}
+
+	if total == 0 {
+		return 0, false
+	}
+
+	return 100 * float64(healthy) / float64(total), true
+}
+
+// Services returns the names of the services we have samples for
+func (t *AvailabilityTracker) Services() []ServiceName {
+	t.RLock()
+	defer t.RUnlock()
+
+	var names []ServiceName
+	for name := range t.buckets {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// SampleAvailability records the health of every service in the catalog. A
+// service with no live instances left, only tombstones or ones that haven't
+// been checked yet, is sampled as a single unhealthy instance, so that
+// losing all of them counts against it.
+func (state *ServicesState) SampleAvailability(now time.Time) {
+	healthy := make(map[ServiceName]int)
+	total := make(map[ServiceName]int)
+	deliberate := make(map[ServiceName]bool)
+
+	state.RLock()
+	state.EachService(func(hostname *string, id *string, svc *service.Service) {
+		name := ServiceName{Namespace: svc.Namespace, Name: svc.Name}
+		if _, ok := total[name]; !ok {
+			total[name] = 0
+		}
+
+		switch svc.Status {
+		case service.ALIVE:
+			healthy[name]++
+			total[name]++
+		case service.UNHEALTHY:
+			total[name]++
+		case service.DRAINING, service.MAINTENANCE:
+			deliberate[name] = true
+		}
+	})
+	state.RUnlock()
+
+	for name, count := range total {
+		if count == 0 {
+			// Taken out on purpose, not down
+			if deliberate[name] {
+				continue
+			}
+			count = 1
+		}
+		state.availability.Record(name, healthy[name], count, now)
+	}
+
+	state.availability.Prune(now)
+}
+
+// Availability returns the percentage of healthy samples of the named
+// service in a namespace over each of the AvailabilityWindows that has any.
+func (state *ServicesState) Availability(namespace string, name string) map[string]float64 {
+	now := time.Now().UTC()
+	result := make(map[string]float64, len(AvailabilityWindows))
+
+	for window, duration := range AvailabilityWindows {
+		serviceName := ServiceName{Namespace: namespace, Name: name}
+		if percent, ok := state.availability.Availability(serviceName, duration, now); ok {
+			result[window] = percent
+		}
+	}
+
+	return result
+}
+
+// AvailabilityServices returns the names of the services with availability
+func (state *ServicesState) AvailabilityServices() []ServiceName {
+	return state.availability.Services()
+}
+
+// TrackAvailability runs in the background sampling the health of every
+// service and reporting their availability as metrics.
+func (state *ServicesState) TrackAvailability(looper director.Looper) {
+	looper.Loop(func() error {
+		state.SampleAvailability(time.Now().UTC())
+
+		for _, name := range state.AvailabilityServices() {
+			for window, percent := range state.Availability(name.Namespace, name.Name) {
+				key := []string{"availability", name.Name, window}
+				if name.Namespace != "" {
+					key = []string{"availability", name.Namespace, name.Name, window}
+				}
+				metrics.SetGauge(key, float32(percent))
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,110 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_AvailabilityTracker(t *testing.T) {
+	Convey("AvailabilityTracker", t, func() {
+		tracker := NewAvailabilityTracker()
+		now := time.Now().UTC().Truncate(AVAILABILITY_BUCKET)
+		bocaccio := ServiceName{Name: "bocaccio"}
+
+		Convey("reports the percentage of healthy samples", func() {
+			tracker.Record(bocaccio, 2, 2, now)
+			tracker.Record(bocaccio, 1, 2, now.Add(time.Minute))
+
+			percent, ok := tracker.Availability(bocaccio, time.Hour, now.Add(time.Minute))
+			So(ok, ShouldBeTrue)
+			So(percent, ShouldEqual, 75)
+		})
+
+		Convey("only counts the samples in the window", func() {
+			tracker.Record(bocaccio, 0, 1, now.Add(-2*time.Hour))
+			tracker.Record(bocaccio, 1, 1, now)
+
+			percent, _ := tracker.Availability(bocaccio, time.Hour, now)
+			So(percent, ShouldEqual, 100)
+
+			percent, _ = tracker.Availability(bocaccio, 24*time.Hour, now)
+			So(percent, ShouldEqual, 50)
+		})
+
+		Convey("drops samples older than the history", func() {
+			tracker.Record(bocaccio, 0, 1, now.Add(-AVAILABILITY_HISTORY))
+			tracker.Record(bocaccio, 1, 1, now)
+
+			So(len(tracker.buckets[bocaccio]), ShouldEqual, 1)
+		})
+
+		Convey("prunes the services it hasn't sampled in the history", func() {
+			chaucer := ServiceName{Name: "chaucer"}
+			tracker.Record(chaucer, 1, 1, now.Add(-AVAILABILITY_HISTORY))
+			tracker.Record(bocaccio, 1, 1, now)
+
+			tracker.Prune(now)
+			So(tracker.Services(), ShouldResemble, []ServiceName{bocaccio})
+		})
+
+		Convey("reports nothing for unknown services", func() {
+			_, ok := tracker.Availability(ServiceName{Name: "chaucer"}, time.Hour, now)
+			So(ok, ShouldBeFalse)
+		})
+	})
+
+	Convey("The state samples availability", t, func() {
+		state := NewServicesState()
+		state.Broadcasts = make(chan [][]byte, 10)
+		now := time.Now().UTC()
+
+		for id, status := range map[string]int{
+			"1": service.ALIVE, "2": service.UNHEALTHY, "3": service.DRAINING, "4": service.TOMBSTONE,
+		} {
+			state.AddServiceEntry(service.Service{
+				ID: id, Name: "bocaccio", Hostname: anotherHostname, Status: status, Updated: now,
+			})
+		}
+
+		state.AddServiceEntry(service.Service{
+			ID: "5", Name: "bocaccio", Namespace: "staging", Hostname: anotherHostname,
+			Status: service.ALIVE, Updated: now,
+		})
+
+		state.SampleAvailability(now)
+
+		availability := state.Availability("", "bocaccio")
+		So(len(availability), ShouldEqual, len(AvailabilityWindows))
+		So(availability["1h"], ShouldEqual, 50)
+		So(state.Availability("staging", "bocaccio")["1h"], ShouldEqual, 100)
+		So(state.AvailabilityServices(), ShouldContain, ServiceName{Namespace: "staging", Name: "bocaccio"})
+		So(len(state.AvailabilityServices()), ShouldEqual, 2)
+
+		Convey("counts a service with no live instances as down", func() {
+			for _, id := range []string{"1", "2", "3"} {
+				state.AddServiceEntry(service.Service{
+					ID: id, Name: "bocaccio", Hostname: anotherHostname,
+					Status: service.TOMBSTONE, Updated: now.Add(time.Second),
+				})
+			}
+
+			state.SampleAvailability(now.Add(time.Second))
+			So(state.Availability("", "bocaccio")["1h"], ShouldAlmostEqual, 100.0/3)
+		})
+
+		Convey("doesn't count one that was taken out on purpose", func() {
+			for _, id := range []string{"1", "2"} {
+				state.AddServiceEntry(service.Service{
+					ID: id, Name: "bocaccio", Hostname: anotherHostname,
+					Status: service.TOMBSTONE, Updated: now.Add(time.Second),
+				})
+			}
+
+			state.SampleAvailability(now.Add(time.Second))
+			So(state.Availability("", "bocaccio")["1h"], ShouldEqual, 50)
+		})
+	})
+}
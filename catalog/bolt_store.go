@@ -0,0 +1,101 @@
+package catalog
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	BOLT_OPEN_TIMEOUT = 5 * time.Second // Don't wait forever on another process's lock
+)
+
+var boltServicesBucket = []byte("services")
+
+// A BoltStore is a Store that keeps services in a BoltDB file on local disk.
+// Each service is one record, keyed by hostname and ID, so a write only
+// touches the record that changed.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens, or creates, the BoltDB file at path
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: BOLT_OPEN_TIMEOUT})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open state store %s: %s", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltServicesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to create bucket in state store %s: %s", path, err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func boltKey(hostname string, id string) []byte {
+	return []byte(hostname + "/" + id)
+}
+
+// Put stores the service, replacing any earlier copy of it
+func (s *BoltStore) Put(svc *service.Service) error {
+	data, err := svc.Encode()
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltServicesBucket).Put(boltKey(svc.Hostname, svc.ID), data)
+	})
+}
+
+// Get returns the stored copy of a service, or nil if there isn't one
+func (s *BoltStore) Get(hostname string, id string) (*service.Service, error) {
+	var svc *service.Service
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltServicesBucket).Get(boltKey(hostname, id))
+		if data == nil {
+			return nil
+		}
+
+		var err error
+		svc, err = service.Decode(data)
+		return err
+	})
+
+	return svc, err
+}
+
+// Delete removes the service. Deleting one that isn't there is not an error.
+func (s *BoltStore) Delete(hostname string, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltServicesBucket).Delete(boltKey(hostname, id))
+	})
+}
+
+// Each calls fn with every stored service. Records that can't be decoded
+// are skipped.
+func (s *BoltStore) Each(fn func(svc *service.Service)) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltServicesBucket).ForEach(func(key []byte, data []byte) error {
+			svc, err := service.Decode(data)
+			if err != nil {
+				return nil
+			}
+
+			fn(svc)
+			return nil
+		})
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,95 @@
+package catalog
+
+import (
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	"github.com/armon/go-metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// A changeBucket is the token bucket for the changes from one host
+type changeBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// SetChangeLimit caps how fast we apply, and so pass on, changes to the
+// services of any one other host: new services and status changes. It is a
+// token bucket filling at perSecond, holding up to burst changes. Changes
+// over the limit are dropped, so that one flapping node can't drive churn
+// through the whole cluster. Since hosts keep re-announcing their services,
+// we catch up on them once the host calms down. Zero means no limit.
+func (state *ServicesState) SetChangeLimit(perSecond float64, burst int) {
+	state.Lock()
+	defer state.Unlock()
+
+	state.maxChangesPerSecond = perSecond
+	state.maxChangeBurst = burst
+	state.changeBuckets = make(map[string]*changeBucket)
+}
+
+// isChange reports whether a service record would change the catalog,
+// rather than just refresh what we already have. Not synchronized!
+func (state *ServicesState) isChange(newSvc *service.Service) bool {
+	if !state.HasServer(newSvc.Hostname) || !state.Servers[newSvc.Hostname].HasService(newSvc.ID) {
+		return true
+	}
+
+	oldEntry := state.Servers[newSvc.Hostname].Services[newSvc.ID]
+	if !newSvc.Invalidates(oldEntry) {
+		return false
+	}
+
+	// We keep draining services draining, see AddServiceEntry()
+	if oldEntry.Status == service.DRAINING && newSvc.Status == service.ALIVE {
+		return false
+	}
+
+	return oldEntry.Status != newSvc.Status
+}
+
+// throttleChange decides whether a service record must be dropped because
+// its host is changing its services too fast. Our own services, tombstones,
+// and refreshes are always let through. We complain the first time a host
+// is throttled, and again if it calms down and then starts up again. Not
+// synchronized!
+func (state *ServicesState) throttleChange(newSvc *service.Service, now time.Time) bool {
+	if state.maxChangesPerSecond <= 0 || newSvc.Hostname == state.Hostname || newSvc.IsTombstone() {
+		return false
+	}
+
+	if !state.isChange(newSvc) {
+		return false
+	}
+
+	bucket, ok := state.changeBuckets[newSvc.Hostname]
+	if !ok {
+		bucket = &changeBucket{tokens: float64(state.maxChangeBurst), last: now}
+		state.changeBuckets[newSvc.Hostname] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * state.maxChangesPerSecond
+	if bucket.tokens > float64(state.maxChangeBurst) {
+		bucket.tokens = float64(state.maxChangeBurst)
+	}
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		delete(state.throttledHosts, newSvc.Hostname)
+		return false
+	}
+
+	metrics.IncrCounter([]string{"services_state", "throttled_changes"}, 1)
+
+	if !state.throttledHosts[newSvc.Hostname] {
+		log.Warnf(
+			"Host %s is changing its services faster than %g per second! Dropping changes, starting with %s (%s)",
+			newSvc.Hostname, state.maxChangesPerSecond, newSvc.Name, newSvc.ID,
+		)
+		state.throttledHosts[newSvc.Hostname] = true
+	}
+
+	return true
+}
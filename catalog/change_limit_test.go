@@ -0,0 +1,77 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ChangeLimit(t *testing.T) {
+	Convey("Limiting the rate of changes per host", t, func() {
+		state := NewServicesState()
+		state.Hostname = hostname
+		state.Broadcasts = make(chan [][]byte, 20)
+		state.SetChangeLimit(0.001, 2)
+
+		baseTime := time.Now().UTC()
+		newSvc := func(id string, host string) service.Service {
+			return service.Service{ID: id, Hostname: host, Updated: baseTime, Status: service.ALIVE}
+		}
+
+		state.AddServiceEntry(newSvc("deadbeef001", anotherHostname))
+		state.AddServiceEntry(newSvc("deadbeef002", anotherHostname))
+
+		Convey("drops changes beyond the burst", func() {
+			state.AddServiceEntry(newSvc("deadbeef003", anotherHostname))
+
+			So(state.Servers[anotherHostname].HasService("deadbeef003"), ShouldBeFalse)
+			So(state.throttledHosts[anotherHostname], ShouldBeTrue)
+
+			svc := newSvc("deadbeef001", anotherHostname)
+			svc.Status = service.UNHEALTHY
+			svc.Updated = baseTime.Add(time.Second)
+			state.AddServiceEntry(svc)
+
+			So(state.Servers[anotherHostname].Services["deadbeef001"].Status, ShouldEqual, service.ALIVE)
+		})
+
+		Convey("lets changes through again as the bucket fills", func() {
+			state.changeBuckets[anotherHostname].last = baseTime.Add(-time.Hour)
+			state.AddServiceEntry(newSvc("deadbeef003", anotherHostname))
+
+			So(state.Servers[anotherHostname].HasService("deadbeef003"), ShouldBeTrue)
+			So(state.throttledHosts[anotherHostname], ShouldBeFalse)
+		})
+
+		Convey("always accepts refreshes and tombstones", func() {
+			svc := newSvc("deadbeef001", anotherHostname)
+			svc.Updated = baseTime.Add(time.Second)
+			state.AddServiceEntry(svc)
+
+			So(state.Servers[anotherHostname].Services["deadbeef001"].Updated, ShouldEqual, svc.Updated)
+
+			svc.Status = service.TOMBSTONE
+			svc.Updated = baseTime.Add(2 * time.Second)
+			state.AddServiceEntry(svc)
+
+			So(state.Servers[anotherHostname].Services["deadbeef001"].IsTombstone(), ShouldBeTrue)
+		})
+
+		Convey("always accepts our own changes", func() {
+			for _, id := range []string{"deadbeef003", "deadbeef004", "deadbeef005"} {
+				state.AddServiceEntry(newSvc(id, hostname))
+			}
+
+			So(len(state.Servers[hostname].Services), ShouldEqual, 3)
+		})
+
+		Convey("does nothing when there is no limit", func() {
+			state.SetChangeLimit(0, 0)
+			state.AddServiceEntry(newSvc("deadbeef003", anotherHostname))
+
+			So(state.Servers[anotherHostname].HasService("deadbeef003"), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,124 @@
+package catalog
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+)
+
+const (
+	DEPARTURE_LOG_SIZE = 500 // The number of recent departures we keep
+)
+
+// A Departure records a service instance leaving the proxies' rotation, and
+// why, so that "where did my instance go" can be answered from the API.
+type Departure struct {
+	ID             string
+	Name           string
+	Image          string
+	Hostname       string
+	PreviousStatus string
+	Status         string
+	Reason         string
+	Time           time.Time
+}
+
+// A DepartureLog keeps the most recent Departures, oldest first
+type DepartureLog struct {
+	departures []Departure
+	size       int
+	sync.RWMutex
+}
+
+func NewDepartureLog(size int) *DepartureLog {
+	return &DepartureLog{
+		departures: make([]Departure, 0, size),
+		size:       size,
+	}
+}
+
+// Add records a departure, dropping the oldest one when the log is full
+func (l *DepartureLog) Add(departure Departure) {
+	if l == nil {
+		return
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if len(l.departures) >= l.size {
+		copy(l.departures, l.departures[1:])
+		l.departures = l.departures[:len(l.departures)-1]
+	}
+
+	l.departures = append(l.departures, departure)
+}
+
+// List returns the departures of the named service, or of all services if
+// name is empty, most recent first.
+func (l *DepartureLog) List(name string) []Departure {
+	result := []Departure{}
+	if l == nil {
+		return result
+	}
+
+	l.RLock()
+	defer l.RUnlock()
+
+	for i := len(l.departures) - 1; i >= 0; i-- {
+		if name == "" || l.departures[i].Name == name {
+			result = append(result, l.departures[i])
+		}
+	}
+
+	return result
+}
+
+// Departures returns the recently departed instances of the named service,
+// or of all services if name is empty. See DepartureLog.List().
+func (state *ServicesState) Departures(name string) []Departure {
+	return state.departures.List(name)
+}
+
+// recordDeparture logs an instance leaving the rotation with a reason.
+// Instances that were already out of it are only logged when they are
+// tombstoned, and new ones never are.
+func (state *ServicesState) recordDeparture(svc *service.Service, previousStatus int, reason string) {
+	if previousStatus == svc.Status || previousStatus == service.UNKNOWN {
+		return
+	}
+
+	if svc.IsAlive() || (previousStatus != service.ALIVE && !svc.IsTombstone()) {
+		return
+	}
+
+	state.departures.Add(Departure{
+		ID:             svc.ID,
+		Name:           svc.Name,
+		Image:          svc.Image,
+		Hostname:       svc.Hostname,
+		PreviousStatus: service.StatusString(previousStatus),
+		Status:         svc.StatusString(),
+		Reason:         reason,
+		Time:           svc.Updated,
+	})
+}
+
+// departureReason explains a status change we heard about from the
+// instance's own host, which knows why it happened.
+func departureReason(svc *service.Service) string {
+	switch svc.Status {
+	case service.UNHEALTHY:
+		return "Failed health checks"
+	case service.DRAINING:
+		return "Draining"
+	case service.MAINTENANCE:
+		return "In maintenance"
+	case service.TOMBSTONE:
+		return fmt.Sprintf("Stopped on %s", svc.Hostname)
+	default:
+		return "Status changed"
+	}
+}
@@ -0,0 +1,122 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_DepartureLog(t *testing.T) {
+	Convey("DepartureLog", t, func() {
+		departures := NewDepartureLog(2)
+
+		Convey("lists the most recent first", func() {
+			departures.Add(Departure{ID: "1", Name: "bocaccio"})
+			departures.Add(Departure{ID: "2", Name: "chaucer"})
+
+			list := departures.List("")
+			So(len(list), ShouldEqual, 2)
+			So(list[0].ID, ShouldEqual, "2")
+			So(list[1].ID, ShouldEqual, "1")
+		})
+
+		Convey("drops the oldest when full", func() {
+			for _, id := range []string{"1", "2", "3"} {
+				departures.Add(Departure{ID: id})
+			}
+
+			list := departures.List("")
+			So(len(list), ShouldEqual, 2)
+			So(list[1].ID, ShouldEqual, "2")
+		})
+
+		Convey("filters by service name", func() {
+			departures.Add(Departure{ID: "1", Name: "bocaccio"})
+			departures.Add(Departure{ID: "2", Name: "chaucer"})
+
+			list := departures.List("bocaccio")
+			So(len(list), ShouldEqual, 1)
+			So(list[0].ID, ShouldEqual, "1")
+		})
+	})
+
+	Convey("The state records departures", t, func() {
+		state := NewServicesState()
+		state.Hostname = hostname
+		state.Broadcasts = make(chan [][]byte, 20)
+		baseTime := time.Now().UTC()
+
+		svc := service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: anotherHostname,
+			Status: service.ALIVE, Updated: baseTime,
+		}
+		state.AddServiceEntry(svc)
+
+		So(state.Departures(""), ShouldBeEmpty)
+
+		Convey("when a host reports a status change", func() {
+			svc.Status = service.DRAINING
+			svc.Updated = baseTime.Add(time.Second)
+			state.AddServiceEntry(svc)
+
+			departures := state.Departures("")
+			So(len(departures), ShouldEqual, 1)
+			So(departures[0].Status, ShouldEqual, "Draining")
+			So(departures[0].Reason, ShouldEqual, "Draining")
+
+			Convey("and again when it's tombstoned", func() {
+				svc.Status = service.TOMBSTONE
+				svc.Updated = baseTime.Add(2 * time.Second)
+				state.AddServiceEntry(svc)
+
+				departures := state.Departures("")
+				So(len(departures), ShouldEqual, 2)
+				So(departures[0].Reason, ShouldEqual, "Stopped on "+anotherHostname)
+			})
+		})
+
+		Convey("but not when it comes back", func() {
+			svc.Status = service.UNHEALTHY
+			svc.Updated = baseTime.Add(time.Second)
+			state.AddServiceEntry(svc)
+
+			svc.Status = service.ALIVE
+			svc.Updated = baseTime.Add(2 * time.Second)
+			state.AddServiceEntry(svc)
+
+			So(len(state.Departures("")), ShouldEqual, 1)
+		})
+
+		Convey("when a host leaves the cluster", func() {
+			state.ExpireServer(anotherHostname)
+
+			departures := state.Departures("")
+			So(len(departures), ShouldEqual, 1)
+			So(departures[0].Reason, ShouldEqual, "Host left the cluster")
+		})
+
+		Convey("when a service hasn't been heard from", func() {
+			state.Servers[anotherHostname].Services[svc.ID].Updated = baseTime.Add(-ALIVE_LIFESPAN - time.Second)
+			state.TombstoneOthersServices()
+
+			departures := state.Departures("")
+			So(len(departures), ShouldEqual, 1)
+			So(departures[0].Reason, ShouldStartWith, "Not heard from")
+		})
+
+		Convey("when one of our containers goes away", func() {
+			local := service.Service{
+				ID: "cafebabe456", Name: "chaucer", Hostname: hostname,
+				Status: service.ALIVE, Updated: baseTime,
+			}
+			state.AddServiceEntry(local)
+			state.TombstoneServices(hostname, []service.Service{})
+
+			departures := state.Departures("chaucer")
+			So(len(departures), ShouldEqual, 1)
+			So(departures[0].Reason, ShouldEqual, "Container stopped or no longer discovered")
+		})
+	})
+}
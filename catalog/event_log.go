@@ -0,0 +1,99 @@
+package catalog
+
+import (
+	"sync"
+)
+
+// An EventLog keeps the most recent ChangeEvents in a ring buffer, numbered
+// in sequence, so that listeners that were briefly disconnected can catch up
+// on what they missed instead of fetching the whole state again.
+type EventLog struct {
+	events   []ChangeEvent
+	size     int
+	sequence uint64 // The sequence number of the last event appended
+	sync.RWMutex
+}
+
+func NewEventLog(size int) *EventLog {
+	return &EventLog{
+		events: make([]ChangeEvent, 0, size),
+		size:   size,
+	}
+}
+
+// Append numbers an event, stores it, and returns it with its sequence
+// number. The oldest event is dropped when the log is full.
+func (l *EventLog) Append(event ChangeEvent) ChangeEvent {
+	if l == nil {
+		return event
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.sequence++
+	event.Sequence = l.sequence
+
+	if len(l.events) < l.size {
+		l.events = append(l.events, event)
+	} else {
+		l.events[(event.Sequence-1)%uint64(l.size)] = event
+	}
+
+	return event
+}
+
+// LastSequence returns the sequence number of the most recent event, or 0
+// if there haven't been any.
+func (l *EventLog) LastSequence() uint64 {
+	if l == nil {
+		return 0
+	}
+
+	l.RLock()
+	defer l.RUnlock()
+
+	return l.sequence
+}
+
+// Since returns the events after the given sequence number, oldest first,
+// and the sequence number of the last of them, read together so that no
+// event can slip in between. The last return value is false when some of
+// those events have already been dropped from the log, in which case the
+// caller has missed events and needs to fetch the whole state instead.
+func (l *EventLog) Since(sequence uint64) ([]ChangeEvent, uint64, bool) {
+	if l == nil {
+		return nil, 0, false
+	}
+
+	l.RLock()
+	defer l.RUnlock()
+
+	if sequence >= l.sequence {
+		return []ChangeEvent{}, l.sequence, sequence == l.sequence
+	}
+
+	oldest := l.sequence - uint64(len(l.events)) + 1
+	complete := sequence+1 >= oldest
+	if !complete {
+		sequence = oldest - 1
+	}
+
+	events := make([]ChangeEvent, 0, l.sequence-sequence)
+	for seq := sequence + 1; seq <= l.sequence; seq++ {
+		events = append(events, l.events[(seq-1)%uint64(l.size)])
+	}
+
+	return events, l.sequence, complete
+}
+
+// EventsSince returns the change events after the given sequence number, and
+// the sequence number of the last one. See EventLog.Since().
+func (state *ServicesState) EventsSince(sequence uint64) ([]ChangeEvent, uint64, bool) {
+	return state.eventLog.Since(sequence)
+}
+
+// LastEventSequence returns the sequence number of the most recent change
+func (state *ServicesState) LastEventSequence() uint64 {
+	return state.eventLog.LastSequence()
+}
@@ -0,0 +1,103 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_EventLog(t *testing.T) {
+	Convey("EventLog", t, func() {
+		eventLog := NewEventLog(3)
+
+		appendEvents := func(count int) {
+			for i := 0; i < count; i++ {
+				eventLog.Append(ChangeEvent{Time: time.Now().UTC()})
+			}
+		}
+
+		Convey("numbers events in sequence", func() {
+			first := eventLog.Append(ChangeEvent{})
+			second := eventLog.Append(ChangeEvent{})
+
+			So(first.Sequence, ShouldEqual, 1)
+			So(second.Sequence, ShouldEqual, 2)
+			So(eventLog.LastSequence(), ShouldEqual, 2)
+		})
+
+		Convey("returns the events since a sequence number", func() {
+			appendEvents(3)
+
+			events, last, complete := eventLog.Since(1)
+			So(complete, ShouldBeTrue)
+			So(last, ShouldEqual, 3)
+			So(len(events), ShouldEqual, 2)
+			So(events[0].Sequence, ShouldEqual, 2)
+			So(events[1].Sequence, ShouldEqual, 3)
+		})
+
+		Convey("returns nothing for a client that is caught up", func() {
+			appendEvents(2)
+
+			events, _, complete := eventLog.Since(2)
+			So(complete, ShouldBeTrue)
+			So(events, ShouldBeEmpty)
+		})
+
+		Convey("drops the oldest events when full", func() {
+			appendEvents(5)
+
+			events, _, complete := eventLog.Since(2)
+			So(complete, ShouldBeTrue)
+			So(len(events), ShouldEqual, 3)
+			So(events[0].Sequence, ShouldEqual, 3)
+			So(events[2].Sequence, ShouldEqual, 5)
+
+			Convey("and reports when a client missed some", func() {
+				events, _, complete := eventLog.Since(1)
+				So(complete, ShouldBeFalse)
+				So(len(events), ShouldEqual, 3)
+				So(events[0].Sequence, ShouldEqual, 3)
+			})
+		})
+
+		Convey("reports a client from the future as incomplete", func() {
+			appendEvents(1)
+
+			events, _, complete := eventLog.Since(10)
+			So(complete, ShouldBeFalse)
+			So(events, ShouldBeEmpty)
+		})
+	})
+
+	Convey("The state logs its change events", t, func() {
+		state := NewServicesState()
+		listener := &mockListener{name: "listener", events: make(chan ChangeEvent, 2)}
+		state.AddListener(listener)
+
+		svc := service.Service{ID: "deadbeef123", Hostname: hostname, Updated: time.Now().UTC()}
+		state.AddServiceEntry(svc)
+
+		So(state.LastEventSequence(), ShouldEqual, 1)
+
+		event := <-listener.events
+		So(event.Sequence, ShouldEqual, 1)
+
+		events, _, complete := state.EventsSince(0)
+		So(complete, ShouldBeTrue)
+		So(len(events), ShouldEqual, 1)
+		So(events[0].Service.ID, ShouldEqual, svc.ID)
+
+		Convey("and encodes the sequence number with them", func() {
+			data, err := events[0].MarshalJSON()
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEndWith, `"Sequence":1}`)
+
+			var decoded ChangeEvent
+			So(decoded.UnmarshalJSON(data), ShouldBeNil)
+			So(decoded.Sequence, ShouldEqual, 1)
+		})
+	})
+}
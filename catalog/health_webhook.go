@@ -0,0 +1,175 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+)
+
+// The kinds of health transition a HealthWebhook reports
+const (
+	HEALTH_EVENT_UNHEALTHY  = "unhealthy"
+	HEALTH_EVENT_RECOVERED  = "recovered"
+	HEALTH_EVENT_TOMBSTONED = "tombstoned"
+)
+
+// A HealthWebhook is a Listener that POSTs to a URL only when a service
+// instance changes health: when it fails its checks, when it recovers, and
+// when it is tombstoned. Unlike the UrlListener, it doesn't send the whole
+// state, just what happened, which suits incident automation.
+type HealthWebhook struct {
+	Url          string
+	Retries      int
+	Client       *http.Client
+	LocalOnly    bool // Only report on services running on this host
+	looper       director.Looper
+	eventChannel chan ChangeEvent
+	name         string
+}
+
+// A HealthTransition is the payload sent by a HealthWebhook
+type HealthTransition struct {
+	Event          string
+	Service        service.Service
+	PreviousStatus string
+	Status         string
+	Time           time.Time
+	ClusterName    string
+	ReportedBy     string // The Sidecar host sending the webhook
+	AliveInstances int    // How many instances of the service are still alive, in its namespace
+	TotalInstances int    // How many instances of the service aren't tombstoned, in its namespace
+}
+
+func NewHealthWebhook(url string) *HealthWebhook {
+	errorChan := make(chan error, 1)
+
+	return &HealthWebhook{
+		Url:          url,
+		Retries:      DefaultRetries,
+		Client:       &http.Client{Timeout: ClientTimeout},
+		looper:       director.NewFreeLooper(director.FOREVER, errorChan),
+		eventChannel: make(chan ChangeEvent, LISTENER_EVENT_BUFFER_SIZE),
+		name:         "HealthWebhook(" + url + ")",
+	}
+}
+
+func (h *HealthWebhook) Name() string {
+	return h.name
+}
+
+func (h *HealthWebhook) Chan() chan ChangeEvent {
+	return h.eventChannel
+}
+
+func (h *HealthWebhook) Managed() bool {
+	return false
+}
+
+func (h *HealthWebhook) Stop() {
+	h.looper.Quit()
+}
+
+// healthEvent classifies a change event, returning an empty string for the
+// ones that aren't health transitions.
+func healthEvent(event *ChangeEvent) string {
+	previous, current := event.PreviousStatus, event.Service.Status
+
+	switch {
+	case previous == current || previous == service.UNKNOWN:
+		return ""
+	case current == service.TOMBSTONE:
+		return HEALTH_EVENT_TOMBSTONED
+	case previous == service.ALIVE && current == service.UNHEALTHY:
+		return HEALTH_EVENT_UNHEALTHY
+	case previous == service.UNHEALTHY && current == service.ALIVE:
+		return HEALTH_EVENT_RECOVERED
+	default:
+		return ""
+	}
+}
+
+// transitionFor builds the payload for a health transition
+func (h *HealthWebhook) transitionFor(state *ServicesState, event *ChangeEvent, kind string) *HealthTransition {
+	state.RLock()
+	defer state.RUnlock()
+
+	transition := &HealthTransition{
+		Event:          kind,
+		Service:        event.Service,
+		PreviousStatus: service.StatusString(event.PreviousStatus),
+		Status:         event.Service.StatusString(),
+		Time:           event.Time,
+		ClusterName:    state.ClusterName,
+		ReportedBy:     state.Hostname,
+	}
+
+	state.EachService(func(hostname *string, id *string, svc *service.Service) {
+		if svc.Name != event.Service.Name || svc.Namespace != event.Service.Namespace || svc.IsTombstone() {
+			return
+		}
+
+		transition.TotalInstances++
+		if svc.IsAlive() {
+			transition.AliveInstances++
+		}
+	})
+
+	return transition
+}
+
+// Watch registers the webhook with the state and starts sending it health
+// transitions in the background.
+func (h *HealthWebhook) Watch(state *ServicesState) {
+	state.AddListener(h)
+
+	go func() {
+		h.looper.Loop(func() error {
+			event := <-h.eventChannel
+
+			kind := healthEvent(&event)
+			if kind == "" {
+				return nil
+			}
+
+			if h.LocalOnly && event.Service.Hostname != state.Hostname {
+				return nil
+			}
+
+			h.send(h.transitionFor(state, &event, kind))
+			return nil
+		})
+	}()
+}
+
+// send POSTs the transition to the webhook, retrying on failure
+func (h *HealthWebhook) send(transition *HealthTransition) {
+	data, err := json.Marshal(transition)
+	if err != nil {
+		log.Warnf("Skipping post to '%s' because of bad encoding! (%s)", h.Url, err)
+		return
+	}
+
+	err = withRetries(h.Retries, func() error {
+		resp, err := h.Client.Post(h.Url, "application/json", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode > 299 || resp.StatusCode < 200 {
+			return fmt.Errorf("Bad status code returned (%d)", resp.StatusCode)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.Warnf("Failed posting health transition to '%s' %s: %s", h.Url, h.Name(), err)
+	}
+}
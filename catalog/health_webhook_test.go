@@ -0,0 +1,122 @@
+package catalog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_HealthWebhook(t *testing.T) {
+	Convey("healthEvent() classifies change events", t, func() {
+		event := func(previous int, current int) *ChangeEvent {
+			return &ChangeEvent{PreviousStatus: previous, Service: service.Service{Status: current}}
+		}
+
+		So(healthEvent(event(service.ALIVE, service.UNHEALTHY)), ShouldEqual, HEALTH_EVENT_UNHEALTHY)
+		So(healthEvent(event(service.UNHEALTHY, service.ALIVE)), ShouldEqual, HEALTH_EVENT_RECOVERED)
+		So(healthEvent(event(service.ALIVE, service.TOMBSTONE)), ShouldEqual, HEALTH_EVENT_TOMBSTONED)
+		So(healthEvent(event(service.DRAINING, service.TOMBSTONE)), ShouldEqual, HEALTH_EVENT_TOMBSTONED)
+
+		// Not health transitions
+		So(healthEvent(event(service.UNKNOWN, service.ALIVE)), ShouldBeEmpty)
+		So(healthEvent(event(service.ALIVE, service.ALIVE)), ShouldBeEmpty)
+		So(healthEvent(event(service.ALIVE, service.DRAINING)), ShouldBeEmpty)
+	})
+
+	Convey("A HealthWebhook", t, func() {
+		received := make(chan HealthTransition, 5)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var transition HealthTransition
+			json.NewDecoder(r.Body).Decode(&transition)
+			received <- transition
+		}))
+		Reset(server.Close)
+
+		state := NewServicesState()
+		state.Hostname = hostname
+		state.ClusterName = "default"
+		state.Broadcasts = make(chan [][]byte, 10)
+
+		baseTime := time.Now().UTC()
+		svc := service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: anotherHostname,
+			Status: service.ALIVE, Updated: baseTime,
+		}
+		state.AddServiceEntry(svc)
+
+		webhook := NewHealthWebhook(server.URL)
+		webhook.Watch(state)
+		Reset(webhook.Stop)
+
+		Convey("posts health transitions with the instance counts", func() {
+			svc.Status = service.UNHEALTHY
+			svc.Updated = baseTime.Add(time.Second)
+			state.AddServiceEntry(svc)
+
+			transition := <-received
+			So(transition.Event, ShouldEqual, HEALTH_EVENT_UNHEALTHY)
+			So(transition.Service.ID, ShouldEqual, svc.ID)
+			So(transition.PreviousStatus, ShouldEqual, "Alive")
+			So(transition.Status, ShouldEqual, "Unhealthy")
+			So(transition.ReportedBy, ShouldEqual, hostname)
+			So(transition.ClusterName, ShouldEqual, "default")
+			So(transition.AliveInstances, ShouldEqual, 0)
+			So(transition.TotalInstances, ShouldEqual, 1)
+		})
+
+		Convey("only counts the instances in the service's namespace", func() {
+			staging := service.Service{
+				ID: "cafebabe456", Name: "bocaccio", Hostname: anotherHostname,
+				Status: service.ALIVE, Updated: baseTime, Namespace: "staging",
+			}
+			state.AddServiceEntry(staging)
+
+			svc.Status = service.UNHEALTHY
+			svc.Updated = baseTime.Add(time.Second)
+			state.AddServiceEntry(svc)
+
+			transition := <-received
+			So(transition.Service.ID, ShouldEqual, svc.ID)
+			So(transition.AliveInstances, ShouldEqual, 0)
+			So(transition.TotalInstances, ShouldEqual, 1)
+		})
+
+		Convey("doesn't post other changes", func() {
+			svc.Status = service.DRAINING
+			svc.Updated = baseTime.Add(time.Second)
+			state.AddServiceEntry(svc)
+
+			svc.Status = service.TOMBSTONE
+			svc.Updated = baseTime.Add(2 * time.Second)
+			state.AddServiceEntry(svc)
+
+			transition := <-received
+			So(transition.Event, ShouldEqual, HEALTH_EVENT_TOMBSTONED)
+			So(transition.PreviousStatus, ShouldEqual, "Draining")
+		})
+
+		Convey("only posts our own services when LocalOnly is set", func() {
+			webhook.LocalOnly = true
+
+			svc.Status = service.UNHEALTHY
+			svc.Updated = baseTime.Add(time.Second)
+			state.AddServiceEntry(svc)
+
+			local := service.Service{
+				ID: "cafebabe456", Name: "chaucer", Hostname: hostname,
+				Status: service.ALIVE, Updated: baseTime,
+			}
+			state.AddServiceEntry(local)
+			state.TombstoneServices(hostname, []service.Service{})
+
+			transition := <-received
+			So(transition.Service.ID, ShouldEqual, local.ID)
+			So(transition.Event, ShouldEqual, HEALTH_EVENT_TOMBSTONED)
+		})
+	})
+}
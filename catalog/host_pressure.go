@@ -0,0 +1,101 @@
+package catalog
+
+import (
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+)
+
+// SetPressureThreshold turns on pressure routing. Instances on hosts whose
+// pressure, as reported with SetHostPressure(), is above the threshold get a
+// lower weight in the proxies, in proportion to how far over it they are. So
+// with a threshold of 0.8, an instance on a host at 1.6 gets half the traffic
+// of one on a host that isn't overloaded. Zero turns it off.
+func (state *ServicesState) SetPressureThreshold(threshold float64) {
+	state.Lock()
+	defer state.Unlock()
+
+	state.pressureThreshold = threshold
+}
+
+// SetHostPressure records how loaded each host is, from 0 for idle, through 1
+// for fully loaded, and up. When that changes the weight of any host's
+// instances, we tell our listeners so that the proxies pick it up.
+func (state *ServicesState) SetHostPressure(pressure map[string]float64) {
+	state.Lock()
+	defer state.Unlock()
+
+	previous := state.hostPressure
+	state.hostPressure = pressure
+
+	if state.pressureThreshold <= 0 {
+		return
+	}
+
+	// Find one service on each host whose weight changed
+	changed := make(map[string]bool)
+	for hostname := range pressure {
+		if state.pressureFactor(previous[hostname]) != state.pressureFactor(pressure[hostname]) {
+			changed[hostname] = true
+		}
+	}
+	for hostname := range previous {
+		if _, ok := pressure[hostname]; !ok && state.pressureFactor(previous[hostname]) != 1 {
+			changed[hostname] = true
+		}
+	}
+
+	if len(changed) < 1 {
+		return
+	}
+
+	state.LastChanged = time.Now().UTC()
+	notified := make(map[ServiceName]bool)
+	state.EachService(func(hostname *string, id *string, svc *service.Service) {
+		name := ServiceName{Namespace: svc.Namespace, Name: svc.Name}
+		if changed[*hostname] && !notified[name] {
+			notified[name] = true
+			state.NotifyListeners(svc, svc.Status, state.LastChanged)
+		}
+	})
+}
+
+// HostPressure returns the last pressure reported for a host, or 0 when we
+// haven't heard. The caller must hold the state lock.
+func (state *ServicesState) HostPressure(hostname string) float64 {
+	return state.hostPressure[hostname]
+}
+
+// pressureFactor is how much to scale the weight of instances on a host with
+// the given pressure. Not synchronized!
+func (state *ServicesState) pressureFactor(pressure float64) float64 {
+	if state.pressureThreshold <= 0 || pressure <= state.pressureThreshold {
+		return 1
+	}
+
+	return state.pressureThreshold / pressure
+}
+
+// ProxyWeight returns the weight a proxy should give a single instance of a
+// service, from 0 up to maxWeight. It starts from the instance's share of the
+// traffic split, if there is one, and scales it down when the instance's host
+// is overloaded. Returns -1 when there is nothing to weight by. With pressure
+// routing on, every instance gets a weight so that they can be compared. The
+// caller must hold the state lock.
+func (state *ServicesState) ProxyWeight(svc *service.Service, maxWeight int) int {
+	weight := state.TrafficWeight(svc, maxWeight)
+	if state.pressureThreshold <= 0 || weight == 0 {
+		return weight
+	}
+
+	if weight < 0 {
+		weight = maxWeight
+	}
+
+	scaled := int(float64(weight) * state.pressureFactor(state.hostPressure[svc.Hostname]))
+	if scaled < 1 {
+		return 1
+	}
+
+	return scaled
+}
@@ -0,0 +1,85 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_HostPressure(t *testing.T) {
+	Convey("Routing by host pressure", t, func() {
+		state := NewServicesState()
+		baseTime := time.Now().UTC()
+
+		newSvc := func(id string, hostname string, version string) service.Service {
+			return service.Service{
+				ID: id, Name: "bocaccio", Hostname: hostname, Updated: baseTime,
+				Status: service.ALIVE, SidecarVersion: version,
+			}
+		}
+
+		svc1 := newSvc("deadbeef001", "chaucer", "blue")
+		svc2 := newSvc("deadbeef002", "dante", "blue")
+		for _, svc := range []service.Service{svc1, svc2} {
+			state.AddServiceEntry(svc)
+		}
+		state.SetHostPressure(map[string]float64{"chaucer": 1.6, "dante": 0.5})
+
+		Convey("leaves the weights alone when it's off", func() {
+			So(state.ProxyWeight(&svc1, 100), ShouldEqual, -1)
+			So(state.ProxyWeight(&svc2, 100), ShouldEqual, -1)
+		})
+
+		Convey("when it's on", func() {
+			state.SetPressureThreshold(0.8)
+
+			Convey("weights every instance, and overloaded ones less", func() {
+				So(state.ProxyWeight(&svc1, 100), ShouldEqual, 50)
+				So(state.ProxyWeight(&svc2, 100), ShouldEqual, 100)
+			})
+
+			Convey("scales the traffic split", func() {
+				state.SetTrafficSplit("", "bocaccio", &TrafficSplit{
+					Weights: map[string]int{"blue": 1, "green": 1}, Updated: baseTime,
+				})
+				So(state.ProxyWeight(&svc1, 100), ShouldEqual, 12)
+				So(state.ProxyWeight(&svc2, 100), ShouldEqual, 25)
+			})
+
+			Convey("never sends traffic a split doesn't", func() {
+				state.SetTrafficSplit("", "bocaccio", &TrafficSplit{
+					Weights: map[string]int{"green": 1}, Updated: baseTime,
+				})
+				So(state.ProxyWeight(&svc1, 100), ShouldEqual, 0)
+			})
+
+			Convey("tells the listeners when a host's weight changes", func() {
+				listener := NewUrlListener("http://localhost/", false)
+				state.AddListener(listener)
+				lastChanged := state.LastChanged
+
+				state.SetHostPressure(map[string]float64{"chaucer": 1.6, "dante": 0.7})
+				So(state.LastChanged, ShouldEqual, lastChanged)
+				So(listener.Chan(), ShouldBeEmpty)
+
+				state.SetHostPressure(map[string]float64{"chaucer": 0.4, "dante": 0.7})
+				So(state.LastChanged.After(lastChanged), ShouldBeTrue)
+				So(listener.Chan(), ShouldHaveLength, 1)
+			})
+
+			Convey("tells the listeners about same-named services in each namespace", func() {
+				staging := newSvc("deadbeef003", "chaucer", "blue")
+				staging.Namespace = "staging"
+				state.AddServiceEntry(staging)
+
+				listener := NewUrlListener("http://localhost/", false)
+				state.AddListener(listener)
+
+				state.SetHostPressure(map[string]float64{"chaucer": 0.4, "dante": 0.5})
+				So(listener.Chan(), ShouldHaveLength, 2)
+			})
+		})
+	})
+}
@@ -0,0 +1,198 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A RegisteredListener is a UrlListener that was added through the API
+type RegisteredListener struct {
+	Name  string
+	Url   string
+	Added time.Time
+}
+
+// A ListenerRegistry keeps the UrlListeners that platform services subscribe
+// at runtime, rather than with container labels or static config. They are
+// saved to a file, when there is one, so they survive restarts. Registered
+// listeners aren't managed, so TrackLocalListeners() leaves them alone.
+type ListenerRegistry struct {
+	Path      string // Where to save the listeners. Empty means not to.
+	state     *ServicesState
+	listeners map[string]*RegisteredListener
+	running   map[string]*UrlListener
+	sync.Mutex
+}
+
+func NewListenerRegistry(state *ServicesState, path string) *ListenerRegistry {
+	return &ListenerRegistry{
+		Path:      path,
+		state:     state,
+		listeners: make(map[string]*RegisteredListener),
+		running:   make(map[string]*UrlListener),
+	}
+}
+
+// Load starts the listeners saved in the file, if there is one yet
+func (r *ListenerRegistry) Load() error {
+	if r.Path == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(r.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var saved []RegisteredListener
+	err = json.Unmarshal(data, &saved)
+	if err != nil {
+		return fmt.Errorf("Unable to decode listeners from %s: %s", r.Path, err)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	for i := range saved {
+		r.start(&saved[i])
+	}
+	log.Infof("Loaded %d registered listeners from %s", len(saved), r.Path)
+
+	return nil
+}
+
+// Add registers a listener and starts sending it events
+func (r *ListenerRegistry) Add(name string, listenUrl string) (*RegisteredListener, error) {
+	if name == "" {
+		return nil, fmt.Errorf("Listeners need a name")
+	}
+
+	parsed, err := url.Parse(listenUrl)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("Invalid listener URL %q, it must be http or https", listenUrl)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	for _, existing := range r.state.GetListeners() {
+		if existing.Name() == name {
+			return nil, fmt.Errorf("There is already a listener named %q", name)
+		}
+	}
+
+	listener := &RegisteredListener{Name: name, Url: listenUrl, Added: time.Now().UTC()}
+	r.start(listener)
+
+	// Better to refuse it than to have it quietly vanish on restart
+	err = r.save()
+	if err != nil {
+		r.stop(name)
+		return nil, fmt.Errorf("Unable to save listeners to %s: %s", r.Path, err)
+	}
+
+	return listener, nil
+}
+
+// Remove stops a registered listener and forgets it. Listeners that weren't
+// registered through the API can't be removed this way.
+func (r *ListenerRegistry) Remove(name string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if _, ok := r.running[name]; !ok {
+		return fmt.Errorf("No registered listener named %q", name)
+	}
+
+	r.stop(name)
+	log.Infof("Removed registered listener %s", name)
+
+	err := r.save()
+	if err != nil {
+		log.Errorf("Failed to save registered listeners to %s: %s", r.Path, err)
+	}
+
+	return nil
+}
+
+// List returns the registered listeners, sorted by name
+func (r *ListenerRegistry) List() []RegisteredListener {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.list()
+}
+
+// list returns the registered listeners. Not synchronized!
+func (r *ListenerRegistry) list() []RegisteredListener {
+	result := make([]RegisteredListener, 0, len(r.listeners))
+	for _, listener := range r.listeners {
+		result = append(result, *listener)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	return result
+}
+
+// start creates the UrlListener and has it watch the state. Not
+// synchronized!
+func (r *ListenerRegistry) start(listener *RegisteredListener) {
+	urlListener := NewUrlListener(listener.Url, false)
+	urlListener.SetName(listener.Name)
+	urlListener.Watch(r.state)
+
+	r.listeners[listener.Name] = listener
+	r.running[listener.Name] = urlListener
+	log.Infof("Added registered listener %s for %s", listener.Name, listener.Url)
+}
+
+// stop stops a listener and forgets it. Not synchronized!
+func (r *ListenerRegistry) stop(name string) {
+	r.running[name].Stop()
+	_ = r.state.RemoveListener(name)
+	delete(r.running, name)
+	delete(r.listeners, name)
+}
+
+// save writes the listeners to the file, if there is one. It writes a new
+// file and renames it into place, so a crash can't leave half a file.
+// Not synchronized!
+func (r *ListenerRegistry) save() error {
+	if r.Path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(r.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(r.Path), ".listeners")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), r.Path)
+}
@@ -0,0 +1,87 @@
+package catalog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ListenerRegistry(t *testing.T) {
+	Convey("ListenerRegistry", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-listeners")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+
+		path := filepath.Join(dir, "listeners.json")
+		state := NewServicesState()
+		registry := NewListenerRegistry(state, path)
+
+		Convey("adds listeners to the state", func() {
+			listener, err := registry.Add("billing", "http://billing.example.com/events")
+			So(err, ShouldBeNil)
+			So(listener.Name, ShouldEqual, "billing")
+
+			So(len(state.GetListeners()), ShouldEqual, 1)
+			So(state.GetListeners()[0].Name(), ShouldEqual, "billing")
+			So(state.GetListeners()[0].Managed(), ShouldBeFalse)
+			So(len(registry.List()), ShouldEqual, 1)
+		})
+
+		Convey("refuses bad listeners", func() {
+			_, err := registry.Add("", "http://billing.example.com/events")
+			So(err, ShouldNotBeNil)
+
+			_, err = registry.Add("billing", "ftp://billing.example.com/events")
+			So(err, ShouldNotBeNil)
+
+			_, err = registry.Add("billing", "http://billing.example.com/events")
+			So(err, ShouldBeNil)
+			_, err = registry.Add("billing", "http://other.example.com/events")
+			So(err, ShouldNotBeNil)
+
+			So(len(state.GetListeners()), ShouldEqual, 1)
+		})
+
+		Convey("removes listeners", func() {
+			_, _ = registry.Add("billing", "http://billing.example.com/events")
+
+			So(registry.Remove("billing"), ShouldBeNil)
+			So(state.GetListeners(), ShouldBeEmpty)
+			So(registry.List(), ShouldBeEmpty)
+
+			Convey("but only registered ones", func() {
+				So(registry.Remove("billing"), ShouldNotBeNil)
+			})
+		})
+
+		Convey("keeps listeners across restarts", func() {
+			_, _ = registry.Add("billing", "http://billing.example.com/events")
+			_, _ = registry.Add("audit", "https://audit.example.com/sidecar")
+			So(registry.Remove("billing"), ShouldBeNil)
+
+			restarted := NewServicesState()
+			reloaded := NewListenerRegistry(restarted, path)
+			So(reloaded.Load(), ShouldBeNil)
+
+			So(len(reloaded.List()), ShouldEqual, 1)
+			So(reloaded.List()[0].Url, ShouldEqual, "https://audit.example.com/sidecar")
+			So(len(restarted.GetListeners()), ShouldEqual, 1)
+		})
+
+		Convey("loads nothing when there's no file yet", func() {
+			So(registry.Load(), ShouldBeNil)
+			So(registry.List(), ShouldBeEmpty)
+		})
+
+		Convey("refuses listeners it can't save", func() {
+			registry.Path = filepath.Join(dir, "missing", "listeners.json")
+
+			_, err := registry.Add("billing", "http://billing.example.com/events")
+			So(err, ShouldNotBeNil)
+			So(state.GetListeners(), ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,90 @@
+package catalog
+
+import (
+	"sort"
+
+	"github.com/Nitro/sidecar/service"
+)
+
+// A ClosedService has fewer instances the proxies could send traffic to than
+// the MinInstances it asks for. Rather than hammer the last few survivors,
+// the proxies fail closed and answer with errors until enough are back.
+type ClosedService struct {
+	Name         string
+	Namespace    string `json:",omitempty"`
+	Alive        int    // Instances the proxies could send traffic to
+	MinInstances int
+}
+
+// ClosedServices returns the services the proxies are failing closed for,
+// in every namespace, sorted by namespace and then by name.
+func (state *ServicesState) ClosedServices() []ClosedService {
+	state.RLock()
+	closed := state.closedServices(func(svc *service.Service) bool { return true })
+	state.RUnlock()
+
+	result := make([]ClosedService, 0, len(closed))
+	for _, svc := range closed {
+		result = append(result, *svc)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Namespace != result[j].Namespace {
+			return result[i].Namespace < result[j].Namespace
+		}
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// ClosedServicesByName returns the services in a namespace the proxies are
+// failing closed for, for proxies writing their config. Instances of a
+// service should all agree on the MinInstances. If they don't, the highest
+// one wins. Only instances that are proxied and not pinned out count towards
+// it. The caller must hold the state lock.
+func (state *ServicesState) ClosedServicesByName(namespace string) map[string]*ClosedService {
+	closed := state.closedServices(func(svc *service.Service) bool { return svc.Namespace == namespace })
+
+	byName := make(map[string]*ClosedService, len(closed))
+	for _, gate := range closed {
+		byName[gate.Name] = gate
+	}
+
+	return byName
+}
+
+// closedServices works out which of the services that include() returns true
+// for are short of their MinInstances, keyed by namespace and name. The
+// caller must hold the state lock.
+func (state *ServicesState) closedServices(include func(*service.Service) bool) map[string]*ClosedService {
+	gates := make(map[string]*ClosedService)
+
+	state.EachService(func(hostname *string, serviceId *string, svc *service.Service) {
+		if svc.IsTombstone() || !include(svc) {
+			return
+		}
+
+		gate, ok := gates[svc.Namespace+"/"+svc.Name]
+		if !ok {
+			gate = &ClosedService{Name: svc.Name, Namespace: svc.Namespace}
+			gates[svc.Namespace+"/"+svc.Name] = gate
+		}
+
+		if svc.MinInstances > gate.MinInstances {
+			gate.MinInstances = svc.MinInstances
+		}
+
+		if svc.IsProxied() && !state.PinnedOut(svc) {
+			gate.Alive++
+		}
+	})
+
+	for key, gate := range gates {
+		if gate.Alive >= gate.MinInstances {
+			delete(gates, key)
+		}
+	}
+
+	return gates
+}
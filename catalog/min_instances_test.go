@@ -0,0 +1,77 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ClosedServices(t *testing.T) {
+	Convey("Finding the services to fail closed for", t, func() {
+		state := NewServicesState()
+		state.Broadcasts = make(chan [][]byte, 100)
+		now := time.Now().UTC()
+
+		addInstance := func(id string, name string, status int, minInstances int) {
+			now = now.Add(time.Second)
+			state.AddServiceEntry(service.Service{
+				ID: id, Name: name, Hostname: anotherHostname, Status: status,
+				Updated: now, MinInstances: minInstances,
+			})
+		}
+
+		addInstance("1", "bocaccio", service.ALIVE, 2)
+		addInstance("2", "bocaccio", service.ALIVE, 2)
+		addInstance("3", "petrarch", service.ALIVE, 0)
+
+		Convey("leaves out services with enough instances", func() {
+			So(state.ClosedServices(), ShouldBeEmpty)
+		})
+
+		Convey("finds services that are short of instances", func() {
+			addInstance("2", "bocaccio", service.UNHEALTHY, 2)
+
+			So(state.ClosedServices(), ShouldResemble, []ClosedService{
+				{Name: "bocaccio", Alive: 1, MinInstances: 2},
+			})
+		})
+
+		Convey("goes by the highest minimum among the instances", func() {
+			addInstance("4", "bocaccio", service.ALIVE, 4)
+
+			So(state.ClosedServices(), ShouldResemble, []ClosedService{
+				{Name: "bocaccio", Alive: 3, MinInstances: 4},
+			})
+		})
+
+		Convey("doesn't count instances the proxies leave out", func() {
+			addInstance("2", "bocaccio", service.DRAINING, 2)
+			So(len(state.ClosedServices()), ShouldEqual, 1)
+
+			addInstance("2", "bocaccio", service.ALIVE, 2)
+			state.SetPin("", "bocaccio", &Pin{Instances: []string{"1"}, Updated: now})
+			So(len(state.ClosedServices()), ShouldEqual, 1)
+		})
+
+		Convey("keeps the namespaces apart", func() {
+			now = now.Add(time.Second)
+			state.AddServiceEntry(service.Service{
+				ID: "5", Name: "bocaccio", Hostname: anotherHostname, Status: service.ALIVE,
+				Updated: now, MinInstances: 3, Namespace: "staging",
+			})
+
+			So(state.ClosedServices(), ShouldResemble, []ClosedService{
+				{Name: "bocaccio", Namespace: "staging", Alive: 1, MinInstances: 3},
+			})
+			So(state.ClosedServicesByName(""), ShouldBeEmpty)
+			So(state.ClosedServicesByName("staging"), ShouldContainKey, "bocaccio")
+		})
+
+		Convey("ignores tombstones", func() {
+			addInstance("3", "petrarch", service.TOMBSTONE, 3)
+			So(state.ClosedServices(), ShouldBeEmpty)
+		})
+	})
+}
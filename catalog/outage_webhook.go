@@ -0,0 +1,75 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// An OutageWebhook POSTs each OutageEvent to a URL: when a service loses its
+// last alive instance anywhere in the cluster, and when it gets one back.
+// That's the condition worth paging someone for, unlike the failure of any
+// one instance, which the HealthWebhook reports.
+type OutageWebhook struct {
+	Url          string
+	Retries      int
+	Client       *http.Client
+	eventChannel chan OutageEvent
+}
+
+func NewOutageWebhook(url string) *OutageWebhook {
+	return &OutageWebhook{
+		Url:          url,
+		Retries:      DefaultRetries,
+		Client:       &http.Client{Timeout: ClientTimeout},
+		eventChannel: make(chan OutageEvent, LISTENER_EVENT_BUFFER_SIZE),
+	}
+}
+
+// Watch registers the webhook for outage events and starts sending them in
+// the background. Events are dropped if the webhook falls too far behind.
+func (h *OutageWebhook) Watch(state *ServicesState) {
+	state.OnOutage(func(event OutageEvent) {
+		select {
+		case h.eventChannel <- event:
+		default:
+			log.Warnf("OutageWebhook(%s) is falling behind, dropping %s event for %s", h.Url, event.Event, event.Outage.Name)
+		}
+	})
+
+	go func() {
+		for event := range h.eventChannel {
+			h.send(&event)
+		}
+	}()
+}
+
+// send POSTs the event to the webhook, retrying on failure
+func (h *OutageWebhook) send(event *OutageEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Warnf("Skipping post to '%s' because of bad encoding! (%s)", h.Url, err)
+		return
+	}
+
+	err = withRetries(h.Retries, func() error {
+		resp, err := h.Client.Post(h.Url, "application/json", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode > 299 || resp.StatusCode < 200 {
+			return fmt.Errorf("Bad status code returned (%d)", resp.StatusCode)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.Warnf("Failed posting outage event to '%s': %s", h.Url, err)
+	}
+}
@@ -0,0 +1,284 @@
+package catalog
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	"github.com/armon/go-metrics"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	OUTAGE_INTERVAL     = 5 * time.Second  // How often we look for services that are down
+	OUTAGE_GRACE_PERIOD = 30 * time.Second // How long a service must be down to count
+
+	OUTAGE_EVENT_STARTED  = "outage"
+	OUTAGE_EVENT_RESOLVED = "resolved"
+)
+
+// An Outage is a service with no alive instances anywhere in the cluster
+type Outage struct {
+	Name      string
+	Namespace string    `json:",omitempty"`
+	Since     time.Time // When it lost its last alive instance
+	Instances int       // How many instances it has left that aren't tombstoned
+}
+
+// An OutageEvent tells an OutageHandler that an outage started or ended
+type OutageEvent struct {
+	Event       string
+	Outage      Outage
+	Time        time.Time
+	ClusterName string
+	ReportedBy  string // The Sidecar host that noticed
+}
+
+// An OutageHandler is called with each outage as it starts and ends
+type OutageHandler func(event OutageEvent)
+
+// An OutageTracker follows which services have no alive instances. A service
+// is down when none of its instances are alive, and either it had alive ones
+// the last time we looked, or some are failing their checks. Services whose
+// instances are all draining or in maintenance were taken out on purpose and
+// are never down, and neither are those whose instances are all tombstoned. A service that stays down for the grace period is an
+// outage, which rides out deploys that replace every instance at once.
+type OutageTracker struct {
+	GracePeriod time.Duration
+	wasAlive    map[ServiceName]bool
+	down        map[ServiceName]time.Time
+	outages     map[ServiceName]*Outage
+	handlers    []OutageHandler
+	isReporter  func() bool
+	sync.Mutex
+}
+
+func NewOutageTracker() *OutageTracker {
+	return &OutageTracker{
+		GracePeriod: OUTAGE_GRACE_PERIOD,
+		wasAlive:    make(map[ServiceName]bool),
+		down:        make(map[ServiceName]time.Time),
+		outages:     make(map[ServiceName]*Outage),
+	}
+}
+
+// serviceHealth counts the instances of a service for outage tracking
+type serviceHealth struct {
+	alive      int
+	failing    int // Unhealthy, or not checked yet
+	deliberate int // Draining or in maintenance
+	remaining  int // Not tombstoned
+}
+
+// isDown tells whether a service with these instances is down. Not
+// synchronized!
+func (t *OutageTracker) isDown(name ServiceName, health *serviceHealth) bool {
+	if health.alive > 0 {
+		return false
+	}
+
+	// With every instance tombstoned, the service is gone, not down
+	if health.remaining == 0 || health.deliberate == health.remaining {
+		return false
+	}
+
+	_, alreadyDown := t.down[name]
+	return t.wasAlive[name] || alreadyDown || health.failing > 0
+}
+
+// Evaluate looks at the health of every service and returns the outages that
+// started or ended since the last time. Services that have left the catalog
+// entirely resolve their outage.
+func (t *OutageTracker) Evaluate(services map[ServiceName]*serviceHealth, now time.Time) []OutageEvent {
+	t.Lock()
+	defer t.Unlock()
+
+	var events []OutageEvent
+
+	for name, health := range services {
+		if !t.isDown(name, health) {
+			delete(t.down, name)
+			if outage, ok := t.outages[name]; ok {
+				outage.Instances = health.remaining
+				events = append(events, OutageEvent{Event: OUTAGE_EVENT_RESOLVED, Outage: *outage})
+				delete(t.outages, name)
+			}
+			t.wasAlive[name] = health.alive > 0
+			continue
+		}
+
+		t.wasAlive[name] = false
+		if _, ok := t.down[name]; !ok {
+			t.down[name] = now
+		}
+
+		if outage, ok := t.outages[name]; ok {
+			outage.Instances = health.remaining
+			continue
+		}
+
+		if now.Sub(t.down[name]) >= t.GracePeriod {
+			outage := &Outage{
+				Name: name.Name, Namespace: name.Namespace, Since: t.down[name], Instances: health.remaining,
+			}
+			t.outages[name] = outage
+			events = append(events, OutageEvent{Event: OUTAGE_EVENT_STARTED, Outage: *outage})
+		}
+	}
+
+	for name := range t.wasAlive {
+		if _, ok := services[name]; ok {
+			continue
+		}
+
+		if outage, ok := t.outages[name]; ok {
+			outage.Instances = 0
+			events = append(events, OutageEvent{Event: OUTAGE_EVENT_RESOLVED, Outage: *outage})
+		}
+		delete(t.wasAlive, name)
+		delete(t.down, name)
+		delete(t.outages, name)
+	}
+
+	for i := range events {
+		events[i].Time = now
+	}
+
+	return events
+}
+
+// Outages returns the current outages, longest running first
+func (t *OutageTracker) Outages() []Outage {
+	t.Lock()
+	defer t.Unlock()
+
+	result := make([]Outage, 0, len(t.outages))
+	for _, outage := range t.outages {
+		result = append(result, *outage)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].Since.Equal(result[j].Since) {
+			return result[i].Since.Before(result[j].Since)
+		}
+		if result[i].Namespace != result[j].Namespace {
+			return result[i].Namespace < result[j].Namespace
+		}
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// SetOutageGracePeriod sets how long a service must have no alive instances
+// before it counts as an outage.
+func (state *ServicesState) SetOutageGracePeriod(grace time.Duration) {
+	state.outages.Lock()
+	defer state.outages.Unlock()
+
+	state.outages.GracePeriod = grace
+}
+
+// OnOutage registers a handler to call as outages start and end. Handlers
+// are called from the tracking loop, so they must not block.
+func (state *ServicesState) OnOutage(handler OutageHandler) {
+	state.outages.Lock()
+	defer state.outages.Unlock()
+
+	state.outages.handlers = append(state.outages.handlers, handler)
+}
+
+// SetOutageReporter sets how we tell whether we're the host that calls the
+// OutageHandlers. Every server tracks outages, but the handlers post them
+// out of the cluster, which only one of them should do. Without it, we
+// always call them.
+func (state *ServicesState) SetOutageReporter(isReporter func() bool) {
+	state.outages.Lock()
+	defer state.outages.Unlock()
+
+	state.outages.isReporter = isReporter
+}
+
+// Outages returns the services that have no alive instances, longest first
+func (state *ServicesState) Outages() []Outage {
+	return state.outages.Outages()
+}
+
+// EvaluateOutages looks for services that have lost all of their alive
+// instances, or got one back, and reports them to the log, the metrics, and
+// the OutageHandlers, if we're the reporter.
+func (state *ServicesState) EvaluateOutages(now time.Time) {
+	services := make(map[ServiceName]*serviceHealth)
+
+	state.RLock()
+	state.EachService(func(hostname *string, id *string, svc *service.Service) {
+		name := ServiceName{Namespace: svc.Namespace, Name: svc.Name}
+		health, ok := services[name]
+		if !ok {
+			health = &serviceHealth{}
+			services[name] = health
+		}
+
+		switch svc.Status {
+		case service.ALIVE:
+			health.alive++
+		case service.UNHEALTHY, service.UNKNOWN:
+			health.failing++
+		case service.DRAINING, service.MAINTENANCE:
+			health.deliberate++
+		}
+
+		if !svc.IsTombstone() {
+			health.remaining++
+		}
+	})
+	clusterName, hostname := state.ClusterName, state.Hostname
+	state.RUnlock()
+
+	events := state.outages.Evaluate(services, now)
+
+	state.outages.Lock()
+	handlers := state.outages.handlers
+	isReporter := state.outages.isReporter
+	state.outages.Unlock()
+
+	if isReporter != nil && !isReporter() {
+		handlers = nil
+	}
+
+	for _, event := range events {
+		event.ClusterName = clusterName
+		event.ReportedBy = hostname
+
+		name := ServiceName{Namespace: event.Outage.Namespace, Name: event.Outage.Name}
+		if event.Event == OUTAGE_EVENT_STARTED {
+			log.Errorf(
+				"Service %s has no alive instances anywhere! It has been down since %s",
+				name, event.Outage.Since.Format(time.RFC3339),
+			)
+			metrics.IncrCounter([]string{"services_state", "outages_started"}, 1)
+		} else {
+			log.Infof(
+				"Service %s is back after being down for %s",
+				name, now.Sub(event.Outage.Since).Round(time.Second),
+			)
+		}
+
+		for _, handler := range handlers {
+			handler(event)
+		}
+	}
+
+	metrics.SetGauge([]string{"services_state", "outages"}, float32(len(state.Outages())))
+}
+
+// TrackOutages runs in the background looking for services with no alive
+// instances. See EvaluateOutages().
+func (state *ServicesState) TrackOutages(looper director.Looper) {
+	looper.Loop(func() error {
+		state.EvaluateOutages(time.Now().UTC())
+		return nil
+	})
+}
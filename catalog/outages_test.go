@@ -0,0 +1,185 @@
+package catalog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Outages(t *testing.T) {
+	Convey("Tracking outages", t, func() {
+		state := NewServicesState()
+		state.Broadcasts = make(chan [][]byte, 100)
+		now := time.Now().UTC()
+
+		var events []OutageEvent
+		state.OnOutage(func(event OutageEvent) { events = append(events, event) })
+
+		setStatus := func(id string, status int) {
+			now = now.Add(time.Second)
+			state.AddServiceEntry(service.Service{
+				ID: id, Name: "bocaccio", Hostname: anotherHostname, Status: status, Updated: now,
+			})
+		}
+
+		setStatus("1", service.ALIVE)
+		setStatus("2", service.ALIVE)
+		state.EvaluateOutages(now)
+
+		Convey("doesn't fire while any instance is alive", func() {
+			setStatus("1", service.UNHEALTHY)
+			state.EvaluateOutages(now.Add(time.Hour))
+
+			So(events, ShouldBeEmpty)
+			So(state.Outages(), ShouldBeEmpty)
+		})
+
+		Convey("fires once the last instance has been down for the grace period", func() {
+			setStatus("1", service.UNHEALTHY)
+			setStatus("2", service.TOMBSTONE)
+			down := now
+
+			state.EvaluateOutages(down)
+			So(events, ShouldBeEmpty)
+
+			state.EvaluateOutages(down.Add(OUTAGE_GRACE_PERIOD))
+			state.EvaluateOutages(down.Add(2 * OUTAGE_GRACE_PERIOD))
+			So(len(events), ShouldEqual, 1)
+			So(events[0].Event, ShouldEqual, OUTAGE_EVENT_STARTED)
+			So(events[0].Outage.Name, ShouldEqual, "bocaccio")
+			So(events[0].Outage.Since, ShouldEqual, down)
+			So(events[0].Outage.Instances, ShouldEqual, 1)
+			So(events[0].ReportedBy, ShouldEqual, state.Hostname)
+
+			So(len(state.Outages()), ShouldEqual, 1)
+
+			Convey("and resolves when an instance is alive again", func() {
+				setStatus("1", service.ALIVE)
+				state.EvaluateOutages(now)
+
+				So(len(events), ShouldEqual, 2)
+				So(events[1].Event, ShouldEqual, OUTAGE_EVENT_RESOLVED)
+				So(state.Outages(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("doesn't fire when every instance is tombstoned", func() {
+			setStatus("1", service.TOMBSTONE)
+			setStatus("2", service.TOMBSTONE)
+
+			state.EvaluateOutages(now)
+			state.EvaluateOutages(now.Add(OUTAGE_GRACE_PERIOD))
+			So(events, ShouldBeEmpty)
+			So(state.Outages(), ShouldBeEmpty)
+		})
+
+		Convey("resolves when the last failing instance is tombstoned", func() {
+			setStatus("1", service.UNHEALTHY)
+			setStatus("2", service.TOMBSTONE)
+			state.EvaluateOutages(now)
+			state.EvaluateOutages(now.Add(OUTAGE_GRACE_PERIOD))
+
+			setStatus("1", service.TOMBSTONE)
+			state.EvaluateOutages(now.Add(OUTAGE_GRACE_PERIOD))
+
+			So(len(events), ShouldEqual, 2)
+			So(events[1].Event, ShouldEqual, OUTAGE_EVENT_RESOLVED)
+			So(events[1].Outage.Instances, ShouldEqual, 0)
+			So(state.Outages(), ShouldBeEmpty)
+		})
+
+		Convey("only calls the handlers on the reporter", func() {
+			state.SetOutageReporter(func() bool { return false })
+			setStatus("1", service.UNHEALTHY)
+			setStatus("2", service.UNHEALTHY)
+
+			state.EvaluateOutages(now)
+			state.EvaluateOutages(now.Add(OUTAGE_GRACE_PERIOD))
+			So(events, ShouldBeEmpty)
+			So(len(state.Outages()), ShouldEqual, 1)
+		})
+
+		Convey("rides out instances being replaced within the grace period", func() {
+			setStatus("1", service.TOMBSTONE)
+			setStatus("2", service.TOMBSTONE)
+			state.EvaluateOutages(now)
+
+			setStatus("3", service.ALIVE)
+			state.EvaluateOutages(now.Add(OUTAGE_GRACE_PERIOD))
+			So(events, ShouldBeEmpty)
+		})
+
+		Convey("doesn't fire for services taken out on purpose", func() {
+			setStatus("1", service.DRAINING)
+			setStatus("2", service.MAINTENANCE)
+
+			state.EvaluateOutages(now)
+			state.EvaluateOutages(now.Add(OUTAGE_GRACE_PERIOD))
+			So(events, ShouldBeEmpty)
+		})
+
+		Convey("tracks services of the same name in other namespaces separately", func() {
+			now = now.Add(time.Second)
+			state.AddServiceEntry(service.Service{
+				ID: "3", Name: "bocaccio", Namespace: "staging", Hostname: anotherHostname,
+				Status: service.UNHEALTHY, Updated: now,
+			})
+
+			state.EvaluateOutages(now)
+			state.EvaluateOutages(now.Add(OUTAGE_GRACE_PERIOD))
+			So(len(events), ShouldEqual, 1)
+			So(events[0].Outage.Name, ShouldEqual, "bocaccio")
+			So(events[0].Outage.Namespace, ShouldEqual, "staging")
+		})
+
+		Convey("resolves outages of services that left the catalog", func() {
+			setStatus("1", service.UNHEALTHY)
+			setStatus("2", service.UNHEALTHY)
+			state.EvaluateOutages(now)
+			state.EvaluateOutages(now.Add(OUTAGE_GRACE_PERIOD))
+
+			delete(state.Servers, anotherHostname)
+			state.EvaluateOutages(now.Add(2 * OUTAGE_GRACE_PERIOD))
+
+			So(len(events), ShouldEqual, 2)
+			So(events[1].Event, ShouldEqual, OUTAGE_EVENT_RESOLVED)
+			So(state.Outages(), ShouldBeEmpty)
+		})
+	})
+}
+
+func Test_OutageWebhook(t *testing.T) {
+	Convey("An OutageWebhook posts outage events", t, func() {
+		received := make(chan OutageEvent, 5)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var event OutageEvent
+			json.NewDecoder(r.Body).Decode(&event)
+			received <- event
+		}))
+		Reset(server.Close)
+
+		state := NewServicesState()
+		state.Broadcasts = make(chan [][]byte, 10)
+		state.SetOutageGracePeriod(0)
+		NewOutageWebhook(server.URL).Watch(state)
+
+		now := time.Now().UTC()
+		state.AddServiceEntry(service.Service{
+			ID: "1", Name: "bocaccio", Hostname: anotherHostname, Status: service.UNHEALTHY, Updated: now,
+		})
+		state.EvaluateOutages(now)
+
+		var event OutageEvent
+		select {
+		case event = <-received:
+		case <-time.After(time.Second):
+		}
+		So(event.Event, ShouldEqual, OUTAGE_EVENT_STARTED)
+		So(event.Outage.Name, ShouldEqual, "bocaccio")
+	})
+}
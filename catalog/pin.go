@@ -0,0 +1,104 @@
+package catalog
+
+import (
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+)
+
+// A Pin restricts the proxies to particular instances of a service, by ID,
+// for example to keep traffic on known-good instances during an incident.
+// Pins stay in place until they are cleared by setting one with no
+// instances. If none of the pinned instances is up, the pin is ignored, so
+// that a stale pin can't take a service down entirely.
+type Pin struct {
+	Instances []string
+	Reason    string `json:",omitempty"`
+	Updated   time.Time
+}
+
+// IsEmpty reports whether the pin no longer restricts any instances
+func (pin *Pin) IsEmpty() bool {
+	return pin == nil || len(pin.Instances) == 0
+}
+
+// Has tells us if the instance with this ID is pinned
+func (pin *Pin) Has(id string) bool {
+	for _, pinned := range pin.Instances {
+		if pinned == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetPin stores the pin for a service in a namespace if it is newer than the
+// one we know about, and tells our listeners so that the proxies pick it up.
+// Like traffic splits, pins reach the rest of the cluster with the state
+// during anti-entropy syncs. Returns true when the pin was applied.
+func (state *ServicesState) SetPin(namespace string, name string, pin *Pin) bool {
+	return state.setPin(serviceKey(namespace, name), pin)
+}
+
+// setPin stores a pin by its service key. See SetPin().
+func (state *ServicesState) setPin(key string, pin *Pin) bool {
+	state.Lock()
+	defer state.Unlock()
+
+	if current, ok := state.Pins[key]; ok && !pin.Updated.After(current.Updated) {
+		return false
+	}
+
+	if state.Pins == nil {
+		state.Pins = make(map[string]*Pin)
+	}
+	state.Pins[key] = pin
+	state.LastChanged = time.Now().UTC()
+	state.notifyServiceKey(key)
+
+	return true
+}
+
+// GetPin returns the current pin for a service in a namespace, or nil if it
+// doesn't have one
+func (state *ServicesState) GetPin(namespace string, name string) *Pin {
+	state.RLock()
+	defer state.RUnlock()
+
+	pin := state.Pins[serviceKey(namespace, name)]
+	if pin.IsEmpty() {
+		return nil
+	}
+
+	return pin
+}
+
+// PinnedOut tells the proxies to leave out an instance because its service
+// is pinned to other instances. The caller must hold the state lock.
+func (state *ServicesState) PinnedOut(svc *service.Service) bool {
+	pin := state.Pins[serviceKey(svc.Namespace, svc.Name)]
+	if pin.IsEmpty() || pin.Has(svc.ID) {
+		return false
+	}
+
+	// Only honor the pin while one of the pinned instances can take traffic
+	var pinnedUp bool
+	state.EachService(func(hostname *string, id *string, other *service.Service) {
+		if other.Name == svc.Name && other.Namespace == svc.Namespace &&
+			other.IsProxied() && pin.Has(other.ID) {
+			pinnedUp = true
+		}
+	})
+
+	return pinnedUp
+}
+
+// mergePins takes any pins that are newer than ours
+func (state *ServicesState) mergePins(pins map[string]*Pin) {
+	for key, pin := range pins {
+		if pin != nil {
+			state.setPin(key, pin)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Pins(t *testing.T) {
+	Convey("Pins", t, func() {
+		state := NewServicesState()
+		state.Broadcasts = make(chan [][]byte, 10)
+		baseTime := time.Now().UTC()
+
+		newSvc := func(id string) service.Service {
+			return service.Service{
+				ID:       id,
+				Name:     "bocaccio",
+				Image:    "bocaccio:101deadbeef",
+				Hostname: "chaucer",
+				Updated:  baseTime,
+				Status:   service.ALIVE,
+			}
+		}
+
+		svc1 := newSvc("deadbeef001")
+		svc2 := newSvc("deadbeef002")
+		for _, svc := range []service.Service{svc1, svc2} {
+			state.AddServiceEntry(svc)
+		}
+
+		pin := &Pin{Instances: []string{svc1.ID}, Updated: baseTime}
+
+		pinnedOut := func(id string) bool {
+			state.RLock()
+			defer state.RUnlock()
+			return state.PinnedOut(state.Servers["chaucer"].Services[id])
+		}
+
+		Convey("SetPin()", func() {
+			Convey("stores the pin", func() {
+				So(state.SetPin("", "bocaccio", pin), ShouldBeTrue)
+				So(state.GetPin("", "bocaccio"), ShouldEqual, pin)
+			})
+
+			Convey("ignores pins older than the one we have", func() {
+				state.SetPin("", "bocaccio", pin)
+
+				older := &Pin{Instances: []string{svc2.ID}, Updated: baseTime.Add(-1 * time.Second)}
+				So(state.SetPin("", "bocaccio", older), ShouldBeFalse)
+				So(state.GetPin("", "bocaccio"), ShouldEqual, pin)
+			})
+
+			Convey("is cleared by a newer empty pin", func() {
+				state.SetPin("", "bocaccio", pin)
+				state.SetPin("", "bocaccio", &Pin{Updated: baseTime.Add(time.Second)})
+
+				So(state.GetPin("", "bocaccio"), ShouldBeNil)
+				So(pinnedOut(svc2.ID), ShouldBeFalse)
+			})
+		})
+
+		Convey("PinnedOut()", func() {
+			Convey("leaves nothing out without a pin", func() {
+				So(pinnedOut(svc1.ID), ShouldBeFalse)
+				So(pinnedOut(svc2.ID), ShouldBeFalse)
+			})
+
+			Convey("leaves out the instances that aren't pinned", func() {
+				state.SetPin("", "bocaccio", pin)
+
+				So(pinnedOut(svc1.ID), ShouldBeFalse)
+				So(pinnedOut(svc2.ID), ShouldBeTrue)
+			})
+
+			Convey("ignores the pin when no pinned instance is up", func() {
+				state.SetPin("", "bocaccio", pin)
+				state.Servers["chaucer"].Services[svc1.ID].Status = service.UNHEALTHY
+
+				So(pinnedOut(svc2.ID), ShouldBeFalse)
+			})
+		})
+
+		Convey("only apply to the service in their namespace", func() {
+			teamA := newSvc("deadbeef003")
+			teamA.Namespace = "teamA"
+			teamB := newSvc("deadbeef004")
+			teamB.Namespace = "teamB"
+			state.AddServiceEntry(teamA)
+			state.AddServiceEntry(teamB)
+
+			state.SetPin("teamA", "bocaccio", &Pin{Instances: []string{teamA.ID}, Updated: baseTime})
+
+			So(state.GetPin("teamA", "bocaccio"), ShouldNotBeNil)
+			So(state.GetPin("", "bocaccio"), ShouldBeNil)
+			So(state.Pins, ShouldContainKey, "teamA/bocaccio")
+			So(pinnedOut(teamA.ID), ShouldBeFalse)
+			So(pinnedOut(teamB.ID), ShouldBeFalse)
+			So(pinnedOut(svc1.ID), ShouldBeFalse)
+		})
+
+		Convey("Merge() takes newer pins from the other state", func() {
+			other := NewServicesState()
+			other.Pins = map[string]*Pin{"bocaccio": pin}
+			state.Merge(other)
+
+			So(state.GetPin("", "bocaccio"), ShouldEqual, pin)
+		})
+
+		Convey("survive encoding the state", func() {
+			state.SetPin("", "bocaccio", pin)
+
+			decoded, err := Decode(state.Encode())
+			So(err, ShouldBeNil)
+			So(decoded.Pins["bocaccio"].Instances, ShouldResemble, pin.Instances)
+		})
+	})
+}
@@ -0,0 +1,182 @@
+package catalog
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	"github.com/armon/go-metrics"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PORT_CONFLICT_INTERVAL = 10 * time.Second // How often we look for ServicePort conflicts
+)
+
+// A PortConflict is a ServicePort that more than one service in a namespace
+// advertises. A proxy can only listen on it for one of them, so the proxies
+// serve it for the Owner, the service that has had it the longest, and leave
+// it out for the Others until the conflict is fixed.
+type PortConflict struct {
+	ServicePort int64
+	Namespace   string `json:",omitempty"`
+	Owner       string
+	Others      []string
+}
+
+// PortConflicts returns the ServicePorts claimed by more than one service,
+// in every namespace, sorted by namespace and then by port.
+func (state *ServicesState) PortConflicts() []PortConflict {
+	state.RLock()
+	conflicts := state.portConflicts(func(svc *service.Service) bool { return true })
+	state.RUnlock()
+
+	result := make([]PortConflict, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		result = append(result, *conflict)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Namespace != result[j].Namespace {
+			return result[i].Namespace < result[j].Namespace
+		}
+		return result[i].ServicePort < result[j].ServicePort
+	})
+
+	return result
+}
+
+// PortConflictsIn returns the ServicePorts claimed by more than one service
+// in a namespace, for proxies writing their config. The caller must hold the
+// state lock.
+func (state *ServicesState) PortConflictsIn(namespace string) map[int64]*PortConflict {
+	conflicts := state.portConflicts(func(svc *service.Service) bool { return svc.Namespace == namespace })
+
+	byPort := make(map[int64]*PortConflict, len(conflicts))
+	for _, conflict := range conflicts {
+		byPort[conflict.ServicePort] = conflict
+	}
+
+	return byPort
+}
+
+// ConflictLoser reports whether a proxy should leave out a ServicePort for a
+// service, because another service owns it.
+func ConflictLoser(conflicts map[int64]*PortConflict, svcName string, svcPort int64) bool {
+	conflict, ok := conflicts[svcPort]
+	return ok && conflict.Owner != svcName
+}
+
+// portConflicts finds the ServicePorts that more than one of the services
+// include() returns true for advertise, keyed by namespace and port. Every
+// instance that isn't tombstoned counts, so a service doesn't lose its port
+// while its instances are failing their health checks. The owner is the
+// service with the oldest instance on the port, so a new deploy can't take a
+// port away from a service that is already serving it. The caller must hold
+// the state lock.
+func (state *ServicesState) portConflicts(include func(*service.Service) bool) map[string]*PortConflict {
+	type claim struct {
+		namespace string
+		port      int64
+		name      string
+	}
+	claims := make(map[claim]time.Time)
+
+	state.EachService(func(hostname *string, serviceId *string, svc *service.Service) {
+		if svc.IsTombstone() || !include(svc) {
+			return
+		}
+
+		for _, port := range svc.Ports {
+			if port.ServicePort < 1 {
+				continue
+			}
+
+			key := claim{svc.Namespace, port.ServicePort, svc.Name}
+			if since, ok := claims[key]; !ok || svc.Created.Before(since) {
+				claims[key] = svc.Created
+			}
+		}
+	})
+
+	// Group the claims by port
+	byPort := make(map[string][]claim)
+	for key := range claims {
+		portKey := key.namespace + "/" + strconv.FormatInt(key.port, 10)
+		byPort[portKey] = append(byPort[portKey], key)
+	}
+
+	conflicts := make(map[string]*PortConflict)
+	for portKey, claimants := range byPort {
+		if len(claimants) < 2 {
+			continue
+		}
+
+		sort.Slice(claimants, func(i, j int) bool {
+			first, second := claims[claimants[i]], claims[claimants[j]]
+			if !first.Equal(second) {
+				return first.Before(second)
+			}
+			return claimants[i].name < claimants[j].name
+		})
+
+		conflict := &PortConflict{
+			ServicePort: claimants[0].port,
+			Namespace:   claimants[0].namespace,
+			Owner:       claimants[0].name,
+		}
+		for _, claimant := range claimants[1:] {
+			conflict.Others = append(conflict.Others, claimant.name)
+		}
+		sort.Strings(conflict.Others)
+
+		conflicts[portKey] = conflict
+	}
+
+	return conflicts
+}
+
+// EvaluatePortConflicts logs each ServicePort conflict when it is first
+// found, and reports how many there are in the metrics.
+func (state *ServicesState) EvaluatePortConflicts() {
+	conflicts := state.PortConflicts()
+
+	state.Lock()
+	defer state.Unlock()
+
+	current := make(map[string]bool, len(conflicts))
+	for _, conflict := range conflicts {
+		key := conflict.Namespace + "/" + strconv.FormatInt(conflict.ServicePort, 10) + "/" +
+			conflict.Owner + "/" + strings.Join(conflict.Others, ",")
+		current[key] = true
+
+		if state.reportedConflicts[key] {
+			continue
+		}
+
+		namespace := ""
+		if conflict.Namespace != "" {
+			namespace = " in namespace " + conflict.Namespace
+		}
+		log.Errorf(
+			"ServicePort %d is advertised by more than one service%s! Proxying it for %s, and not for %s",
+			conflict.ServicePort, namespace, conflict.Owner, strings.Join(conflict.Others, ", "),
+		)
+		metrics.IncrCounter([]string{"services_state", "port_conflicts_found"}, 1)
+	}
+	state.reportedConflicts = current
+
+	metrics.SetGauge([]string{"services_state", "port_conflicts"}, float32(len(conflicts)))
+}
+
+// TrackPortConflicts runs in the background looking for ServicePort
+// conflicts. See EvaluatePortConflicts().
+func (state *ServicesState) TrackPortConflicts(looper director.Looper) {
+	looper.Loop(func() error {
+		state.EvaluatePortConflicts()
+		return nil
+	})
+}
@@ -0,0 +1,94 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_PortConflicts(t *testing.T) {
+	Convey("Finding ServicePort conflicts", t, func() {
+		state := NewServicesState()
+		state.Broadcasts = make(chan [][]byte, 100)
+		baseTime := time.Now().UTC()
+
+		addInstance := func(id string, name string, created time.Time, svcPort int64) *service.Service {
+			state.AddServiceEntry(service.Service{
+				ID: id, Name: name, Hostname: anotherHostname, Status: service.ALIVE,
+				Created: created, Updated: time.Now().UTC(),
+				Ports: []service.Port{{Type: "tcp", Port: 31000, ServicePort: svcPort}},
+			})
+			return state.Servers[anotherHostname].Services[id]
+		}
+
+		addInstance("1", "bocaccio", baseTime, 10100)
+		addInstance("2", "bocaccio", baseTime.Add(time.Minute), 10100)
+		addInstance("3", "petrarch", baseTime, 10101)
+
+		Convey("finds none when every service has its own ports", func() {
+			So(state.PortConflicts(), ShouldBeEmpty)
+		})
+
+		Convey("gives the port to the service that has had it the longest", func() {
+			addInstance("4", "dante", baseTime.Add(time.Hour), 10100)
+			addInstance("5", "chaucer", baseTime.Add(2*time.Hour), 10100)
+
+			So(state.PortConflicts(), ShouldResemble, []PortConflict{
+				{ServicePort: 10100, Owner: "bocaccio", Others: []string{"chaucer", "dante"}},
+			})
+
+			conflicts := state.PortConflictsIn("")
+			So(ConflictLoser(conflicts, "bocaccio", 10100), ShouldBeFalse)
+			So(ConflictLoser(conflicts, "dante", 10100), ShouldBeTrue)
+			So(ConflictLoser(conflicts, "petrarch", 10101), ShouldBeFalse)
+		})
+
+		Convey("ignores tombstoned instances", func() {
+			addInstance("4", "dante", baseTime.Add(time.Hour), 10100).Status = service.TOMBSTONE
+			So(state.PortConflicts(), ShouldBeEmpty)
+		})
+
+		Convey("keeps the port with its owner while the owner is unhealthy", func() {
+			state.Servers[anotherHostname].Services["1"].Status = service.UNHEALTHY
+			state.Servers[anotherHostname].Services["2"].Status = service.UNHEALTHY
+			addInstance("4", "dante", baseTime.Add(time.Hour), 10100)
+
+			So(state.PortConflicts(), ShouldResemble, []PortConflict{
+				{ServicePort: 10100, Owner: "bocaccio", Others: []string{"dante"}},
+			})
+			So(ConflictLoser(state.PortConflictsIn(""), "dante", 10100), ShouldBeTrue)
+		})
+
+		Convey("counts instances the proxies leave out", func() {
+			addInstance("4", "dante", baseTime.Add(-time.Hour), 10100).ProxyDisabled = true
+
+			So(state.PortConflicts(), ShouldResemble, []PortConflict{
+				{ServicePort: 10100, Owner: "dante", Others: []string{"bocaccio"}},
+			})
+		})
+
+		Convey("keeps the namespaces apart", func() {
+			addInstance("4", "dante", baseTime.Add(time.Hour), 10100).Namespace = "staging"
+			So(state.PortConflicts(), ShouldBeEmpty)
+
+			addInstance("5", "chaucer", baseTime, 10100).Namespace = "staging"
+			So(state.PortConflicts(), ShouldResemble, []PortConflict{
+				{ServicePort: 10100, Namespace: "staging", Owner: "chaucer", Others: []string{"dante"}},
+			})
+			So(state.PortConflictsIn(""), ShouldBeEmpty)
+		})
+
+		Convey("reports each conflict once", func() {
+			addInstance("4", "dante", baseTime.Add(time.Hour), 10100)
+
+			state.EvaluatePortConflicts()
+			So(state.reportedConflicts, ShouldHaveLength, 1)
+
+			state.Servers[anotherHostname].Services["4"].Status = service.TOMBSTONE
+			state.EvaluatePortConflicts()
+			So(state.reportedConflicts, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,170 @@
+package catalog
+
+import (
+	"errors"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	REAP_INTERVAL = 5 * time.Second // How often we look for departed hosts to reap
+)
+
+// A ReapPolicy decides how long the services of a host that has gone from
+// the cluster stay in the catalog. A host that left cleanly, because Sidecar
+// was stopped, is handled separately from one that crashed or can't be
+// reached, which might still come back. The grace periods are how long its
+// services stay before they are tombstoned, and the purge periods how long
+// those tombstones are then kept before the host is removed for good.
+type ReapPolicy struct {
+	LeftGrace   time.Duration
+	FailedGrace time.Duration
+	LeftPurge   time.Duration
+	FailedPurge time.Duration
+}
+
+// DefaultReapPolicy tombstones the services of departed hosts right away
+// and keeps the tombstones for the usual TOMBSTONE_LIFESPAN.
+func DefaultReapPolicy() ReapPolicy {
+	return ReapPolicy{
+		LeftPurge:   TOMBSTONE_LIFESPAN,
+		FailedPurge: TOMBSTONE_LIFESPAN,
+	}
+}
+
+// Validate makes sure the policy keeps tombstones long enough to reach the
+// rest of the cluster. Purged any sooner, the services they replace could be
+// merged back in from a node that hasn't heard. Grace periods have to be
+// shorter than the ALIVE_LIFESPAN, because services that aren't heard from
+// are tombstoned then anyway.
+func (p ReapPolicy) Validate() error {
+	if p.LeftGrace < 0 || p.FailedGrace < 0 {
+		return errors.New("grace periods can't be negative")
+	}
+
+	if p.LeftGrace >= ALIVE_LIFESPAN || p.FailedGrace >= ALIVE_LIFESPAN {
+		return errors.New("grace periods must be shorter than " + ALIVE_LIFESPAN.String())
+	}
+
+	if p.LeftPurge < ALIVE_LIFESPAN || p.FailedPurge < ALIVE_LIFESPAN {
+		return errors.New("tombstones must be kept for at least " + ALIVE_LIFESPAN.String())
+	}
+
+	return nil
+}
+
+// A departedHost is one that memberlist told us has gone
+type departedHost struct {
+	graceful bool
+	at       time.Time
+	expired  bool
+}
+
+// SetReapPolicy sets how departed hosts are reaped. See ReapPolicy.
+func (state *ServicesState) SetReapPolicy(policy ReapPolicy) {
+	state.Lock()
+	defer state.Unlock()
+
+	state.reapPolicy = policy
+}
+
+// HostDeparted is called when a host goes from the cluster, having left
+// cleanly or not. Its services are tombstoned once the grace period for that
+// kind of departure is up, unless it comes back first. See HostReturned().
+func (state *ServicesState) HostDeparted(hostname string, graceful bool) {
+	state.Lock()
+	defer state.Unlock()
+
+	state.departedHosts[hostname] = &departedHost{graceful: graceful, at: time.Now().UTC()}
+
+	kind := "failed"
+	if graceful {
+		kind = "left"
+	}
+	metrics.IncrCounter([]string{"services_state", "hosts_departed", kind}, 1)
+
+	if grace := state.reapGrace(graceful); grace > 0 {
+		log.Infof("Host %s %s, tombstoning its services in %s unless it comes back", hostname, kind, grace)
+		return
+	}
+
+	state.reapHost(hostname)
+}
+
+// HostReturned is called when a host joins the cluster, so that if it had
+// departed, it isn't reaped.
+func (state *ServicesState) HostReturned(hostname string) {
+	state.Lock()
+	defer state.Unlock()
+
+	if departed, ok := state.departedHosts[hostname]; ok {
+		if !departed.expired {
+			log.Infof("Host %s came back, keeping its services", hostname)
+		}
+		delete(state.departedHosts, hostname)
+	}
+}
+
+// ReapDepartedHosts tombstones the services of the departed hosts whose
+// grace period is up.
+func (state *ServicesState) ReapDepartedHosts() {
+	state.Lock()
+	defer state.Unlock()
+
+	now := time.Now().UTC()
+	for hostname, departed := range state.departedHosts {
+		if !departed.expired && now.Sub(departed.at) >= state.reapGrace(departed.graceful) {
+			state.reapHost(hostname)
+		}
+	}
+}
+
+// TrackDepartedHosts runs in the background reaping departed hosts. See
+// ReapDepartedHosts().
+func (state *ServicesState) TrackDepartedHosts(looper director.Looper) {
+	looper.Loop(func() error {
+		state.ReapDepartedHosts()
+		return nil
+	})
+}
+
+// reapHost tombstones the services of a departed host. Not synchronized!
+func (state *ServicesState) reapHost(hostname string) {
+	departed, ok := state.departedHosts[hostname]
+	if !ok {
+		return
+	}
+	departed.expired = true
+
+	reason := "Host failed or became unreachable"
+	if departed.graceful {
+		reason = "Host left the cluster"
+	}
+	state.expireServer(hostname, reason)
+}
+
+// reapGrace returns how long a departed host's services are kept before
+// they are tombstoned. Not synchronized!
+func (state *ServicesState) reapGrace(graceful bool) time.Duration {
+	if graceful {
+		return state.reapPolicy.LeftGrace
+	}
+	return state.reapPolicy.FailedGrace
+}
+
+// tombstoneLifespan returns how long to keep the tombstones from a host,
+// which depends on how it departed, if it did. Not synchronized!
+func (state *ServicesState) tombstoneLifespan(hostname string) time.Duration {
+	departed, ok := state.departedHosts[hostname]
+	switch {
+	case !ok:
+		return TOMBSTONE_LIFESPAN
+	case departed.graceful:
+		return state.reapPolicy.LeftPurge
+	default:
+		return state.reapPolicy.FailedPurge
+	}
+}
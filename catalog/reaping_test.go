@@ -0,0 +1,114 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ReapPolicy(t *testing.T) {
+	Convey("Validating a reaping policy", t, func() {
+		policy := DefaultReapPolicy()
+
+		Convey("accepts the default", func() {
+			So(policy.Validate(), ShouldBeNil)
+		})
+
+		Convey("refuses negative grace periods", func() {
+			policy.FailedGrace = -time.Second
+			So(policy.Validate(), ShouldNotBeNil)
+		})
+
+		Convey("refuses grace periods that would outlast the services", func() {
+			policy.FailedGrace = 70 * time.Second
+			So(policy.Validate(), ShouldBeNil)
+
+			policy.LeftGrace = 5 * time.Minute
+			So(policy.Validate(), ShouldNotBeNil)
+		})
+
+		Convey("refuses to purge tombstones before they can get around", func() {
+			policy.LeftPurge = time.Second
+			So(policy.Validate(), ShouldNotBeNil)
+		})
+	})
+}
+
+func Test_ReapingDepartedHosts(t *testing.T) {
+	Convey("Reaping the hosts that leave the cluster", t, func() {
+		state := NewServicesState()
+		state.Broadcasts = make(chan [][]byte, 100)
+		state.tombstoneRetransmit = 1 * time.Nanosecond
+
+		svc := service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: anotherHostname,
+			Status: service.ALIVE, Updated: time.Now().UTC(),
+		}
+		state.AddServiceEntry(svc)
+		instance := state.Servers[anotherHostname].Services[svc.ID]
+
+		Convey("tombstones the services right away by default", func() {
+			state.HostDeparted(anotherHostname, false)
+
+			So(instance.IsTombstone(), ShouldBeTrue)
+			So(state.Departures("")[0].Reason, ShouldEqual, "Host failed or became unreachable")
+		})
+
+		Convey("says when a host left cleanly", func() {
+			state.HostDeparted(anotherHostname, true)
+
+			So(instance.IsTombstone(), ShouldBeTrue)
+			So(state.Departures("")[0].Reason, ShouldEqual, "Host left the cluster")
+		})
+
+		Convey("with a grace period for failed hosts", func() {
+			state.SetReapPolicy(ReapPolicy{
+				FailedGrace: time.Minute, LeftPurge: TOMBSTONE_LIFESPAN, FailedPurge: TOMBSTONE_LIFESPAN,
+			})
+			state.HostDeparted(anotherHostname, false)
+
+			Convey("keeps the services until it's up", func() {
+				state.ReapDepartedHosts()
+				So(instance.IsTombstone(), ShouldBeFalse)
+
+				state.departedHosts[anotherHostname].at = time.Now().UTC().Add(-2 * time.Minute)
+				state.ReapDepartedHosts()
+				So(instance.IsTombstone(), ShouldBeTrue)
+			})
+
+			Convey("forgets a host that comes back", func() {
+				state.HostReturned(anotherHostname)
+				So(state.departedHosts, ShouldBeEmpty)
+
+				state.ReapDepartedHosts()
+				So(instance.IsTombstone(), ShouldBeFalse)
+			})
+
+			Convey("still tombstones hosts that left cleanly right away", func() {
+				state.HostDeparted(anotherHostname, true)
+				So(instance.IsTombstone(), ShouldBeTrue)
+			})
+		})
+
+		Convey("purges the tombstones under the policy for how the host departed", func() {
+			state.SetReapPolicy(ReapPolicy{LeftPurge: time.Hour, FailedPurge: 5 * time.Hour})
+			state.HostDeparted(anotherHostname, true)
+			instance.Updated = time.Now().UTC().Add(-2 * time.Hour)
+
+			state.TombstoneOthersServices()
+			So(state.HasServer(anotherHostname), ShouldBeFalse)
+			So(state.departedHosts, ShouldBeEmpty)
+		})
+
+		Convey("keeps the tombstones of failed hosts for longer when asked", func() {
+			state.SetReapPolicy(ReapPolicy{LeftPurge: time.Hour, FailedPurge: 5 * time.Hour})
+			state.HostDeparted(anotherHostname, false)
+			instance.Updated = time.Now().UTC().Add(-4 * time.Hour)
+
+			state.TombstoneOthersServices()
+			So(state.HasServer(anotherHostname), ShouldBeTrue)
+		})
+	})
+}
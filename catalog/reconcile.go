@@ -0,0 +1,112 @@
+package catalog
+
+import (
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	"github.com/armon/go-metrics"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	RECONCILE_INTERVAL = 1 * time.Minute // How often we compare discovery to the catalog
+)
+
+// Drift counts the differences a reconciliation pass found between the
+// services discovered on this host and what the catalog says it's running.
+type Drift struct {
+	Missing    int // Discovered here, but not alive in the catalog
+	Stale      int // Alive in the catalog, but no longer discovered here
+	Mismatched int // In both places, but with a different status
+}
+
+// Total returns how many services had drifted
+func (d Drift) Total() int {
+	return d.Missing + d.Stale + d.Mismatched
+}
+
+// ReconcileServices runs in the background and periodically makes a full
+// pass comparing the services discovered on this host with the catalog,
+// repairing anything that has drifted. Normally the event handling and the
+// pollers keep the two in step and this finds nothing to do.
+func (state *ServicesState) ReconcileServices(fn func() []service.Service, looper director.Looper) {
+	looper.Loop(func() error {
+		drift := state.Reconcile(fn())
+
+		metrics.SetGauge([]string{"reconcile", "missing"}, float32(drift.Missing))
+		metrics.SetGauge([]string{"reconcile", "stale"}, float32(drift.Stale))
+		metrics.SetGauge([]string{"reconcile", "mismatched"}, float32(drift.Mismatched))
+
+		if drift.Total() > 0 {
+			metrics.IncrCounter([]string{"reconcile", "repairs"}, float32(drift.Total()))
+			log.Warnf(
+				"Reconciliation repaired drift: %d missing, %d stale, %d mismatched",
+				drift.Missing, drift.Stale, drift.Mismatched,
+			)
+		}
+
+		return nil
+	})
+}
+
+// Reconcile compares the discovered services for this host against the
+// catalog and repairs any discrepancies. Missing and mismatched services are
+// re-announced with a fresh timestamp so they win over what the cluster has.
+// Stale ones are tombstoned.
+func (state *ServicesState) Reconcile(discovered []service.Service) Drift {
+	defer metrics.MeasureSince([]string{"services_state", "Reconcile"}, time.Now())
+
+	var drift Drift
+	var repairs []service.Service
+
+	state.Lock()
+	for _, svc := range discovered {
+		var found *service.Service
+		if state.HasServer(svc.Hostname) {
+			found = state.Servers[svc.Hostname].Services[svc.ID]
+		}
+
+		switch {
+		case found == nil && state.overServiceLimit(svc.Hostname):
+			// The catalog rejects it, so it's not missing, and we mustn't
+			// announce it around the limit
+			continue
+		case found == nil || found.IsTombstone():
+			log.Warnf("Reconcile: %s is running but missing from the catalog", svc.ID)
+			drift.Missing++
+		case found.Status == svc.Status:
+			continue
+		case found.Status == service.DRAINING && svc.Status == service.ALIVE:
+			// Draining is set in the catalog and is expected to differ
+			continue
+		default:
+			log.Warnf(
+				"Reconcile: %s is %s but the catalog has %s",
+				svc.ID, svc.StatusString(), found.StatusString(),
+			)
+			drift.Mismatched++
+		}
+
+		svc.Updated = time.Now().UTC()
+		repairs = append(repairs, svc)
+	}
+
+	tombstones := state.TombstoneServices(state.Hostname, discovered)
+	// TombstoneServices doubles each record to help with receipt
+	drift.Stale = len(tombstones) / 2
+	state.Unlock()
+
+	for _, svc := range repairs {
+		state.AddServiceEntry(svc)
+	}
+
+	if len(repairs) > 0 || len(tombstones) > 0 {
+		state.SendServices(
+			append(repairs, tombstones...),
+			director.NewTimedLooper(ALIVE_COUNT, state.tombstoneRetransmit, nil),
+		)
+	}
+
+	return drift
+}
@@ -0,0 +1,121 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	"github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Reconcile(t *testing.T) {
+	Convey("Reconciling discovery with the catalog", t, func() {
+		state := NewServicesState()
+		state.Hostname = hostname
+		state.Broadcasts = make(chan [][]byte, ALIVE_COUNT)
+		state.tombstoneRetransmit = 1 * time.Nanosecond
+		baseTime := time.Now().UTC().Add(-1 * time.Minute)
+
+		newSvc := func(id string, status int) service.Service {
+			return service.Service{
+				ID:       id,
+				Name:     "bocaccio",
+				Image:    "bocaccio:101deadbeef",
+				Hostname: hostname,
+				Updated:  baseTime,
+				Status:   status,
+			}
+		}
+
+		svc1 := newSvc("deadbeef123", service.ALIVE)
+		svc2 := newSvc("deadbeef456", service.ALIVE)
+		state.AddServiceEntry(svc1)
+		state.AddServiceEntry(svc2)
+
+		Convey("finds no drift when the two agree", func() {
+			drift := state.Reconcile([]service.Service{svc1, svc2})
+
+			So(drift.Total(), ShouldEqual, 0)
+			So(len(state.Broadcasts), ShouldEqual, 0)
+		})
+
+		Convey("adds services missing from the catalog", func() {
+			svc3 := newSvc("deadbeef789", service.ALIVE)
+
+			drift := state.Reconcile([]service.Service{svc1, svc2, svc3})
+
+			So(drift.Missing, ShouldEqual, 1)
+			So(drift.Total(), ShouldEqual, 1)
+			So(state.Servers[hostname].HasService(svc3.ID), ShouldBeTrue)
+			So(state.Servers[hostname].Services[svc3.ID].Updated, ShouldHappenAfter, baseTime)
+		})
+
+		Convey("doesn't repair services rejected for being over the limit", func() {
+			state.SetServiceLimit(2)
+			svc3 := newSvc("deadbeef789", service.ALIVE)
+
+			drift := state.Reconcile([]service.Service{svc1, svc2, svc3})
+
+			So(drift.Total(), ShouldEqual, 0)
+			So(state.Servers[hostname].HasService(svc3.ID), ShouldBeFalse)
+			So(len(state.Broadcasts), ShouldEqual, 0)
+		})
+
+		Convey("revives services that were wrongly tombstoned", func() {
+			state.Servers[hostname].Services[svc2.ID].Tombstone()
+
+			drift := state.Reconcile([]service.Service{svc1, svc2})
+
+			So(drift.Missing, ShouldEqual, 1)
+			So(state.Servers[hostname].Services[svc2.ID].Status, ShouldEqual, service.ALIVE)
+		})
+
+		Convey("tombstones services that are no longer discovered", func() {
+			drift := state.Reconcile([]service.Service{svc1})
+
+			So(drift.Stale, ShouldEqual, 1)
+			So(drift.Total(), ShouldEqual, 1)
+			So(state.Servers[hostname].Services[svc2.ID].IsTombstone(), ShouldBeTrue)
+		})
+
+		Convey("fixes services with the wrong status", func() {
+			unhealthy := svc2
+			unhealthy.Status = service.UNHEALTHY
+
+			drift := state.Reconcile([]service.Service{svc1, unhealthy})
+
+			So(drift.Mismatched, ShouldEqual, 1)
+			So(state.Servers[hostname].Services[svc2.ID].Status, ShouldEqual, service.UNHEALTHY)
+		})
+
+		Convey("leaves draining services alone", func() {
+			state.Servers[hostname].Services[svc2.ID].Status = service.DRAINING
+
+			drift := state.Reconcile([]service.Service{svc1, svc2})
+
+			So(drift.Total(), ShouldEqual, 0)
+			So(state.Servers[hostname].Services[svc2.ID].Status, ShouldEqual, service.DRAINING)
+		})
+
+		Convey("broadcasts the repairs", func() {
+			svc3 := newSvc("deadbeef789", service.ALIVE)
+
+			state.Reconcile([]service.Service{svc1, svc3})
+
+			broadcast := <-state.Broadcasts
+			So(len(broadcast), ShouldEqual, 3) // The new one, plus the tombstone twice
+		})
+
+		Convey("runs in a loop", func() {
+			svc3 := newSvc("deadbeef789", service.ALIVE)
+			looper := director.NewFreeLooper(director.ONCE, nil)
+
+			state.ReconcileServices(func() []service.Service {
+				return []service.Service{svc1, svc2, svc3}
+			}, looper)
+
+			So(state.Servers[hostname].HasService(svc3.ID), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,67 @@
+package catalog
+
+import (
+	"github.com/Nitro/sidecar/service"
+	"github.com/armon/go-metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// SetServiceLimit caps how many live services any one host may advertise.
+// New services beyond the limit are rejected, so that a runaway deployment
+// spawning containers on one host can't flood the catalog and the proxies of
+// the whole cluster. Zero means no limit.
+func (state *ServicesState) SetServiceLimit(max int) {
+	state.Lock()
+	defer state.Unlock()
+
+	state.maxServicesPerHost = max
+}
+
+// overServiceLimit reports whether a host is already advertising as many
+// live services as it may. Not synchronized!
+func (state *ServicesState) overServiceLimit(hostname string) bool {
+	if state.maxServicesPerHost <= 0 || !state.HasServer(hostname) {
+		return false
+	}
+
+	var count int
+	for _, svc := range state.Servers[hostname].Services {
+		if !svc.IsTombstone() {
+			count++
+		}
+	}
+
+	return count >= state.maxServicesPerHost
+}
+
+// rejectOverLimit decides whether a service must be rejected because it is
+// new and its host is over the limit. Updates to services we already know
+// about, and tombstones, are always let through. We complain loudly the
+// first time a host hits the limit, and again if it comes back under and
+// then goes over again. Not synchronized!
+func (state *ServicesState) rejectOverLimit(newSvc *service.Service) bool {
+	if newSvc.IsTombstone() {
+		return false
+	}
+
+	if !state.overServiceLimit(newSvc.Hostname) {
+		delete(state.limitedHosts, newSvc.Hostname)
+		return false
+	}
+
+	if state.Servers[newSvc.Hostname].HasService(newSvc.ID) {
+		return false
+	}
+
+	metrics.IncrCounter([]string{"services_state", "rejected_services"}, 1)
+
+	if !state.limitedHosts[newSvc.Hostname] {
+		log.Errorf(
+			"Host %s is advertising more than %d services! Rejecting new ones, starting with %s (%s)",
+			newSvc.Hostname, state.maxServicesPerHost, newSvc.Name, newSvc.ID,
+		)
+		state.limitedHosts[newSvc.Hostname] = true
+	}
+
+	return true
+}
@@ -0,0 +1,90 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	"github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ServiceLimit(t *testing.T) {
+	Convey("Limiting the services per host", t, func() {
+		state := NewServicesState()
+		state.Hostname = hostname
+		state.Broadcasts = make(chan [][]byte, 10)
+		state.SetServiceLimit(2)
+
+		baseTime := time.Now().UTC()
+		newSvc := func(id string, host string) service.Service {
+			return service.Service{ID: id, Hostname: host, Updated: baseTime, Status: service.ALIVE}
+		}
+
+		state.AddServiceEntry(newSvc("deadbeef001", anotherHostname))
+		state.AddServiceEntry(newSvc("deadbeef002", anotherHostname))
+
+		Convey("rejects new services beyond the limit", func() {
+			state.AddServiceEntry(newSvc("deadbeef003", anotherHostname))
+
+			So(len(state.Servers[anotherHostname].Services), ShouldEqual, 2)
+			So(state.Servers[anotherHostname].HasService("deadbeef003"), ShouldBeFalse)
+			So(state.limitedHosts[anotherHostname], ShouldBeTrue)
+		})
+
+		Convey("applies the limit to each host separately", func() {
+			state.AddServiceEntry(newSvc("deadbeef003", hostname))
+
+			So(state.Servers[hostname].HasService("deadbeef003"), ShouldBeTrue)
+		})
+
+		Convey("still accepts updates to services it knows", func() {
+			svc := newSvc("deadbeef001", anotherHostname)
+			svc.Status = service.UNHEALTHY
+			svc.Updated = baseTime.Add(time.Second)
+			state.AddServiceEntry(svc)
+
+			So(state.Servers[anotherHostname].Services["deadbeef001"].Status, ShouldEqual, service.UNHEALTHY)
+		})
+
+		Convey("makes room again when services are tombstoned", func() {
+			tombstone := newSvc("deadbeef001", anotherHostname)
+			tombstone.Tombstone()
+			tombstone.Updated = baseTime.Add(time.Second)
+			state.AddServiceEntry(tombstone)
+
+			state.AddServiceEntry(newSvc("deadbeef003", anotherHostname))
+			So(state.Servers[anotherHostname].HasService("deadbeef003"), ShouldBeTrue)
+			So(state.limitedHosts, ShouldBeEmpty)
+		})
+
+		Convey("doesn't limit anything when set to zero", func() {
+			state.SetServiceLimit(0)
+			state.AddServiceEntry(newSvc("deadbeef003", anotherHostname))
+
+			So(len(state.Servers[anotherHostname].Services), ShouldEqual, 3)
+		})
+	})
+
+	Convey("Our own rejected services aren't broadcast", t, func() {
+		state := NewServicesState()
+		state.Hostname = hostname
+		state.Broadcasts = make(chan [][]byte, 1)
+		state.SetServiceLimit(2)
+
+		newSvc := func(id string) service.Service {
+			return service.Service{ID: id, Hostname: hostname, Updated: time.Now().UTC(), Status: service.ALIVE}
+		}
+
+		state.AddServiceEntry(newSvc("deadbeef101"))
+		state.AddServiceEntry(newSvc("deadbeef102"))
+
+		services := []service.Service{newSvc("deadbeef101"), newSvc("deadbeef102"), newSvc("deadbeef103")}
+		state.BroadcastServices(
+			func() []service.Service { return services },
+			director.NewFreeLooper(director.ONCE, nil),
+		)
+
+		So(len(<-state.Broadcasts), ShouldEqual, 2)
+	})
+}
@@ -34,15 +34,19 @@ const (
 	ALIVE_SLEEP_INTERVAL       = 1 * time.Second                // Sleep between local service checks
 	ALIVE_BROADCAST_INTERVAL   = 1 * time.Minute                // Broadcast Alive messages every minute
 	LISTENER_EVENT_BUFFER_SIZE = 20                             // The number of events that can be buffered in the listener eventChannel
+	EVENT_LOG_SIZE             = 1000                           // The number of past events we keep for replay
 )
 
 // A ChangeEvent represents the time and hostname that was modified and signals a major
 // state change event. It is passed to listeners over the listeners channel in the
 // state object.
+// Events are numbered in sequence so listeners can tell when they missed
+// some, and ask for them again from EventsSince().
 type ChangeEvent struct {
 	Service        service.Service
 	PreviousStatus int
 	Time           time.Time
+	Sequence       uint64 `json:",omitempty"`
 }
 
 // Holds the state about one server in our cluster
@@ -72,10 +76,28 @@ type ServicesState struct {
 	LastChanged         time.Time
 	ClusterName         string
 	Hostname            string
-	Broadcasts          chan [][]byte        `json:"-"`
-	ServiceMsgs         chan service.Service `json:"-"`
+	TrafficSplits       map[string]*TrafficSplit `json:",omitempty"`
+	Pins                map[string]*Pin          `json:",omitempty"`
+	Broadcasts          chan [][]byte            `json:"-"`
+	ServiceMsgs         chan service.Service     `json:"-"`
 	listeners           map[string]Listener
+	eventLog            *EventLog
+	departures          *DepartureLog
+	availability        *AvailabilityTracker
+	outages             *OutageTracker
 	tombstoneRetransmit time.Duration
+	maxServicesPerHost  int
+	limitedHosts        map[string]bool
+	maxChangesPerSecond float64
+	maxChangeBurst      int
+	changeBuckets       map[string]*changeBucket
+	throttledHosts      map[string]bool
+	reportedConflicts   map[string]bool
+	hostPressure        map[string]float64
+	pressureThreshold   float64
+	reapPolicy          ReapPolicy
+	departedHosts       map[string]*departedHost
+	store               *storeWriter
 	sync.RWMutex
 }
 
@@ -96,6 +118,15 @@ func NewServicesState() *ServicesState {
 		tombstoneRetransmit: TOMBSTONE_RETRANSMIT,
 		ServiceMsgs:         make(chan service.Service, 25),
 		listeners:           make(map[string]Listener),
+		limitedHosts:        make(map[string]bool),
+		changeBuckets:       make(map[string]*changeBucket),
+		throttledHosts:      make(map[string]bool),
+		eventLog:            NewEventLog(EVENT_LOG_SIZE),
+		departures:          NewDepartureLog(DEPARTURE_LOG_SIZE),
+		availability:        NewAvailabilityTracker(),
+		outages:             NewOutageTracker(),
+		reapPolicy:          DefaultReapPolicy(),
+		departedHosts:       make(map[string]*departedHost),
 	}
 	state.Hostname, err = os.Hostname()
 	if err != nil {
@@ -151,6 +182,12 @@ func (state *ServicesState) ExpireServer(hostname string) {
 	state.Lock()
 	defer state.Unlock()
 
+	state.expireServer(hostname, "Host left the cluster")
+}
+
+// expireServer tombstones all of a server's records, recording the reason
+// they departed. Not synchronized!
+func (state *ServicesState) expireServer(hostname string, reason string) {
 	if !state.HasServer(hostname) || len(state.Servers[hostname].Services) == 0 {
 		log.Infof("No records to expire for %s", hostname)
 		return
@@ -177,6 +214,7 @@ func (state *ServicesState) ExpireServer(hostname string) {
 		previousStatus := svc.Status
 		svc.Tombstone()
 		state.ServiceChanged(svc, previousStatus, svc.Updated)
+		state.recordDeparture(svc, previousStatus, reason)
 		tombstones = append(tombstones, *svc)
 	}
 
@@ -194,6 +232,7 @@ func (state *ServicesState) ExpireServer(hostname string) {
 // Tell the state that a particular service transitioned from one state to another.
 func (state *ServicesState) ServiceChanged(svc *service.Service, previousStatus int, updated time.Time) {
 	state.serverChanged(svc.Hostname, updated)
+	state.storePut(svc)
 	state.NotifyListeners(svc, previousStatus, state.LastChanged)
 }
 
@@ -217,6 +256,11 @@ func (state *ServicesState) serverChanged(hostname string, updated time.Time) {
 func (state *ServicesState) NotifyListeners(svc *service.Service, previousStatus int, changedTime time.Time) {
 	listeners := state.listeners
 
+	// Events are logged for replay even when nobody is listening right now
+	event := state.eventLog.Append(
+		ChangeEvent{Service: *svc, PreviousStatus: previousStatus, Time: changedTime},
+	)
+
 	if len(listeners) < 1 {
 		log.Debugf("Skipping listeners, there are none")
 		return
@@ -224,7 +268,6 @@ func (state *ServicesState) NotifyListeners(svc *service.Service, previousStatus
 
 	log.Debugf("Notifying listeners of change at %s", changedTime.String())
 
-	event := ChangeEvent{Service: *svc, PreviousStatus: previousStatus, Time: changedTime}
 	for _, listener := range listeners {
 		if listener == nil {
 			continue
@@ -296,6 +339,28 @@ func (state *ServicesState) AddServiceEntry(newSvc service.Service) {
 	state.Lock()
 	defer state.Unlock()
 
+	// Refuse new services from hosts that are advertising too many
+	if state.rejectOverLimit(&newSvc) {
+		return
+	}
+
+	// Drop changes from hosts that are changing their services too fast
+	if state.throttleChange(&newSvc, time.Now().UTC()) {
+		return
+	}
+
+	// A service we don't have in memory may still have a tombstone in the
+	// Store, which it has to be newer than
+	previousStatus := service.UNKNOWN
+	if !state.HasServer(newSvc.Hostname) || !state.Servers[newSvc.Hostname].HasService(newSvc.ID) {
+		if spilled := state.spilledTombstone(newSvc.Hostname, newSvc.ID); spilled != nil {
+			if !newSvc.Invalidates(spilled) {
+				return
+			}
+			previousStatus = spilled.Status
+		}
+	}
+
 	if !state.HasServer(newSvc.Hostname) {
 		state.Servers[newSvc.Hostname] = NewServer(newSvc.Hostname)
 	}
@@ -305,7 +370,7 @@ func (state *ServicesState) AddServiceEntry(newSvc service.Service) {
 	// Only apply changes that are newer or services are missing
 	if !server.HasService(newSvc.ID) {
 		server.Services[newSvc.ID] = &newSvc
-		state.ServiceChanged(&newSvc, service.UNKNOWN, newSvc.Updated)
+		state.ServiceChanged(&newSvc, previousStatus, newSvc.Updated)
 		state.retransmit(newSvc)
 	} else if newSvc.Invalidates(server.Services[newSvc.ID]) {
 		// We have to set these even if the status did not change
@@ -326,6 +391,7 @@ func (state *ServicesState) AddServiceEntry(newSvc service.Service) {
 		// update all the accounting fields in the state and Server newSvc.
 		if oldEntry.Status != newSvc.Status {
 			state.ServiceChanged(&newSvc, oldEntry.Status, newSvc.Updated)
+			state.recordDeparture(&newSvc, oldEntry.Status, departureReason(&newSvc))
 		}
 
 		// We tell our gossip peers about the updated service
@@ -359,6 +425,9 @@ func (state *ServicesState) Merge(otherState *ServicesState) {
 			state.UpdateService(*svc)
 		}
 	}
+
+	state.mergeTrafficSplits(otherState.TrafficSplits)
+	state.mergePins(otherState.Pins)
 }
 
 // Take a service we already handled, and drop it back into the
@@ -527,6 +596,11 @@ func (state *ServicesState) BroadcastServices(fn func() []service.Service, loope
 		for _, svc := range servicesList {
 			isNew := state.IsNewService(&svc)
 
+			// Don't keep announcing services the catalog rejected
+			if isNew && state.overServiceLimit(svc.Hostname) && !state.Servers[svc.Hostname].HasService(svc.ID) {
+				continue
+			}
+
 			// We'll broadcast it now if it's new or we've hit refresh window
 			if isNew {
 				log.Debug("Found service changes in BroadcastServices()")
@@ -632,13 +706,14 @@ func (state *ServicesState) TombstoneOthersServices() []service.Service {
 	// time at all.
 	state.EachService(func(hostname *string, id *string, svc *service.Service) {
 		if svc.IsTombstone() &&
-			svc.Updated.Before(time.Now().UTC().Add(0-TOMBSTONE_LIFESPAN)) {
-			delete(state.Servers[*hostname].Services, *id)
-
-			// If this is the last service, remove the server
-			if len(state.Servers[*hostname].Services) < 1 {
-				delete(state.Servers, *hostname)
-			}
+			svc.Updated.Before(time.Now().UTC().Add(0-state.tombstoneLifespan(*hostname))) {
+			state.forgetService(*hostname, *id)
+			state.storeDelete(*hostname, *id)
+		} else if state.shouldSpill(svc, time.Now().UTC()) {
+			// Keep it only in the Store from here on. Writing it again
+			// makes sure the Store has its latest timestamp.
+			state.storePut(svc)
+			state.forgetService(*hostname, *id)
 		}
 
 		svcLifespan := ALIVE_LIFESPAN
@@ -663,6 +738,7 @@ func (state *ServicesState) TombstoneOthersServices() []service.Service {
 			svc.Status = service.TOMBSTONE
 			svc.Updated = svc.Updated.Add(time.Second)
 			state.ServiceChanged(svc, previousStatus, svc.Updated)
+			state.recordDeparture(svc, previousStatus, fmt.Sprintf("Not heard from in over %s", svcLifespan))
 
 			result = append(result, *svc)
 		}
@@ -671,6 +747,18 @@ func (state *ServicesState) TombstoneOthersServices() []service.Service {
 	return result
 }
 
+// forgetService drops a service from memory, and its server too if this
+// was the last one.
+// Note: not synchronized!
+func (state *ServicesState) forgetService(hostname string, id string) {
+	delete(state.Servers[hostname].Services, id)
+
+	if len(state.Servers[hostname].Services) < 1 {
+		delete(state.Servers, hostname)
+		delete(state.departedHosts, hostname)
+	}
+}
+
 func (state *ServicesState) TombstoneServices(hostname string, containerList []service.Service) []service.Service {
 
 	if !state.HasServer(hostname) {
@@ -692,6 +780,7 @@ func (state *ServicesState) TombstoneServices(hostname string, containerList []s
 			previousStatus := svc.Status
 			svc.Tombstone()
 			state.ServiceChanged(svc, previousStatus, svc.Updated)
+			state.recordDeparture(svc, previousStatus, "Container stopped or no longer discovered")
 
 			// Tombstone each record twice to help with receipt
 			for i := 0; i < 2; i++ {
@@ -736,6 +825,49 @@ func (state *ServicesState) ByService() map[string][]*service.Service {
 	return serviceMap
 }
 
+// ByServiceIn is like ByService but only has the services in the given
+// namespace. Services without one are in the default namespace, "".
+func (state *ServicesState) ByServiceIn(namespace string) map[string][]*service.Service {
+	serviceMap := make(map[string][]*service.Service)
+
+	state.EachServiceSorted(
+		func(hostname *string, serviceId *string, svc *service.Service) {
+			if svc.Namespace == namespace {
+				serviceMap[svc.Name] = append(serviceMap[svc.Name], svc)
+			}
+		},
+	)
+
+	return serviceMap
+}
+
+// A ServiceName identifies a service across namespaces
+type ServiceName struct {
+	Namespace string `json:",omitempty"`
+	Name      string
+}
+
+// String returns the service's name, and its namespace unless it's the default
+func (n ServiceName) String() string {
+	if n.Namespace == "" {
+		return n.Name
+	}
+
+	return n.Name + " in namespace " + n.Namespace
+}
+
+// serviceKey identifies a service across namespaces in the maps we gossip,
+// like the pins and traffic splits. Services in the default namespace are
+// keyed by name alone, as they were before namespaces, so that the pins and
+// splits from older nodes and snapshots still apply to them.
+func serviceKey(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+
+	return namespace + "/" + name
+}
+
 func DecodeStream(input io.Reader, callback func(map[string][]*service.Service, error)) error {
 	dec := json.NewDecoder(input)
 	for dec.More() {
@@ -5,6 +5,7 @@ package catalog
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/Nitro/sidecar/service"
 	fflib "github.com/pquerna/ffjson/fflib/v1"
@@ -57,6 +58,10 @@ func (j *ChangeEvent) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
 		buf.Write(obj)
 
 	}
+	if j.Sequence != 0 {
+		buf.WriteString(`,"Sequence":`)
+		fflib.FormatBits2(buf, uint64(j.Sequence), 10, false)
+	}
 	buf.WriteByte('}')
 	return nil
 }
@@ -70,6 +75,8 @@ const (
 	ffjtChangeEventPreviousStatus
 
 	ffjtChangeEventTime
+
+	ffjtChangeEventSequence
 )
 
 var ffjKeyChangeEventService = []byte("Service")
@@ -78,6 +85,8 @@ var ffjKeyChangeEventPreviousStatus = []byte("PreviousStatus")
 
 var ffjKeyChangeEventTime = []byte("Time")
 
+var ffjKeyChangeEventSequence = []byte("Sequence")
+
 // UnmarshalJSON umarshall json - template of ffjson
 func (j *ChangeEvent) UnmarshalJSON(input []byte) error {
 	fs := fflib.NewFFLexer(input)
@@ -153,6 +162,11 @@ mainparse:
 						currentKey = ffjtChangeEventService
 						state = fflib.FFParse_want_colon
 						goto mainparse
+
+					} else if bytes.Equal(ffjKeyChangeEventSequence, kn) {
+						currentKey = ffjtChangeEventSequence
+						state = fflib.FFParse_want_colon
+						goto mainparse
 					}
 
 				case 'T':
@@ -165,6 +179,12 @@ mainparse:
 
 				}
 
+				if fflib.EqualFoldRight(ffjKeyChangeEventSequence, kn) {
+					currentKey = ffjtChangeEventSequence
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
 				if fflib.SimpleLetterEqualFold(ffjKeyChangeEventTime, kn) {
 					currentKey = ffjtChangeEventTime
 					state = fflib.FFParse_want_colon
@@ -209,6 +229,9 @@ mainparse:
 				case ffjtChangeEventTime:
 					goto handle_Time
 
+				case ffjtChangeEventSequence:
+					goto handle_Sequence
+
 				case ffjtChangeEventnosuchkey:
 					err = fs.SkipField(tok)
 					if err != nil {
@@ -298,6 +321,36 @@ handle_Time:
 	state = fflib.FFParse_after_value
 	goto mainparse
 
+handle_Sequence:
+
+	/* handler: j.Sequence type=uint64 kind=uint64 quoted=false*/
+
+	{
+		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for uint64", tok))
+		}
+	}
+
+	{
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tval, err := fflib.ParseUint(fs.Output.Bytes(), 10, 64)
+
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			j.Sequence = uint64(tval)
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
 wantedvalue:
 	return fs.WrapErr(fmt.Errorf("wanted value token, but got token: %v", tok))
 wrongtokenerror:
@@ -798,6 +851,22 @@ func (j *ServicesState) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
 	fflib.WriteJsonString(buf, string(j.ClusterName))
 	buf.WriteString(`,"Hostname":`)
 	fflib.WriteJsonString(buf, string(j.Hostname))
+	if len(j.TrafficSplits) != 0 {
+		buf.WriteString(`,"TrafficSplits":`)
+		/* Falling back. type=map[string]*catalog.TrafficSplit kind=map */
+		err = buf.Encode(j.TrafficSplits)
+		if err != nil {
+			return err
+		}
+	}
+	if len(j.Pins) != 0 {
+		buf.WriteString(`,"Pins":`)
+		/* Falling back. type=map[string]*catalog.Pin kind=map */
+		err = buf.Encode(j.Pins)
+		if err != nil {
+			return err
+		}
+	}
 	buf.WriteByte('}')
 	return nil
 }
@@ -813,6 +882,10 @@ const (
 	ffjtServicesStateClusterName
 
 	ffjtServicesStateHostname
+
+	ffjtServicesStateTrafficSplits
+
+	ffjtServicesStatePins
 )
 
 var ffjKeyServicesStateServers = []byte("Servers")
@@ -823,6 +896,10 @@ var ffjKeyServicesStateClusterName = []byte("ClusterName")
 
 var ffjKeyServicesStateHostname = []byte("Hostname")
 
+var ffjKeyServicesStateTrafficSplits = []byte("TrafficSplits")
+
+var ffjKeyServicesStatePins = []byte("Pins")
+
 // UnmarshalJSON umarshall json - template of ffjson
 func (j *ServicesState) UnmarshalJSON(input []byte) error {
 	fs := fflib.NewFFLexer(input)
@@ -908,6 +985,14 @@ mainparse:
 						goto mainparse
 					}
 
+				case 'P':
+
+					if bytes.Equal(ffjKeyServicesStatePins, kn) {
+						currentKey = ffjtServicesStatePins
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
 				case 'S':
 
 					if bytes.Equal(ffjKeyServicesStateServers, kn) {
@@ -916,6 +1001,26 @@ mainparse:
 						goto mainparse
 					}
 
+				case 'T':
+
+					if bytes.Equal(ffjKeyServicesStateTrafficSplits, kn) {
+						currentKey = ffjtServicesStateTrafficSplits
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				}
+
+				if fflib.EqualFoldRight(ffjKeyServicesStatePins, kn) {
+					currentKey = ffjtServicesStatePins
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffjKeyServicesStateTrafficSplits, kn) {
+					currentKey = ffjtServicesStateTrafficSplits
+					state = fflib.FFParse_want_colon
+					goto mainparse
 				}
 
 				if fflib.EqualFoldRight(ffjKeyServicesStateHostname, kn) {
@@ -971,6 +1076,12 @@ mainparse:
 				case ffjtServicesStateHostname:
 					goto handle_Hostname
 
+				case ffjtServicesStateTrafficSplits:
+					goto handle_TrafficSplits
+
+				case ffjtServicesStatePins:
+					goto handle_Pins
+
 				case ffjtServicesStatenosuchkey:
 					err = fs.SkipField(tok)
 					if err != nil {
@@ -1167,6 +1278,46 @@ handle_Hostname:
 	state = fflib.FFParse_after_value
 	goto mainparse
 
+handle_TrafficSplits:
+
+	/* handler: j.TrafficSplits type=map[string]*catalog.TrafficSplit kind=map quoted=false*/
+
+	{
+		/* Falling back. type=map[string]*catalog.TrafficSplit kind=map */
+		tbuf, err := fs.CaptureField(tok)
+		if err != nil {
+			return fs.WrapErr(err)
+		}
+
+		err = json.Unmarshal(tbuf, &j.TrafficSplits)
+		if err != nil {
+			return fs.WrapErr(err)
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_Pins:
+
+	/* handler: j.Pins type=map[string]*catalog.Pin kind=map quoted=false*/
+
+	{
+		/* Falling back. type=map[string]*catalog.Pin kind=map */
+		tbuf, err := fs.CaptureField(tok)
+		if err != nil {
+			return fs.WrapErr(err)
+		}
+
+		err = json.Unmarshal(tbuf, &j.Pins)
+		if err != nil {
+			return fs.WrapErr(err)
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
 wantedvalue:
 	return fs.WrapErr(fmt.Errorf("wanted value token, but got token: %v", tok))
 wrongtokenerror:
@@ -0,0 +1,51 @@
+package catalog
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Restore seeds the state from a snapshot of an earlier catalog, as used to
+// bring a cluster back up after an outage. Only services we don't already
+// know about are added, and tombstones are skipped. Restored services are
+// stamped with the current time so they last a full ALIVE_LIFESPAN, giving
+// their hosts time to come back and announce them. Those that don't are
+// expired as usual. Our own services always come from discovery, so they are
+// skipped too. Returns the number of services restored.
+func (state *ServicesState) Restore(snapshot *ServicesState) int {
+	now := time.Now().UTC()
+	var restored int
+
+	for hostname, server := range snapshot.Servers {
+		if hostname == state.Hostname {
+			continue
+		}
+
+		for id, svc := range server.Services {
+			if svc.IsTombstone() {
+				continue
+			}
+
+			state.RLock()
+			known := state.HasServer(hostname) && state.Servers[hostname].HasService(id)
+			state.RUnlock()
+
+			if known {
+				continue
+			}
+
+			restoredSvc := *svc
+			restoredSvc.Updated = now
+			state.AddServiceEntry(restoredSvc)
+			restored++
+		}
+	}
+
+	state.mergeTrafficSplits(snapshot.TrafficSplits)
+	state.mergePins(snapshot.Pins)
+
+	log.Infof("Restored %d services from snapshot", restored)
+
+	return restored
+}
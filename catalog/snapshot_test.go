@@ -0,0 +1,70 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Restore(t *testing.T) {
+	Convey("Restoring from a snapshot", t, func() {
+		state := NewServicesState()
+		state.Hostname = hostname
+		state.Broadcasts = make(chan [][]byte, 10)
+
+		snapshot := NewServicesState()
+		oldTime := time.Now().UTC().Add(-24 * time.Hour)
+
+		newSvc := func(id string, host string, status int) service.Service {
+			return service.Service{
+				ID:       id,
+				Name:     "bocaccio",
+				Image:    "bocaccio:101deadbeef",
+				Hostname: host,
+				Updated:  oldTime,
+				Status:   status,
+			}
+		}
+
+		remote := newSvc("deadbeef123", anotherHostname, service.ALIVE)
+		snapshot.AddServiceEntry(remote)
+
+		Convey("adds services we don't know about", func() {
+			So(state.Restore(snapshot), ShouldEqual, 1)
+
+			restored := state.Servers[anotherHostname].Services[remote.ID]
+			So(restored, ShouldNotBeNil)
+			So(restored.Status, ShouldEqual, service.ALIVE)
+			So(restored.Updated, ShouldHappenAfter, oldTime)
+		})
+
+		Convey("leaves services we already know about alone", func() {
+			current := remote
+			current.Status = service.UNHEALTHY
+			current.Updated = time.Now().UTC()
+			state.AddServiceEntry(current)
+
+			So(state.Restore(snapshot), ShouldEqual, 0)
+			So(state.Servers[anotherHostname].Services[remote.ID].Status, ShouldEqual, service.UNHEALTHY)
+		})
+
+		Convey("skips tombstones and our own services", func() {
+			snapshot.AddServiceEntry(newSvc("deadbeef456", anotherHostname, service.TOMBSTONE))
+			snapshot.AddServiceEntry(newSvc("deadbeef789", hostname, service.ALIVE))
+
+			So(state.Restore(snapshot), ShouldEqual, 1)
+			So(state.HasServer(hostname), ShouldBeFalse)
+		})
+
+		Convey("restores traffic splits", func() {
+			snapshot.TrafficSplits = map[string]*TrafficSplit{
+				"bocaccio": {Weights: map[string]int{"v1": 1}, Updated: oldTime},
+			}
+
+			state.Restore(snapshot)
+			So(state.GetTrafficSplit("", "bocaccio"), ShouldNotBeNil)
+		})
+	})
+}
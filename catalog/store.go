@@ -0,0 +1,311 @@
+package catalog
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	"github.com/armon/go-metrics"
+	"github.com/mohae/deepcopy"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	TOMBSTONE_SPILL_AGE  = 5 * time.Minute  // Tombstones older than this only live in the Store
+	STORE_SWEEP_INTERVAL = 10 * time.Minute // How often to expire tombstones from the Store
+)
+
+// A Store keeps a durable copy of the catalog alongside the in-memory one,
+// so a node that restarts can pick up where it left off instead of waiting
+// for the whole cluster to gossip everything to it again. The state writes
+// through to the Store on every status change, and removes records when
+// their tombstones expire. The writes are queued and made in the
+// background, so a slow disk never holds up the state lock.
+//
+// Tombstones from other hosts make up most of a busy catalog, and are only
+// needed to turn away stale records. Once they have been retransmitted and
+// are older than TOMBSTONE_SPILL_AGE, they are dropped from memory and only
+// kept in the Store, which is checked for them when a service we don't have
+// comes in. Get returns nil, and no error, for a service it doesn't have.
+type Store interface {
+	Put(svc *service.Service) error
+	Get(hostname string, id string) (*service.Service, error)
+	Delete(hostname string, id string) error
+	Each(fn func(svc *service.Service)) error
+	Close() error
+}
+
+// A storeWrite is a pending Put of svc, or a Delete when svc is nil
+type storeWrite struct {
+	hostname string
+	id       string
+	svc      *service.Service
+}
+
+// A storeWriter makes the writes to a Store in the background. Only the
+// latest write for each service is kept, so a service that changes several
+// times while the Store is busy only gets written once.
+type storeWriter struct {
+	store     Store
+	pending   map[string]*storeWrite
+	flushing  map[string]*storeWrite
+	ready     chan struct{}
+	flushLock sync.Mutex
+	sync.Mutex
+}
+
+func newStoreWriter(store Store) *storeWriter {
+	return &storeWriter{
+		store:   store,
+		pending: make(map[string]*storeWrite),
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+// queue adds a write, replacing any pending one for the same service
+func (w *storeWriter) queue(write *storeWrite) {
+	w.Lock()
+	w.pending[write.hostname+"/"+write.id] = write
+	w.Unlock()
+
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+// run flushes the pending writes whenever there are some
+func (w *storeWriter) run() {
+	for range w.ready {
+		w.flush()
+	}
+}
+
+// flush makes all the pending writes. Errors are logged and counted, but
+// never stop the in-memory state from changing.
+func (w *storeWriter) flush() {
+	w.flushLock.Lock()
+	defer w.flushLock.Unlock()
+
+	w.Lock()
+	pending := w.pending
+	w.pending = make(map[string]*storeWrite)
+	w.flushing = pending
+	w.Unlock()
+
+	defer func() {
+		w.Lock()
+		w.flushing = nil
+		w.Unlock()
+	}()
+
+	for _, write := range pending {
+		if write.svc == nil {
+			err := w.store.Delete(write.hostname, write.id)
+			if err != nil {
+				log.Errorf("Failed to delete service %s from %s in store: %s", write.id, write.hostname, err)
+				metrics.IncrCounter([]string{"services_state", "store_errors"}, 1)
+			}
+			continue
+		}
+
+		err := w.store.Put(write.svc)
+		if err != nil {
+			log.Errorf("Failed to store service %s from %s: %s", write.id, write.hostname, err)
+			metrics.IncrCounter([]string{"services_state", "store_errors"}, 1)
+		}
+	}
+}
+
+// get returns the latest copy of a service, from the writes that haven't
+// been made yet if there is one, or nil if it's been deleted
+func (w *storeWriter) get(hostname string, id string) (*service.Service, error) {
+	key := hostname + "/" + id
+
+	w.Lock()
+	write, ok := w.pending[key]
+	if !ok {
+		write, ok = w.flushing[key]
+	}
+	w.Unlock()
+
+	if ok {
+		return write.svc, nil
+	}
+
+	return w.store.Get(hostname, id)
+}
+
+// sweep deletes the tombstones that expired before cutoff. Those spilled
+// from memory, and any left from before a restart, are only ever removed
+// here. A service with a write pending is left for that write to update.
+func (w *storeWriter) sweep(cutoff time.Time) {
+	w.flushLock.Lock()
+	defer w.flushLock.Unlock()
+
+	var expired []*service.Service
+	err := w.store.Each(func(svc *service.Service) {
+		if svc.IsTombstone() && svc.Updated.Before(cutoff) {
+			expired = append(expired, svc)
+		}
+	})
+	if err != nil {
+		log.Errorf("Failed to read the store to expire tombstones: %s", err)
+		metrics.IncrCounter([]string{"services_state", "store_errors"}, 1)
+		return
+	}
+
+	for _, svc := range expired {
+		w.Lock()
+		_, pending := w.pending[svc.Hostname+"/"+svc.ID]
+		w.Unlock()
+
+		if pending {
+			continue
+		}
+
+		err := w.store.Delete(svc.Hostname, svc.ID)
+		if err != nil {
+			log.Errorf("Failed to delete service %s from %s in store: %s", svc.ID, svc.Hostname, err)
+			metrics.IncrCounter([]string{"services_state", "store_errors"}, 1)
+		}
+	}
+}
+
+// SetStore attaches a Store that every status change will be written to,
+// starting with the services already in the catalog.
+func (state *ServicesState) SetStore(store Store) {
+	state.Lock()
+	defer state.Unlock()
+
+	state.store = newStoreWriter(store)
+	go state.store.run()
+
+	// A Store attached after we've started still gets everything we know
+	state.EachService(func(hostname *string, id *string, svc *service.Service) {
+		state.storePut(svc)
+	})
+}
+
+// FlushStore waits until every change so far has been written to the Store.
+// Call it before closing the Store.
+func (state *ServicesState) FlushStore() {
+	state.RLock()
+	writer := state.store
+	state.RUnlock()
+
+	if writer != nil {
+		writer.flush()
+	}
+}
+
+// LoadStore seeds the state from the services in the Store, the same way as
+// Restore() does from a snapshot. Returns the number of services loaded.
+func (state *ServicesState) LoadStore() (int, error) {
+	state.RLock()
+	writer := state.store
+	state.RUnlock()
+
+	if writer == nil {
+		return 0, nil
+	}
+
+	stored := NewServicesState()
+	err := writer.store.Each(func(svc *service.Service) {
+		if !stored.HasServer(svc.Hostname) {
+			stored.Servers[svc.Hostname] = NewServer(svc.Hostname)
+		}
+		stored.Servers[svc.Hostname].Services[svc.ID] = svc
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return state.Restore(stored), nil
+}
+
+// SweepStore runs in the background, expiring the tombstones in the Store
+// that are no longer kept in memory.
+func (state *ServicesState) SweepStore(looper director.Looper) {
+	looper.Loop(func() error {
+		state.sweepStore(time.Now().UTC())
+		return nil
+	})
+}
+
+// sweepStore expires the tombstones in the Store that are older than the
+// longest anyone's are kept for
+func (state *ServicesState) sweepStore(now time.Time) {
+	state.RLock()
+	writer := state.store
+	lifespan := TOMBSTONE_LIFESPAN
+	for _, purge := range []time.Duration{state.reapPolicy.LeftPurge, state.reapPolicy.FailedPurge} {
+		if purge > lifespan {
+			lifespan = purge
+		}
+	}
+	state.RUnlock()
+
+	if writer != nil {
+		writer.sweep(now.Add(-lifespan))
+	}
+}
+
+// spilledTombstone returns the tombstone for a service from another host
+// that was spilled to the Store, or nil if there isn't one.
+// Note: not synchronized!
+func (state *ServicesState) spilledTombstone(hostname string, id string) *service.Service {
+	if state.store == nil || hostname == state.Hostname {
+		return nil
+	}
+
+	svc, err := state.store.get(hostname, id)
+	if err != nil {
+		log.Errorf("Failed to look up service %s from %s in store: %s", id, hostname, err)
+		metrics.IncrCounter([]string{"services_state", "store_errors"}, 1)
+		return nil
+	}
+
+	if svc == nil || !svc.IsTombstone() {
+		return nil
+	}
+
+	return svc
+}
+
+// shouldSpill says whether a service can be dropped from memory and only
+// kept in the Store. Only other hosts' tombstones are, once they have been
+// retransmitted; we need our own to know what we've already announced.
+// Note: not synchronized!
+func (state *ServicesState) shouldSpill(svc *service.Service, now time.Time) bool {
+	return state.store != nil &&
+		svc.IsTombstone() &&
+		svc.Hostname != state.Hostname &&
+		svc.Updated.Before(now.Add(-TOMBSTONE_SPILL_AGE))
+}
+
+// storePut queues a copy of a service to be written to the Store, if there
+// is one.
+// Note: not synchronized!
+func (state *ServicesState) storePut(svc *service.Service) {
+	if state.store == nil {
+		return
+	}
+
+	state.store.queue(&storeWrite{
+		hostname: svc.Hostname,
+		id:       svc.ID,
+		svc:      deepcopy.Copy(svc).(*service.Service),
+	})
+}
+
+// storeDelete queues the removal of a service from the Store, if there is one.
+// Note: not synchronized!
+func (state *ServicesState) storeDelete(hostname string, id string) {
+	if state.store == nil {
+		return
+	}
+
+	state.store.queue(&storeWrite{hostname: hostname, id: id})
+}
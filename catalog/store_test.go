@@ -0,0 +1,306 @@
+package catalog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_BoltStore(t *testing.T) {
+	Convey("BoltStore", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-store")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+
+		path := filepath.Join(dir, "state.db")
+		store, err := NewBoltStore(path)
+		So(err, ShouldBeNil)
+
+		svc := &service.Service{
+			ID:       "deadbeef123",
+			Name:     "bocaccio",
+			Hostname: anotherHostname,
+			Status:   service.ALIVE,
+		}
+
+		stored := func(store *BoltStore) []*service.Service {
+			var services []*service.Service
+			So(store.Each(func(svc *service.Service) { services = append(services, svc) }), ShouldBeNil)
+			return services
+		}
+
+		Convey("stores and replaces services", func() {
+			So(store.Put(svc), ShouldBeNil)
+			svc.Status = service.DRAINING
+			So(store.Put(svc), ShouldBeNil)
+
+			services := stored(store)
+			So(len(services), ShouldEqual, 1)
+			So(services[0].ID, ShouldEqual, svc.ID)
+			So(services[0].Status, ShouldEqual, service.DRAINING)
+			So(store.Close(), ShouldBeNil)
+		})
+
+		Convey("deletes services", func() {
+			So(store.Put(svc), ShouldBeNil)
+			So(store.Delete(svc.Hostname, svc.ID), ShouldBeNil)
+			So(store.Delete(svc.Hostname, "missing"), ShouldBeNil)
+
+			So(stored(store), ShouldBeEmpty)
+			So(store.Close(), ShouldBeNil)
+		})
+
+		Convey("keeps services across a reopen", func() {
+			So(store.Put(svc), ShouldBeNil)
+			So(store.Close(), ShouldBeNil)
+
+			store, err = NewBoltStore(path)
+			So(err, ShouldBeNil)
+			So(len(stored(store)), ShouldEqual, 1)
+			So(store.Close(), ShouldBeNil)
+		})
+	})
+}
+
+func Test_StateStore(t *testing.T) {
+	Convey("A state with a Store", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-store")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+
+		path := filepath.Join(dir, "state.db")
+		store, err := NewBoltStore(path)
+		So(err, ShouldBeNil)
+
+		state := NewServicesState()
+		state.Hostname = hostname
+		state.Broadcasts = make(chan [][]byte, 10)
+		state.SetStore(store)
+
+		oldTime := time.Now().UTC().Add(-1 * time.Hour)
+		remote := service.Service{
+			ID:       "deadbeef123",
+			Name:     "bocaccio",
+			Hostname: anotherHostname,
+			Updated:  oldTime,
+			Status:   service.ALIVE,
+		}
+		state.AddServiceEntry(remote)
+
+		Convey("writes new services through to it", func() {
+			state.FlushStore()
+
+			var ids []string
+			So(store.Each(func(svc *service.Service) { ids = append(ids, svc.ID) }), ShouldBeNil)
+			So(ids, ShouldResemble, []string{remote.ID})
+			So(store.Close(), ShouldBeNil)
+		})
+
+		Convey("writes status changes through to it", func() {
+			state.ExpireServer(anotherHostname)
+			state.FlushStore()
+
+			var status int
+			So(store.Each(func(svc *service.Service) { status = svc.Status }), ShouldBeNil)
+			So(status, ShouldEqual, service.TOMBSTONE)
+			So(store.Close(), ShouldBeNil)
+		})
+
+		Convey("removes expired tombstones from it", func() {
+			state.Lock()
+			svc := state.Servers[anotherHostname].Services[remote.ID]
+			svc.Status = service.TOMBSTONE
+			svc.Updated = time.Now().UTC().Add(-TOMBSTONE_LIFESPAN - time.Minute)
+			state.TombstoneOthersServices()
+			state.Unlock()
+			state.FlushStore()
+
+			var count int
+			So(store.Each(func(svc *service.Service) { count++ }), ShouldBeNil)
+			So(count, ShouldEqual, 0)
+			So(store.Close(), ShouldBeNil)
+		})
+
+		Convey("spills older tombstones from other hosts to it", func() {
+			spilledAt := time.Now().UTC().Add(-TOMBSTONE_SPILL_AGE - time.Minute)
+			state.Lock()
+			svc := state.Servers[anotherHostname].Services[remote.ID]
+			svc.Status = service.TOMBSTONE
+			svc.Updated = spilledAt
+			state.TombstoneOthersServices()
+			state.Unlock()
+			state.FlushStore()
+			Reset(func() { store.Close() })
+
+			So(state.HasServer(anotherHostname), ShouldBeFalse)
+
+			stored, err := store.Get(anotherHostname, remote.ID)
+			So(err, ShouldBeNil)
+			So(stored.Status, ShouldEqual, service.TOMBSTONE)
+			So(stored.Updated, ShouldEqual, spilledAt)
+
+			Convey("and doesn't let older records bring them back", func() {
+				stale := remote
+				stale.Updated = spilledAt.Add(-time.Second)
+				state.AddServiceEntry(stale)
+
+				So(state.HasServer(anotherHostname), ShouldBeFalse)
+			})
+
+			Convey("but takes newer ones", func() {
+				newer := remote
+				newer.Updated = time.Now().UTC()
+				state.AddServiceEntry(newer)
+
+				So(state.HasServer(anotherHostname), ShouldBeTrue)
+				So(state.Servers[anotherHostname].Services[remote.ID].Status, ShouldEqual, service.ALIVE)
+			})
+
+			Convey("and sweeps them once they expire", func() {
+				state.sweepStore(time.Now().UTC())
+				stored, err := store.Get(anotherHostname, remote.ID)
+				So(err, ShouldBeNil)
+				So(stored, ShouldNotBeNil)
+
+				state.sweepStore(time.Now().UTC().Add(TOMBSTONE_LIFESPAN))
+				stored, err = store.Get(anotherHostname, remote.ID)
+				So(err, ShouldBeNil)
+				So(stored, ShouldBeNil)
+			})
+		})
+
+		Convey("keeps our own tombstones in memory", func() {
+			local := remote
+			local.ID = "cafebabe456"
+			local.Hostname = hostname
+			local.Status = service.TOMBSTONE
+			local.Updated = time.Now().UTC().Add(-TOMBSTONE_SPILL_AGE - time.Minute)
+			state.AddServiceEntry(local)
+
+			state.Lock()
+			state.TombstoneOthersServices()
+			state.Unlock()
+			So(store.Close(), ShouldBeNil)
+
+			So(state.Servers[hostname].HasService(local.ID), ShouldBeTrue)
+		})
+
+		Convey("is seeded from it on restart", func() {
+			state.FlushStore()
+			So(store.Close(), ShouldBeNil)
+
+			store, err = NewBoltStore(path)
+			So(err, ShouldBeNil)
+			Reset(func() { store.Close() })
+
+			restarted := NewServicesState()
+			restarted.Hostname = hostname
+			restarted.Broadcasts = make(chan [][]byte, 10)
+			restarted.SetStore(store)
+
+			loaded, err := restarted.LoadStore()
+			So(err, ShouldBeNil)
+			So(loaded, ShouldEqual, 1)
+
+			svc := restarted.Servers[anotherHostname].Services[remote.ID]
+			So(svc, ShouldNotBeNil)
+			So(svc.Updated, ShouldHappenAfter, oldTime)
+		})
+
+		Convey("writes out what it already had when it's attached late", func() {
+			state.FlushStore()
+			So(store.Close(), ShouldBeNil)
+
+			store, err = NewBoltStore(filepath.Join(dir, "late.db"))
+			So(err, ShouldBeNil)
+			Reset(func() { store.Close() })
+
+			state.SetStore(store)
+			state.FlushStore()
+
+			stored, err := store.Get(anotherHostname, remote.ID)
+			So(err, ShouldBeNil)
+			So(stored, ShouldNotBeNil)
+		})
+
+		Convey("loads nothing without one", func() {
+			So(store.Close(), ShouldBeNil)
+
+			loaded, err := NewServicesState().LoadStore()
+			So(err, ShouldBeNil)
+			So(loaded, ShouldEqual, 0)
+		})
+	})
+}
+
+// A blockingStore holds up every Put until it is released
+type blockingStore struct {
+	release chan struct{}
+	puts    chan *service.Service
+}
+
+func (s *blockingStore) Put(svc *service.Service) error {
+	s.puts <- svc
+	<-s.release
+	return nil
+}
+
+func (s *blockingStore) Get(hostname string, id string) (*service.Service, error) { return nil, nil }
+func (s *blockingStore) Delete(hostname string, id string) error                  { return nil }
+func (s *blockingStore) Each(fn func(svc *service.Service)) error                 { return nil }
+func (s *blockingStore) Close() error                                             { return nil }
+
+func Test_StoreWriter(t *testing.T) {
+	Convey("Writing to a Store", t, func() {
+		store := &blockingStore{
+			release: make(chan struct{}),
+			puts:    make(chan *service.Service, 10),
+		}
+
+		state := NewServicesState()
+		state.Hostname = hostname
+		state.Broadcasts = make(chan [][]byte, 10)
+		state.SetStore(store)
+
+		svc := service.Service{
+			ID:       "deadbeef123",
+			Name:     "bocaccio",
+			Hostname: anotherHostname,
+			Updated:  time.Now().UTC(),
+			Status:   service.ALIVE,
+		}
+		state.AddServiceEntry(svc)
+
+		Convey("doesn't hold the state lock", func() {
+			written := <-store.puts
+
+			state.Lock()
+			state.Servers[anotherHostname].Services[svc.ID].Status = service.DRAINING
+			state.Unlock()
+
+			So(written.Status, ShouldEqual, service.ALIVE)
+			close(store.release)
+		})
+
+		Convey("only keeps the latest write for each service", func() {
+			<-store.puts
+
+			for _, status := range []int{service.UNHEALTHY, service.DRAINING} {
+				svc.Status = status
+				svc.Updated = svc.Updated.Add(time.Second)
+				state.AddServiceEntry(svc)
+			}
+
+			close(store.release)
+			state.FlushStore()
+
+			So(len(store.puts), ShouldEqual, 1)
+			So((<-store.puts).Status, ShouldEqual, service.DRAINING)
+		})
+	})
+}
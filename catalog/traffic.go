@@ -0,0 +1,135 @@
+package catalog
+
+import (
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+)
+
+// A TrafficSplit sets how much of a service's traffic each deployed version
+// receives. Versions come from the SidecarVersion label on the service, or
+// from the image tag when there is no label. The weights are relative to each
+// other, so 90/10 and 9/1 split traffic the same way. A split with no weights
+// clears any earlier one.
+type TrafficSplit struct {
+	Weights map[string]int
+	Updated time.Time
+}
+
+// IsEmpty reports whether the split no longer applies any weights
+func (split *TrafficSplit) IsEmpty() bool {
+	return split == nil || len(split.Weights) == 0
+}
+
+// SetTrafficSplit stores the traffic split for a service in a namespace if it
+// is newer than the one we know about, and tells our listeners so that the
+// proxies pick it up. Splits reach the rest of the cluster with the state
+// during anti-entropy syncs. Returns true when the split was applied.
+func (state *ServicesState) SetTrafficSplit(namespace string, name string, split *TrafficSplit) bool {
+	return state.setTrafficSplit(serviceKey(namespace, name), split)
+}
+
+// setTrafficSplit stores a traffic split by its service key. See
+// SetTrafficSplit().
+func (state *ServicesState) setTrafficSplit(key string, split *TrafficSplit) bool {
+	state.Lock()
+	defer state.Unlock()
+
+	if current, ok := state.TrafficSplits[key]; ok && !split.Updated.After(current.Updated) {
+		return false
+	}
+
+	if state.TrafficSplits == nil {
+		state.TrafficSplits = make(map[string]*TrafficSplit)
+	}
+	state.TrafficSplits[key] = split
+	state.LastChanged = time.Now().UTC()
+	state.notifyServiceKey(key)
+
+	return true
+}
+
+// notifyServiceKey tells our listeners that something changed about how a
+// service is proxied. Listeners are notified about a service, so we send them
+// any instance of this one. If there are none, there's nothing for the
+// proxies to do.
+// Note: not synchronized!
+func (state *ServicesState) notifyServiceKey(key string) {
+	var instance *service.Service
+	state.EachService(func(hostname *string, id *string, svc *service.Service) {
+		if instance == nil && serviceKey(svc.Namespace, svc.Name) == key {
+			instance = svc
+		}
+	})
+
+	if instance != nil {
+		state.NotifyListeners(instance, instance.Status, state.LastChanged)
+	}
+}
+
+// GetTrafficSplit returns the current traffic split for a service in a
+// namespace, or nil if it doesn't have one.
+func (state *ServicesState) GetTrafficSplit(namespace string, name string) *TrafficSplit {
+	state.RLock()
+	defer state.RUnlock()
+
+	split := state.TrafficSplits[serviceKey(namespace, name)]
+	if split.IsEmpty() {
+		return nil
+	}
+
+	return split
+}
+
+// TrafficWeight returns the weight a proxy should give a single instance of a
+// service, from 0 up to maxWeight, so that each version gets its share of the
+// traffic split no matter how many instances it has. Returns -1 when the
+// service has no traffic split. Versions that aren't in the split get no
+// traffic. The caller must hold the state lock.
+func (state *ServicesState) TrafficWeight(svc *service.Service, maxWeight int) int {
+	split := state.TrafficSplits[serviceKey(svc.Namespace, svc.Name)]
+	if split.IsEmpty() {
+		return -1
+	}
+
+	weight := split.Weights[svc.Version()]
+	if weight <= 0 {
+		return 0
+	}
+
+	var total int
+	for _, w := range split.Weights {
+		if w > 0 {
+			total += w
+		}
+	}
+
+	// Spread the version's share across all of its proxied instances
+	var instances int
+	state.EachService(func(hostname *string, id *string, other *service.Service) {
+		if other.Name == svc.Name && other.Namespace == svc.Namespace &&
+			other.Version() == svc.Version() && other.IsProxied() {
+			instances++
+		}
+	})
+
+	if instances < 1 {
+		instances = 1
+	}
+
+	scaled := weight * maxWeight / (total * instances)
+	if scaled < 1 {
+		return 1
+	}
+
+	return scaled
+}
+
+// mergeTrafficSplits takes any traffic splits that are newer than ours
+func (state *ServicesState) mergeTrafficSplits(splits map[string]*TrafficSplit) {
+	for key, split := range splits {
+		if split != nil {
+			state.setTrafficSplit(key, split)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_TrafficSplits(t *testing.T) {
+	Convey("Traffic splits", t, func() {
+		state := NewServicesState()
+		baseTime := time.Now().UTC()
+
+		newSvc := func(id string, version string) service.Service {
+			return service.Service{
+				ID:             id,
+				Name:           "bocaccio",
+				Image:          "bocaccio:101deadbeef",
+				Hostname:       "chaucer",
+				Updated:        baseTime,
+				Status:         service.ALIVE,
+				SidecarVersion: version,
+			}
+		}
+
+		svc1 := newSvc("deadbeef001", "blue")
+		svc2 := newSvc("deadbeef002", "blue")
+		svc3 := newSvc("deadbeef003", "green")
+		for _, svc := range []service.Service{svc1, svc2, svc3} {
+			state.AddServiceEntry(svc)
+		}
+
+		split := &TrafficSplit{
+			Weights: map[string]int{"blue": 80, "green": 20},
+			Updated: baseTime,
+		}
+
+		Convey("SetTrafficSplit()", func() {
+			Convey("stores the split", func() {
+				So(state.SetTrafficSplit("", "bocaccio", split), ShouldBeTrue)
+				So(state.GetTrafficSplit("", "bocaccio"), ShouldEqual, split)
+			})
+
+			Convey("ignores splits older than the one we have", func() {
+				state.SetTrafficSplit("", "bocaccio", split)
+
+				older := &TrafficSplit{
+					Weights: map[string]int{"blue": 1},
+					Updated: baseTime.Add(-1 * time.Second),
+				}
+				So(state.SetTrafficSplit("", "bocaccio", older), ShouldBeFalse)
+				So(state.GetTrafficSplit("", "bocaccio"), ShouldEqual, split)
+			})
+
+			Convey("updates LastChanged", func() {
+				state.SetTrafficSplit("", "bocaccio", split)
+				So(state.LastChanged.After(baseTime), ShouldBeTrue)
+			})
+
+			Convey("clears the split when given no weights", func() {
+				state.SetTrafficSplit("", "bocaccio", split)
+				state.SetTrafficSplit("", "bocaccio", &TrafficSplit{Updated: baseTime.Add(time.Second)})
+				So(state.GetTrafficSplit("", "bocaccio"), ShouldBeNil)
+			})
+		})
+
+		Convey("TrafficWeight()", func() {
+			Convey("returns -1 when there is no split", func() {
+				So(state.TrafficWeight(&svc1, 100), ShouldEqual, -1)
+			})
+
+			Convey("spreads each version's share across its instances", func() {
+				state.SetTrafficSplit("", "bocaccio", split)
+				So(state.TrafficWeight(&svc1, 100), ShouldEqual, 40)
+				So(state.TrafficWeight(&svc2, 100), ShouldEqual, 40)
+				So(state.TrafficWeight(&svc3, 100), ShouldEqual, 20)
+			})
+
+			Convey("sends no traffic to versions outside the split", func() {
+				split.Weights = map[string]int{"green": 1}
+				state.SetTrafficSplit("", "bocaccio", split)
+				So(state.TrafficWeight(&svc1, 100), ShouldEqual, 0)
+				So(state.TrafficWeight(&svc3, 100), ShouldEqual, 100)
+			})
+
+			Convey("never rounds a version down to no traffic", func() {
+				split.Weights = map[string]int{"blue": 1000, "green": 1}
+				state.SetTrafficSplit("", "bocaccio", split)
+				So(state.TrafficWeight(&svc3, 100), ShouldEqual, 1)
+			})
+
+			Convey("only applies the split to the service in its namespace", func() {
+				staging := newSvc("deadbeef004", "blue")
+				staging.Namespace = "staging"
+				state.AddServiceEntry(staging)
+
+				state.SetTrafficSplit("staging", "bocaccio", split)
+				So(state.TrafficWeight(&svc1, 100), ShouldEqual, -1)
+				So(state.TrafficWeight(&staging, 100), ShouldEqual, 80)
+			})
+
+			Convey("uses the image tag when there is no version label", func() {
+				svc4 := newSvc("deadbeef004", "")
+				state.AddServiceEntry(svc4)
+				split.Weights = map[string]int{"101deadbeef": 1}
+				state.SetTrafficSplit("", "bocaccio", split)
+				So(state.TrafficWeight(&svc4, 100), ShouldEqual, 100)
+			})
+		})
+
+		Convey("Splits survive encoding and merging the state", func() {
+			state.SetTrafficSplit("", "bocaccio", split)
+
+			decoded, err := Decode(state.Encode())
+			So(err, ShouldBeNil)
+
+			otherState := NewServicesState()
+			otherState.Merge(decoded)
+			So(otherState.GetTrafficSplit("", "bocaccio"), ShouldNotBeNil)
+			So(otherState.GetTrafficSplit("", "bocaccio").Weights, ShouldResemble, split.Weights)
+		})
+	})
+}
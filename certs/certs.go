@@ -0,0 +1,242 @@
+// The certs package obtains and renews TLS certificates from an ACME provider
+// like Let's Encrypt for the hostnames that services ask to be served on, and
+// writes them out where the proxy can load them.
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// A Manager keeps a certificate for every TLS hostname in the catalog.
+// Certificates come from the ACME provider using the HTTP-01 challenge,
+// which the proxy must route to our HTTPHandler. They are cached in the
+// CacheDir, and written to the CertDir as one PEM bundle per hostname,
+// which is the layout HAProxy's "crt <dir>" expects.
+//
+// Only the Issuer requests certificates. The other edge nodes share its
+// CacheDir and pick up the certificates, and the challenge tokens, from
+// there.
+type Manager struct {
+	CertDir  string
+	Issuer   bool   // Request certificates, rather than just reading the cache
+	OnChange func() // Called after any certificate was written
+	state    *catalog.ServicesState
+	acme     *autocert.Manager
+	written  map[string][]byte
+}
+
+func NewManager(state *catalog.ServicesState, cacheDir string, certDir string, email string, directoryURL string) *Manager {
+	m := &Manager{
+		CertDir: certDir,
+		state:   state,
+		written: make(map[string][]byte),
+	}
+
+	m.acme = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cacheDir),
+		HostPolicy: m.hostPolicy,
+		Email:      email,
+	}
+
+	if directoryURL == "" {
+		directoryURL = autocert.DefaultACMEDirectory
+	}
+	m.acme.Client = &acme.Client{DirectoryURL: directoryURL}
+
+	return m
+}
+
+// HTTPHandler answers the ACME provider's HTTP-01 challenges. It must be
+// reachable at /.well-known/acme-challenge/ on port 80 of each hostname.
+func (m *Manager) HTTPHandler() http.Handler {
+	return m.acme.HTTPHandler(nil)
+}
+
+// Hosts returns the sorted list of TLS hostnames for all the live services
+// in the catalog.
+func (m *Manager) Hosts() []string {
+	m.state.RLock()
+	defer m.state.RUnlock()
+
+	return TLSHosts(m.state)
+}
+
+// TLSHosts returns the sorted list of TLS hostnames for all the live
+// services in the state. The caller must hold the state lock.
+func TLSHosts(state *catalog.ServicesState) []string {
+	seen := make(map[string]bool)
+	state.EachService(func(hostname *string, id *string, svc *service.Service) {
+		if !svc.IsProxied() {
+			return
+		}
+		for _, host := range svc.TLSHosts {
+			seen[strings.ToLower(host)] = true
+		}
+	})
+
+	var hosts []string
+	for host := range seen {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	return hosts
+}
+
+// hostPolicy only lets us request certificates for hostnames in the catalog
+func (m *Manager) hostPolicy(ctx context.Context, host string) error {
+	for _, known := range m.Hosts() {
+		if known == strings.ToLower(host) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("No service is configured for TLS host %s", host)
+}
+
+// Run makes sure we have a current certificate for each hostname on every
+// pass of the looper. The ACME manager renews certificates ahead of their
+// expiry, so this also picks up renewals.
+func (m *Manager) Run(looper director.Looper) {
+	looper.Loop(func() error {
+		changed := false
+
+		for _, host := range m.Hosts() {
+			cert, err := m.certificate(host)
+			if err != nil {
+				log.Warnf("Unable to get TLS certificate for %s: %s", host, err)
+				continue
+			}
+
+			wrote, err := m.writeCert(host, cert)
+			if err != nil {
+				log.Errorf("Unable to write TLS certificate for %s: %s", host, err)
+				continue
+			}
+
+			changed = changed || wrote
+		}
+
+		if changed && m.OnChange != nil {
+			m.OnChange()
+		}
+
+		return nil
+	})
+}
+
+// certificate gets the certificate for a host. The Issuer asks the ACME
+// manager, which requests or renews it as needed. Everyone else waits for
+// the Issuer to put it in the shared cache.
+func (m *Manager) certificate(host string) (*tls.Certificate, error) {
+	if m.Issuer {
+		return m.acme.GetCertificate(issuerHello(host))
+	}
+
+	// The cache holds the private key followed by the chain, all as PEM
+	data, err := m.acme.Cache.Get(context.Background(), host)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cert, nil
+}
+
+// issuerHello is the TLS hello the Issuer asks the ACME manager for a
+// certificate with. The manager caches certificates for clients without
+// ECDSA support under host+"+rsa", so we advertise it to get the certificate
+// cached under the bare hostname, where the other nodes look for it.
+func issuerHello(host string) *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{
+		ServerName:      host,
+		SupportedCurves: []tls.CurveID{tls.CurveP256},
+		CipherSuites:    []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	}
+}
+
+// writeCert writes the certificate chain and key for a host to the CertDir
+// as a single PEM file, if it has changed since we last wrote it.
+func (m *Manager) writeCert(host string, cert *tls.Certificate) (bool, error) {
+	bundle, err := EncodePEM(cert)
+	if err != nil {
+		return false, err
+	}
+
+	if bytes.Equal(m.written[host], bundle) {
+		return false, nil
+	}
+
+	err = os.MkdirAll(m.CertDir, 0700)
+	if err != nil {
+		return false, err
+	}
+
+	// Write then rename so the proxy never sees a partial file
+	filename := filepath.Join(m.CertDir, host+".pem")
+	err = ioutil.WriteFile(filename+".tmp", bundle, 0600)
+	if err != nil {
+		return false, err
+	}
+
+	err = os.Rename(filename+".tmp", filename)
+	if err != nil {
+		return false, err
+	}
+
+	log.Infof("Wrote TLS certificate for %s to %s", host, filename)
+	m.written[host] = bundle
+
+	return true, nil
+}
+
+// EncodePEM encodes a certificate chain followed by its private key
+func EncodePEM(cert *tls.Certificate) ([]byte, error) {
+	if cert == nil || len(cert.Certificate) < 1 {
+		return nil, errors.New("No certificate to encode")
+	}
+
+	var buf bytes.Buffer
+	for _, der := range cert.Certificate {
+		err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pem.Encode(&buf, &pem.Block{Type: "PRIVATE KEY", Bytes: key})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
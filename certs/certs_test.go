@@ -0,0 +1,192 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func selfSigned(host string) *tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// A fakeCA is just enough of an RFC 8555 ACME server to issue certificates.
+// Orders are ready as soon as they are created, so there are no challenges.
+type fakeCA struct {
+	*httptest.Server
+	key    *ecdsa.PrivateKey
+	issued []byte
+}
+
+func newFakeCA() *fakeCA {
+	ca := &fakeCA{}
+	ca.key, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca.Server = httptest.NewServer(http.HandlerFunc(ca.serve))
+	return ca
+}
+
+func (ca *fakeCA) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", "nonce")
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.URL.Path {
+	case "/directory":
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   ca.URL + "/nonce",
+			"newAccount": ca.URL + "/account",
+			"newOrder":   ca.URL + "/order",
+		})
+	case "/nonce":
+	case "/account":
+		w.Header().Set("Location", ca.URL+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"status":"valid"}`))
+	case "/order":
+		w.Header().Set("Location", ca.URL+"/order/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"status":"ready","finalize":"` + ca.URL + `/finalize"}`))
+	case "/finalize":
+		ca.finalize(w, r)
+	case "/cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: ca.issued})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (ca *fakeCA) finalize(w http.ResponseWriter, r *http.Request) {
+	var jws struct{ Payload string }
+	json.NewDecoder(r.Body).Decode(&jws)
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+
+	var order struct{ CSR string }
+	json.Unmarshal(payload, &order)
+	der, _ := base64.RawURLEncoding.DecodeString(order.CSR)
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Fake CA"},
+		DNSNames:     append([]string{csr.Subject.CommonName}, csr.DNSNames...),
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	ca.issued, _ = x509.CreateCertificate(rand.Reader, template, template, csr.PublicKey, ca.key)
+
+	w.Header().Set("Location", ca.URL+"/order/1")
+	w.Write([]byte(`{"status":"valid","certificate":"` + ca.URL + `/cert"}`))
+}
+
+func Test_Manager(t *testing.T) {
+	Convey("Manager", t, func() {
+		state := catalog.NewServicesState()
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "awesome-svc", Hostname: "chaucer",
+			Updated: time.Now().UTC(), Status: service.ALIVE,
+			TLSHosts: []string{"www.example.com", "Example.com"},
+		})
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef456", Name: "broken-svc", Hostname: "chaucer",
+			Updated: time.Now().UTC(), Status: service.UNHEALTHY,
+			TLSHosts: []string{"broken.example.com"},
+		})
+
+		certDir, _ := ioutil.TempDir("", "certs")
+		defer os.RemoveAll(certDir)
+
+		manager := NewManager(state, filepath.Join(certDir, "cache"), certDir, "", "")
+
+		Convey("Finds the TLS hosts of live services", func() {
+			So(manager.Hosts(), ShouldResemble, []string{"example.com", "www.example.com"})
+		})
+
+		Convey("Only allows certificates for known hosts", func() {
+			So(manager.hostPolicy(context.Background(), "www.example.com"), ShouldBeNil)
+			So(manager.hostPolicy(context.Background(), "broken.example.com"), ShouldNotBeNil)
+			So(manager.hostPolicy(context.Background(), "evil.example.com"), ShouldNotBeNil)
+		})
+
+		Convey("Writes a PEM bundle only when it changes", func() {
+			cert := selfSigned("www.example.com")
+
+			wrote, err := manager.writeCert("www.example.com", cert)
+			So(err, ShouldBeNil)
+			So(wrote, ShouldBeTrue)
+
+			wrote, err = manager.writeCert("www.example.com", cert)
+			So(err, ShouldBeNil)
+			So(wrote, ShouldBeFalse)
+
+			bundle, err := ioutil.ReadFile(filepath.Join(certDir, "www.example.com.pem"))
+			So(err, ShouldBeNil)
+
+			// HAProxy wants the cert and key in one file
+			parsed, err := tls.X509KeyPair(bundle, bundle)
+			So(err, ShouldBeNil)
+			So(parsed.Certificate, ShouldResemble, cert.Certificate)
+		})
+
+		Convey("Uses the ACME v2 directory by default", func() {
+			So(manager.acme.Client.DirectoryURL, ShouldEqual, autocert.DefaultACMEDirectory)
+		})
+
+		Convey("Reads the certificates the issuer requested from the shared cache", func() {
+			ca := newFakeCA()
+			defer ca.Close()
+
+			issuer := NewManager(state, filepath.Join(certDir, "cache"), certDir, "", ca.URL+"/directory")
+			issuer.Issuer = true
+
+			issued, err := issuer.certificate("www.example.com")
+			So(err, ShouldBeNil)
+
+			found, err := manager.certificate("www.example.com")
+			So(err, ShouldBeNil)
+			So(found.Certificate, ShouldResemble, issued.Certificate)
+
+			_, err = manager.certificate("example.com")
+			So(err, ShouldEqual, autocert.ErrCacheMiss)
+		})
+
+		Convey("Won't encode an empty certificate", func() {
+			_, err := EncodePEM(&tls.Certificate{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -8,12 +8,20 @@ import (
 )
 
 type CliOpts struct {
-	AdvertiseIP  *string
-	ClusterIPs   *[]string
-	ClusterName  *string
-	CpuProfile   *bool
-	Discover     *[]string
-	LoggingLevel *string
+	AdvertiseIP       *string
+	AdvertiseHostname *string
+	ClusterIPs        *[]string
+	ClusterName       *string
+	CpuProfile        *bool
+	Discover          *[]string
+	DryRun            *bool
+	LoggingLevel      *string
+
+	Command      string
+	EventsAddr   *string
+	EventsFollow *bool
+	EventsJson   *bool
+	EventsSince  *string
 }
 
 func exitWithError(err error, message string) {
@@ -28,14 +36,25 @@ func parseCommandLine() *CliOpts {
 	app := kingpin.New("sidecar", "")
 
 	opts.AdvertiseIP = app.Flag("advertise-ip", "The address to advertise to the cluster").Short('a').String()
+	opts.AdvertiseHostname = app.Flag("advertise-hostname", "The host name to advertise to the cluster").String()
 	opts.ClusterIPs = app.Flag("cluster-ip", "The cluster seed addresses").Short('c').NoEnvar().Strings()
 	opts.ClusterName = app.Flag("cluster-name", "The cluster we're part of").Short('n').String()
 	opts.CpuProfile = app.Flag("cpuprofile", "Enable CPU profiling").Short('p').Bool()
 	opts.Discover = app.Flag("discover", "Method of discovery").Short('d').NoEnvar().Strings()
+	opts.DryRun = app.Flag("dry-run", "Discover and health check, but don't touch the proxy or the cluster").Bool()
 	opts.LoggingLevel = app.Flag("logging-level", "Set the logging level").Short('l').String()
 
-	_, err := app.Parse(os.Args[1:])
+	app.Command("run", "Run Sidecar (the default)").Default()
+
+	events := app.Command("events", "Print the cluster's service and host events")
+	opts.EventsAddr = events.Flag("address", "The Sidecar node to get events from").Default("localhost:7777").String()
+	opts.EventsFollow = events.Flag("follow", "Keep printing new events as they happen").Short('f').Bool()
+	opts.EventsJson = events.Flag("json", "Print one JSON object per event").Bool()
+	opts.EventsSince = events.Flag("since", "Print the events after this sequence number").String()
+
+	command, err := app.Parse(os.Args[1:])
 	exitWithError(err, "Failed to parse CLI opts")
+	opts.Command = command
 
 	return &opts
 }
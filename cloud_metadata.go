@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Support for finding the address to advertise from the instance metadata
+// service of the cloud we're running in, rather than guessing at interfaces.
+
+const (
+	METADATA_URL     = "http://169.254.169.254" // Where EC2, GCE, and Azure serve instance metadata
+	METADATA_TIMEOUT = 2 * time.Second          // How long we wait on the metadata service
+	EC2_TOKEN_TTL    = "60"                     // How long, in seconds, our IMDSv2 token lives
+
+	CLOUD_AUTO  = "auto"
+	CLOUD_EC2   = "ec2"
+	CLOUD_GCE   = "gce"
+	CLOUD_AZURE = "azure"
+)
+
+// The clouds we try, in order, when told to work it out
+var autoClouds = []string{CLOUD_EC2, CLOUD_GCE, CLOUD_AZURE}
+
+// A metadataClient asks a cloud's instance metadata service for the private
+// IPv4 address of one of the instance's network interfaces, numbered from 0.
+type metadataClient struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func newMetadataClient() *metadataClient {
+	return &metadataClient{
+		BaseURL: METADATA_URL,
+		// Proxies from the environment have no business seeing this
+		Client: &http.Client{Timeout: METADATA_TIMEOUT, Transport: &http.Transport{}},
+	}
+}
+
+// PrivateIP returns the private address of the numbered interface from the
+// named cloud, or from the first one that answers when cloud is "auto".
+func (m *metadataClient) PrivateIP(cloud string, iface int) (string, error) {
+	if cloud != CLOUD_AUTO {
+		return m.privateIPFrom(cloud, iface)
+	}
+
+	for _, candidate := range autoClouds {
+		ip, err := m.privateIPFrom(candidate, iface)
+		if err == nil {
+			log.Infof("Found our address in the %s instance metadata", candidate)
+			return ip, nil
+		}
+		log.Debugf("No address from %s instance metadata: %s", candidate, err)
+	}
+
+	return "", errors.New("No cloud instance metadata service answered")
+}
+
+func (m *metadataClient) privateIPFrom(cloud string, iface int) (string, error) {
+	var ip string
+	var err error
+
+	switch cloud {
+	case CLOUD_EC2:
+		ip, err = m.ec2PrivateIP(iface)
+	case CLOUD_GCE:
+		ip, err = m.get(
+			fmt.Sprintf("/computeMetadata/v1/instance/network-interfaces/%d/ip", iface),
+			map[string]string{"Metadata-Flavor": "Google"},
+		)
+	case CLOUD_AZURE:
+		ip, err = m.get(
+			fmt.Sprintf(
+				"/metadata/instance/network/interface/%d/ipv4/ipAddress/0/privateIpAddress?api-version=2017-08-01&format=text",
+				iface,
+			),
+			map[string]string{"Metadata": "true"},
+		)
+	default:
+		return "", fmt.Errorf("Unknown cloud %q, expected one of %s, %s, %s, or %s",
+			cloud, CLOUD_EC2, CLOUD_GCE, CLOUD_AZURE, CLOUD_AUTO)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if parsed := net.ParseIP(ip); parsed == nil || parsed.To4() == nil {
+		return "", fmt.Errorf("Got %q from %s instance metadata, which isn't an IPv4 address", ip, cloud)
+	}
+
+	return ip, nil
+}
+
+// ec2PrivateIP finds the address of the interface with the given device
+// number. It uses an IMDSv2 token when it can get one, since instances may
+// be set to require them.
+func (m *metadataClient) ec2PrivateIP(iface int) (string, error) {
+	headers := make(map[string]string)
+	if token, err := m.ec2Token(); err == nil {
+		headers["X-aws-ec2-metadata-token"] = token
+	}
+
+	if iface == 0 {
+		return m.get("/latest/meta-data/local-ipv4", headers)
+	}
+
+	macs, err := m.get("/latest/meta-data/network/interfaces/macs/", headers)
+	if err != nil {
+		return "", err
+	}
+
+	for _, mac := range strings.Fields(macs) {
+		mac = strings.TrimSuffix(mac, "/")
+		path := "/latest/meta-data/network/interfaces/macs/" + mac
+
+		device, err := m.get(path+"/device-number", headers)
+		if err != nil || device != fmt.Sprint(iface) {
+			continue
+		}
+
+		ips, err := m.get(path+"/local-ipv4s", headers)
+		if err != nil {
+			return "", err
+		}
+
+		if fields := strings.Fields(ips); len(fields) > 0 {
+			return fields[0], nil
+		}
+	}
+
+	return "", fmt.Errorf("No network interface with device number %d", iface)
+}
+
+// ec2Token gets a session token for IMDSv2
+func (m *metadataClient) ec2Token() (string, error) {
+	req, err := http.NewRequest(http.MethodPut, m.BaseURL+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", EC2_TOKEN_TTL)
+
+	return m.do(req)
+}
+
+// get fetches a metadata path with the headers the cloud wants
+func (m *metadataClient) get(path string, headers map[string]string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, m.BaseURL+path, nil)
+	if err != nil {
+		return "", err
+	}
+
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	return m.do(req)
+}
+
+func (m *metadataClient) do(req *http.Request) (string, error) {
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Bad status code from %s (%d)", req.URL.Path, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(body)), nil
+}
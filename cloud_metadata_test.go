@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_CloudMetadata(t *testing.T) {
+	Convey("Finding our address in cloud instance metadata", t, func() {
+		responses := map[string]string{}
+		var headers http.Header
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPut && r.URL.Path == "/latest/api/token" {
+				w.Write([]byte("sekrit"))
+				return
+			}
+
+			body, ok := responses[r.URL.Path]
+			if !ok {
+				w.WriteHeader(404)
+				return
+			}
+			headers = r.Header
+			w.Write([]byte(body + "\n"))
+		}))
+		Reset(server.Close)
+
+		client := newMetadataClient()
+		client.BaseURL = server.URL
+
+		Convey("on EC2", func() {
+			responses["/latest/meta-data/local-ipv4"] = "10.0.1.5"
+			responses["/latest/meta-data/network/interfaces/macs/"] = "0e:00:00:00:00:01/\n0e:00:00:00:00:02/"
+			responses["/latest/meta-data/network/interfaces/macs/0e:00:00:00:00:01/device-number"] = "0"
+			responses["/latest/meta-data/network/interfaces/macs/0e:00:00:00:00:02/device-number"] = "1"
+			responses["/latest/meta-data/network/interfaces/macs/0e:00:00:00:00:02/local-ipv4s"] = "10.0.2.7\n10.0.2.8"
+
+			Convey("uses an IMDSv2 token", func() {
+				ip, err := client.PrivateIP(CLOUD_EC2, 0)
+				So(err, ShouldBeNil)
+				So(ip, ShouldEqual, "10.0.1.5")
+				So(headers.Get("X-aws-ec2-metadata-token"), ShouldEqual, "sekrit")
+			})
+
+			Convey("finds the interface by device number", func() {
+				ip, err := client.PrivateIP(CLOUD_EC2, 1)
+				So(err, ShouldBeNil)
+				So(ip, ShouldEqual, "10.0.2.7")
+			})
+
+			Convey("errors on interfaces that don't exist", func() {
+				_, err := client.PrivateIP(CLOUD_EC2, 2)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("on GCE", func() {
+			responses["/computeMetadata/v1/instance/network-interfaces/0/ip"] = "10.128.0.2"
+
+			ip, err := client.PrivateIP(CLOUD_GCE, 0)
+			So(err, ShouldBeNil)
+			So(ip, ShouldEqual, "10.128.0.2")
+			So(headers.Get("Metadata-Flavor"), ShouldEqual, "Google")
+		})
+
+		Convey("on Azure", func() {
+			responses["/metadata/instance/network/interface/0/ipv4/ipAddress/0/privateIpAddress"] = "10.1.0.4"
+
+			ip, err := client.PrivateIP(CLOUD_AZURE, 0)
+			So(err, ShouldBeNil)
+			So(ip, ShouldEqual, "10.1.0.4")
+			So(headers.Get("Metadata"), ShouldEqual, "true")
+		})
+
+		Convey("works out which cloud it's in", func() {
+			responses["/metadata/instance/network/interface/0/ipv4/ipAddress/0/privateIpAddress"] = "10.1.0.4"
+
+			ip, err := client.PrivateIP(CLOUD_AUTO, 0)
+			So(err, ShouldBeNil)
+			So(ip, ShouldEqual, "10.1.0.4")
+		})
+
+		Convey("rejects answers that aren't IPv4 addresses", func() {
+			responses["/computeMetadata/v1/instance/network-interfaces/0/ip"] = "<html>Captive portal</html>"
+
+			_, err := client.PrivateIP(CLOUD_GCE, 0)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("errors on unknown clouds", func() {
+			_, err := client.PrivateIP("digitalocean", 0)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("errors when no cloud answers", func() {
+			_, err := client.PrivateIP(CLOUD_AUTO, 0)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
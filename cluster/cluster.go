@@ -0,0 +1,124 @@
+// Package cluster gossips a catalog.ServicesState between the members of a
+// Sidecar cluster over memberlist. Together with the catalog, discovery,
+// and healthy packages, it lets other programs embed Sidecar's core and
+// build their own control planes on top of it, without the daemon.
+//
+// A minimal member of a cluster looks like:
+//
+//	state := catalog.NewServicesState()
+//	list, err := cluster.Join(state, cluster.Config{
+//		ClusterName: "default",
+//		BindPort:    7946,
+//	}, []string{"sidecar-1.example.com"})
+//	go state.ProcessServiceMsgs(director.NewFreeLooper(director.FOREVER, nil))
+//
+// From then on the state follows the cluster, and Listeners added to it
+// receive every change. Services added to the state with AddServiceEntry()
+// are gossiped to the other members.
+package cluster
+
+import (
+	"time"
+
+	"github.com/Nitro/memberlist"
+	"github.com/Nitro/sidecar/catalog"
+	log "github.com/sirupsen/logrus"
+)
+
+// Config holds the settings for joining a cluster. Zero values get
+// memberlist's LAN defaults, except where noted.
+type Config struct {
+	ClusterName      string        // Only nodes with the same name can join
+	Name             string        // Defaults to the state's Hostname
+	BindPort         int           // Where to gossip
+	AdvertiseAddr    string        // The address other nodes reach us on
+	PushPullInterval time.Duration // Defaults to just under catalog.ALIVE_LIFESPAN
+	GossipMessages   int
+	Metadata         NodeMetadata // What we announce about ourselves
+}
+
+// NewMemberlistConfig returns a memberlist.Config that gossips the state,
+// along with its Delegate, which is already started.
+func NewMemberlistConfig(state *catalog.ServicesState, config Config) (*memberlist.Config, *Delegate) {
+	delegate := NewDelegate(state)
+	delegate.Metadata = config.Metadata
+	if delegate.Metadata.ClusterName == "" {
+		delegate.Metadata.ClusterName = config.ClusterName
+	}
+	delegate.Start()
+
+	// Use a LAN config but add our delegate
+	mlConfig := memberlist.DefaultLANConfig()
+	mlConfig.Delegate = delegate
+	mlConfig.Events = delegate
+
+	// Set some memberlist settings
+	mlConfig.LogOutput = &LoggingBridge{} // Use logrus as backend for Memberlist
+	mlConfig.PreferTCPDNS = false
+
+	// Set up the push pull interval for Memberlist
+	if config.PushPullInterval == 0 {
+		mlConfig.PushPullInterval = catalog.ALIVE_LIFESPAN - 1*time.Second
+	} else {
+		mlConfig.PushPullInterval = config.PushPullInterval
+	}
+	if config.GossipMessages != 0 {
+		mlConfig.GossipMessages = config.GossipMessages
+	}
+
+	// Make sure we pass on the cluster name to Memberlist
+	mlConfig.ClusterName = config.ClusterName
+
+	// Our node is known by the same name we announce our services from
+	mlConfig.Name = config.Name
+	if mlConfig.Name == "" {
+		mlConfig.Name = state.Hostname
+	}
+
+	mlConfig.BindPort = config.BindPort
+	mlConfig.AdvertiseAddr = config.AdvertiseAddr
+	mlConfig.AdvertisePort = config.BindPort
+
+	return mlConfig, delegate
+}
+
+// Join creates the memberlist for the state and joins the cluster through
+// the seeds. Leaving out the seeds starts a new cluster.
+func Join(state *catalog.ServicesState, config Config, seeds []string) (*memberlist.Memberlist, error) {
+	mlConfig, _ := NewMemberlistConfig(state, config)
+
+	list, err := memberlist.Create(mlConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(seeds) > 0 {
+		_, err = list.Join(seeds)
+		if err != nil {
+			list.Shutdown()
+			return nil, err
+		}
+	}
+
+	return list, nil
+}
+
+// Leave leaves the cluster cleanly. We first tell the other nodes that we're
+// leaving, in our metadata, so they can tell us apart from a node that died.
+func Leave(list *memberlist.Memberlist, delegate *Delegate, timeout time.Duration) error {
+	delegate.metaLock.Lock()
+	delegate.Metadata.Leaving = true
+	delegate.metaLock.Unlock()
+
+	err := list.UpdateNode(timeout)
+	if err != nil {
+		log.Warnf("Failed to announce that we're leaving: %s", err)
+	}
+
+	err = list.Leave(timeout)
+	if err != nil {
+		return err
+	}
+
+	return list.Shutdown()
+}
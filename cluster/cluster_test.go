@@ -0,0 +1,77 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
+	"github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_NewMemberlistConfig(t *testing.T) {
+	Convey("NewMemberlistConfig()", t, func() {
+		state := catalog.NewServicesState()
+		state.Hostname = "chaucer"
+
+		Convey("gossips the state under our hostname", func() {
+			mlConfig, delegate := NewMemberlistConfig(state, Config{
+				ClusterName: "bocaccio", BindPort: 7947, AdvertiseAddr: "10.0.0.1",
+			})
+
+			So(mlConfig.Name, ShouldEqual, "chaucer")
+			So(mlConfig.ClusterName, ShouldEqual, "bocaccio")
+			So(mlConfig.AdvertiseAddr, ShouldEqual, "10.0.0.1")
+			So(mlConfig.AdvertisePort, ShouldEqual, 7947)
+			So(mlConfig.PushPullInterval, ShouldEqual, catalog.ALIVE_LIFESPAN-1*time.Second)
+			So(mlConfig.Delegate, ShouldEqual, delegate)
+			So(delegate.Started, ShouldBeTrue)
+			So(delegate.Metadata.ClusterName, ShouldEqual, "bocaccio")
+		})
+
+		Convey("takes the settings it's given", func() {
+			mlConfig, delegate := NewMemberlistConfig(state, Config{
+				Name:             "petrarch",
+				PushPullInterval: 10 * time.Second,
+				GossipMessages:   7,
+				Metadata:         NodeMetadata{ClusterName: "bocaccio", DisplayName: "Petrarch"},
+			})
+
+			So(mlConfig.Name, ShouldEqual, "petrarch")
+			So(mlConfig.PushPullInterval, ShouldEqual, 10*time.Second)
+			So(mlConfig.GossipMessages, ShouldEqual, 7)
+			So(delegate.Metadata.DisplayName, ShouldEqual, "Petrarch")
+		})
+	})
+}
+
+func Test_Join(t *testing.T) {
+	Convey("Join() shares the state between members", t, func() {
+		first := catalog.NewServicesState()
+		first.Hostname = "chaucer"
+		first.Broadcasts = make(chan [][]byte, 10)
+		second := catalog.NewServicesState()
+		second.Hostname = "petrarch"
+		second.Broadcasts = make(chan [][]byte, 10)
+
+		first.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Image: "bocaccio:v1", Hostname: "chaucer",
+			Status: service.ALIVE, Updated: time.Now().UTC(),
+		})
+
+		firstList, err := Join(first, Config{BindPort: 17946, AdvertiseAddr: "127.0.0.1"}, nil)
+		So(err, ShouldBeNil)
+		Reset(func() { firstList.Shutdown() })
+
+		secondList, err := Join(second, Config{BindPort: 17947, AdvertiseAddr: "127.0.0.1"},
+			[]string{"127.0.0.1:17946"})
+		So(err, ShouldBeNil)
+		Reset(func() { secondList.Shutdown() })
+
+		So(secondList.NumMembers(), ShouldEqual, 2)
+
+		second.ProcessServiceMsgs(director.NewFreeLooper(director.ONCE, nil))
+		So(second.HasServer("chaucer"), ShouldBeTrue)
+	})
+}
@@ -1,7 +1,8 @@
-package main
+package cluster
 
 import (
 	"encoding/json"
+	"sync"
 	"time"
 
 	"github.com/Nitro/memberlist"
@@ -16,22 +17,41 @@ const (
 	MAX_PENDING_LENGTH = 100 // Number of messages we can replace into the pending queue
 )
 
-type servicesDelegate struct {
+// A Delegate is the memberlist.Delegate and memberlist.EventDelegate that
+// gossips a ServicesState. It sends the state's Broadcasts to the cluster,
+// applies the services it hears about, and expires the services of nodes
+// that leave.
+type Delegate struct {
 	state             *catalog.ServicesState
 	pendingBroadcasts [][]byte
 	notifications     chan []byte
 	Started           bool
 	StartedAt         time.Time
 	Metadata          NodeMetadata
+	metaLock          sync.Mutex
 }
 
+// NodeMetadata is what a node announces about itself to the cluster
 type NodeMetadata struct {
 	ClusterName string
 	State       string
+	DisplayName string            `json:",omitempty"`
+	Metadata    map[string]string `json:",omitempty"`
+	// Takes turns with the other hosts that set it when reloading HAproxy
+	StaggersReloads bool `json:",omitempty"`
+	// How loaded the host is, when it reports it. See ReadHostPressure().
+	Pressure float64 `json:",omitempty"`
+	// Has outage webhooks. The first such host posts to them, see
+	// OutageReporter().
+	ReportsOutages bool `json:",omitempty"`
+	// Set just before the node leaves the cluster cleanly. See Leave().
+	Leaving bool `json:",omitempty"`
 }
 
-func NewServicesDelegate(state *catalog.ServicesState) *servicesDelegate {
-	delegate := servicesDelegate{
+// NewDelegate returns a Delegate for the state. Start() it before joining
+// a cluster.
+func NewDelegate(state *catalog.ServicesState) *Delegate {
+	delegate := Delegate{
 		state:             state,
 		pendingBroadcasts: make([][]byte, 0),
 		notifications:     make(chan []byte, 25),
@@ -42,7 +62,7 @@ func NewServicesDelegate(state *catalog.ServicesState) *servicesDelegate {
 }
 
 // Start kicks off the goroutine that will process incoming notifications of services
-func (d *servicesDelegate) Start() {
+func (d *Delegate) Start() {
 	go func() {
 		for message := range d.notifications {
 			entry, err := service.Decode(message)
@@ -58,17 +78,30 @@ func (d *servicesDelegate) Start() {
 	d.StartedAt = time.Now().UTC()
 }
 
-func (d *servicesDelegate) NodeMeta(limit int) []byte {
+func (d *Delegate) NodeMeta(limit int) []byte {
 	log.Debugf("NodeMeta(): %d", limit)
+	d.metaLock.Lock()
+	defer d.metaLock.Unlock()
+
 	data, err := json.Marshal(d.Metadata)
 	if err != nil {
 		log.Error("Error encoding Node metadata!")
 		data = []byte("{}")
 	}
+
+	// Memberlist won't take more than it asked for, so drop the host
+	// metadata rather than announce nothing at all
+	if len(data) > limit {
+		log.Errorf("Node metadata is longer than the %d byte limit, leaving out host metadata", limit)
+		trimmed := d.Metadata
+		trimmed.Metadata = nil
+		data, _ = json.Marshal(trimmed)
+	}
+
 	return data
 }
 
-func (d *servicesDelegate) NotifyMsg(message []byte) {
+func (d *Delegate) NotifyMsg(message []byte) {
 	defer metrics.MeasureSince([]string{"delegate", "NotifyMsg"}, time.Now())
 
 	if len(message) < 1 {
@@ -81,7 +114,7 @@ func (d *servicesDelegate) NotifyMsg(message []byte) {
 	d.notifications <- message
 }
 
-func (d *servicesDelegate) GetBroadcasts(overhead, limit int) [][]byte {
+func (d *Delegate) GetBroadcasts(overhead, limit int) [][]byte {
 	defer metrics.MeasureSince([]string{"delegate", "GetBroadcasts"}, time.Now())
 	metrics.SetGauge([]string{"delegate", "pendingBroadcasts"}, float32(len(d.pendingBroadcasts)))
 
@@ -142,14 +175,14 @@ func (d *servicesDelegate) GetBroadcasts(overhead, limit int) [][]byte {
 	return broadcast
 }
 
-func (d *servicesDelegate) LocalState(join bool) []byte {
+func (d *Delegate) LocalState(join bool) []byte {
 	log.Debugf("LocalState(): %t", join)
 	d.state.RLock()
 	defer d.state.RUnlock()
 	return d.state.Encode()
 }
 
-func (d *servicesDelegate) MergeRemoteState(buf []byte, join bool) {
+func (d *Delegate) MergeRemoteState(buf []byte, join bool) {
 	defer metrics.MeasureSince([]string{"delegate", "MergeRemoteState"}, time.Now())
 
 	log.Debugf("MergeRemoteState(): %s %t", string(buf), join)
@@ -165,16 +198,23 @@ func (d *servicesDelegate) MergeRemoteState(buf []byte, join bool) {
 	d.state.Merge(otherState)
 }
 
-func (d *servicesDelegate) NotifyJoin(node *memberlist.Node) {
+func (d *Delegate) NotifyJoin(node *memberlist.Node) {
 	log.Debugf("NotifyJoin(): %s %s", node.Name, string(node.Meta))
+	go d.state.HostReturned(node.Name)
 }
 
-func (d *servicesDelegate) NotifyLeave(node *memberlist.Node) {
+// NotifyLeave hands the node to the state to be reaped. Memberlist doesn't
+// tell us whether it left or died, so we go by whether it said it was
+// leaving in its metadata.
+func (d *Delegate) NotifyLeave(node *memberlist.Node) {
 	log.Debugf("NotifyLeave(): %s", node.Name)
-	go d.state.ExpireServer(node.Name)
+
+	var meta NodeMetadata
+	graceful := json.Unmarshal(node.Meta, &meta) == nil && meta.Leaving
+	go d.state.HostDeparted(node.Name, graceful)
 }
 
-func (d *servicesDelegate) NotifyUpdate(node *memberlist.Node) {
+func (d *Delegate) NotifyUpdate(node *memberlist.Node) {
 	log.Debugf("NotifyUpdate(): %s", node.Name)
 }
 
@@ -182,7 +222,7 @@ func (d *servicesDelegate) NotifyUpdate(node *memberlist.Node) {
 // assumes that no messages will be longer than the normal UDP packet size.
 // This means that max message length is somewhere around 1398 when taking
 // messaging overhead into account.
-func (d *servicesDelegate) packPacket(broadcasts [][]byte, limit int, overhead int) (packet [][]byte, leftover [][]byte) {
+func (d *Delegate) packPacket(broadcasts [][]byte, limit int, overhead int) (packet [][]byte, leftover [][]byte) {
 	total := 0
 	lastItem := -1
 
@@ -1,16 +1,19 @@
-package main
+package cluster
 
 import (
 	"testing"
+	"time"
 
+	"github.com/Nitro/memberlist"
 	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
 func Test_GetBroadcasts(t *testing.T) {
 	Convey("When handing back broadcast messages", t, func() {
 		state := catalog.NewServicesState()
-		delegate := NewServicesDelegate(state)
+		delegate := NewDelegate(state)
 		bCast := [][]byte{
 			[]byte(`{"ID":"d419fa7ad1a7","Name":"/dockercon-6adfe629eebc91","Image":"nginx:latest","Created":"2015-02-25T19:04:46Z","Hostname":"docker2","Ports":[{"Type":"tcp","Port":10234}],"Updated":"2015-03-04T01:12:46.669648453Z","Status":0}`),
 			[]byte(`{"ID":"deadbeefabba","Name":"/dockercon-6c01869525db08","Image":"nginx:latest","Created":"2015-02-25T19:04:46Z","Hostname":"docker2","Ports":[{"Type":"tcp","Port":10234}],"Updated":"2015-03-04T01:12:46.669648453Z","Status":0}`),
@@ -102,5 +105,56 @@ func Test_GetBroadcasts(t *testing.T) {
 				So(len(delegate.pendingBroadcasts), ShouldEqual, 0)
 			})
 		})
+
+		Convey("NodeMeta()", func() {
+			delegate.Metadata = NodeMetadata{
+				ClusterName: "default",
+				State:       "Running",
+				DisplayName: "edge-1",
+				Metadata:    map[string]string{"rack": "b12"},
+			}
+
+			Convey("Encodes the display name and host metadata", func() {
+				So(string(delegate.NodeMeta(512)), ShouldEqual,
+					`{"ClusterName":"default","State":"Running","DisplayName":"edge-1","Metadata":{"rack":"b12"}}`,
+				)
+			})
+
+			Convey("Leaves out the host metadata when it's too long", func() {
+				meta := delegate.NodeMeta(90)
+				So(string(meta), ShouldEqual, `{"ClusterName":"default","State":"Running","DisplayName":"edge-1"}`)
+				So(delegate.Metadata.Metadata, ShouldNotBeEmpty)
+			})
+		})
+
+		Convey("NotifyLeave()", func() {
+			state.Broadcasts = make(chan [][]byte, 20)
+			state.AddServiceEntry(service.Service{
+				ID: "deadbeefabba", Name: "bocaccio", Hostname: "docker2",
+				Status: service.ALIVE, Updated: time.Now().UTC(),
+			})
+			<-state.Broadcasts // The new service
+
+			// The node is reaped in the background
+			waitForDeparture := func() {
+				for i := 0; i < 100 && len(state.Departures("")) < 1; i++ {
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+
+			Convey("Reaps a node that said it was leaving as having left", func() {
+				delegate.NotifyLeave(&memberlist.Node{Name: "docker2", Meta: []byte(`{"Leaving":true}`)})
+				waitForDeparture()
+
+				So(state.Departures("")[0].Reason, ShouldEqual, "Host left the cluster")
+			})
+
+			Convey("Reaps any other node as having failed", func() {
+				delegate.NotifyLeave(&memberlist.Node{Name: "docker2", Meta: []byte(`{"ClusterName":"default"}`)})
+				waitForDeparture()
+
+				So(state.Departures("")[0].Reason, ShouldEqual, "Host failed or became unreachable")
+			})
+		})
 	})
 }
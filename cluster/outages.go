@@ -0,0 +1,27 @@
+package cluster
+
+import (
+	"encoding/json"
+
+	"github.com/Nitro/memberlist"
+)
+
+// OutageReporter returns the name of the member that posts outage events to
+// the webhooks: the first by name of those that have them configured, and
+// aren't leaving. Every server tracks outages, so otherwise each event would
+// be posted once by each of them. Returns "" if there is no such member.
+func OutageReporter(members []*memberlist.Node) string {
+	var reporter string
+	for _, member := range members {
+		var meta NodeMetadata
+		if json.Unmarshal(member.Meta, &meta) != nil || !meta.ReportsOutages || meta.Leaving {
+			continue
+		}
+
+		if reporter == "" || member.Name < reporter {
+			reporter = member.Name
+		}
+	}
+
+	return reporter
+}
@@ -0,0 +1,40 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/Nitro/memberlist"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_OutageReporter(t *testing.T) {
+	Convey("OutageReporter()", t, func() {
+		Convey("picks the first member by name that reports outages", func() {
+			members := []*memberlist.Node{
+				{Name: "petrarch", Meta: []byte(`{"ClusterName":"default","ReportsOutages":true}`)},
+				{Name: "boccaccio", Meta: []byte(`{"ClusterName":"default"}`)},
+				{Name: "chaucer", Meta: []byte(`{"ClusterName":"default","ReportsOutages":true}`)},
+				{Name: "alighieri", Meta: []byte(`garbage`)},
+			}
+
+			So(OutageReporter(members), ShouldEqual, "chaucer")
+		})
+
+		Convey("skips members that are leaving", func() {
+			members := []*memberlist.Node{
+				{Name: "chaucer", Meta: []byte(`{"ClusterName":"default","ReportsOutages":true,"Leaving":true}`)},
+				{Name: "petrarch", Meta: []byte(`{"ClusterName":"default","ReportsOutages":true}`)},
+			}
+
+			So(OutageReporter(members), ShouldEqual, "petrarch")
+		})
+
+		Convey("returns nothing when no member reports outages", func() {
+			members := []*memberlist.Node{
+				{Name: "chaucer", Meta: []byte(`{"ClusterName":"default"}`)},
+			}
+
+			So(OutageReporter(members), ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,151 @@
+package cluster
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nitro/memberlist"
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PRESSURE_INTERVAL   = 15 * time.Second // How often we look at the host's load
+	PRESSURE_PRECISION  = 0.1              // Smaller changes than this aren't announced
+	UPDATE_NODE_TIMEOUT = 5 * time.Second  // How long to wait announcing new metadata
+)
+
+// ReadHostPressure works out how loaded this host is, from procDir, which is
+// normally /proc. It is the higher of the 1 minute load average per CPU, and
+// the fraction of the memory that is in use. 0 is idle, 1 is fully loaded,
+// and anything above that is overloaded.
+func ReadHostPressure(procDir string) (float64, error) {
+	loadavg, err := ioutil.ReadFile(filepath.Join(procDir, "loadavg"))
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(loadavg))
+	if len(fields) < 1 {
+		return 0, fmt.Errorf("can't parse loadavg: %q", string(loadavg))
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("can't parse loadavg: %s", err)
+	}
+	cpuPressure := load / float64(runtime.NumCPU())
+
+	memPressure, err := readMemoryPressure(filepath.Join(procDir, "meminfo"))
+	if err != nil {
+		return 0, err
+	}
+
+	return math.Max(cpuPressure, memPressure), nil
+}
+
+// readMemoryPressure returns the fraction of memory in use from meminfo
+func readMemoryPressure(path string) (float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err == nil {
+			values[strings.TrimSuffix(fields[0], ":")] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	if values["MemTotal"] <= 0 {
+		return 0, fmt.Errorf("no MemTotal in %s", path)
+	}
+	available, ok := values["MemAvailable"]
+	if !ok {
+		return 0, fmt.Errorf("no MemAvailable in %s", path)
+	}
+
+	return 1 - available/values["MemTotal"], nil
+}
+
+// SetPressure updates the pressure we announce in our node metadata.
+// Returns true when it changed enough to be worth announcing.
+func (d *Delegate) SetPressure(pressure float64) bool {
+	// Round it so the metadata only changes when it means something
+	pressure = math.Round(pressure/PRESSURE_PRECISION) * PRESSURE_PRECISION
+
+	d.metaLock.Lock()
+	defer d.metaLock.Unlock()
+
+	if math.Abs(d.Metadata.Pressure-pressure) < PRESSURE_PRECISION/2 {
+		return false
+	}
+
+	d.Metadata.Pressure = pressure
+	return true
+}
+
+// ReportPressure announces this host's pressure to the cluster in our node
+// metadata, reading it from procDir. See ReadHostPressure().
+func ReportPressure(list *memberlist.Memberlist, delegate *Delegate, procDir string, looper director.Looper) {
+	looper.Loop(func() error {
+		pressure, err := ReadHostPressure(procDir)
+		if err != nil {
+			log.Warnf("Can't read the host pressure: %s", err)
+			return nil
+		}
+
+		if delegate.SetPressure(pressure) {
+			err = list.UpdateNode(UPDATE_NODE_TIMEOUT)
+			if err != nil {
+				log.Warnf("Failed to announce the host pressure: %s", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// PeerPressure returns the pressure announced by each member of the cluster
+// that reports it.
+func PeerPressure(members []*memberlist.Node) map[string]float64 {
+	pressure := make(map[string]float64, len(members))
+	for _, member := range members {
+		var meta NodeMetadata
+		if json.Unmarshal(member.Meta, &meta) == nil && meta.Pressure > 0 {
+			pressure[member.Name] = meta.Pressure
+		}
+	}
+
+	return pressure
+}
+
+// TrackPressure keeps the state's host pressure up to date with what the
+// members of the cluster announce, for routing by host pressure.
+func TrackPressure(list *memberlist.Memberlist, state *catalog.ServicesState, looper director.Looper) {
+	looper.Loop(func() error {
+		state.SetHostPressure(PeerPressure(list.Members()))
+		return nil
+	})
+}
@@ -0,0 +1,78 @@
+package cluster
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/Nitro/memberlist"
+	"github.com/Nitro/sidecar/catalog"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_HostPressure(t *testing.T) {
+	Convey("Host pressure", t, func() {
+		Convey("ReadHostPressure()", func() {
+			procDir, err := ioutil.TempDir("", "sidecar-proc")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(procDir)
+
+			writeProc := func(load float64, total int, available int) {
+				loadavg := strconv.FormatFloat(load, 'f', 2, 64) + " 0.50 0.25 1/123 4567\n"
+				meminfo := "MemTotal:       " + strconv.Itoa(total) + " kB\n" +
+					"MemFree:          100000 kB\n" +
+					"MemAvailable:   " + strconv.Itoa(available) + " kB\n"
+				So(ioutil.WriteFile(filepath.Join(procDir, "loadavg"), []byte(loadavg), 0644), ShouldBeNil)
+				So(ioutil.WriteFile(filepath.Join(procDir, "meminfo"), []byte(meminfo), 0644), ShouldBeNil)
+			}
+
+			Convey("goes by the load per CPU when that's higher", func() {
+				writeProc(float64(runtime.NumCPU())*1.5, 1000000, 800000)
+
+				pressure, err := ReadHostPressure(procDir)
+				So(err, ShouldBeNil)
+				So(pressure, ShouldAlmostEqual, 1.5)
+			})
+
+			Convey("goes by the memory in use when that's higher", func() {
+				writeProc(0, 1000000, 250000)
+
+				pressure, err := ReadHostPressure(procDir)
+				So(err, ShouldBeNil)
+				So(pressure, ShouldAlmostEqual, 0.75)
+			})
+
+			Convey("returns an error when it can't read them", func() {
+				_, err := ReadHostPressure(filepath.Join(procDir, "missing"))
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("SetPressure() only announces changes that matter", func() {
+			delegate := NewDelegate(catalog.NewServicesState())
+
+			So(delegate.SetPressure(0.93), ShouldBeTrue)
+			So(delegate.Metadata.Pressure, ShouldAlmostEqual, 0.9)
+			So(delegate.SetPressure(0.87), ShouldBeFalse)
+			So(delegate.SetPressure(1.24), ShouldBeTrue)
+
+			var meta NodeMetadata
+			So(json.Unmarshal(delegate.NodeMeta(512), &meta), ShouldBeNil)
+			So(meta.Pressure, ShouldAlmostEqual, 1.2)
+		})
+
+		Convey("PeerPressure() reads it from the members that report it", func() {
+			members := []*memberlist.Node{
+				{Name: "chaucer", Meta: []byte(`{"ClusterName":"default","Pressure":1.3}`)},
+				{Name: "dante", Meta: []byte(`{"ClusterName":"default"}`)},
+				{Name: "petrarch", Meta: []byte(`garbage`)},
+			}
+
+			So(PeerPressure(members), ShouldResemble, map[string]float64{"chaucer": 1.3})
+		})
+	})
+}
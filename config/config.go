@@ -8,10 +8,27 @@ import (
 	"gopkg.in/relistan/rubberneck.v1"
 )
 
+// A Secret is a setting that must not be logged when the config is printed
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+
+	return "********"
+}
+
 type ListenerUrlsConfig struct {
 	Urls []string `envconfig:"URLS"`
 }
 
+type WebhooksConfig struct {
+	HealthUrls []string `envconfig:"HEALTH_URLS"`
+	LocalOnly  bool     `envconfig:"LOCAL_ONLY"`
+	OutageUrls []string `envconfig:"OUTAGE_URLS"`
+}
+
 type HAproxyConfig struct {
 	ReloadCmd    string `envconfig:"RELOAD_COMMAND"`
 	VerifyCmd    string `envconfig:"VERIFY_COMMAND"`
@@ -23,6 +40,11 @@ type HAproxyConfig struct {
 	User         string `envconfig:"USER" default:"haproxy"`
 	Group        string `envconfig:"GROUP" default:"haproxy"`
 	UseHostnames bool   `envconfig:"USE_HOSTNAMES"`
+	TLSBindIP    string `envconfig:"TLS_BIND_IP" default:"0.0.0.0"`
+
+	StaggerMode      string        `envconfig:"STAGGER_MODE"`
+	StaggerWindow    time.Duration `envconfig:"STAGGER_WINDOW" default:"30s"`
+	StaggerThreshold int           `envconfig:"STAGGER_THRESHOLD" default:"10"`
 }
 
 type EnvoyConfig struct {
@@ -36,39 +58,114 @@ type ServicesConfig struct {
 	NameMatch    string `envconfig:"NAME_MATCH"`
 	ServiceNamer string `envconfig:"NAMER" default:"docker_label"`
 	NameLabel    string `envconfig:"NAME_LABEL" default:"ServiceName"`
+	AliasNetwork string `envconfig:"ALIAS_NETWORK"`
 }
 
 type SidecarConfig struct {
-	ExcludeIPs           []string      `envconfig:"EXCLUDE_IPS" default:"192.168.168.168"`
-	Discovery            []string      `envconfig:"DISCOVERY" default:"docker"`
-	StatsAddr            string        `envconfig:"STATS_ADDR"`
-	PushPullInterval     time.Duration `envconfig:"PUSH_PULL_INTERVAL" default:"20s"`
-	GossipMessages       int           `envconfig:"GOSSIP_MESSAGES" default:"15"`
-	LoggingFormat        string        `envconfig:"LOGGING_FORMAT"`
-	LoggingLevel         string        `envconfig:"LOGGING_LEVEL" default:"info"`
-	DefaultCheckEndpoint string        `envconfig:"DEFAULT_CHECK_ENDPOINT" default:"/version"`
-	Seeds                []string      `envconfig:"SEEDS"`
-	ClusterName          string        `envconfig:"CLUSTER_NAME" default:"default"`
-	AdvertiseIP          string        `envconfig:"ADVERTISE_IP"`
-	BindPort             int           `envconfig:"BIND_PORT" default:"7946"`
+	ExcludeIPs            []string          `envconfig:"EXCLUDE_IPS" default:"192.168.168.168"`
+	Discovery             []string          `envconfig:"DISCOVERY" default:"docker"`
+	StatsAddr             string            `envconfig:"STATS_ADDR"`
+	PushPullInterval      time.Duration     `envconfig:"PUSH_PULL_INTERVAL" default:"20s"`
+	GossipMessages        int               `envconfig:"GOSSIP_MESSAGES" default:"15"`
+	LoggingFormat         string            `envconfig:"LOGGING_FORMAT"`
+	LoggingLevel          string            `envconfig:"LOGGING_LEVEL" default:"info"`
+	DefaultCheckEndpoint  string            `envconfig:"DEFAULT_CHECK_ENDPOINT" default:"/version"`
+	DefaultCheckPolicy    string            `envconfig:"DEFAULT_CHECK_POLICY" default:"http"`
+	Seeds                 []string          `envconfig:"SEEDS"`
+	ClusterName           string            `envconfig:"CLUSTER_NAME" default:"default"`
+	AdvertiseIP           string            `envconfig:"ADVERTISE_IP"`
+	AdvertiseHostname     string            `envconfig:"ADVERTISE_HOSTNAME"`
+	AdvertiseInterface    string            `envconfig:"ADVERTISE_INTERFACE"`
+	AdvertiseDefaultRoute bool              `envconfig:"ADVERTISE_DEFAULT_ROUTE"`
+	AdvertiseCloud        string            `envconfig:"ADVERTISE_CLOUD"`
+	AdvertiseCloudIface   int               `envconfig:"ADVERTISE_CLOUD_INTERFACE"`
+	DisplayName           string            `envconfig:"DISPLAY_NAME"`
+	HostMetadata          map[string]string `envconfig:"HOST_METADATA"`
+	BindPort              int               `envconfig:"BIND_PORT" default:"7946"`
+	ReadOnlyAPI           bool              `envconfig:"READ_ONLY_API"`
+	Role                  string            `envconfig:"ROLE" default:"server"`
+	Servers               []string          `envconfig:"SERVERS"`
+	Zone                  string            `envconfig:"ZONE"`
+	Namespace             string            `envconfig:"NAMESPACE"`
+	ReportPressure        bool              `envconfig:"REPORT_PRESSURE"`
+	PressureThreshold     float64           `envconfig:"PRESSURE_THRESHOLD"`
+	ProcDir               string            `envconfig:"PROC_DIR" default:"/proc"`
+	UIAssetDir            string            `envconfig:"UI_ASSET_DIR"`
+	MaxServicesPerHost    int               `envconfig:"MAX_SERVICES_PER_HOST"`
+	MaxChangesPerSecond   float64           `envconfig:"MAX_CHANGES_PER_SECOND"`
+	MaxChangeBurst        int               `envconfig:"MAX_CHANGE_BURST" default:"50"`
+	StateStore            string            `envconfig:"STATE_STORE"`
+	DryRun                bool              `envconfig:"DRY_RUN"`
+	ReusePort             bool              `envconfig:"REUSE_PORT"`
+	HandoffTimeout        time.Duration     `envconfig:"HANDOFF_TIMEOUT" default:"10s"`
+	OutageGracePeriod     time.Duration     `envconfig:"OUTAGE_GRACE_PERIOD" default:"30s"`
+	LeftNodeGrace         time.Duration     `envconfig:"LEFT_NODE_GRACE"`
+	FailedNodeGrace       time.Duration     `envconfig:"FAILED_NODE_GRACE"`
+	LeftNodePurge         time.Duration     `envconfig:"LEFT_NODE_PURGE" default:"3h"`
+	FailedNodePurge       time.Duration     `envconfig:"FAILED_NODE_PURGE" default:"3h"`
+	ApiToken              Secret            `envconfig:"API_TOKEN"`
+	ListenerRegistry      string            `envconfig:"LISTENER_REGISTRY"`
+	MaintenanceWindows    string            `envconfig:"MAINTENANCE_WINDOWS"`
 }
 
 type DockerConfig struct {
-	DockerURL string `envconfig:"URL" default:"unix:///var/run/docker.sock"`
+	DockerURL         string   `envconfig:"URL" default:"unix:///var/run/docker.sock"`
+	AdvertiseNetworks []string `envconfig:"ADVERTISE_NETWORKS"`
+	UseEnvConfig      bool     `envconfig:"USE_ENV_CONFIG"`
+	ExcludeInfra      bool     `envconfig:"EXCLUDE_INFRA" default:"true"`
+	ExcludeImages     []string `envconfig:"EXCLUDE_IMAGES"`
+	APIVersion        string   `envconfig:"API_VERSION"`
+}
+
+type AcmeConfig struct {
+	Enable        bool          `envconfig:"ENABLE"`
+	Issuer        string        `envconfig:"ISSUER"` // The one host that requests certificates
+	Email         string        `envconfig:"EMAIL"`
+	DirectoryURL  string        `envconfig:"DIRECTORY_URL"`
+	CacheDir      string        `envconfig:"CACHE_DIR" default:"/var/lib/sidecar/acme"`
+	CertDir       string        `envconfig:"CERT_DIR" default:"/etc/haproxy/certs"`
+	CheckInterval time.Duration `envconfig:"CHECK_INTERVAL" default:"1h"`
+}
+
+type SimulationConfig struct {
+	Services     int           `envconfig:"SERVICES" default:"20"`
+	Hosts        int           `envconfig:"HOSTS" default:"3"`
+	ServiceChurn time.Duration `envconfig:"SERVICE_CHURN" default:"30s"`
+	HostChurn    time.Duration `envconfig:"HOST_CHURN" default:"5m"`
+	FlapRate     float64       `envconfig:"FLAP_RATE" default:"0.05"`
+}
+
+type SnapshotConfig struct {
+	Interval       time.Duration `envconfig:"INTERVAL"`
+	RestoreOnStart bool          `envconfig:"RESTORE_ON_START"`
+	S3Bucket       string        `envconfig:"S3_BUCKET"`
+	S3Key          string        `envconfig:"S3_KEY" default:"sidecar/state.json"`
+	S3Region       string        `envconfig:"S3_REGION" default:"us-east-1"`
+	S3Endpoint     string        `envconfig:"S3_ENDPOINT"`
 }
 
 type StaticConfig struct {
 	ConfigFile string `envconfig:"CONFIG_FILE" default:"static.json"`
 }
 
+type ExternalConfig struct {
+	Source          string        `envconfig:"SOURCE"`
+	RefreshInterval time.Duration `envconfig:"REFRESH_INTERVAL" default:"1m"`
+}
+
 type Config struct {
 	Sidecar         SidecarConfig      // SIDECAR_
 	DockerDiscovery DockerConfig       // DOCKER_
 	StaticDiscovery StaticConfig       // STATIC_
+	External        ExternalConfig     // EXTERNAL_
+	Simulation      SimulationConfig   // SIMULATION_
 	Services        ServicesConfig     // SERVICES_
 	HAproxy         HAproxyConfig      // HAPROXY_
 	Envoy           EnvoyConfig        // ENVOY_
+	Acme            AcmeConfig         // ACME_
+	Snapshot        SnapshotConfig     // SNAPSHOT_
 	Listeners       ListenerUrlsConfig // LISTENERS_
+	Webhooks        WebhooksConfig     // WEBHOOKS_
 }
 
 func ParseConfig() *Config {
@@ -78,10 +175,15 @@ func ParseConfig() *Config {
 		envconfig.Process("sidecar", &config.Sidecar),
 		envconfig.Process("docker", &config.DockerDiscovery),
 		envconfig.Process("static", &config.StaticDiscovery),
+		envconfig.Process("external", &config.External),
+		envconfig.Process("simulation", &config.Simulation),
 		envconfig.Process("services", &config.Services),
 		envconfig.Process("haproxy", &config.HAproxy),
 		envconfig.Process("envoy", &config.Envoy),
+		envconfig.Process("acme", &config.Acme),
+		envconfig.Process("snapshot", &config.Snapshot),
 		envconfig.Process("listeners", &config.Listeners),
+		envconfig.Process("webhooks", &config.Webhooks),
 	}
 
 	for _, err := range errs {
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Support for running Sidecar itself in a container, talking to the host's
+// Docker daemon over its mounted socket rather than running Docker-in-Docker.
+
+const (
+	DOCKER_ENV_FILE = "/.dockerenv"          // Docker drops this in every container
+	CGROUP_FILE     = "/proc/self/cgroup"    // Names our container on cgroup v1
+	MOUNTINFO_FILE  = "/proc/self/mountinfo" // Names our container on cgroup v2
+	ROUTE_FILE      = "/proc/net/route"      // The IPv4 routing table
+
+	DEFAULT_DOCKER_URL = "unix:///var/run/docker.sock"    // Where Docker listens on Linux
+	WINDOWS_DOCKER_URL = "npipe:////./pipe/docker_engine" // Where Docker listens on Windows
+)
+
+var (
+	containerIDMatch = regexp.MustCompile(`[0-9a-f]{64}`)
+	mountIDMatch     = regexp.MustCompile(`/containers/([0-9a-f]{64})/`)
+
+	// The mounts Docker makes from the container's own directory
+	containerIDMounts = map[string]bool{"/etc/hostname": true, "/etc/hosts": true}
+)
+
+// runningInContainer tells us if we appear to be running in a container
+func runningInContainer() bool {
+	_, err := os.Stat(DOCKER_ENV_FILE)
+	return err == nil
+}
+
+// ownContainerID returns the ID of the container we're running in, or an
+// empty string if we can't tell or aren't in one. We only look when we are
+// in a container: on the host, the files below name other containers.
+func ownContainerID() string {
+	if !runningInContainer() {
+		return ""
+	}
+
+	if id := containerIDFromCgroup(CGROUP_FILE); id != "" {
+		return id
+	}
+
+	return containerIDFromMountinfo(MOUNTINFO_FILE)
+}
+
+// containerIDFromCgroup finds a container ID in the cgroup paths we're in.
+// This works for Docker, and for Kubernetes running on Docker, with cgroup v1.
+func containerIDFromCgroup(path string) string {
+	var id string
+	eachLine(path, func(line string) bool {
+		id = containerIDMatch.FindString(line)
+		return id == ""
+	})
+
+	return id
+}
+
+// containerIDFromMountinfo finds a container ID in the files Docker mounts
+// into the container, /etc/hostname and /etc/hosts. Needed with cgroup v2,
+// where the cgroup file doesn't tell us anything. Other mounts, like shared
+// memory, can come from other containers.
+func containerIDFromMountinfo(path string) string {
+	var id string
+	eachLine(path, func(line string) bool {
+		// ID, parent ID, device, root, mount point, ...
+		fields := strings.Fields(line)
+		if len(fields) < 5 || !containerIDMounts[fields[4]] {
+			return true
+		}
+
+		if match := mountIDMatch.FindStringSubmatch(fields[3]); match != nil {
+			id = match[1]
+		}
+		return id == ""
+	})
+
+	return id
+}
+
+// defaultRouteInterface returns the name of the interface the IPv4 default
+// route goes out of, from the kernel's routing table.
+func defaultRouteInterface(path string) (string, error) {
+	var iface string
+	eachLine(path, func(line string) bool {
+		fields := strings.Fields(line)
+		// Iface, Destination, Gateway, ... Mask is the eighth field
+		if len(fields) >= 8 && fields[1] == "00000000" && fields[7] == "00000000" {
+			iface = fields[0]
+		}
+		return iface == ""
+	})
+
+	if iface == "" {
+		return "", errors.New("Can't find the default route in " + path)
+	}
+
+	return iface, nil
+}
+
+// interfaceAddress returns the first IPv4 address on the named interface
+func interfaceAddress(name string) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", errors.New("Can't find interface " + name + ": " + err.Error())
+	}
+
+	addresses, err := iface.Addrs()
+	if err != nil {
+		return "", errors.New("Can't get addresses for " + name + ": " + err.Error())
+	}
+
+	for _, rawAddr := range addresses {
+		if addr, ok := rawAddr.(*net.IPNet); ok && addr.IP.To4() != nil {
+			return addr.IP.String(), nil
+		}
+	}
+
+	return "", errors.New("No IPv4 address on interface " + name)
+}
+
+// advertiseAddress works out which address to advertise, when it was not
+// set explicitly, from the configured interface or the one the default route
+// goes out of. Returns an empty string when neither is configured, so that
+// getPublishedIP() will go looking for a private address.
+func advertiseAddress(advertiseIP string, iface string, useDefaultRoute bool) (string, error) {
+	if advertiseIP != "" {
+		return advertiseIP, nil
+	}
+
+	if iface == "" && useDefaultRoute {
+		var err error
+		iface, err = defaultRouteInterface(ROUTE_FILE)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if iface == "" {
+		return "", nil
+	}
+
+	return interfaceAddress(iface)
+}
+
+// dockerEndpoint checks that the Docker socket we were told to use is there.
+// When it isn't, we fall back to the DOCKER_* environment variables if they
+// are set, or otherwise explain how to mount it into our container.
+func dockerEndpoint(url string) string {
+	url = platformDockerURL(url, runtime.GOOS)
+	if !strings.HasPrefix(url, "unix://") {
+		return url
+	}
+
+	socket := strings.TrimPrefix(url, "unix://")
+	if _, err := os.Stat(socket); err == nil {
+		return url
+	}
+
+	if os.Getenv("DOCKER_HOST") != "" {
+		log.Warnf("Docker socket %s not found, using DOCKER_HOST instead", socket)
+		return ""
+	}
+
+	if runningInContainer() {
+		log.Errorf(
+			"Docker socket %s not found! When running Sidecar in a container, mount it with -v %s:%s",
+			socket, socket, socket,
+		)
+	}
+
+	return url
+}
+
+// platformDockerURL swaps the default Docker socket for the named pipe
+// Docker listens on under Windows, where there are no Unix sockets.
+func platformDockerURL(url string, goos string) string {
+	if goos == "windows" && url == DEFAULT_DOCKER_URL {
+		return WINDOWS_DOCKER_URL
+	}
+
+	return url
+}
+
+// eachLine calls fn with each line of a file until fn returns false. Files
+// that can't be read are treated as empty.
+func eachLine(path string, fn func(line string) bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if !fn(scanner.Text()) {
+			return
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Container(t *testing.T) {
+	Convey("Running in a container", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-container")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+
+		id := "4c01db0b339cbd6d6d6ab8ba1e4e7bb21a09cc0fb1e08dd1e4b7cc1f83ab3c4f"
+
+		writeFile := func(name string, contents string) string {
+			path := filepath.Join(dir, name)
+			So(ioutil.WriteFile(path, []byte(contents), 0644), ShouldBeNil)
+			return path
+		}
+
+		Convey("containerIDFromCgroup()", func() {
+			Convey("finds the ID of a Docker container", func() {
+				path := writeFile("cgroup", "12:pids:/docker/"+id+"\n11:memory:/docker/"+id+"\n")
+				So(containerIDFromCgroup(path), ShouldEqual, id)
+			})
+
+			Convey("finds nothing outside a container", func() {
+				path := writeFile("cgroup", "12:pids:/user.slice/user-1000.slice\n0::/init.scope\n")
+				So(containerIDFromCgroup(path), ShouldBeEmpty)
+			})
+
+			Convey("finds nothing when the file is missing", func() {
+				So(containerIDFromCgroup(filepath.Join(dir, "missing")), ShouldBeEmpty)
+			})
+		})
+
+		Convey("containerIDFromMountinfo()", func() {
+			Convey("finds the ID with cgroup v2", func() {
+				path := writeFile("mountinfo",
+					"633 614 0:52 / / rw,relatime master:308 - overlay overlay rw\n"+
+						"650 633 259:1 /var/lib/docker/containers/"+id+"/hostname /etc/hostname rw - ext4 /dev/root rw\n",
+				)
+				So(containerIDFromMountinfo(path), ShouldEqual, id)
+			})
+
+			Convey("ignores other containers' mounts on the host", func() {
+				path := writeFile("mountinfo",
+					"26 1 259:1 / / rw,relatime - ext4 /dev/root rw\n"+
+						"812 26 0:61 / /var/lib/docker/containers/"+id+"/mounts/shm rw - tmpfs shm rw\n",
+				)
+				So(containerIDFromMountinfo(path), ShouldBeEmpty)
+			})
+		})
+
+		Convey("defaultRouteInterface()", func() {
+			Convey("finds the interface the default route uses", func() {
+				path := writeFile("route",
+					"Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n"+
+						"docker0\t000011AC\t00000000\t0001\t0\t0\t0\t0000FFFF\t0\t0\t0\n"+
+						"ens5\t00000000\t0100000A\t0003\t0\t0\t100\t00000000\t0\t0\t0\n",
+				)
+				iface, err := defaultRouteInterface(path)
+				So(err, ShouldBeNil)
+				So(iface, ShouldEqual, "ens5")
+			})
+
+			Convey("errors when there is no default route", func() {
+				path := writeFile("route", "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\n")
+				_, err := defaultRouteInterface(path)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("advertiseAddress()", func() {
+			Convey("prefers the configured address", func() {
+				address, err := advertiseAddress("10.3.2.1", "eth0", true)
+				So(err, ShouldBeNil)
+				So(address, ShouldEqual, "10.3.2.1")
+			})
+
+			Convey("returns nothing when there's nothing configured", func() {
+				address, err := advertiseAddress("", "", false)
+				So(err, ShouldBeNil)
+				So(address, ShouldBeEmpty)
+			})
+
+			Convey("errors on an interface that doesn't exist", func() {
+				_, err := advertiseAddress("", "nonexistent0", false)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("dockerEndpoint()", func() {
+			Convey("leaves TCP URLs alone", func() {
+				So(dockerEndpoint("tcp://10.3.2.1:2375"), ShouldEqual, "tcp://10.3.2.1:2375")
+			})
+
+			Convey("uses the socket when it's there", func() {
+				socket := writeFile("docker.sock", "")
+				So(dockerEndpoint("unix://"+socket), ShouldEqual, "unix://"+socket)
+			})
+
+			Convey("falls back to DOCKER_HOST when the socket is missing", func() {
+				os.Setenv("DOCKER_HOST", "tcp://10.3.2.1:2375")
+				Reset(func() { os.Unsetenv("DOCKER_HOST") })
+
+				So(dockerEndpoint("unix://"+filepath.Join(dir, "missing.sock")), ShouldBeEmpty)
+			})
+		})
+
+		Convey("platformDockerURL()", func() {
+			Convey("uses the named pipe on Windows", func() {
+				So(platformDockerURL(DEFAULT_DOCKER_URL, "windows"), ShouldEqual, WINDOWS_DOCKER_URL)
+			})
+
+			Convey("leaves the default alone elsewhere", func() {
+				So(platformDockerURL(DEFAULT_DOCKER_URL, "linux"), ShouldEqual, DEFAULT_DOCKER_URL)
+			})
+
+			Convey("leaves configured URLs alone on Windows", func() {
+				So(platformDockerURL("tcp://10.3.2.1:2375", "windows"), ShouldEqual, "tcp://10.3.2.1:2375")
+			})
+		})
+	})
+}
@@ -0,0 +1,82 @@
+package discovery
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Infrastructure containers, like the Kubernetes pod sandbox, hold namespaces
+// for other containers and never serve anything themselves. Their images are
+// called pause, or pause-<arch> for the older per-architecture builds, e.g.
+// k8s.gcr.io/pause:3.1 or gcr.io/google_containers/pause-amd64:3.0.
+var infraImageMatch = regexp.MustCompile(`^pause(-(amd64|arm|arm64|ppc64le|s390x|386|windows))?$`)
+
+// excludeReason tells us why a container should be left out of discovery,
+// or returns an empty string if it shouldn't be. The SidecarDiscover label is
+// handled separately since it applies to all discovery methods.
+// Note: not synchronized!
+func (d *DockerDiscovery) excludeReason(container *docker.APIContainers) string {
+	if d.SelfID != "" && strings.HasPrefix(container.ID, d.SelfID) {
+		return "it is the Sidecar container"
+	}
+
+	if d.ExcludeInfra && isInfraContainer(container) {
+		return "it is an infrastructure container"
+	}
+
+	for _, pattern := range d.ExcludeImages {
+		if matched, _ := path.Match(pattern, container.Image); matched {
+			return "its image matches " + pattern
+		}
+	}
+
+	return ""
+}
+
+// isInfraContainer recognizes pause containers by their Kubernetes labels,
+// their names, or their images.
+func isInfraContainer(container *docker.APIContainers) bool {
+	if container.Labels["io.kubernetes.container.name"] == "POD" {
+		return true
+	}
+
+	for _, name := range container.Names {
+		if strings.HasPrefix(name, "/k8s_POD_") {
+			return true
+		}
+	}
+
+	return infraImageMatch.MatchString(imageName(container.Image))
+}
+
+// imageName strips the registry, repository path, tag, and digest from an
+// image reference, e.g. k8s.gcr.io/pause:3.1 becomes pause.
+func imageName(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+
+	if i := strings.LastIndex(image, "/"); i >= 0 {
+		image = image[i+1:]
+	}
+
+	if i := strings.Index(image, ":"); i >= 0 {
+		image = image[:i]
+	}
+
+	return image
+}
+
+// ValidateImagePatterns checks the patterns given for ExcludeImages
+func ValidateImagePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package discovery
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ContainerFilter(t *testing.T) {
+	Convey("Filtering containers from discovery", t, func() {
+		disco := NewDockerDiscovery("", nil, "127.0.0.1")
+
+		container := &docker.APIContainers{
+			ID:     "deadbeef4567abcdef",
+			Image:  "nginx:latest",
+			Names:  []string{"/beowulf-deadbeef4567"},
+			Labels: map[string]string{},
+		}
+
+		Convey("keeps ordinary containers", func() {
+			So(disco.excludeReason(container), ShouldBeEmpty)
+		})
+
+		Convey("leaves out the Sidecar container", func() {
+			disco.SelfID = "deadbeef4567"
+			So(disco.excludeReason(container), ShouldEqual, "it is the Sidecar container")
+		})
+
+		Convey("leaves out infrastructure containers", func() {
+			for _, image := range []string{
+				"k8s.gcr.io/pause:3.1",
+				"gcr.io/google_containers/pause-amd64:3.0",
+				"pause@sha256:f78411e19d84a252e53bff71a4407a5686c46983a2c2eeed83929b888179acea",
+			} {
+				container.Image = image
+				So(disco.excludeReason(container), ShouldEqual, "it is an infrastructure container")
+			}
+		})
+
+		Convey("keeps images that merely start with pause", func() {
+			for _, image := range []string{
+				"pauser:1.0",
+				"example.com/pause-service:2",
+				"pause-detector@sha256:f78411e19d84a252e53bff71a4407a5686c46983a2c2eeed83929b888179acea",
+			} {
+				container.Image = image
+				So(disco.excludeReason(container), ShouldBeEmpty)
+			}
+		})
+
+		Convey("recognizes Kubernetes pod sandboxes by label and name", func() {
+			container.Labels["io.kubernetes.container.name"] = "POD"
+			So(isInfraContainer(container), ShouldBeTrue)
+
+			delete(container.Labels, "io.kubernetes.container.name")
+			container.Names = []string{"/k8s_POD_beowulf-5d8f7b_default_0"}
+			So(isInfraContainer(container), ShouldBeTrue)
+		})
+
+		Convey("keeps infrastructure containers when asked to", func() {
+			disco.ExcludeInfra = false
+			container.Image = "k8s.gcr.io/pause:3.1"
+			So(disco.excludeReason(container), ShouldBeEmpty)
+		})
+
+		Convey("leaves out images matching the configured patterns", func() {
+			disco.ExcludeImages = []string{"datadog/agent*", "nginx:*"}
+			So(disco.excludeReason(container), ShouldEqual, "its image matches nginx:*")
+
+			container.Image = "datadog/agent:7"
+			So(disco.excludeReason(container), ShouldEqual, "its image matches datadog/agent*")
+
+			container.Image = "registry.example.com/datadog/agent:7"
+			So(disco.excludeReason(container), ShouldBeEmpty)
+		})
+	})
+
+	Convey("ValidateImagePatterns()", t, func() {
+		So(ValidateImagePatterns([]string{"datadog/*", "nginx"}), ShouldBeNil)
+		So(ValidateImagePatterns([]string{"nginx["}), ShouldNotBeNil)
+	})
+}
@@ -36,6 +36,37 @@ type Discoverer interface {
 	Run(director.Looper)
 }
 
+// A MaintenanceScheduler is a Discoverer that can also tell us when a service
+// is scheduled to be down for maintenance. The window is returned as a spec
+// string and is parsed by the health checker.
+type MaintenanceScheduler interface {
+	MaintenanceWindow(svc *service.Service) string
+}
+
+// A MaintenanceTagger is a Discoverer that can also tell us which maintenance
+// tags a service has. Each tag stands for a window shared by all the services
+// that carry it, which is configured on the health checker.
+type MaintenanceTagger interface {
+	MaintenanceTags(svc *service.Service) []string
+}
+
+// CheckTLS holds the TLS settings for an HTTPS health check, for services
+// behind an internal CA or that require client certificates. File paths are
+// read on the host running Sidecar.
+type CheckTLS struct {
+	SkipVerify bool   `json:",omitempty"`
+	CAFile     string `json:",omitempty"`
+	CertFile   string `json:",omitempty"`
+	KeyFile    string `json:",omitempty"`
+	ServerName string `json:",omitempty"`
+}
+
+// A CheckTLSProvider is a Discoverer that can also supply TLS settings for
+// the health check of a service. Returns nil when there are none.
+type CheckTLSProvider interface {
+	HealthCheckTLS(svc *service.Service) *CheckTLS
+}
+
 // A MultiDiscovery is a wrapper around zero or more Discoverers.
 // It allows the use of potentially multiple Discoverers in place of one.
 type MultiDiscovery struct {
@@ -53,6 +84,86 @@ func (d *MultiDiscovery) HealthCheck(svc *service.Service) (string, string) {
 	return "", ""
 }
 
+// Get the maintenance window for a service from the first discoverer that
+// supports them and has one
+func (d *MultiDiscovery) MaintenanceWindow(svc *service.Service) string {
+	for _, disco := range d.Discoverers {
+		scheduler, ok := disco.(MaintenanceScheduler)
+		if !ok {
+			continue
+		}
+
+		if window := scheduler.MaintenanceWindow(svc); window != "" {
+			return window
+		}
+	}
+	return ""
+}
+
+// Get the maintenance tags for a service from the first discoverer that
+// supports them and has some
+func (d *MultiDiscovery) MaintenanceTags(svc *service.Service) []string {
+	for _, disco := range d.Discoverers {
+		tagger, ok := disco.(MaintenanceTagger)
+		if !ok {
+			continue
+		}
+
+		if tags := tagger.MaintenanceTags(svc); len(tags) > 0 {
+			return tags
+		}
+	}
+	return nil
+}
+
+// Get the health check TLS settings for a service from the first discoverer
+// that supports them and has some
+func (d *MultiDiscovery) HealthCheckTLS(svc *service.Service) *CheckTLS {
+	for _, disco := range d.Discoverers {
+		provider, ok := disco.(CheckTLSProvider)
+		if !ok {
+			continue
+		}
+
+		if checkTLS := provider.HealthCheckTLS(svc); checkTLS != nil {
+			return checkTLS
+		}
+	}
+	return nil
+}
+
+// Aggregates all the label warnings from the discoverers that report them
+func (d *MultiDiscovery) Warnings() []LabelWarning {
+	var aggregate []LabelWarning
+
+	for _, disco := range d.Discoverers {
+		reporter, ok := disco.(WarningReporter)
+		if !ok {
+			continue
+		}
+
+		aggregate = append(aggregate, reporter.Warnings()...)
+	}
+
+	return aggregate
+}
+
+// Returns the Docker daemon version from the first discoverer that knows it
+func (d *MultiDiscovery) DockerVersion() *DockerVersion {
+	for _, disco := range d.Discoverers {
+		reporter, ok := disco.(DockerVersionReporter)
+		if !ok {
+			continue
+		}
+
+		if version := reporter.DockerVersion(); version != nil {
+			return version
+		}
+	}
+
+	return nil
+}
+
 // Aggregates all the service slices from the discoverers
 func (d *MultiDiscovery) Services() []service.Service {
 	var aggregate []service.Service
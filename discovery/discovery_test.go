@@ -115,5 +115,35 @@ func Test_MultiDiscovery(t *testing.T) {
 			So(check, ShouldEqual, "")
 			So(args, ShouldEqual, "")
 		})
+
+		Convey("MaintenanceWindow() asks the discoverers that support it", func() {
+			static := &StaticDiscovery{
+				Targets: []*Target{{Service: svc2, MaintenanceWindow: "0 3 * * * 1h"}},
+			}
+			multi.Discoverers = append(multi.Discoverers, static)
+
+			So(multi.MaintenanceWindow(&svc2), ShouldEqual, "0 3 * * * 1h")
+			So(multi.MaintenanceWindow(&svc1), ShouldEqual, "")
+		})
+
+		Convey("MaintenanceTags() asks the discoverers that support it", func() {
+			static := &StaticDiscovery{
+				Targets: []*Target{{Service: svc2, MaintenanceTags: []string{"databases"}}},
+			}
+			multi.Discoverers = append(multi.Discoverers, static)
+
+			So(multi.MaintenanceTags(&svc2), ShouldResemble, []string{"databases"})
+			So(multi.MaintenanceTags(&svc1), ShouldBeEmpty)
+		})
+
+		Convey("HealthCheckTLS() asks the discoverers that support it", func() {
+			static := &StaticDiscovery{
+				Targets: []*Target{{Service: svc2, Check: StaticCheck{TLS: &CheckTLS{SkipVerify: true}}}},
+			}
+			multi.Discoverers = append(multi.Discoverers, static)
+
+			So(multi.HealthCheckTLS(&svc2), ShouldResemble, &CheckTLS{SkipVerify: true})
+			So(multi.HealthCheckTLS(&svc1), ShouldBeNil)
+		})
 	})
 }
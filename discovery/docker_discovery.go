@@ -2,10 +2,13 @@ package discovery
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/armon/go-metrics"
 	director "github.com/relistan/go-director"
 	log "github.com/sirupsen/logrus"
 
@@ -15,6 +18,8 @@ import (
 
 const (
 	CacheDrainInterval = 10 * time.Minute // Drain the cache every 10 mins
+	EventBufferSize    = 1024             // How many Docker events we queue up before they get dropped
+	WindowsNatNetwork  = "nat"            // The default Windows network, only reachable from the host
 )
 
 type DockerClient interface {
@@ -23,28 +28,41 @@ type DockerClient interface {
 	AddEventListener(listener chan<- *docker.APIEvents) error
 	RemoveEventListener(listener chan *docker.APIEvents) error
 	Ping() error
+	CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error)
+	StartExec(id string, opts docker.StartExecOptions) error
+	InspectExec(id string) (*docker.ExecInspect, error)
 }
 
 type DockerDiscovery struct {
-	events         chan *docker.APIEvents       // Where events are announced to us
-	endpoint       string                       // The Docker endpoint to talk to
-	services       []*service.Service           // The list of services we know about
-	ClientProvider func() (DockerClient, error) // Return the client we'll use to connect
-	serviceNamer   ServiceNamer                 // The service namer implementation
-	advertiseIp    string                       // The address we'll advertise for services
-	containerCache *ContainerCache              // Stores full container data for fast lookups
-	sleepInterval  time.Duration                // The sleep interval for event processing and reconnection
-	sync.RWMutex                                // Reader/Writer lock
+	events            chan *docker.APIEvents       // Where events are announced to us
+	endpoint          string                       // The Docker endpoint to talk to
+	services          []*service.Service           // The list of services we know about
+	ClientProvider    func() (DockerClient, error) // Return the client we'll use to connect
+	serviceNamer      ServiceNamer                 // The service namer implementation
+	advertiseIp       string                       // The address we'll advertise for services
+	containerCache    *ContainerCache              // Stores full container data for fast lookups
+	sleepInterval     time.Duration                // The sleep interval for event processing and reconnection
+	AdvertiseNetworks []string                     // Docker networks whose address we prefer to advertiseIp
+	UseEnvConfig      bool                         // Also read SIDECAR_* env vars in place of labels
+	Hostname          string                       // Announce services from this host name instead of ours
+	SelfID            string                       // The container Sidecar itself runs in, never discovered
+	ExcludeInfra      bool                         // Leave out pause containers and the like
+	ExcludeImages     []string                     // Leave out containers whose image matches these patterns
+	warnings          []LabelWarning               // Malformed labels found on the last pass
+	APIVersion        string                       // Use this Docker API version rather than negotiating one
+	dockerVersion     *DockerVersion               // The daemon's version, found when we connect
+	sync.RWMutex                                   // Reader/Writer lock
 }
 
 func NewDockerDiscovery(endpoint string, svcNamer ServiceNamer, ip string) *DockerDiscovery {
 	discovery := DockerDiscovery{
 		endpoint:       endpoint,
-		events:         make(chan *docker.APIEvents),
+		events:         make(chan *docker.APIEvents, EventBufferSize),
 		containerCache: NewContainerCache(),
 		serviceNamer:   svcNamer,
 		advertiseIp:    ip,
 		sleepInterval:  DefaultSleepInterval,
+		ExcludeInfra:   true,
 	}
 
 	// Default to our own method for returning this
@@ -53,9 +71,20 @@ func NewDockerDiscovery(endpoint string, svcNamer ServiceNamer, ip string) *Dock
 	return &discovery
 }
 
+// getDockerClient connects with the API version we negotiated with the
+// daemon, if we have yet, or lets the daemon pick one.
 func (d *DockerDiscovery) getDockerClient() (DockerClient, error) {
+	version := d.negotiatedVersion()
+
 	if d.endpoint != "" {
-		client, err := docker.NewClient(d.endpoint)
+		newClient := docker.NewClient
+		if version != "" {
+			newClient = func(endpoint string) (*docker.Client, error) {
+				return docker.NewVersionedClient(endpoint, version)
+			}
+		}
+
+		client, err := newClient(d.endpoint)
 		if err != nil {
 			return nil, err
 		}
@@ -63,7 +92,7 @@ func (d *DockerDiscovery) getDockerClient() (DockerClient, error) {
 		return client, nil
 	}
 
-	client, err := docker.NewClientFromEnv()
+	client, err := docker.NewVersionedClientFromEnv(version)
 	if err != nil {
 		return nil, err
 	}
@@ -81,6 +110,57 @@ func (d *DockerDiscovery) HealthCheck(svc *service.Service) (string, string) {
 	return container.Config.Labels["HealthCheck"], container.Config.Labels["HealthCheckArgs"]
 }
 
+// MaintenanceWindow returns the value of the MaintenanceWindow label, if any
+func (d *DockerDiscovery) MaintenanceWindow(svc *service.Service) string {
+	container, err := d.inspectContainer(svc)
+	if err != nil {
+		return ""
+	}
+
+	return container.Config.Labels["MaintenanceWindow"]
+}
+
+// MaintenanceTags returns the comma separated tags in the MaintenanceTags
+// label, if any
+func (d *DockerDiscovery) MaintenanceTags(svc *service.Service) []string {
+	container, err := d.inspectContainer(svc)
+	if err != nil {
+		return nil
+	}
+
+	var tags []string
+	for _, tag := range strings.Split(container.Config.Labels["MaintenanceTags"], ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// HealthCheckTLS returns the TLS settings for the health check from the
+// HealthCheckTLS* labels, or nil if there are none
+func (d *DockerDiscovery) HealthCheckTLS(svc *service.Service) *CheckTLS {
+	container, err := d.inspectContainer(svc)
+	if err != nil {
+		return nil
+	}
+
+	labels := container.Config.Labels
+	checkTLS := &CheckTLS{
+		SkipVerify: labels["HealthCheckTLSSkipVerify"] == "true",
+		CAFile:     labels["HealthCheckTLSCA"],
+		CertFile:   labels["HealthCheckTLSCert"],
+		KeyFile:    labels["HealthCheckTLSKey"],
+		ServerName: labels["HealthCheckTLSServerName"],
+	}
+
+	if *checkTLS == (CheckTLS{}) {
+		return nil
+	}
+
+	return checkTLS
+}
+
 func (d *DockerDiscovery) inspectContainer(svc *service.Service) (*docker.Container, error) {
 	// If we have it cached, return it!
 	container := d.containerCache.Get(svc.ID)
@@ -101,6 +181,11 @@ func (d *DockerDiscovery) inspectContainer(svc *service.Service) (*docker.Contai
 		return nil, err
 	}
 
+	// Env vars stand in for any labels that weren't set
+	if d.UseEnvConfig && container.Config != nil {
+		container.Config.Labels = mergeEnvLabels(container.Config.Labels, container)
+	}
+
 	// Cache it for next time
 	d.containerCache.Set(svc, container)
 
@@ -114,6 +199,9 @@ func (d *DockerDiscovery) Run(looper director.Looper) {
 	go d.manageConnection(connQuitChan)
 
 	go func() {
+		var overflowed bool
+		lastResync := time.Now()
+
 		// Loop around, process any events which came in, and
 		// periodically fetch the whole container list
 		looper.Loop(func() error {
@@ -124,10 +212,29 @@ func (d *DockerDiscovery) Run(looper director.Looper) {
 					// Sleep, let us reconnect in the background, then loop.
 					return nil
 				}
+
+				// The Docker client drops events rather than block when our
+				// buffer is full, so we may have missed some. Once we've
+				// caught up, or if the storm keeps going, resync the whole
+				// container list so we still end up with the right services.
+				if eventBufferFull(d.events) {
+					if !overflowed {
+						log.Warn("Docker event buffer overflowed, will resync containers")
+					}
+					overflowed = true
+				}
+
 				log.Debugf("Event: %#v\n", event)
 				d.handleEvent(*event)
+
+				if overflowed && (len(d.events) == 0 || time.Since(lastResync) > d.sleepInterval) {
+					d.getContainers()
+					lastResync = time.Now()
+					overflowed = false
+				}
 			case <-time.After(d.sleepInterval):
 				d.getContainers()
+				lastResync = time.Now()
 			case <-time.After(CacheDrainInterval):
 				d.containerCache.Drain(len(d.services))
 			}
@@ -154,6 +261,18 @@ func (d *DockerDiscovery) Services() []service.Service {
 	return svcList
 }
 
+// Warnings returns the malformed labels we found on the containers that
+// were running on the last pass
+func (d *DockerDiscovery) Warnings() []LabelWarning {
+	d.RLock()
+	defer d.RUnlock()
+
+	warnings := make([]LabelWarning, len(d.warnings))
+	copy(warnings, d.warnings)
+
+	return warnings
+}
+
 // Listeners returns any containers we found that had the
 // SidecarListener label set to a valid ServicePort.
 func (d *DockerDiscovery) Listeners() []ChangeListener {
@@ -265,19 +384,117 @@ func (d *DockerDiscovery) getContainers() {
 
 	// Build up the service list, and prepare to prune the containerCache
 	d.services = make([]*service.Service, 0, len(containers))
+	d.warnings = nil
 	for _, container := range containers {
+		if d.UseEnvConfig {
+			inspected, err := d.inspectContainer(&service.Service{ID: container.ID[:12]})
+			if err == nil {
+				container.Labels = mergeEnvLabels(container.Labels, inspected)
+			}
+		}
+
 		// Skip services that are purposely excluded from discovery.
 		if container.Labels["SidecarDiscover"] == "false" {
 			continue
 		}
 
-		svc := service.ToService(&container, d.advertiseIp)
+		// Skip ourselves, infrastructure containers, and anything else we
+		// were told to leave out
+		if reason := d.excludeReason(&container); reason != "" {
+			log.Debugf("Not discovering container %s because %s", container.ID, reason)
+			continue
+		}
+
+		warnings := validateLabels(&container)
+		for _, warning := range warnings {
+			log.Warnf("Container %s has a bad %s label: %s", warning.ContainerID, warning.Label, warning.Message)
+		}
+		d.warnings = append(d.warnings, warnings...)
+
+		var svc service.Service
+		if ip := d.networkIPFor(&container); ip != "" {
+			svc = service.ToServiceOnNetwork(&container, ip)
+		} else if ip := d.windowsNetworkIPFor(&container); ip != "" {
+			svc = service.ToServiceOnNetwork(&container, ip)
+		} else {
+			svc = service.ToService(&container, d.advertiseIp)
+		}
 		svc.Name = d.serviceNamer.ServiceName(&container)
+		if d.Hostname != "" {
+			svc.Hostname = d.Hostname
+		}
 		d.services = append(d.services, &svc)
 		containerMap[svc.ID] = true
 	}
 
 	d.containerCache.Prune(containerMap)
+
+	metrics.SetGauge([]string{"discovery", "label_warnings"}, float32(len(d.warnings)))
+}
+
+// networkIPFor returns the container's address on the first preferred Docker
+// network it is attached to, or an empty string if there is none. Preferences
+// come from the SidecarNetwork label on the container (a comma-separated
+// list), falling back to the configured AdvertiseNetworks.
+func (d *DockerDiscovery) networkIPFor(container *docker.APIContainers) string {
+	networks := d.AdvertiseNetworks
+	if label, ok := container.Labels["SidecarNetwork"]; ok {
+		networks = strings.Split(label, ",")
+	}
+
+	for _, name := range networks {
+		network, ok := container.Networks.Networks[strings.TrimSpace(name)]
+		if ok && network.IPAddress != "" {
+			return network.IPAddress
+		}
+	}
+
+	if len(networks) > 0 {
+		log.Debugf(
+			"Container %s is not attached to any of %v, using the host address",
+			container.ID, networks,
+		)
+	}
+
+	return ""
+}
+
+// windowsNetworkIPFor returns the address of a Windows container that
+// publishes none of its ports, or an empty string. Windows containers on
+// transparent, l2bridge, and overlay networks are reached on their own address
+// rather than through port mappings on the host, so Docker reports no public
+// ports for them. The default nat network's addresses can only be reached
+// from the host, so it's the last resort. Otherwise we pick the first network
+// by name so the choice is stable. Not synchronized!
+func (d *DockerDiscovery) windowsNetworkIPFor(container *docker.APIContainers) string {
+	if !d.onWindows() {
+		return ""
+	}
+
+	for _, port := range container.Ports {
+		if port.PublicPort != 0 {
+			return ""
+		}
+	}
+
+	names := make([]string, 0, len(container.Networks.Networks))
+	for name := range container.Networks.Networks {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == WindowsNatNetwork) != (names[j] == WindowsNatNetwork) {
+			return names[j] == WindowsNatNetwork
+		}
+		return names[i] < names[j]
+	})
+
+	for _, name := range names {
+		if ip := container.Networks.Networks[name].IPAddress; ip != "" {
+			return ip
+		}
+	}
+
+	return ""
 }
 
 func (d *DockerDiscovery) configureDockerConnection() DockerClient {
@@ -287,6 +504,18 @@ func (d *DockerDiscovery) configureDockerConnection() DockerClient {
 		return nil
 	}
 
+	// The daemon may have been upgraded or downgraded since we last spoke,
+	// so find out which API version to use and reconnect with it
+	previous := d.negotiatedVersion()
+	d.detectVersion(client)
+	if d.negotiatedVersion() != previous {
+		client, err = d.ClientProvider()
+		if err != nil {
+			log.Errorf("Error creating Docker client: %s", err)
+			return nil
+		}
+	}
+
 	err = client.AddEventListener(d.events)
 	if err != nil {
 		log.Errorf("Error adding Docker client event listener: %s", err)
@@ -308,7 +537,7 @@ func (d *DockerDiscovery) manageConnection(quit chan bool) {
 				// Swallow errors since we're overwriting the client anyway
 				_ = client.RemoveEventListener(d.events)
 			}
-			d.events = make(chan *docker.APIEvents) // RemoveEventListener closes it
+			d.events = make(chan *docker.APIEvents, EventBufferSize) // RemoveEventListener closes it
 
 			client = d.configureDockerConnection()
 		}
@@ -324,6 +553,12 @@ func (d *DockerDiscovery) manageConnection(quit chan bool) {
 	}
 }
 
+// eventBufferFull tells us whether the events buffer was full when we took the
+// last event from it, which is the only time the Docker client drops events.
+func eventBufferFull(events chan *docker.APIEvents) bool {
+	return len(events) >= cap(events)-1
+}
+
 func (d *DockerDiscovery) handleEvent(event docker.APIEvents) {
 	// We're only worried about stopping containers
 	if event.Status == "die" || event.Status == "stop" {
@@ -7,6 +7,7 @@ import (
 
 	"github.com/Nitro/sidecar/service"
 	"github.com/fsouza/go-dockerclient"
+	"github.com/relistan/go-director"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -17,6 +18,11 @@ type stubDockerClient struct {
 	ErrorOnInspectContainer bool
 	ErrorOnPing             bool
 	PingChan                chan struct{}
+	ExtraLabels             map[string]string
+	ExecCmd                 []string
+	ExecExitCode            int
+	ListCount               int
+	Containers              []docker.APIContainers
 }
 
 func (s *stubDockerClient) InspectContainer(id string) (*docker.Container, error) {
@@ -26,16 +32,19 @@ func (s *stubDockerClient) InspectContainer(id string) (*docker.Container, error
 
 	// If we match this ID, return a real setup
 	if id == "deadbeef1231" { // svcId1
+		labels := map[string]string{
+			"HealthCheck":     "HttpGet",
+			"HealthCheckArgs": "service1 check arguments",
+			"ServicePort_80":  "10000",
+			"SidecarListener": "10000",
+		}
+		for k, v := range s.ExtraLabels {
+			labels[k] = v
+		}
+
 		return &docker.Container{
-			ID: "deadbeef1231",
-			Config: &docker.Config{
-				Labels: map[string]string{
-					"HealthCheck":     "HttpGet",
-					"HealthCheckArgs": "service1 check arguments",
-					"ServicePort_80":  "10000",
-					"SidecarListener": "10000",
-				},
-			},
+			ID:     "deadbeef1231",
+			Config: &docker.Config{Labels: labels},
 		}, nil
 	}
 
@@ -48,7 +57,8 @@ func (s *stubDockerClient) InspectContainer(id string) (*docker.Container, error
 }
 
 func (s *stubDockerClient) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
-	return nil, nil
+	s.ListCount++
+	return s.Containers, nil
 }
 
 func (s *stubDockerClient) AddEventListener(listener chan<- *docker.APIEvents) error {
@@ -59,6 +69,19 @@ func (s *stubDockerClient) RemoveEventListener(listener chan *docker.APIEvents)
 	return nil
 }
 
+func (s *stubDockerClient) CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error) {
+	s.ExecCmd = opts.Cmd
+	return &docker.Exec{ID: "exec1"}, nil
+}
+
+func (s *stubDockerClient) StartExec(id string, opts docker.StartExecOptions) error {
+	return nil
+}
+
+func (s *stubDockerClient) InspectExec(id string) (*docker.ExecInspect, error) {
+	return &docker.ExecInspect{ID: id, ExitCode: s.ExecExitCode}, nil
+}
+
 func (s *stubDockerClient) Ping() error {
 	if s.ErrorOnPing {
 		return errors.New("dummy errror")
@@ -137,6 +160,57 @@ func Test_DockerDiscovery(t *testing.T) {
 			)
 		})
 
+		Convey("getContainers() collects label warnings", func() {
+			client.Containers = []docker.APIContainers{
+				{
+					ID: "deadbeef4567", Names: []string{"/beowulf-deadbeef4567"},
+					Labels: map[string]string{"HealthCheck": "HttpGte"},
+				},
+				{
+					ID: "deadbeef8910", Names: []string{"/grendel-deadbeef8910"},
+					Labels: map[string]string{"HealthCheck": "HttpGte", "SidecarDiscover": "false"},
+				},
+			}
+			disco.getContainers()
+
+			So(len(disco.Services()), ShouldEqual, 1)
+
+			warnings := disco.Warnings()
+			So(len(warnings), ShouldEqual, 1)
+			So(warnings[0].ContainerID, ShouldEqual, "deadbeef4567")
+			So(warnings[0].Label, ShouldEqual, "HealthCheck")
+
+			Convey("and forgets them when the container goes away", func() {
+				client.Containers = nil
+				disco.getContainers()
+
+				So(disco.Warnings(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("getContainers() announces services from the configured Hostname", func() {
+			client.Containers = []docker.APIContainers{
+				{ID: "deadbeef4567", Names: []string{"/beowulf-deadbeef4567"}},
+			}
+			disco.Hostname = "edge-1.example.com"
+			disco.getContainers()
+
+			So(len(disco.Services()), ShouldEqual, 1)
+			So(disco.Services()[0].Hostname, ShouldEqual, "edge-1.example.com")
+		})
+
+		Convey("getContainers() skips the container Sidecar runs in", func() {
+			client.Containers = []docker.APIContainers{
+				{ID: "deadbeef4567abcdef", Names: []string{"/sidecar-deadbeef4567"}},
+				{ID: "deadbeef8910abcdef", Names: []string{"/beowulf-deadbeef8910"}},
+			}
+			disco.SelfID = "deadbeef4567abcdef"
+			disco.getContainers()
+
+			So(len(disco.Services()), ShouldEqual, 1)
+			So(disco.Services()[0].ID, ShouldEqual, "deadbeef8910")
+		})
+
 		Convey("handleEvents() prunes dead containers", func() {
 			disco.services = services
 			disco.handleEvent(docker.APIEvents{ID: svcId1, Status: "die"})
@@ -172,6 +246,47 @@ func Test_DockerDiscovery(t *testing.T) {
 			})
 		})
 
+		Convey("HealthCheckTLS()", func() {
+			Convey("returns the settings from the labels", func() {
+				disco.ClientProvider = func() (DockerClient, error) {
+					return &stubDockerClient{
+						ExtraLabels: map[string]string{
+							"HealthCheckTLSSkipVerify": "true",
+							"HealthCheckTLSCA":         "/etc/ssl/internal-ca.pem",
+							"HealthCheckTLSServerName": "service1.internal",
+						},
+					}, nil
+				}
+
+				checkTLS := disco.HealthCheckTLS(&service1)
+				So(checkTLS, ShouldNotBeNil)
+				So(checkTLS.SkipVerify, ShouldBeTrue)
+				So(checkTLS.CAFile, ShouldEqual, "/etc/ssl/internal-ca.pem")
+				So(checkTLS.ServerName, ShouldEqual, "service1.internal")
+				So(checkTLS.CertFile, ShouldBeEmpty)
+			})
+
+			Convey("returns nil when there are no settings", func() {
+				So(disco.HealthCheckTLS(&service1), ShouldBeNil)
+			})
+		})
+
+		Convey("MaintenanceTags()", func() {
+			Convey("splits up the tags in the label", func() {
+				disco.ClientProvider = func() (DockerClient, error) {
+					return &stubDockerClient{
+						ExtraLabels: map[string]string{"MaintenanceTags": "databases, batch,"},
+					}, nil
+				}
+
+				So(disco.MaintenanceTags(&service1), ShouldResemble, []string{"databases", "batch"})
+			})
+
+			Convey("returns nothing when there are no tags", func() {
+				So(disco.MaintenanceTags(&service1), ShouldBeEmpty)
+			})
+		})
+
 		Convey("inspectContainer()", func() {
 			Convey("looks in the cache first", func() {
 				disco.containerCache.Set(&service1, &docker.Container{Path: "cached"})
@@ -219,6 +334,78 @@ func Test_DockerDiscovery(t *testing.T) {
 			})
 		})
 
+		Convey("networkIPFor()", func() {
+			container := &docker.APIContainers{
+				ID:     svcId1,
+				Labels: map[string]string{},
+				Networks: docker.NetworkList{
+					Networks: map[string]docker.ContainerNetwork{
+						"bridge":       {IPAddress: "172.17.0.2"},
+						"prod-overlay": {IPAddress: "10.0.9.4"},
+					},
+				},
+			}
+
+			Convey("returns nothing when no networks are configured", func() {
+				So(disco.networkIPFor(container), ShouldEqual, "")
+			})
+
+			Convey("returns the address on the first preferred network", func() {
+				disco.AdvertiseNetworks = []string{"missing", "prod-overlay", "bridge"}
+				So(disco.networkIPFor(container), ShouldEqual, "10.0.9.4")
+			})
+
+			Convey("prefers the SidecarNetwork label over the config", func() {
+				disco.AdvertiseNetworks = []string{"prod-overlay"}
+				container.Labels["SidecarNetwork"] = "missing, bridge"
+				So(disco.networkIPFor(container), ShouldEqual, "172.17.0.2")
+			})
+
+			Convey("returns nothing when attached to none of the networks", func() {
+				disco.AdvertiseNetworks = []string{"missing"}
+				So(disco.networkIPFor(container), ShouldEqual, "")
+			})
+		})
+
+		Convey("windowsNetworkIPFor()", func() {
+			container := &docker.APIContainers{
+				ID: svcId1,
+				Networks: docker.NetworkList{
+					Networks: map[string]docker.ContainerNetwork{
+						"transparent": {IPAddress: "10.1.2.3"},
+						"nat":         {IPAddress: "172.24.0.5"},
+					},
+				},
+			}
+
+			Convey("returns nothing when the daemon isn't on Windows", func() {
+				So(disco.windowsNetworkIPFor(container), ShouldEqual, "")
+			})
+
+			Convey("on Windows", func() {
+				disco.dockerVersion = &DockerVersion{Os: "windows"}
+
+				Convey("prefers other networks to nat", func() {
+					So(disco.windowsNetworkIPFor(container), ShouldEqual, "10.1.2.3")
+				})
+
+				Convey("returns the address on the first network by name", func() {
+					container.Networks.Networks["l2bridge"] = docker.ContainerNetwork{IPAddress: "10.4.5.6"}
+					So(disco.windowsNetworkIPFor(container), ShouldEqual, "10.4.5.6")
+				})
+
+				Convey("falls back to nat", func() {
+					delete(container.Networks.Networks, "transparent")
+					So(disco.windowsNetworkIPFor(container), ShouldEqual, "172.24.0.5")
+				})
+
+				Convey("returns nothing when ports are published on the host", func() {
+					container.Ports = []docker.APIPort{{PrivatePort: 80, PublicPort: 8080, Type: "tcp"}}
+					So(disco.windowsNetworkIPFor(container), ShouldEqual, "")
+				})
+			})
+		})
+
 		Convey("Run()", func() {
 			disco.sleepInterval = 1 * time.Millisecond
 
@@ -238,6 +425,34 @@ func Test_DockerDiscovery(t *testing.T) {
 				}
 			})
 
+			Convey("resyncs the containers when the event buffer overflows", func() {
+				disco.sleepInterval = 1 * time.Hour
+				disco.events = make(chan *docker.APIEvents, 3)
+				for i := 0; i < 3; i++ {
+					disco.events <- &docker.APIEvents{ID: "deadbeef9999", Status: "start"}
+				}
+
+				looper := director.NewFreeLooper(3, make(chan error))
+				disco.Run(looper)
+				_ = looper.Wait()
+
+				So(client.ListCount, ShouldEqual, 1)
+			})
+
+			Convey("doesn't resync when no events were dropped", func() {
+				disco.sleepInterval = 1 * time.Hour
+				disco.events = make(chan *docker.APIEvents, 10)
+				for i := 0; i < 3; i++ {
+					disco.events <- &docker.APIEvents{ID: "deadbeef9999", Status: "start"}
+				}
+
+				looper := director.NewFreeLooper(3, make(chan error))
+				disco.Run(looper)
+				_ = looper.Wait()
+
+				So(client.ListCount, ShouldEqual, 0)
+			})
+
 			Convey("reconnects if the connection is dropped", func() {
 				connectEvent := make(chan struct{})
 				disco.ClientProvider = func() (DockerClient, error) {
@@ -0,0 +1,93 @@
+package discovery
+
+import (
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	EnvLabelPrefix = "SIDECAR_"
+)
+
+// envLabels maps the container environment variables we understand onto the
+// Docker labels they stand in for. ServicePort_XXX labels are handled
+// separately since they carry the port in the name.
+var envLabels = map[string]string{
+	"SIDECAR_SERVICE_NAME":                "ServiceName",
+	"SIDECAR_HEALTHCHECK":                 "HealthCheck",
+	"SIDECAR_HEALTHCHECK_ARGS":            "HealthCheckArgs",
+	"SIDECAR_HEALTHCHECK_TLS_SKIP_VERIFY": "HealthCheckTLSSkipVerify",
+	"SIDECAR_HEALTHCHECK_TLS_CA":          "HealthCheckTLSCA",
+	"SIDECAR_HEALTHCHECK_TLS_CERT":        "HealthCheckTLSCert",
+	"SIDECAR_HEALTHCHECK_TLS_KEY":         "HealthCheckTLSKey",
+	"SIDECAR_HEALTHCHECK_TLS_SERVER_NAME": "HealthCheckTLSServerName",
+	"SIDECAR_HEALTHCHECK_THROUGH_PROXY":   "HealthCheckThroughProxy",
+	"SIDECAR_DISCOVER":                    "SidecarDiscover",
+	"SIDECAR_LISTENER":                    "SidecarListener",
+	"SIDECAR_PROXY":                       "SidecarProxy",
+	"SIDECAR_PROXY_MODE":                  "ProxyMode",
+	"SIDECAR_NETWORK":                     "SidecarNetwork",
+	"SIDECAR_MAINTENANCE_WINDOW":          "MaintenanceWindow",
+	"SIDECAR_MAINTENANCE_TAGS":            "MaintenanceTags",
+	"SIDECAR_PRE_STOP_URL":                "PreStopUrl",
+	"SIDECAR_PRE_STOP_COMMAND":            "PreStopCommand",
+	"SIDECAR_VERSION":                     "SidecarVersion",
+	"SIDECAR_MAX_CONNECTIONS":             "MaxConnections",
+	"SIDECAR_MAX_PENDING_REQUESTS":        "MaxPendingRequests",
+	"SIDECAR_MAX_CONSECUTIVE_ERRORS":      "MaxConsecutiveErrors",
+	"SIDECAR_BALANCE_ALGORITHM":           "BalanceAlgorithm",
+	"SIDECAR_MIN_INSTANCES":               "MinInstances",
+	"SIDECAR_NAMESPACE":                   "Namespace",
+}
+
+// labelsFromEnv translates SIDECAR_* environment variables, as returned by
+// a container inspect, into the equivalent Sidecar Docker labels. This
+// supports platforms where setting env vars is easier than setting labels.
+func labelsFromEnv(env []string) map[string]string {
+	labels := make(map[string]string)
+
+	for _, entry := range env {
+		if !strings.HasPrefix(entry, EnvLabelPrefix) {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		name, value := parts[0], parts[1]
+
+		if strings.HasPrefix(name, "SIDECAR_SERVICEPORT_") {
+			port := strings.TrimPrefix(name, "SIDECAR_SERVICEPORT_")
+			labels["ServicePort_"+port] = value
+			continue
+		}
+
+		if label, ok := envLabels[name]; ok {
+			labels[label] = value
+		}
+	}
+
+	return labels
+}
+
+// mergeEnvLabels adds the labels derived from the container's environment to
+// the existing labels. Real labels always win over environment variables.
+func mergeEnvLabels(labels map[string]string, container *docker.Container) map[string]string {
+	if container == nil || container.Config == nil {
+		return labels
+	}
+
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+
+	for label, value := range labelsFromEnv(container.Config.Env) {
+		if _, ok := labels[label]; !ok {
+			labels[label] = value
+		}
+	}
+
+	return labels
+}
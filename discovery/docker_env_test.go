@@ -0,0 +1,63 @@
+package discovery
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_labelsFromEnv(t *testing.T) {
+	Convey("labelsFromEnv()", t, func() {
+		env := []string{
+			"PATH=/usr/bin:/bin",
+			"SIDECAR_SERVICE_NAME=awesome-svc",
+			"SIDECAR_SERVICEPORT_80=10000",
+			"SIDECAR_HEALTHCHECK=HttpGet",
+			"SIDECAR_HEALTHCHECK_ARGS=http://{{ host }}:{{ tcp 10000 }}/",
+			"SIDECAR_UNKNOWN=junk",
+			"SIDECAR_DISCOVER",
+		}
+
+		Convey("Translates known env vars into labels", func() {
+			labels := labelsFromEnv(env)
+
+			So(labels, ShouldResemble, map[string]string{
+				"ServiceName":     "awesome-svc",
+				"ServicePort_80":  "10000",
+				"HealthCheck":     "HttpGet",
+				"HealthCheckArgs": "http://{{ host }}:{{ tcp 10000 }}/",
+			})
+		})
+	})
+}
+
+func Test_mergeEnvLabels(t *testing.T) {
+	Convey("mergeEnvLabels()", t, func() {
+		container := &docker.Container{
+			Config: &docker.Config{
+				Env: []string{
+					"SIDECAR_DISCOVER=false",
+					"SIDECAR_PROXY_MODE=tcp",
+				},
+			},
+		}
+
+		Convey("Adds labels from the environment", func() {
+			labels := mergeEnvLabels(nil, container)
+			So(labels["SidecarDiscover"], ShouldEqual, "false")
+			So(labels["ProxyMode"], ShouldEqual, "tcp")
+		})
+
+		Convey("Prefers existing labels", func() {
+			labels := mergeEnvLabels(map[string]string{"ProxyMode": "http"}, container)
+			So(labels["SidecarDiscover"], ShouldEqual, "false")
+			So(labels["ProxyMode"], ShouldEqual, "http")
+		})
+
+		Convey("Handles a container with no config", func() {
+			labels := mergeEnvLabels(map[string]string{"ProxyMode": "http"}, &docker.Container{})
+			So(labels, ShouldResemble, map[string]string{"ProxyMode": "http"})
+		})
+	})
+}
@@ -0,0 +1,159 @@
+package discovery
+
+import (
+	"fmt"
+
+	"github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	MinDockerAPIVersion = "1.24" // The oldest Docker API we need (Docker 1.12)
+	MaxDockerAPIVersion = "1.37" // The newest Docker API our client understands
+)
+
+// A DockerVersion describes the Docker daemon we're talking to, and the API
+// version we settled on with it.
+type DockerVersion struct {
+	EngineVersion string // The version of the Docker engine
+	Os            string `json:",omitempty"` // The OS the daemon runs containers on, e.g. "windows"
+	APIVersion    string // The newest API version the daemon supports
+	MinAPIVersion string `json:",omitempty"` // The oldest one it still accepts
+	Negotiated    string // The API version we're using with it
+	Compatible    bool
+	Problem       string `json:",omitempty"` // What's wrong, when we aren't compatible
+}
+
+// A DockerVersionReporter knows about the Docker daemon it's talking to
+type DockerVersionReporter interface {
+	DockerVersion() *DockerVersion
+}
+
+// A dockerVersioner is a DockerClient that can tell us the daemon's version
+type dockerVersioner interface {
+	Version() (*docker.Env, error)
+}
+
+// negotiateVersion picks the API version to use with the daemon described
+// by env: the newest that both the daemon and our client understand, unless
+// one was pinned. A daemon that is too old, or too new to still accept what
+// we speak, is reported as incompatible.
+func negotiateVersion(env *docker.Env, pinned string) *DockerVersion {
+	version := &DockerVersion{
+		EngineVersion: env.Get("Version"),
+		APIVersion:    env.Get("ApiVersion"),
+		MinAPIVersion: env.Get("MinAPIVersion"),
+		Os:            env.Get("Os"),
+		Compatible:    true,
+	}
+
+	daemonMax, err := docker.NewAPIVersion(version.APIVersion)
+	if err != nil {
+		version.Compatible = false
+		version.Problem = fmt.Sprintf("Can't parse the daemon's API version: %s", err)
+		return version
+	}
+
+	ours, _ := docker.NewAPIVersion(MaxDockerAPIVersion)
+	if pinned != "" {
+		ours, err = docker.NewAPIVersion(pinned)
+		if err != nil {
+			version.Compatible = false
+			version.Problem = fmt.Sprintf("Can't parse the configured API version: %s", err)
+			return version
+		}
+	}
+
+	negotiated := ours
+	if daemonMax.LessThan(negotiated) {
+		negotiated = daemonMax
+	}
+	version.Negotiated = negotiated.String()
+
+	minimum, _ := docker.NewAPIVersion(MinDockerAPIVersion)
+	if negotiated.LessThan(minimum) {
+		version.Compatible = false
+		version.Problem = fmt.Sprintf(
+			"Docker API %s is older than the %s we need", negotiated, MinDockerAPIVersion,
+		)
+		return version
+	}
+
+	if daemonMin, err := docker.NewAPIVersion(version.MinAPIVersion); err == nil && negotiated.LessThan(daemonMin) {
+		version.Compatible = false
+		version.Problem = fmt.Sprintf(
+			"The daemon no longer accepts Docker API %s, it needs at least %s", negotiated, daemonMin,
+		)
+	}
+
+	return version
+}
+
+// detectVersion asks the daemon for its version and settles on the API
+// version to use from then on. Clients that can't tell us leave things as
+// they were. The version is kept for DockerVersion() and used by
+// getDockerClient() for the connections made after this.
+func (d *DockerDiscovery) detectVersion(client DockerClient) {
+	versioner, ok := client.(dockerVersioner)
+	if !ok {
+		return
+	}
+
+	env, err := versioner.Version()
+	if err != nil {
+		log.Warnf("Unable to get the Docker daemon's version: %s", err)
+		return
+	}
+
+	version := negotiateVersion(env, d.APIVersion)
+
+	d.Lock()
+	changed := d.dockerVersion == nil || d.dockerVersion.Negotiated != version.Negotiated
+	d.dockerVersion = version
+	d.Unlock()
+
+	if !version.Compatible {
+		log.Errorf("Docker engine %s may not work with Sidecar: %s", version.EngineVersion, version.Problem)
+		return
+	}
+
+	if changed {
+		log.Infof(
+			"Connected to Docker engine %s, using API version %s",
+			version.EngineVersion, version.Negotiated,
+		)
+	}
+}
+
+// DockerVersion returns what we know about the Docker daemon, or nil if we
+// haven't managed to ask it yet.
+func (d *DockerDiscovery) DockerVersion() *DockerVersion {
+	d.RLock()
+	defer d.RUnlock()
+
+	if d.dockerVersion == nil {
+		return nil
+	}
+
+	version := *d.dockerVersion
+	return &version
+}
+
+// onWindows tells us whether the daemon runs Windows containers. Not
+// synchronized!
+func (d *DockerDiscovery) onWindows() bool {
+	return d.dockerVersion != nil && d.dockerVersion.Os == "windows"
+}
+
+// negotiatedVersion returns the API version to create clients with, or an
+// empty string to let the daemon decide.
+func (d *DockerDiscovery) negotiatedVersion() string {
+	d.RLock()
+	defer d.RUnlock()
+
+	if d.dockerVersion != nil && d.dockerVersion.Compatible {
+		return d.dockerVersion.Negotiated
+	}
+
+	return d.APIVersion
+}
@@ -0,0 +1,101 @@
+package discovery
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// A versionedDockerClient is a stubDockerClient that knows its version
+type versionedDockerClient struct {
+	stubDockerClient
+	env *docker.Env
+}
+
+func (c *versionedDockerClient) Version() (*docker.Env, error) {
+	if c.env == nil {
+		return nil, errors.New("Oh no!")
+	}
+	return c.env, nil
+}
+
+func daemonEnv(version string, apiVersion string, minAPIVersion string) *docker.Env {
+	env := &docker.Env{}
+	env.Set("Version", version)
+	env.Set("ApiVersion", apiVersion)
+	if minAPIVersion != "" {
+		env.Set("MinAPIVersion", minAPIVersion)
+	}
+	return env
+}
+
+func Test_negotiateVersion(t *testing.T) {
+	Convey("negotiateVersion()", t, func() {
+		Convey("uses the daemon's version when it's older than ours", func() {
+			version := negotiateVersion(daemonEnv("17.03.2-ce", "1.27", "1.12"), "")
+			So(version.Negotiated, ShouldEqual, "1.27")
+			So(version.EngineVersion, ShouldEqual, "17.03.2-ce")
+			So(version.Compatible, ShouldBeTrue)
+		})
+
+		Convey("uses ours when the daemon's is newer", func() {
+			version := negotiateVersion(daemonEnv("24.0.7", "1.43", "1.12"), "")
+			So(version.Negotiated, ShouldEqual, MaxDockerAPIVersion)
+			So(version.Compatible, ShouldBeTrue)
+		})
+
+		Convey("uses a pinned version when the daemon supports it", func() {
+			version := negotiateVersion(daemonEnv("24.0.7", "1.43", "1.12"), "1.30")
+			So(version.Negotiated, ShouldEqual, "1.30")
+		})
+
+		Convey("flags daemons that are too old", func() {
+			version := negotiateVersion(daemonEnv("1.11.2", "1.23", ""), "")
+			So(version.Compatible, ShouldBeFalse)
+			So(version.Problem, ShouldContainSubstring, "older than")
+		})
+
+		Convey("flags daemons that no longer accept our version", func() {
+			version := negotiateVersion(daemonEnv("30.0.0", "1.50", "1.40"), "")
+			So(version.Compatible, ShouldBeFalse)
+			So(version.Problem, ShouldContainSubstring, "no longer accepts")
+		})
+
+		Convey("flags versions it can't parse", func() {
+			So(negotiateVersion(daemonEnv("", "", ""), "").Compatible, ShouldBeFalse)
+			So(negotiateVersion(daemonEnv("24.0.7", "1.43", ""), "new").Compatible, ShouldBeFalse)
+		})
+	})
+
+	Convey("DockerDiscovery", t, func() {
+		disco := NewDockerDiscovery("unix:///var/run/docker.sock", nil, "127.0.0.1")
+
+		Convey("knows nothing about the daemon before connecting", func() {
+			So(disco.DockerVersion(), ShouldBeNil)
+			So(disco.negotiatedVersion(), ShouldBeEmpty)
+		})
+
+		Convey("negotiates a version when it connects", func() {
+			disco.detectVersion(&versionedDockerClient{env: daemonEnv("17.03.2-ce", "1.27", "1.12")})
+
+			So(disco.DockerVersion().Negotiated, ShouldEqual, "1.27")
+			So(disco.negotiatedVersion(), ShouldEqual, "1.27")
+		})
+
+		Convey("lets the daemon decide when it's incompatible", func() {
+			disco.detectVersion(&versionedDockerClient{env: daemonEnv("1.11.2", "1.23", "")})
+
+			So(disco.DockerVersion().Compatible, ShouldBeFalse)
+			So(disco.negotiatedVersion(), ShouldBeEmpty)
+		})
+
+		Convey("leaves things alone when it can't get the version", func() {
+			disco.detectVersion(&versionedDockerClient{})
+			disco.detectVersion(&stubDockerClient{})
+
+			So(disco.DockerVersion(), ShouldBeNil)
+		})
+	})
+}
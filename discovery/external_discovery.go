@@ -0,0 +1,203 @@
+package discovery
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/ghodss/yaml"
+	director "github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Nitro/sidecar/service"
+)
+
+const (
+	ExternalFetchTimeout = 10 * time.Second // How long we wait for a manifest URL
+)
+
+// An ExternalDiscovery announces services that don't run in containers,
+// like databases and third party endpoints, from a manifest of Targets in
+// the same format as the StaticDiscovery config file. The manifest can be
+// JSON or YAML, read from a local path or an http(s) URL, and it is
+// re-read every RefreshInterval. Unlike the StaticDiscovery, service IDs
+// are derived from the service itself, so that they stay the same across
+// reloads and even across hosts loading the same manifest. If a reload
+// fails, we keep announcing what we had.
+type ExternalDiscovery struct {
+	StaticDiscovery
+	Source          string
+	RefreshInterval time.Duration
+	Client          *http.Client
+	sync.RWMutex
+}
+
+func NewExternalDiscovery(source string, defaultIP string) *ExternalDiscovery {
+	return &ExternalDiscovery{
+		StaticDiscovery: *NewStaticDiscovery(source, defaultIP),
+		Source:          source,
+		RefreshInterval: 1 * time.Minute,
+		Client:          &http.Client{Timeout: ExternalFetchTimeout},
+	}
+}
+
+func (d *ExternalDiscovery) HealthCheck(svc *service.Service) (string, string) {
+	d.RLock()
+	defer d.RUnlock()
+	return d.StaticDiscovery.HealthCheck(svc)
+}
+
+func (d *ExternalDiscovery) MaintenanceWindow(svc *service.Service) string {
+	d.RLock()
+	defer d.RUnlock()
+	return d.StaticDiscovery.MaintenanceWindow(svc)
+}
+
+func (d *ExternalDiscovery) MaintenanceTags(svc *service.Service) []string {
+	d.RLock()
+	defer d.RUnlock()
+	return d.StaticDiscovery.MaintenanceTags(svc)
+}
+
+func (d *ExternalDiscovery) HealthCheckTLS(svc *service.Service) *CheckTLS {
+	d.RLock()
+	defer d.RUnlock()
+	return d.StaticDiscovery.HealthCheckTLS(svc)
+}
+
+// Services returns the services from the last manifest we loaded
+func (d *ExternalDiscovery) Services() []service.Service {
+	// Takes the write lock because we stamp the Updated time on the targets
+	d.Lock()
+	defer d.Unlock()
+	return d.StaticDiscovery.Services()
+}
+
+// Listeners returns nothing: external services can't be sent events
+func (d *ExternalDiscovery) Listeners() []ChangeListener {
+	return nil
+}
+
+// Run loads the manifest and then keeps reloading it in the background
+func (d *ExternalDiscovery) Run(looper director.Looper) {
+	d.refresh()
+
+	if d.RefreshInterval <= 0 {
+		return
+	}
+
+	go looper.Loop(func() error {
+		time.Sleep(d.RefreshInterval)
+		d.refresh()
+		return nil
+	})
+}
+
+// refresh reloads the manifest, keeping the current targets on failure
+func (d *ExternalDiscovery) refresh() {
+	targets, err := d.Load()
+	if err != nil {
+		log.Errorf("ExternalDiscovery cannot load '%s': %s", d.Source, err)
+		metrics.IncrCounter([]string{"discovery", "external", "load_errors"}, 1)
+		return
+	}
+
+	d.Lock()
+	d.Targets = targets
+	d.Unlock()
+
+	metrics.SetGauge([]string{"discovery", "external", "services"}, float32(len(targets)))
+}
+
+// Load fetches and parses the manifest, returning its Targets
+func (d *ExternalDiscovery) Load() ([]*Target, error) {
+	data, err := d.fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	return d.ParseManifest(data)
+}
+
+// fetch reads the manifest from a URL or a file, depending on the Source
+func (d *ExternalDiscovery) fetch() ([]byte, error) {
+	if !strings.HasPrefix(d.Source, "http://") && !strings.HasPrefix(d.Source, "https://") {
+		return ioutil.ReadFile(d.Source)
+	}
+
+	resp, err := d.Client.Get(d.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Bad status code returned (%d)", resp.StatusCode)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// ParseManifest parses a JSON or YAML list of Targets. YAML is converted to
+// JSON first, so both use the same field names. Targets without an ID get
+// one derived from their hostname, name, and ports.
+func (d *ExternalDiscovery) ParseManifest(data []byte) ([]*Target, error) {
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse manifest: %s", err)
+	}
+
+	var targets []*Target
+	err = json.Unmarshal(jsonData, &targets)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to unmarshal Target: %s", err)
+	}
+
+	for _, target := range targets {
+		if target.Service.Name == "" {
+			return nil, fmt.Errorf("Manifest contains a service without a Name")
+		}
+
+		d.applyDefaults(target)
+
+		if target.Service.ID == "" {
+			target.Service.ID = externalID(&target.Service)
+		}
+
+		if target.Service.Created.IsZero() {
+			target.Service.Created = time.Now().UTC()
+		}
+	}
+
+	// Keep the creation times of the services we already knew about
+	d.RLock()
+	for _, target := range targets {
+		for _, existing := range d.Targets {
+			if existing.Service.ID == target.Service.ID {
+				target.Service.Created = existing.Service.Created
+			}
+		}
+	}
+	d.RUnlock()
+
+	return targets, nil
+}
+
+// externalID derives a stable ID for an external service, formatted like
+// the container IDs we use elsewhere.
+func externalID(svc *service.Service) string {
+	hash := sha1.New()
+	fmt.Fprintf(hash, "%s/%s", svc.Hostname, svc.Name)
+	for _, port := range svc.Ports {
+		fmt.Fprintf(hash, "/%s:%d", port.IP, port.Port)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:12]
+}
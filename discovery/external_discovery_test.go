@@ -0,0 +1,109 @@
+package discovery
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	EXTERNAL_YAML = `
+- Service:
+    Name: postgres
+    Hostname: db1.example.com
+    Ports:
+      - Type: tcp
+        Port: 5432
+        ServicePort: 15432
+        IP: 10.0.0.5
+  Check:
+    Type: AlwaysSuccessful
+- Service:
+    Name: payments-api
+    Ports:
+      - Type: tcp
+        Port: 443
+        ServicePort: 10443
+`
+)
+
+func Test_ExternalDiscovery(t *testing.T) {
+	Convey("ExternalDiscovery", t, func() {
+		ip := "127.0.0.1"
+		disco := NewExternalDiscovery(STATIC_JSON, ip)
+		disco.Hostname = hostname
+
+		Convey("ParseManifest()", func() {
+			Convey("parses YAML and applies the defaults", func() {
+				targets, err := disco.ParseManifest([]byte(EXTERNAL_YAML))
+				So(err, ShouldBeNil)
+				So(len(targets), ShouldEqual, 2)
+
+				So(targets[0].Service.Hostname, ShouldEqual, "db1.example.com")
+				So(targets[0].Service.Ports[0].IP, ShouldEqual, "10.0.0.5")
+				So(targets[0].Check.Type, ShouldEqual, "AlwaysSuccessful")
+				So(targets[1].Service.Hostname, ShouldEqual, hostname)
+				So(targets[1].Service.Ports[0].IP, ShouldEqual, ip)
+			})
+
+			Convey("gives services the same IDs every time", func() {
+				first, _ := disco.ParseManifest([]byte(EXTERNAL_YAML))
+				second, _ := disco.ParseManifest([]byte(EXTERNAL_YAML))
+
+				So(first[0].Service.ID, ShouldNotBeEmpty)
+				So(first[0].Service.ID, ShouldNotEqual, first[1].Service.ID)
+				So(second[0].Service.ID, ShouldEqual, first[0].Service.ID)
+				So(second[1].Service.ID, ShouldEqual, first[1].Service.ID)
+			})
+
+			Convey("keeps the creation time of known services", func() {
+				disco.Targets, _ = disco.ParseManifest([]byte(EXTERNAL_YAML))
+				reloaded, _ := disco.ParseManifest([]byte(EXTERNAL_YAML))
+
+				So(reloaded[0].Service.Created, ShouldEqual, disco.Targets[0].Service.Created)
+			})
+
+			Convey("errors on services without a name", func() {
+				_, err := disco.ParseManifest([]byte(`[{"Service": {"Image": "postgres"}}]`))
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("errors on a bad manifest", func() {
+				_, err := disco.ParseManifest([]byte(`{"Service": `))
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("loads the manifest from a file", func() {
+			disco.Run(director.NewFreeLooper(director.ONCE, nil))
+
+			services := disco.Services()
+			So(len(services), ShouldEqual, 1)
+			So(services[0].Name, ShouldEqual, "some_service")
+			So(disco.Listeners(), ShouldBeEmpty)
+		})
+
+		Convey("loads the manifest from a URL", func() {
+			status := http.StatusOK
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+				fmt.Fprint(w, EXTERNAL_YAML)
+			}))
+			Reset(server.Close)
+
+			disco.Source = server.URL
+			disco.refresh()
+			So(len(disco.Services()), ShouldEqual, 2)
+
+			Convey("and keeps the services when a reload fails", func() {
+				status = http.StatusInternalServerError
+				disco.refresh()
+				So(len(disco.Services()), ShouldEqual, 2)
+			})
+		})
+	})
+}
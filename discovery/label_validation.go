@@ -0,0 +1,169 @@
+package discovery
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Nitro/sidecar/service"
+	"github.com/fsouza/go-dockerclient"
+)
+
+// HealthCheckTypes are the values the HealthCheck label may take, sorted. The
+// healthy package adds every check type it registers, built in or not, so
+// this always matches what the Monitor can run.
+// healthy.Monitor.GetCommandNamed() quietly falls back to an HttpGet check for
+// anything it doesn't know.
+var HealthCheckTypes []string
+
+// Check types that don't need any HealthCheckArgs
+var argsOptional = make(map[string]bool)
+
+// AddHealthCheckType makes a check type valid for the HealthCheck label. Not
+// synchronized, so it must be called before discovery starts!
+func AddHealthCheckType(name string, needsArgs bool) {
+	if isKnownCheckType(name) {
+		return
+	}
+
+	HealthCheckTypes = append(HealthCheckTypes, name)
+	sort.Strings(HealthCheckTypes)
+	argsOptional[name] = !needsArgs
+}
+
+// A LabelWarning describes a malformed Sidecar label on a container. The
+// container is still discovered, but the label is ignored or won't do what
+// was intended.
+type LabelWarning struct {
+	ContainerID string
+	Container   string
+	Label       string
+	Value       string
+	Message     string
+}
+
+// A WarningReporter is a Discoverer that can also report problems with the
+// configuration of the services it found.
+type WarningReporter interface {
+	Warnings() []LabelWarning
+}
+
+// validateLabels checks the Sidecar labels on a container and returns a
+// warning for each one that is malformed.
+func validateLabels(container *docker.APIContainers) []LabelWarning {
+	var warnings []LabelWarning
+	labels := container.Labels
+
+	warn := func(label string, format string, args ...interface{}) {
+		warning := LabelWarning{
+			ContainerID: container.ID,
+			Label:       label,
+			Value:       labels[label],
+			Message:     fmt.Sprintf(format, args...),
+		}
+		if len(warning.ContainerID) > 12 {
+			warning.ContainerID = warning.ContainerID[:12]
+		}
+		if len(container.Names) > 0 {
+			warning.Container = container.Names[0]
+		}
+		warnings = append(warnings, warning)
+	}
+
+	if checkType, ok := labels["HealthCheck"]; ok {
+		if !isKnownCheckType(checkType) {
+			warn("HealthCheck", "Unknown check type, expected one of %s", strings.Join(HealthCheckTypes, ", "))
+		} else if !argsOptional[checkType] && labels["HealthCheckArgs"] == "" {
+			warn("HealthCheck", "%s check has no HealthCheckArgs", checkType)
+		}
+	}
+
+	// Which private ports the container exposes, to match ServicePort_ labels
+	exposed := make(map[int64]bool, len(container.Ports))
+	for _, port := range container.Ports {
+		exposed[port.PrivatePort] = true
+	}
+
+	// Sort the labels so the warnings come out in a stable order
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	svcPorts := make(map[string]bool)
+	for _, name := range names {
+		if !strings.HasPrefix(name, "ServicePort_") {
+			continue
+		}
+
+		port, err := strconv.ParseInt(strings.TrimPrefix(name, "ServicePort_"), 10, 64)
+		if err != nil {
+			warn(name, "Label name should be ServicePort_ followed by a port number")
+			continue
+		}
+
+		if !isValidPort(labels[name]) {
+			warn(name, "Value should be a port number")
+			continue
+		}
+
+		if !exposed[port] {
+			warn(name, "Container does not expose port %d", port)
+			continue
+		}
+
+		svcPorts[labels[name]] = true
+	}
+
+	if listener, ok := labels["SidecarListener"]; ok {
+		if !isValidPort(listener) {
+			warn("SidecarListener", "Value should be a ServicePort number")
+		} else if !svcPorts[listener] {
+			warn("SidecarListener", "No ServicePort_ label maps to ServicePort %s", listener)
+		}
+	}
+
+	for _, name := range []string{"MaxConnections", "MaxPendingRequests", "MaxConsecutiveErrors", "MinInstances"} {
+		value, ok := labels[name]
+		if !ok {
+			continue
+		}
+
+		if valueInt, err := strconv.Atoi(value); err != nil || valueInt < 0 {
+			warn(name, "Value should be a positive integer")
+		}
+	}
+
+	if algorithm, ok := labels["BalanceAlgorithm"]; ok && !service.BalanceAlgorithms[strings.ToLower(strings.TrimSpace(algorithm))] {
+		warn("BalanceAlgorithm", "Unknown algorithm, expected roundrobin, leastconn, or source")
+	}
+
+	if namespace, ok := labels["Namespace"]; ok && !service.IsValidNamespace(strings.TrimSpace(namespace)) {
+		warn("Namespace", "Value should only have letters, digits, dashes, underscores, and dots")
+	}
+
+	for _, name := range []string{"SidecarDiscover", "SidecarProxy", "HealthCheckTLSSkipVerify", "HealthCheckThroughProxy"} {
+		if value, ok := labels[name]; ok && value != "true" && value != "false" {
+			warn(name, "Value should be true or false")
+		}
+	}
+
+	return warnings
+}
+
+func isKnownCheckType(checkType string) bool {
+	for _, known := range HealthCheckTypes {
+		if checkType == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isValidPort(value string) bool {
+	port, err := strconv.Atoi(value)
+	return err == nil && port > 0 && port <= 65535
+}
@@ -0,0 +1,104 @@
+package discovery
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// The healthy package registers the check types in the real binary, but it
+// imports this one, so the tests add the ones they need themselves
+func init() {
+	AddHealthCheckType("HttpGet", true)
+	AddHealthCheckType("AlwaysSuccessful", false)
+}
+
+func Test_validateLabels(t *testing.T) {
+	Convey("validateLabels()", t, func() {
+		container := &docker.APIContainers{
+			ID:    "deadbeef12345678",
+			Names: []string{"/bocaccio"},
+			Ports: []docker.APIPort{{PrivatePort: 80, PublicPort: 32768, Type: "tcp"}},
+			Labels: map[string]string{
+				"HealthCheck":     "HttpGet",
+				"HealthCheckArgs": "http://{{ host }}:{{ tcp 10000 }}/",
+				"ServicePort_80":  "10000",
+				"SidecarListener": "10000",
+				"MaxConnections":  "100",
+				"SidecarDiscover": "true",
+			},
+		}
+
+		labelsWarned := func() []string {
+			var labels []string
+			for _, warning := range validateLabels(container) {
+				labels = append(labels, warning.Label)
+			}
+			return labels
+		}
+
+		Convey("accepts well-formed labels", func() {
+			So(validateLabels(container), ShouldBeEmpty)
+		})
+
+		Convey("identifies the container", func() {
+			container.Labels["MaxConnections"] = "lots"
+
+			warnings := validateLabels(container)
+			So(len(warnings), ShouldEqual, 1)
+			So(warnings[0].ContainerID, ShouldEqual, "deadbeef1234")
+			So(warnings[0].Container, ShouldEqual, "/bocaccio")
+			So(warnings[0].Value, ShouldEqual, "lots")
+		})
+
+		Convey("warns about unknown check types", func() {
+			container.Labels["HealthCheck"] = "HttpGte"
+			So(labelsWarned(), ShouldResemble, []string{"HealthCheck"})
+		})
+
+		Convey("accepts check types added later", func() {
+			container.Labels["HealthCheck"] = "Gopher"
+			delete(container.Labels, "HealthCheckArgs")
+
+			AddHealthCheckType("Gopher", false)
+			So(labelsWarned(), ShouldBeEmpty)
+			So(sort.StringsAreSorted(HealthCheckTypes), ShouldBeTrue)
+		})
+
+		Convey("warns about checks without args", func() {
+			delete(container.Labels, "HealthCheckArgs")
+			So(labelsWarned(), ShouldResemble, []string{"HealthCheck"})
+
+			container.Labels["HealthCheck"] = "AlwaysSuccessful"
+			So(labelsWarned(), ShouldBeEmpty)
+		})
+
+		Convey("warns about bad ServicePort labels", func() {
+			delete(container.Labels, "SidecarListener")
+			container.Labels["ServicePort_http"] = "10001"
+			container.Labels["ServicePort_81"] = "10002"
+			container.Labels["ServicePort_80"] = "ten"
+
+			So(labelsWarned(), ShouldResemble, []string{"ServicePort_80", "ServicePort_81", "ServicePort_http"})
+		})
+
+		Convey("warns about listeners without a matching ServicePort", func() {
+			container.Labels["SidecarListener"] = "10001"
+			So(labelsWarned(), ShouldResemble, []string{"SidecarListener"})
+
+			container.Labels["SidecarListener"] = "bocaccio"
+			So(labelsWarned(), ShouldResemble, []string{"SidecarListener"})
+		})
+
+		Convey("warns about bad numbers and booleans", func() {
+			container.Labels["MaxPendingRequests"] = "-1"
+			container.Labels["SidecarDiscover"] = "yes"
+			container.Labels["BalanceAlgorithm"] = "random"
+			container.Labels["Namespace"] = "team/a"
+
+			So(labelsWarned(), ShouldResemble, []string{"MaxPendingRequests", "BalanceAlgorithm", "Namespace", "SidecarDiscover"})
+		})
+	})
+}
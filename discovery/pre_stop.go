@@ -0,0 +1,145 @@
+package discovery
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	"github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PreStopTimeout = 30 * time.Second // How long we'll wait on a pre-stop hook
+)
+
+// A PreStopper is a Discoverer that can notify a service that it is about to
+// be drained, so it can finish any in-flight work before traffic is removed.
+type PreStopper interface {
+	PreStop(svc *service.Service) error
+}
+
+// PreStop runs the pre-stop hook for a service on whichever discoverer
+// supports them and knows about the service.
+func (d *MultiDiscovery) PreStop(svc *service.Service) error {
+	for _, disco := range d.Discoverers {
+		stopper, ok := disco.(PreStopper)
+		if !ok {
+			continue
+		}
+
+		err := stopper.PreStop(svc)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PreStop calls the URL in the PreStopUrl label, or executes the command in
+// the PreStopCommand label inside the container, and waits for it to finish.
+// Containers with neither label are left alone.
+func (d *DockerDiscovery) PreStop(svc *service.Service) error {
+	container, err := d.inspectContainer(svc)
+	if err != nil {
+		// Not one of ours
+		return nil
+	}
+
+	if url := container.Config.Labels["PreStopUrl"]; url != "" {
+		log.Infof("Calling pre-stop URL for %s (id: %s): %s", svc.Name, svc.ID, url)
+		return preStopURL(url)
+	}
+
+	if command := container.Config.Labels["PreStopCommand"]; command != "" {
+		log.Infof("Running pre-stop command for %s (id: %s): %s", svc.Name, svc.ID, command)
+		return d.preStopExec(container.ID, command)
+	}
+
+	return nil
+}
+
+// preStopURL POSTs to the URL and expects a 2xx in return.
+func preStopURL(url string) error {
+	client := &http.Client{Timeout: PreStopTimeout}
+
+	resp, err := client.Post(url, "text/plain", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Pre-stop URL returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// preStopExec runs the command inside the container and expects it to exit
+// 0. Like External health checks, it is not wrapped in a shell, except in
+// Windows containers where cmd.exe has to make sense of the quoting.
+func (d *DockerDiscovery) preStopExec(containerID string, command string) error {
+	client, err := d.ClientProvider()
+	if err != nil {
+		return err
+	}
+
+	d.RLock()
+	cmd := execCommand(command, d.onWindows())
+	d.RUnlock()
+
+	exec, err := client.CreateExec(docker.CreateExecOptions{
+		Container:    containerID,
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	var output bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- client.StartExec(exec.ID, docker.StartExecOptions{
+			OutputStream: &output,
+			ErrorStream:  &output,
+		})
+	}()
+
+	select {
+	case err = <-done:
+		if err != nil {
+			return err
+		}
+	case <-time.After(PreStopTimeout):
+		return errors.New("Timed out waiting on pre-stop command")
+	}
+
+	inspect, err := client.InspectExec(exec.ID)
+	if err != nil {
+		return err
+	}
+
+	if inspect.ExitCode != 0 {
+		return fmt.Errorf("Pre-stop command exited %d: %s", inspect.ExitCode, strings.TrimSpace(output.String()))
+	}
+
+	return nil
+}
+
+// execCommand splits a command to run in a container. Windows paths are full
+// of spaces, so there we hand the whole thing to cmd.exe instead.
+func execCommand(command string, windows bool) []string {
+	if windows {
+		return []string{"cmd", "/S", "/C", command}
+	}
+
+	return strings.Fields(command)
+}
@@ -0,0 +1,78 @@
+package discovery
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_DockerPreStop(t *testing.T) {
+	Convey("Running pre-stop hooks", t, func() {
+		svc := &service.Service{ID: "deadbeef1231", Name: "beowulf"}
+		client := &stubDockerClient{}
+
+		disco := NewDockerDiscovery("", &RegexpNamer{}, "127.0.0.1")
+		disco.ClientProvider = func() (DockerClient, error) { return client, nil }
+
+		Convey("Does nothing without a hook label", func() {
+			So(disco.PreStop(svc), ShouldBeNil)
+			So(client.ExecCmd, ShouldBeNil)
+		})
+
+		Convey("POSTs to the pre-stop URL", func() {
+			called := false
+			code := 200
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = r.Method == http.MethodPost
+				w.WriteHeader(code)
+			}))
+			defer server.Close()
+
+			client.ExtraLabels = map[string]string{"PreStopUrl": server.URL + "/shutdown"}
+
+			So(disco.PreStop(svc), ShouldBeNil)
+			So(called, ShouldBeTrue)
+
+			Convey("and returns an error when it fails", func() {
+				code = 500
+				disco.containerCache.Drain(0)
+				So(disco.PreStop(svc), ShouldNotBeNil)
+			})
+		})
+
+		Convey("Execs the pre-stop command in the container", func() {
+			client.ExtraLabels = map[string]string{"PreStopCommand": "/bin/drain --wait 10"}
+
+			So(disco.PreStop(svc), ShouldBeNil)
+			So(client.ExecCmd, ShouldResemble, []string{"/bin/drain", "--wait", "10"})
+		})
+
+		Convey("Execs the pre-stop command through cmd.exe in Windows containers", func() {
+			disco.dockerVersion = &DockerVersion{Os: "windows"}
+			client.ExtraLabels = map[string]string{"PreStopCommand": `"C:\Program Files\app\drain.exe" --wait 10`}
+
+			So(disco.PreStop(svc), ShouldBeNil)
+			So(client.ExecCmd, ShouldResemble, []string{
+				"cmd", "/S", "/C", `"C:\Program Files\app\drain.exe" --wait 10`,
+			})
+		})
+
+		Convey("Returns an error when the command exits non-zero", func() {
+			client.ExtraLabels = map[string]string{"PreStopCommand": "/bin/drain"}
+			client.ExecExitCode = 1
+
+			So(disco.PreStop(svc), ShouldNotBeNil)
+		})
+
+		Convey("MultiDiscovery runs the hook on its discoverers", func() {
+			client.ExtraLabels = map[string]string{"PreStopCommand": "/bin/drain"}
+			multi := &MultiDiscovery{[]Discoverer{&StaticDiscovery{}, disco}}
+
+			So(multi.PreStop(svc), ShouldBeNil)
+			So(client.ExecCmd, ShouldResemble, []string{"/bin/drain"})
+		})
+	})
+}
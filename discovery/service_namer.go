@@ -3,6 +3,7 @@ package discovery
 import (
 	"fmt"
 	"regexp"
+	"sort"
 
 	"github.com/fsouza/go-dockerclient"
 	log "github.com/sirupsen/logrus"
@@ -83,3 +84,45 @@ func (d *DockerLabelNamer) ServiceName(container *docker.APIContainers) string {
 
 	return container.Image
 }
+
+// A ServiceNamer that uses the container's Docker network aliases, as set by
+// Compose or Swarm, as the name for the service. This makes discovery names
+// match the ones applications already resolve through Docker's DNS.
+type NetworkAliasNamer struct {
+	Network string // Only consider aliases on this network, if set
+}
+
+// Return the first network alias that isn't just the container ID, or default
+// to the image name.
+func (n *NetworkAliasNamer) ServiceName(container *docker.APIContainers) string {
+	if container == nil {
+		log.Warn("ServiceName() called with nil service passed!")
+		return ""
+	}
+
+	// Sort the network names so we return a stable name
+	var networks []string
+	for name := range container.Networks.Networks {
+		if n.Network == "" || name == n.Network {
+			networks = append(networks, name)
+		}
+	}
+	sort.Strings(networks)
+
+	for _, name := range networks {
+		for _, alias := range container.Networks.Networks[name].Aliases {
+			// Docker adds the short container ID as an alias
+			if alias == "" || (len(container.ID) >= 12 && alias == container.ID[:12]) {
+				continue
+			}
+			return alias
+		}
+	}
+
+	log.Debugf(
+		"Found container with no network aliases: %s (%s), returning '%s'",
+		container.ID, container.Names[0], container.Image,
+	)
+
+	return container.Image
+}
@@ -10,7 +10,7 @@ import (
 func Test_RegexpNamer(t *testing.T) {
 	Convey("RegexpNamer", t, func() {
 		container := &docker.APIContainers{
-			ID:     "deadbeef001",
+			ID:     "deadbeef0011223344556677",
 			Image:  "gonitro/awesome-svc:0.1.34",
 			Names:  []string{"/awesome-svc-1231b1b12323"},
 			Labels: map[string]string{},
@@ -40,7 +40,7 @@ func Test_RegexpNamer(t *testing.T) {
 func Test_DockerLabelNamer(t *testing.T) {
 	Convey("DockerLabelNamer", t, func() {
 		container := &docker.APIContainers{
-			ID:     "deadbeef001",
+			ID:     "deadbeef0011223344556677",
 			Image:  "gonitro/awesome-svc:0.1.34",
 			Names:  []string{"/awesome-svc-1231b1b12323"},
 			Labels: map[string]string{"ServiceName": "awesome-svc-1"},
@@ -64,3 +64,48 @@ func Test_DockerLabelNamer(t *testing.T) {
 		})
 	})
 }
+
+func Test_NetworkAliasNamer(t *testing.T) {
+	Convey("NetworkAliasNamer", t, func() {
+		container := &docker.APIContainers{
+			ID:     "deadbeef0011223344556677",
+			Image:  "gonitro/awesome-svc:0.1.34",
+			Names:  []string{"/awesome-svc-1231b1b12323"},
+			Labels: map[string]string{},
+			Networks: docker.NetworkList{
+				Networks: map[string]docker.ContainerNetwork{
+					"backend":  {Aliases: []string{"deadbeef0011", "awesome-db"}},
+					"frontend": {Aliases: []string{"deadbeef0011", "awesome-svc"}},
+					"legacy":   {Aliases: []string{"dead"}},
+				},
+			},
+		}
+
+		var namer ServiceNamer
+
+		Convey("Extracts a ServiceName, skipping the container ID", func() {
+			namer = &NetworkAliasNamer{}
+			So(namer.ServiceName(container), ShouldEqual, "awesome-db")
+		})
+
+		Convey("Only looks at the configured network", func() {
+			namer = &NetworkAliasNamer{Network: "frontend"}
+			So(namer.ServiceName(container), ShouldEqual, "awesome-svc")
+		})
+
+		Convey("Only skips the short container ID itself", func() {
+			namer = &NetworkAliasNamer{Network: "legacy"}
+			So(namer.ServiceName(container), ShouldEqual, "dead")
+		})
+
+		Convey("Returns the image when there are no aliases", func() {
+			namer = &NetworkAliasNamer{Network: "ASDF"}
+			So(namer.ServiceName(container), ShouldEqual, "gonitro/awesome-svc:0.1.34")
+		})
+
+		Convey("Handles error when passed a nil service", func() {
+			namer = &NetworkAliasNamer{}
+			So(namer.ServiceName(nil), ShouldEqual, "")
+		})
+	})
+}
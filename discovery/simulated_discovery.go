@@ -0,0 +1,178 @@
+package discovery
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	SimulatedServiceNames = 5     // Instances are spread across this many service names
+	SimulatedBasePort     = 20000 // Instance ports are numbered from here
+	SimulatedServicePort  = 10000 // Service ports are numbered from here
+)
+
+// A SimulatedDiscovery makes up services instead of finding real ones, for
+// load testing proxy reloads and listeners before going to production. It
+// keeps a set of fake service instances spread across this host and some
+// fake peers, replaces one instance at random every ServiceChurn, and
+// replaces a whole fake peer every HostChurn. The fake peer's services are
+// simply dropped, so they expire from the catalog like those of a host that
+// died. Health checks fail at random at the FlapRate.
+type SimulatedDiscovery struct {
+	ServiceCount  int
+	HostCount     int
+	ServiceChurn  time.Duration
+	HostChurn     time.Duration
+	FlapRate      float64
+	Hostname      string
+	DefaultIP     string
+	sleepInterval time.Duration
+	services      []service.Service
+	hosts         []string
+	hostSerial    int
+	lastSvcChurn  time.Time
+	lastHostChurn time.Time
+	rand          *rand.Rand
+	sync.RWMutex
+}
+
+func NewSimulatedDiscovery(hostname string, defaultIP string) *SimulatedDiscovery {
+	return &SimulatedDiscovery{
+		ServiceCount:  20,
+		HostCount:     3,
+		ServiceChurn:  30 * time.Second,
+		HostChurn:     5 * time.Minute,
+		FlapRate:      0.05,
+		Hostname:      hostname,
+		DefaultIP:     defaultIP,
+		sleepInterval: DefaultSleepInterval,
+		rand:          rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// HealthCheck gives our services a check that fails at the FlapRate
+func (d *SimulatedDiscovery) HealthCheck(svc *service.Service) (string, string) {
+	d.RLock()
+	defer d.RUnlock()
+
+	for _, simulated := range d.services {
+		if simulated.ID == svc.ID {
+			return "Simulated", fmt.Sprintf("%g", d.FlapRate)
+		}
+	}
+
+	return "", ""
+}
+
+// Services returns the current set of fake services
+func (d *SimulatedDiscovery) Services() []service.Service {
+	d.RLock()
+	defer d.RUnlock()
+
+	services := make([]service.Service, len(d.services))
+	copy(services, d.services)
+
+	return services
+}
+
+// Listeners returns nothing, simulated services don't listen for events
+func (d *SimulatedDiscovery) Listeners() []ChangeListener {
+	return nil
+}
+
+// Run makes up the initial services and then keeps churning them in the
+// background.
+func (d *SimulatedDiscovery) Run(looper director.Looper) {
+	log.Warnf(
+		"Simulated discovery is on! Making up %d services across %d hosts",
+		d.ServiceCount, d.HostCount,
+	)
+
+	d.populate(time.Now().UTC())
+
+	go looper.Loop(func() error {
+		time.Sleep(d.sleepInterval)
+		d.churn(time.Now().UTC())
+		return nil
+	})
+}
+
+// populate creates the fake hosts and the initial set of services
+func (d *SimulatedDiscovery) populate(now time.Time) {
+	d.Lock()
+	defer d.Unlock()
+
+	// Our own host is always one of them, so our services are tombstoned
+	// the usual way when they go away
+	d.hosts = []string{d.Hostname}
+	for len(d.hosts) < d.HostCount {
+		d.hosts = append(d.hosts, d.newHostname())
+	}
+
+	d.services = nil
+	for i := 0; i < d.ServiceCount; i++ {
+		d.services = append(d.services, d.newService(i, d.hosts[i%len(d.hosts)], now))
+	}
+
+	d.lastSvcChurn = now
+	d.lastHostChurn = now
+}
+
+// churn replaces a random service, and a fake host, when they are due
+func (d *SimulatedDiscovery) churn(now time.Time) {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.ServiceChurn > 0 && len(d.services) > 0 && now.Sub(d.lastSvcChurn) >= d.ServiceChurn {
+		i := d.rand.Intn(len(d.services))
+		log.Infof("Simulated discovery: replacing service %s", d.services[i].ID)
+		d.services[i] = d.newService(i, d.services[i].Hostname, now)
+		d.lastSvcChurn = now
+	}
+
+	if d.HostChurn > 0 && len(d.hosts) > 1 && now.Sub(d.lastHostChurn) >= d.HostChurn {
+		h := 1 + d.rand.Intn(len(d.hosts)-1)
+		oldHost := d.hosts[h]
+		d.hosts[h] = d.newHostname()
+		log.Infof("Simulated discovery: replacing host %s with %s", oldHost, d.hosts[h])
+
+		for i, svc := range d.services {
+			if svc.Hostname == oldHost {
+				d.services[i] = d.newService(i, d.hosts[h], now)
+			}
+		}
+		d.lastHostChurn = now
+	}
+}
+
+// newService makes up the i-th service instance. Not synchronized!
+func (d *SimulatedDiscovery) newService(i int, hostname string, now time.Time) service.Service {
+	nameIdx := i % SimulatedServiceNames
+
+	return service.Service{
+		ID:        fmt.Sprintf("%012x", d.rand.Int63n(1<<48)),
+		Name:      fmt.Sprintf("simulated-%d", nameIdx),
+		Image:     fmt.Sprintf("simulated:%d", now.Unix()),
+		Created:   now,
+		Hostname:  hostname,
+		ProxyMode: "http",
+		Ports: []service.Port{{
+			Type:        "tcp",
+			Port:        int64(SimulatedBasePort + i),
+			ServicePort: int64(SimulatedServicePort + nameIdx),
+			IP:          d.DefaultIP,
+		}},
+	}
+}
+
+// newHostname makes up a name for a fake host. Not synchronized!
+func (d *SimulatedDiscovery) newHostname() string {
+	d.hostSerial++
+	return fmt.Sprintf("%s-simulated-%d", d.Hostname, d.hostSerial)
+}
@@ -0,0 +1,89 @@
+package discovery
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_SimulatedDiscovery(t *testing.T) {
+	Convey("SimulatedDiscovery", t, func() {
+		disco := NewSimulatedDiscovery("chaucer", "10.0.0.1")
+		disco.ServiceCount = 6
+		disco.HostCount = 3
+		disco.FlapRate = 0.25
+
+		now := time.Now().UTC()
+		disco.populate(now)
+
+		hosts := func() map[string]int {
+			counts := make(map[string]int)
+			for _, svc := range disco.Services() {
+				counts[svc.Hostname]++
+			}
+			return counts
+		}
+
+		Convey("makes up services across the hosts", func() {
+			services := disco.Services()
+			So(len(services), ShouldEqual, 6)
+			So(len(hosts()), ShouldEqual, 3)
+			So(hosts()["chaucer"], ShouldEqual, 2)
+
+			So(services[0].Name, ShouldEqual, "simulated-0")
+			So(services[0].Ports[0].IP, ShouldEqual, "10.0.0.1")
+			So(services[0].Ports[0].ServicePort, ShouldEqual, SimulatedServicePort)
+		})
+
+		Convey("gives them a flapping health check", func() {
+			services := disco.Services()
+			check, args := disco.HealthCheck(&services[0])
+			So(check, ShouldEqual, "Simulated")
+			So(args, ShouldEqual, "0.25")
+
+			check, _ = disco.HealthCheck(&service.Service{ID: "real"})
+			So(check, ShouldEqual, "")
+		})
+
+		Convey("doesn't churn before it's due", func() {
+			before := disco.Services()
+			disco.churn(now.Add(time.Second))
+			So(disco.Services(), ShouldResemble, before)
+		})
+
+		Convey("replaces a service when it's due", func() {
+			before := disco.Services()
+			disco.churn(now.Add(disco.ServiceChurn))
+
+			var changed int
+			for i, svc := range disco.Services() {
+				if svc.ID != before[i].ID {
+					changed++
+					So(svc.Hostname, ShouldEqual, before[i].Hostname)
+				}
+			}
+			So(changed, ShouldEqual, 1)
+		})
+
+		Convey("replaces a fake host, never our own, when it's due", func() {
+			before := hosts()
+			disco.churn(now.Add(disco.HostChurn))
+			after := hosts()
+
+			So(len(after), ShouldEqual, 3)
+			So(after["chaucer"], ShouldEqual, 2)
+
+			var replaced int
+			for host := range before {
+				if _, ok := after[host]; !ok {
+					replaced++
+					So(strings.HasPrefix(host, "chaucer-simulated-"), ShouldBeTrue)
+				}
+			}
+			So(replaced, ShouldEqual, 1)
+		})
+	})
+}
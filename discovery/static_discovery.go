@@ -16,9 +16,11 @@ import (
 )
 
 type Target struct {
-	Service    service.Service
-	Check      StaticCheck
-	ListenPort int64
+	Service           service.Service
+	Check             StaticCheck
+	ListenPort        int64
+	MaintenanceWindow string
+	MaintenanceTags   []string
 }
 
 // A StaticDiscovery is an instance of a configuration file based discovery
@@ -34,6 +36,7 @@ type StaticDiscovery struct {
 type StaticCheck struct {
 	Type string
 	Args string
+	TLS  *CheckTLS
 }
 
 func NewStaticDiscovery(filename string, defaultIP string) *StaticDiscovery {
@@ -57,6 +60,36 @@ func (d *StaticDiscovery) HealthCheck(svc *service.Service) (string, string) {
 	return "", ""
 }
 
+// Returns the maintenance window configured for a target, if any
+func (d *StaticDiscovery) MaintenanceWindow(svc *service.Service) string {
+	for _, target := range d.Targets {
+		if svc.ID == target.Service.ID {
+			return target.MaintenanceWindow
+		}
+	}
+	return ""
+}
+
+// Returns the maintenance tags configured for a target, if any
+func (d *StaticDiscovery) MaintenanceTags(svc *service.Service) []string {
+	for _, target := range d.Targets {
+		if svc.ID == target.Service.ID {
+			return target.MaintenanceTags
+		}
+	}
+	return nil
+}
+
+// Returns the TLS settings configured for a target's check, if any
+func (d *StaticDiscovery) HealthCheckTLS(svc *service.Service) *CheckTLS {
+	for _, target := range d.Targets {
+		if svc.ID == target.Service.ID {
+			return target.Check.TLS
+		}
+	}
+	return nil
+}
+
 // Returns the list of services derived from the targets that were parsed
 // out of the config file.
 func (d *StaticDiscovery) Services() []service.Service {
@@ -123,18 +156,7 @@ func (d *StaticDiscovery) ParseConfig(filename string) ([]*Target, error) {
 
 		target.Service.ID = string(idBytes)
 		target.Service.Created = time.Now().UTC()
-		// We _can_ export services for a 3rd party. If we don't specify
-		// the hostname, then it's for this host.
-		if target.Service.Hostname == "" {
-			target.Service.Hostname = d.Hostname
-		}
-
-		// Make sure we have an IP address on ports
-		for i, port := range target.Service.Ports {
-			if len(port.IP) == 0 {
-				target.Service.Ports[i].IP = d.DefaultIP
-			}
-		}
+		d.applyDefaults(target)
 
 		log.Printf("Discovered service: %s, ID: %s",
 			target.Service.Name,
@@ -144,6 +166,22 @@ func (d *StaticDiscovery) ParseConfig(filename string) ([]*Target, error) {
 	return targets, nil
 }
 
+// applyDefaults fills in the hostname and port IPs a target left out
+func (d *StaticDiscovery) applyDefaults(target *Target) {
+	// We _can_ export services for a 3rd party. If we don't specify
+	// the hostname, then it's for this host.
+	if target.Service.Hostname == "" {
+		target.Service.Hostname = d.Hostname
+	}
+
+	// Make sure we have an IP address on ports
+	for i, port := range target.Service.Ports {
+		if len(port.IP) == 0 {
+			target.Service.Ports[i].IP = d.DefaultIP
+		}
+	}
+}
+
 // Return a defined number of random bytes as a slice
 func RandomHex(count int) ([]byte, error) {
 	raw := make([]byte, count)
@@ -6,6 +6,6 @@ die() {
 }
 
 file ../sidecar | grep "ELF.*LSB" || die "../sidecar is missing or not a Linux binary"
+grep -q "ui/app/bower_components/angular" ../sidecar || die "../sidecar was built without the UI dependencies, run 'npm install' in ui/ and rebuild it"
 echo "Building..."
-cd ../ui && npm install
 cd .. && docker build -f docker/Dockerfile -t sidecar . || die "Failed to build"
@@ -3,29 +3,37 @@ package adapter
 import (
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/Nitro/sidecar/catalog"
 	"github.com/Nitro/sidecar/service"
 	api "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	envoy_cluster "github.com/envoyproxy/go-control-plane/envoy/api/v2/cluster"
 	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	endpoint "github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
 	listener "github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
 	route "github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
 	hcm "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
 	tcpp "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/tcp_proxy/v2"
+	envoy_type "github.com/envoyproxy/go-control-plane/envoy/type"
 	"github.com/envoyproxy/go-control-plane/pkg/cache"
 	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
 	"github.com/gogo/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/golang/protobuf/ptypes/wrappers"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
 	// ServiceNameSeparator is used to join service name and port. Must not occur in service names.
 	ServiceNameSeparator = ":"
+
+	// MaxEndpointWeight is the weight we scale traffic splits to. Envoy only
+	// needs the sum of the weights in a group to fit in a uint32.
+	MaxEndpointWeight = 1000
 )
 
 // EnvoyResources is a collection of Enovy API resource definitions
@@ -69,31 +77,56 @@ func LookupHost(hostname string) (string, error) {
 
 // EnvoyResourcesFromState creates a set of Enovy API resource definitions from all
 // the ServicePorts in the Sidecar state. The Sidecar state needs to be locked by the
-// caller before calling this function.
+// caller before calling this function. When a zone is given, endpoints in other
+// zones are put at a lower priority so Envoy only fails over to them when the
+// endpoints in our zone aren't healthy. Services with fewer instances than their
+// MinInstances get clusters without endpoints, so Envoy fails closed with 503s.
+// They keep their listeners even when they have no instances left to proxy,
+// rather than having Envoy refuse connections. Only the services in the given namespace are included, and ServicePorts that
+// more than one service advertises are only included for the one that owns them.
 func EnvoyResourcesFromState(state *catalog.ServicesState, bindIP string,
-	useHostnames bool) EnvoyResources {
+	useHostnames bool, zone string, namespace string) EnvoyResources {
 
 	clusterMap := make(map[string]*api.Cluster)
 	listenerMap := make(map[string]cache.Resource)
+	conflicts := state.PortConflictsIn(namespace)
+	closed := state.ClosedServicesByName(namespace)
 
 	state.EachService(func(hostname *string, id *string, svc *service.Service) {
-		if svc == nil || !svc.IsAlive() {
+		if svc == nil || svc.Namespace != namespace {
+			return
+		}
+
+		// The instances of closed services only tell us which ports to
+		// listen on, since their clusters get no endpoints anyway
+		isClosed := closed[svc.Name] != nil && !svc.IsTombstone()
+		if !isClosed && (!svc.IsProxied() || state.PinnedOut(svc)) {
 			return
 		}
 
 		// Loop over the ports and generate a named listener for each port
 		for _, port := range svc.Ports {
-			// Only listen on ServicePorts
-			if port.ServicePort < 1 {
+			// Only listen on ServicePorts, and only for the service that owns
+			// them when more than one advertises the same one
+			if port.ServicePort < 1 || catalog.ConflictLoser(conflicts, svc.Name, port.ServicePort) {
+				continue
+			}
+
+			// Envoy doesn't accept a weight of 0, so we leave out instances
+			// that a traffic split sends no traffic to
+			weight := state.ProxyWeight(svc, MaxEndpointWeight)
+			if weight == 0 && !isClosed {
 				continue
 			}
 
 			envoyServiceName := SvcName(svc.Name, port.ServicePort)
+			priority := endpointPriority(svc, zone)
+			endpoints := weightEndpoints(
+				envoyServiceFromService(svc, port.ServicePort, useHostnames), weight,
+			)
 
 			if cluster, ok := clusterMap[envoyServiceName]; ok {
-				cluster.LoadAssignment.Endpoints[0].LbEndpoints =
-					append(cluster.LoadAssignment.Endpoints[0].LbEndpoints,
-						envoyServiceFromService(svc, port.ServicePort, useHostnames)...)
+				addEndpoints(cluster.LoadAssignment, priority, endpoints)
 			} else {
 				envoyCluster := &api.Cluster{
 					Name:                 envoyServiceName,
@@ -109,9 +142,13 @@ func EnvoyResourcesFromState(state *catalog.ServicesState, bindIP string,
 					LoadAssignment: &api.ClusterLoadAssignment{
 						ClusterName: envoyServiceName,
 						Endpoints: []*endpoint.LocalityLbEndpoints{{
-							LbEndpoints: envoyServiceFromService(svc, port.ServicePort, useHostnames),
+							Priority:    priority,
+							LbEndpoints: endpoints,
 						}},
 					},
+					LbPolicy:         lbPolicyFor(svc),
+					CircuitBreakers:  circuitBreakersFor(svc),
+					OutlierDetection: outlierDetectionFor(svc),
 					// Contour believes the IdleTimeout should be set to 60s. Not sure if we also need to enable these.
 					// See here: https://github.com/projectcontour/contour/blob/2858fec20d26f56cc75a19d91b61d625a86f36de/internal/envoy/listener.go#L102-L106
 					// CommonHttpProtocolOptions: &core.HttpProtocolOptions{
@@ -138,6 +175,15 @@ func EnvoyResourcesFromState(state *catalog.ServicesState, bindIP string,
 
 	clusters := make([]cache.Resource, 0, len(clusterMap))
 	for _, cluster := range clusterMap {
+		if name, _, err := SvcNameSplit(cluster.Name); err == nil && closed[name] != nil {
+			cluster.LoadAssignment.Endpoints = nil
+		}
+
+		// Priorities have to start at 0, so with no endpoints in our own
+		// zone the others become the first choice.
+		if len(cluster.LoadAssignment.Endpoints) == 1 {
+			cluster.LoadAssignment.Endpoints[0].Priority = 0
+		}
 		clusters = append(clusters, cluster)
 	}
 
@@ -152,6 +198,122 @@ func EnvoyResourcesFromState(state *catalog.ServicesState, bindIP string,
 	}
 }
 
+// endpointPriority returns 0 for services in our zone, or when we're not
+// zone-aware, and 1 for services elsewhere.
+func endpointPriority(svc *service.Service, zone string) uint32 {
+	if zone == "" || svc.Zone == zone {
+		return 0
+	}
+	return 1
+}
+
+// lbPolicyFor returns the Envoy load balancing policy for a service's
+// BalanceAlgorithm. Envoy's least request balancer is the closest thing it
+// has to least connections, and hashing on the source address keeps clients
+// on the same endpoint.
+func lbPolicyFor(svc *service.Service) api.Cluster_LbPolicy {
+	switch svc.BalanceAlgorithm {
+	case "leastconn":
+		return api.Cluster_LEAST_REQUEST
+	case "source":
+		return api.Cluster_RING_HASH
+	default:
+		return api.Cluster_ROUND_ROBIN
+	}
+}
+
+// circuitBreakersFor returns the connection limits for a service's cluster, or
+// nil to use the Envoy defaults.
+func circuitBreakersFor(svc *service.Service) *envoy_cluster.CircuitBreakers {
+	if svc.MaxConnections < 1 && svc.MaxPendingRequests < 1 {
+		return nil
+	}
+
+	thresholds := &envoy_cluster.CircuitBreakers_Thresholds{}
+	if svc.MaxConnections > 0 {
+		thresholds.MaxConnections = &wrappers.UInt32Value{Value: uint32(svc.MaxConnections)}
+	}
+	if svc.MaxPendingRequests > 0 {
+		thresholds.MaxPendingRequests = &wrappers.UInt32Value{Value: uint32(svc.MaxPendingRequests)}
+	}
+
+	return &envoy_cluster.CircuitBreakers{
+		Thresholds: []*envoy_cluster.CircuitBreakers_Thresholds{thresholds},
+	}
+}
+
+// outlierDetectionFor returns the settings for ejecting endpoints of a service
+// after consecutive errors, or nil when the service doesn't want that.
+// Connection failures count as errors for TCP services.
+func outlierDetectionFor(svc *service.Service) *envoy_cluster.OutlierDetection {
+	if svc.MaxConsecutiveErrors < 1 {
+		return nil
+	}
+
+	return &envoy_cluster.OutlierDetection{
+		Consecutive_5Xx: &wrappers.UInt32Value{Value: uint32(svc.MaxConsecutiveErrors)},
+	}
+}
+
+// weightEndpoints sets the load balancing weight on the endpoints of a service
+// with a traffic split. A negative weight leaves them at Envoy's default.
+func weightEndpoints(endpoints []*endpoint.LbEndpoint, weight int) []*endpoint.LbEndpoint {
+	if weight < 0 {
+		return endpoints
+	}
+
+	for _, lbEndpoint := range endpoints {
+		lbEndpoint.LoadBalancingWeight = &wrappers.UInt32Value{Value: uint32(weight)}
+	}
+
+	return endpoints
+}
+
+// addEndpoints adds endpoints to the group for their priority, creating the
+// group if this is the first endpoint with that priority.
+func addEndpoints(assignment *api.ClusterLoadAssignment, priority uint32, endpoints []*endpoint.LbEndpoint) {
+	for _, group := range assignment.Endpoints {
+		if group.Priority == priority {
+			group.LbEndpoints = append(group.LbEndpoints, endpoints...)
+			return
+		}
+	}
+
+	assignment.Endpoints = append(assignment.Endpoints, &endpoint.LocalityLbEndpoints{
+		Priority:    priority,
+		LbEndpoints: endpoints,
+	})
+
+	sort.Slice(assignment.Endpoints, func(i, j int) bool {
+		return assignment.Endpoints[i].Priority < assignment.Endpoints[j].Priority
+	})
+}
+
+// routeHashPolicyFor hashes HTTP requests on the client address for services
+// balanced by source, and returns nil for the rest
+func routeHashPolicyFor(svc *service.Service) []*route.RouteAction_HashPolicy {
+	if svc.BalanceAlgorithm != "source" {
+		return nil
+	}
+
+	return []*route.RouteAction_HashPolicy{{
+		PolicySpecifier: &route.RouteAction_HashPolicy_ConnectionProperties_{
+			ConnectionProperties: &route.RouteAction_HashPolicy_ConnectionProperties{SourceIp: true},
+		},
+	}}
+}
+
+// tcpHashPolicyFor is routeHashPolicyFor for TCP services
+func tcpHashPolicyFor(svc *service.Service) []*envoy_type.HashPolicy {
+	if svc.BalanceAlgorithm != "source" {
+		return nil
+	}
+
+	return []*envoy_type.HashPolicy{{
+		PolicySpecifier: &envoy_type.HashPolicy_SourceIp_{SourceIp: &envoy_type.HashPolicy_SourceIp{}},
+	}}
+}
+
 // envoyListenerFromService creates an Envoy listener from a service instance
 func envoyListenerFromService(svc *service.Service, envoyServiceName string,
 	servicePort int64, bindIP string) (cache.Resource, error) {
@@ -183,7 +345,8 @@ func envoyListenerFromService(svc *service.Service, envoyServiceName string,
 									ClusterSpecifier: &route.RouteAction_Cluster{
 										Cluster: envoyServiceName,
 									},
-									Timeout: &duration.Duration{},
+									Timeout:    &duration.Duration{},
+									HashPolicy: routeHashPolicyFor(svc),
 								},
 							},
 						}},
@@ -199,6 +362,7 @@ func envoyListenerFromService(svc *service.Service, envoyServiceName string,
 			ClusterSpecifier: &tcpp.TcpProxy_Cluster{
 				Cluster: envoyServiceName,
 			},
+			HashPolicy: tcpHashPolicyFor(svc),
 		}
 	default:
 		return nil, fmt.Errorf("unrecognised proxy mode: %s", svc.ProxyMode)
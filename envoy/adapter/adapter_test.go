@@ -0,0 +1,201 @@
+package adapter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
+	api "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_EnvoyResourcesFromState(t *testing.T) {
+	Convey("EnvoyResourcesFromState()", t, func() {
+		state := catalog.NewServicesState()
+		baseTime := time.Now().UTC()
+
+		newSvc := func(id string, hostname string, zone string, port int64) service.Service {
+			return service.Service{
+				ID:        id,
+				Name:      "bocaccio",
+				Hostname:  hostname,
+				Updated:   baseTime,
+				Status:    service.ALIVE,
+				ProxyMode: "http",
+				Zone:      zone,
+				Ports: []service.Port{
+					{IP: "127.0.0.1", Port: port, ServicePort: 10100},
+				},
+			}
+		}
+
+		clusterFor := func(zone string) *api.Cluster {
+			resources := EnvoyResourcesFromState(state, "192.168.168.168", false, zone, "")
+			So(resources.Clusters, ShouldHaveLength, 1)
+			return resources.Clusters[0].(*api.Cluster)
+		}
+
+		state.AddServiceEntry(newSvc("deadbeef123", "carcasone", "us-east-1a", 9990))
+		state.AddServiceEntry(newSvc("deadbeef456", "avignon", "us-east-1b", 9991))
+
+		Convey("puts all the endpoints together when we have no zone", func() {
+			endpoints := clusterFor("").LoadAssignment.Endpoints
+			So(endpoints, ShouldHaveLength, 1)
+			So(endpoints[0].Priority, ShouldEqual, 0)
+			So(endpoints[0].LbEndpoints, ShouldHaveLength, 2)
+		})
+
+		Convey("prefers the endpoints in our zone", func() {
+			endpoints := clusterFor("us-east-1b").LoadAssignment.Endpoints
+			So(endpoints, ShouldHaveLength, 2)
+
+			So(endpoints[0].Priority, ShouldEqual, 0)
+			So(endpoints[0].LbEndpoints, ShouldHaveLength, 1)
+			So(endpoints[0].LbEndpoints[0].GetEndpoint().GetAddress().GetSocketAddress().GetPortValue(), ShouldEqual, 9991)
+
+			So(endpoints[1].Priority, ShouldEqual, 1)
+			So(endpoints[1].LbEndpoints[0].GetEndpoint().GetAddress().GetSocketAddress().GetPortValue(), ShouldEqual, 9990)
+		})
+
+		Convey("weights the endpoints by the traffic split", func() {
+			state.Servers["carcasone"].Services["deadbeef123"].SidecarVersion = "blue"
+			state.Servers["avignon"].Services["deadbeef456"].SidecarVersion = "green"
+			state.SetTrafficSplit("", "bocaccio", &catalog.TrafficSplit{
+				Weights: map[string]int{"blue": 9, "green": 1},
+				Updated: time.Now().UTC(),
+			})
+
+			endpoints := clusterFor("").LoadAssignment.Endpoints[0].LbEndpoints
+			So(endpoints, ShouldHaveLength, 2)
+
+			weights := make(map[uint32]uint32)
+			for _, lbEndpoint := range endpoints {
+				port := lbEndpoint.GetEndpoint().GetAddress().GetSocketAddress().GetPortValue()
+				weights[port] = lbEndpoint.GetLoadBalancingWeight().GetValue()
+			}
+			So(weights, ShouldResemble, map[uint32]uint32{9990: 900, 9991: 100})
+		})
+
+		Convey("leaves out endpoints that get no traffic", func() {
+			state.Servers["carcasone"].Services["deadbeef123"].SidecarVersion = "blue"
+			state.SetTrafficSplit("", "bocaccio", &catalog.TrafficSplit{
+				Weights: map[string]int{"blue": 1},
+				Updated: time.Now().UTC(),
+			})
+
+			endpoints := clusterFor("").LoadAssignment.Endpoints[0].LbEndpoints
+			So(endpoints, ShouldHaveLength, 1)
+			So(endpoints[0].GetEndpoint().GetAddress().GetSocketAddress().GetPortValue(), ShouldEqual, 9990)
+		})
+
+		Convey("leaves out services that opted out of the proxy", func() {
+			state.Servers["avignon"].Services["deadbeef456"].ProxyDisabled = true
+
+			endpoints := clusterFor("").LoadAssignment.Endpoints[0].LbEndpoints
+			So(endpoints, ShouldHaveLength, 1)
+			So(endpoints[0].GetEndpoint().GetAddress().GetSocketAddress().GetPortValue(), ShouldEqual, 9990)
+		})
+
+		Convey("fails closed for services short of their minimum instances", func() {
+			state.Servers["carcasone"].Services["deadbeef123"].MinInstances = 3
+
+			So(clusterFor("").LoadAssignment.Endpoints, ShouldBeEmpty)
+		})
+
+		Convey("keeps failing closed for services with no instances left to proxy", func() {
+			state.EachService(func(hostname *string, id *string, svc *service.Service) {
+				svc.MinInstances = 2
+				svc.Status = service.UNHEALTHY
+			})
+
+			resources := EnvoyResourcesFromState(state, "192.168.168.168", false, "", "")
+			So(resources.Listeners, ShouldHaveLength, 1)
+			So(resources.Clusters, ShouldHaveLength, 1)
+			So(resources.Clusters[0].(*api.Cluster).LoadAssignment.Endpoints, ShouldBeEmpty)
+		})
+
+		Convey("drops services with no instances left that aren't closed", func() {
+			state.EachService(func(hostname *string, id *string, svc *service.Service) {
+				svc.Status = service.UNHEALTHY
+			})
+
+			resources := EnvoyResourcesFromState(state, "192.168.168.168", false, "", "")
+			So(resources.Listeners, ShouldBeEmpty)
+			So(resources.Clusters, ShouldBeEmpty)
+		})
+
+		Convey("leaves out services in other namespaces", func() {
+			state.Servers["avignon"].Services["deadbeef456"].Namespace = "staging"
+
+			endpoints := clusterFor("").LoadAssignment.Endpoints[0].LbEndpoints
+			So(endpoints, ShouldHaveLength, 1)
+			So(endpoints[0].GetEndpoint().GetAddress().GetSocketAddress().GetPortValue(), ShouldEqual, 9990)
+
+			resources := EnvoyResourcesFromState(state, "192.168.168.168", false, "", "staging")
+			So(resources.Clusters, ShouldHaveLength, 1)
+			So(resources.Clusters[0].(*api.Cluster).LoadAssignment.Endpoints[0].LbEndpoints, ShouldHaveLength, 1)
+		})
+
+		Convey("only listens on a ServicePort for the service that owns it", func() {
+			rogue := newSvc("deadbeef789", "avignon", "", 9992)
+			rogue.Name = "rogue"
+			rogue.Created = baseTime
+			state.AddServiceEntry(rogue)
+
+			resources := EnvoyResourcesFromState(state, "192.168.168.168", false, "", "")
+			So(resources.Clusters, ShouldHaveLength, 1)
+			So(resources.Listeners, ShouldHaveLength, 1)
+			So(resources.Clusters[0].(*api.Cluster).Name, ShouldEqual, SvcName("bocaccio", 10100))
+		})
+
+		Convey("sets up circuit breaking for the cluster", func() {
+			state.EachService(func(hostname *string, id *string, svc *service.Service) {
+				svc.MaxConnections = 100
+				svc.MaxPendingRequests = 10
+				svc.MaxConsecutiveErrors = 5
+			})
+
+			cluster := clusterFor("")
+			thresholds := cluster.GetCircuitBreakers().GetThresholds()
+			So(thresholds, ShouldHaveLength, 1)
+			So(thresholds[0].GetMaxConnections().GetValue(), ShouldEqual, 100)
+			So(thresholds[0].GetMaxPendingRequests().GetValue(), ShouldEqual, 10)
+			So(cluster.GetOutlierDetection().GetConsecutive_5Xx().GetValue(), ShouldEqual, 5)
+		})
+
+		Convey("sets the load balancing policy for the cluster", func() {
+			So(clusterFor("").LbPolicy, ShouldEqual, api.Cluster_ROUND_ROBIN)
+
+			state.EachService(func(hostname *string, id *string, svc *service.Service) {
+				svc.BalanceAlgorithm = "leastconn"
+			})
+			So(clusterFor("").LbPolicy, ShouldEqual, api.Cluster_LEAST_REQUEST)
+		})
+
+		Convey("hashes on the source address for services balanced by source", func() {
+			svc := newSvc("deadbeef789", "carcasone", "", 9992)
+			svc.BalanceAlgorithm = "source"
+			So(lbPolicyFor(&svc), ShouldEqual, api.Cluster_RING_HASH)
+			So(routeHashPolicyFor(&svc)[0].GetConnectionProperties().GetSourceIp(), ShouldBeTrue)
+			So(tcpHashPolicyFor(&svc)[0].GetSourceIp(), ShouldNotBeNil)
+
+			svc.BalanceAlgorithm = ""
+			So(routeHashPolicyFor(&svc), ShouldBeNil)
+			So(tcpHashPolicyFor(&svc), ShouldBeNil)
+		})
+
+		Convey("leaves the Envoy circuit breaking defaults alone", func() {
+			cluster := clusterFor("")
+			So(cluster.CircuitBreakers, ShouldBeNil)
+			So(cluster.OutlierDetection, ShouldBeNil)
+		})
+
+		Convey("falls back to other zones when there are none in ours", func() {
+			endpoints := clusterFor("us-west-2a").LoadAssignment.Endpoints
+			So(endpoints, ShouldHaveLength, 1)
+			So(endpoints[0].Priority, ShouldEqual, 0)
+			So(endpoints[0].LbEndpoints, ShouldHaveLength, 2)
+		})
+	})
+}
@@ -43,6 +43,8 @@ func (*xdsCallbacks) OnFetchResponse(*api.DiscoveryRequest, *api.DiscoveryRespon
 // Server is a wrapper around Envoy's control plane xDS gRPC server and it uses
 // the Aggregated Discovery Service (ADS) mechanism.
 type Server struct {
+	Zone          string // Prefer endpoints in this zone when set
+	Namespace     string // Only serve the services in this namespace
 	config        config.EnvoyConfig
 	state         *catalog.ServicesState
 	snapshotCache cache.SnapshotCache
@@ -76,7 +78,7 @@ func (s *Server) Run(ctx context.Context, looper director.Looper, grpcListener n
 			s.state.RUnlock()
 			return nil
 		}
-		resources := adapter.EnvoyResourcesFromState(s.state, s.config.BindIP, s.config.UseHostnames)
+		resources := adapter.EnvoyResourcesFromState(s.state, s.config.BindIP, s.config.UseHostnames, s.Zone, s.Namespace)
 		s.state.RUnlock()
 
 		prevStateLastChanged = lastChanged
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
+	"github.com/Nitro/sidecar/sidecarhttp"
+)
+
+const (
+	EVENTS_POLL_INTERVAL = 1 * time.Second  // How often `sidecar events --follow` asks for new events
+	EVENTS_MAX_BACKOFF   = 30 * time.Second // The longest we wait to try again when the node can't be reached
+	EVENTS_TIMEOUT       = 5 * time.Second  // HTTP timeout when fetching events
+)
+
+// A ClusterEvent is one line of `sidecar events` output. Service events come
+// straight from the server's change stream. Host events are worked out from
+// them: a host joins when its first live service shows up, and leaves when
+// its last one is tombstoned.
+type ClusterEvent struct {
+	Time     time.Time
+	Sequence uint64 `json:",omitempty"`
+	Event    string
+	Hostname string
+	Service  *service.Service `json:",omitempty"`
+	Previous string           `json:",omitempty"`
+}
+
+// An eventTailer fetches the change stream from a Sidecar node and prints it
+// as it goes, either for people or as one JSON object per line. Notices about
+// the stream itself go to errOut, so they don't get mixed in with the JSON.
+type eventTailer struct {
+	address string
+	client  *http.Client
+	out     io.Writer
+	errOut  io.Writer
+	json    bool
+	since   uint64
+	hosts   map[string]map[string]bool // Hostname -> service ID -> live
+	sleep   func(time.Duration)
+}
+
+func newEventTailer(address string, out io.Writer, asJson bool) *eventTailer {
+	return &eventTailer{
+		address: serverURLs([]string{address}, "")[0],
+		client:  newNodeClient(EVENTS_TIMEOUT),
+		out:     out,
+		errOut:  os.Stderr,
+		json:    asJson,
+		hosts:   make(map[string]map[string]bool),
+		sleep:   time.Sleep,
+	}
+}
+
+// runEvents is the `sidecar events` subcommand. It prints the events the
+// node still has after the given sequence number, or all of them. When
+// following, it starts from now unless given a sequence number, and keeps
+// printing until killed.
+func runEvents(address string, follow bool, asJson bool, since string) error {
+	tailer := newEventTailer(address, os.Stdout, asJson)
+
+	var sinceSeq uint64
+	if since != "" {
+		var err error
+		sinceSeq, err = strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid sequence number %q", since)
+		}
+	}
+
+	result, err := tailer.fetchEvents(0)
+	if err != nil {
+		return err
+	}
+
+	err = tailer.seedHosts()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case since != "":
+		tailer.since = sinceSeq
+	case follow:
+		tailer.since = result.LastSequence
+	}
+
+	if follow {
+		return tailer.follow()
+	}
+
+	return tailer.poll()
+}
+
+// follow prints new events until killed. When the node can't be reached, we
+// keep trying, waiting longer each time up to EVENTS_MAX_BACKOFF, so that a
+// node restarting doesn't end the tail.
+func (t *eventTailer) follow() error {
+	backoff := EVENTS_POLL_INTERVAL
+
+	for {
+		result, err := t.fetchEvents(t.since)
+		if err != nil {
+			fmt.Fprintf(t.errOut, "# Failed to fetch events, trying again in %s: %s\n", backoff, err)
+			t.sleep(backoff)
+
+			backoff *= 2
+			if backoff > EVENTS_MAX_BACKOFF {
+				backoff = EVENTS_MAX_BACKOFF
+			}
+			continue
+		}
+		backoff = EVENTS_POLL_INTERVAL
+
+		err = t.printEvents(result)
+		if err != nil {
+			return err
+		}
+
+		t.sleep(EVENTS_POLL_INTERVAL)
+	}
+}
+
+// seedHosts learns which hosts are up, and what they run, from the current
+// state. That way we don't announce every host we hear from as joining.
+func (t *eventTailer) seedHosts() error {
+	data, err := t.get("/api/state.json")
+	if err != nil {
+		return err
+	}
+
+	state, err := catalog.Decode(data)
+	if err != nil {
+		return fmt.Errorf("unable to decode the state: %s", err)
+	}
+
+	for hostname, server := range state.Servers {
+		for id, svc := range server.Services {
+			t.track(hostname, id, !svc.IsTombstone())
+		}
+	}
+
+	return nil
+}
+
+// poll fetches and prints the events since the last one we saw
+func (t *eventTailer) poll() error {
+	result, err := t.fetchEvents(t.since)
+	if err != nil {
+		return err
+	}
+
+	return t.printEvents(result)
+}
+
+// printEvents prints the events the node sent, and moves on past them
+func (t *eventTailer) printEvents(result *sidecarhttp.ApiEvents) error {
+	if !result.Complete {
+		notice := t.out
+		if t.json {
+			notice = t.errOut
+		}
+		fmt.Fprintln(notice, "# Some events were missed, they are no longer available on the server")
+	}
+
+	for _, change := range result.Events {
+		for _, event := range t.interpret(change) {
+			err := t.print(event)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Resume after the last event we were actually sent, which is all we can
+	// be sure we haven't missed
+	if len(result.Events) > 0 {
+		t.since = result.Events[len(result.Events)-1].Sequence
+	} else if result.LastSequence < t.since {
+		// The server restarted and started counting again
+		t.since = result.LastSequence
+	}
+
+	return nil
+}
+
+func (t *eventTailer) fetchEvents(since uint64) (*sidecarhttp.ApiEvents, error) {
+	data, err := t.get(fmt.Sprintf("/api/events?since=%d", since))
+	if err != nil {
+		return nil, err
+	}
+
+	var result sidecarhttp.ApiEvents
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode events: %s", err)
+	}
+
+	return &result, nil
+}
+
+func (t *eventTailer) get(path string) ([]byte, error) {
+	resp, err := t.client.Get(t.address + path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 || resp.StatusCode < 200 {
+		return nil, fmt.Errorf("bad status code returned from %s (%d)", path, resp.StatusCode)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// interpret turns one change from the server into the events we print, with
+// a host joining before its service event, or leaving after it.
+func (t *eventTailer) interpret(change catalog.ChangeEvent) []ClusterEvent {
+	svc := change.Service
+	_, known := t.hosts[svc.Hostname][svc.ID]
+	wasUp := t.hostUp(svc.Hostname)
+
+	t.track(svc.Hostname, svc.ID, !svc.IsTombstone())
+	isUp := t.hostUp(svc.Hostname)
+
+	event := ClusterEvent{
+		Time:     change.Time,
+		Sequence: change.Sequence,
+		Event:    serviceEventName(&svc, change.PreviousStatus, known),
+		Hostname: svc.Hostname,
+		Service:  &svc,
+	}
+	if known && svc.Status != change.PreviousStatus {
+		event.Previous = service.StatusString(change.PreviousStatus)
+	}
+
+	hostEvent := ClusterEvent{Time: change.Time, Hostname: svc.Hostname}
+	switch {
+	case !wasUp && isUp:
+		hostEvent.Event = "host joined"
+		return []ClusterEvent{hostEvent, event}
+	case wasUp && !isUp:
+		hostEvent.Event = "host left"
+		return []ClusterEvent{event, hostEvent}
+	}
+
+	return []ClusterEvent{event}
+}
+
+// serviceEventName describes what happened to a service
+func serviceEventName(svc *service.Service, previousStatus int, known bool) string {
+	switch {
+	case svc.IsTombstone():
+		return "tombstoned"
+	case !known:
+		return "added"
+	case svc.Status == previousStatus:
+		return "updated"
+	case svc.Status == service.UNHEALTHY || svc.Status == service.UNKNOWN:
+		return "failed"
+	case svc.IsAlive():
+		return "recovered"
+	case svc.IsDraining():
+		return "draining"
+	case svc.Status == service.MAINTENANCE:
+		return "maintenance"
+	}
+
+	return "updated"
+}
+
+func (t *eventTailer) track(hostname string, id string, live bool) {
+	if t.hosts[hostname] == nil {
+		t.hosts[hostname] = make(map[string]bool)
+	}
+	t.hosts[hostname][id] = live
+}
+
+// hostUp reports whether a host has any services that aren't tombstoned
+func (t *eventTailer) hostUp(hostname string) bool {
+	for _, live := range t.hosts[hostname] {
+		if live {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *eventTailer) print(event ClusterEvent) error {
+	if t.json {
+		data, err := json.Marshal(&event)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(t.out, "%s\n", data)
+		return err
+	}
+
+	_, err := fmt.Fprintln(t.out, formatEvent(&event))
+	return err
+}
+
+// formatEvent renders an event on one line for people to read
+func formatEvent(event *ClusterEvent) string {
+	timestamp := event.Time.UTC().Format(time.RFC3339)
+
+	if event.Service == nil {
+		return fmt.Sprintf("%s %6s  %-12s %s", timestamp, "", event.Event, event.Hostname)
+	}
+
+	svc := event.Service
+	name := svc.Name
+	if svc.Namespace != "" {
+		name = svc.Namespace + "/" + svc.Name
+	}
+
+	line := fmt.Sprintf("%s %6d  %-12s %s %s on %s (%s",
+		timestamp, event.Sequence, event.Event, name, svc.ID, svc.Hostname, svc.StatusString(),
+	)
+	if event.Previous != "" {
+		line += ", was " + event.Previous
+	}
+
+	return line + ")"
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
+	"github.com/Nitro/sidecar/sidecarhttp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_EventTailer(t *testing.T) {
+	Convey("When tailing the cluster events", t, func() {
+		baseTime := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+
+		remoteState := catalog.NewServicesState()
+		remoteState.AddServiceEntry(service.Service{
+			ID: "1", Name: "bocaccio", Hostname: "chaucer", Status: service.ALIVE, Updated: baseTime,
+		})
+
+		changes := []catalog.ChangeEvent{
+			{
+				Service:  service.Service{ID: "2", Name: "petrarch", Hostname: "dante", Status: service.ALIVE},
+				Time:     baseTime,
+				Sequence: 1,
+			},
+			{
+				Service:        service.Service{ID: "1", Name: "bocaccio", Hostname: "chaucer", Status: service.UNHEALTHY},
+				PreviousStatus: service.ALIVE,
+				Time:           baseTime.Add(time.Second),
+				Sequence:       2,
+			},
+			{
+				Service:        service.Service{ID: "2", Name: "petrarch", Hostname: "dante", Status: service.TOMBSTONE},
+				PreviousStatus: service.ALIVE,
+				Time:           baseTime.Add(2 * time.Second),
+				Sequence:       3,
+			},
+		}
+
+		var requestedSince string
+		lastSequence := uint64(3)
+		complete := true
+		failures := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/state.json":
+				_, _ = w.Write(remoteState.Encode())
+			case "/api/events":
+				if failures > 0 {
+					failures--
+					w.WriteHeader(503)
+					return
+				}
+				requestedSince = r.URL.Query().Get("since")
+				data, _ := json.Marshal(&sidecarhttp.ApiEvents{Events: changes, LastSequence: lastSequence, Complete: complete})
+				_, _ = w.Write(data)
+			default:
+				w.WriteHeader(404)
+			}
+		}))
+		defer server.Close()
+
+		var out, errOut bytes.Buffer
+		tailer := newEventTailer(server.URL, &out, false)
+		tailer.errOut = &errOut
+		So(tailer.seedHosts(), ShouldBeNil)
+
+		Convey("prints service and host events for people", func() {
+			So(tailer.poll(), ShouldBeNil)
+			So(requestedSince, ShouldEqual, "0")
+			So(tailer.since, ShouldEqual, 3)
+
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			So(lines, ShouldHaveLength, 5)
+			So(lines[0], ShouldEqual, "2026-10-15T12:00:00Z         host joined  dante")
+			So(lines[1], ShouldEqual, "2026-10-15T12:00:00Z      1  added        petrarch 2 on dante (Alive)")
+			So(lines[2], ShouldEqual, "2026-10-15T12:00:01Z      2  failed       bocaccio 1 on chaucer (Unhealthy, was Alive)")
+			So(lines[3], ShouldEqual, "2026-10-15T12:00:02Z      3  tombstoned   petrarch 2 on dante (Tombstone, was Alive)")
+			So(lines[4], ShouldEqual, "2026-10-15T12:00:02Z         host left    dante")
+		})
+
+		Convey("prints one JSON object per event", func() {
+			tailer.json = true
+			So(tailer.poll(), ShouldBeNil)
+
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			So(lines, ShouldHaveLength, 5)
+
+			var event ClusterEvent
+			So(json.Unmarshal([]byte(lines[2]), &event), ShouldBeNil)
+			So(event.Event, ShouldEqual, "failed")
+			So(event.Sequence, ShouldEqual, 2)
+			So(event.Previous, ShouldEqual, "Alive")
+			So(event.Service.Name, ShouldEqual, "bocaccio")
+		})
+
+		Convey("tells people when events were missed", func() {
+			complete = false
+			So(tailer.poll(), ShouldBeNil)
+			So(out.String(), ShouldStartWith, "# Some events were missed")
+		})
+
+		Convey("keeps the notice out of the JSON", func() {
+			complete = false
+			tailer.json = true
+			So(tailer.poll(), ShouldBeNil)
+			So(out.String(), ShouldStartWith, "{")
+			So(errOut.String(), ShouldStartWith, "# Some events were missed")
+		})
+
+		Convey("backs off and keeps following when the node can't be reached", func() {
+			failures = 3
+			var waits []time.Duration
+			tailer.sleep = func(wait time.Duration) { waits = append(waits, wait) }
+			tailer.out = &failingWriter{}
+
+			So(tailer.follow(), ShouldNotBeNil)
+			So(waits, ShouldResemble, []time.Duration{
+				EVENTS_POLL_INTERVAL, 2 * EVENTS_POLL_INTERVAL, 4 * EVENTS_POLL_INTERVAL,
+			})
+			So(strings.Count(errOut.String(), "# Failed to fetch events"), ShouldEqual, 3)
+		})
+
+		Convey("resumes after the last event it was sent", func() {
+			changes = changes[:2]
+			lastSequence = 4
+			So(tailer.poll(), ShouldBeNil)
+			So(tailer.since, ShouldEqual, 2)
+		})
+
+		Convey("starts over when the server does", func() {
+			changes = nil
+			lastSequence = 1
+			tailer.since = 3
+			So(tailer.poll(), ShouldBeNil)
+			So(tailer.since, ShouldEqual, 1)
+		})
+
+		Convey("asks for the events after the last one it saw", func() {
+			tailer.since = 2
+			So(tailer.poll(), ShouldBeNil)
+			So(requestedSince, ShouldEqual, "2")
+		})
+	})
+}
+
+// A failingWriter fails every write, to stop a tailer that's following
+type failingWriter struct{}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("closed")
+}
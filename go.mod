@@ -1,6 +1,6 @@
 module github.com/Nitro/sidecar
 
-go 1.12
+go 1.16
 
 require (
 	github.com/Nitro/memberlist v0.0.0-20170522194404-cfac2b5cf519
@@ -13,6 +13,7 @@ require (
 	github.com/containerd/continuity v0.0.0-20181203112020-004b46473808 // indirect
 	github.com/envoyproxy/go-control-plane v0.9.2
 	github.com/fsouza/go-dockerclient v1.3.1
+	github.com/ghodss/yaml v1.0.0
 	github.com/gogo/protobuf v1.2.1
 	github.com/golang/protobuf v1.3.2
 	github.com/gorilla/mux v1.6.2
@@ -33,9 +34,10 @@ require (
 	github.com/sirupsen/logrus v1.0.6
 	github.com/smartystreets/assertions v0.0.0-20190215210624-980c5ac6f3ac // indirect
 	github.com/smartystreets/goconvey v0.0.0-20190306220146-200a235640ff
-	golang.org/x/crypto v0.0.0-20190325154230-a5d413f7728c // indirect
-	golang.org/x/net v0.0.0-20190503192946-f4e77d36d62c // indirect
-	golang.org/x/sys v0.0.0-20190523142557-0e01d883c5c5 // indirect
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
+	golang.org/x/net v0.0.0-20190503192946-f4e77d36d62c
+	golang.org/x/sys v0.0.0-20200202164722-d101bd2416d5
 	golang.org/x/text v0.3.2 // indirect
 	google.golang.org/grpc v1.26.0
 	gopkg.in/alecthomas/kingpin.v2 v2.2.5
@@ -43,3 +45,7 @@ require (
 	gopkg.in/relistan/rubberneck.v1 v1.0.1
 	gotest.tools v2.2.0+incompatible // indirect
 )
+
+// The Docker client we build against predates the SECURITY_DESCRIPTOR change
+// in x/sys/windows, which x/crypto and bbolt would otherwise pull in.
+replace golang.org/x/sys => golang.org/x/sys v0.0.0-20190523142557-0e01d883c5c5
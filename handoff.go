@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Nitro/memberlist"
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/cluster"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	HANDOFF_BIND_WAIT     = 2 * time.Minute // How long we wait for the old Sidecar to free the gossip port
+	HANDOFF_BIND_RETRY    = 1 * time.Second // How often we try for it
+	HANDOFF_DRAIN_TIMEOUT = 5 * time.Second // How long in-flight API requests get to finish
+	LEAVE_TIMEOUT         = 5 * time.Second // How long we wait to tell the cluster we're leaving
+)
+
+// listen opens a TCP listener on the address, with SO_REUSEPORT when asked,
+// so that two Sidecars can serve the same port during an upgrade.
+func listen(address string, reuse bool) (net.Listener, error) {
+	listenConfig := net.ListenConfig{}
+	if reuse {
+		listenConfig.Control = reusePort
+	}
+
+	return listenConfig.Listen(context.Background(), "tcp", address)
+}
+
+// createMemberlist creates the memberlist. When we're taking over from
+// another Sidecar on this host, it still holds the gossip port, so we keep
+// trying until it lets go.
+func createMemberlist(mlConfig *memberlist.Config, handingOff bool) (*memberlist.Memberlist, error) {
+	deadline := time.Now().Add(HANDOFF_BIND_WAIT)
+
+	for {
+		list, err := memberlist.Create(mlConfig)
+		if err == nil || !handingOff || time.Now().After(deadline) ||
+			!strings.Contains(err.Error(), "address already in use") {
+			return list, err
+		}
+
+		log.Infof("Waiting for the Sidecar we're replacing to free port %d", mlConfig.BindPort)
+		time.Sleep(HANDOFF_BIND_RETRY)
+	}
+}
+
+// openStateStore opens the state store. When we're taking over from another
+// Sidecar, it keeps the file locked until it has handed off and exited, so we
+// keep trying for as long as that can take.
+func openStateStore(path string, handingOff bool, handoffTimeout time.Duration) (*catalog.BoltStore, error) {
+	deadline := time.Now().Add(HANDOFF_BIND_WAIT + handoffTimeout + HANDOFF_DRAIN_TIMEOUT)
+
+	for {
+		// Each try waits catalog.BOLT_OPEN_TIMEOUT for the lock
+		store, err := catalog.NewBoltStore(path)
+		if err == nil || !handingOff || time.Now().After(deadline) ||
+			!strings.Contains(err.Error(), "timeout") {
+			return store, err
+		}
+
+		log.Infof("Waiting for the Sidecar we're replacing to release the state store %s", path)
+	}
+}
+
+// waitForHandoff blocks until we're asked to stop, then hands off to the
+// Sidecar replacing us. We leave the gossip cluster first, without telling
+// the other nodes so they don't expire our services, so that the new Sidecar
+// can join in our place. Then we keep serving the API alongside it for the
+// timeout, while it catches up on the catalog, and then finish the requests
+// in flight and exit.
+func waitForHandoff(list *memberlist.Memberlist, server *http.Server, stopEnvoy func(), timeout time.Duration) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, os.Interrupt)
+	sig := <-sigChan
+
+	log.Warnf("Captured %v, handing off to the next Sidecar within %s", sig, timeout)
+
+	if list != nil {
+		err := list.Shutdown()
+		if err != nil {
+			log.Errorf("Failed to shut down memberlist: %s", err)
+		}
+	}
+
+	time.Sleep(timeout)
+
+	if stopEnvoy != nil {
+		stopEnvoy()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), HANDOFF_DRAIN_TIMEOUT)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.Warnf("Not all API requests finished before exiting: %s", err)
+	}
+
+	log.Info("Handoff complete, exiting")
+}
+
+// waitForLeave blocks until we're asked to stop, then leaves the gossip
+// cluster cleanly, so that the other nodes reap our services as those of a
+// node that left rather than one that failed.
+func waitForLeave(list *memberlist.Memberlist, delegate *cluster.Delegate) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, os.Interrupt)
+	sig := <-sigChan
+
+	log.Warnf("Captured %v, leaving the cluster", sig)
+
+	if list != nil {
+		err := cluster.Leave(list, delegate, LEAVE_TIMEOUT)
+		if err != nil {
+			log.Errorf("Failed to leave the cluster cleanly: %s", err)
+		}
+	}
+}
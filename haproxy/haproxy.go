@@ -8,11 +8,12 @@ import (
 	"os/exec"
 	"os/signal"
 	"path"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
-	"syscall"
 	"text/template"
 	"time"
 
@@ -21,21 +22,34 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	MAX_SERVER_WEIGHT   = 256           // The highest server weight HAproxy accepts
+	FAIL_CLOSED_BACKEND = "fail_closed" // Prefix of the backends that answer for closed services
+)
+
 type portset map[string]string
 type portmap map[string]portset
 
 // Configuration and state for the HAproxy management module
 type HAproxy struct {
-	ReloadCmd      string `toml:"reload_cmd"`
-	VerifyCmd      string `toml:"verify_cmd"`
-	BindIP         string `toml:"bind_ip"`
-	Template       string `toml:"template"`
-	ConfigFile     string `toml:"config_file"`
-	PidFile        string `toml:"pid_file"`
-	User           string `toml:"user"`
-	Group          string `toml:"group"`
-	UseHostnames   bool   `toml:"use_hostnames"`
+	ReloadCmd      string         `toml:"reload_cmd"`
+	VerifyCmd      string         `toml:"verify_cmd"`
+	BindIP         string         `toml:"bind_ip"`
+	Template       string         `toml:"template"`
+	ConfigFile     string         `toml:"config_file"`
+	PidFile        string         `toml:"pid_file"`
+	User           string         `toml:"user"`
+	Group          string         `toml:"group"`
+	UseHostnames   bool           `toml:"use_hostnames"`
+	CertDir        string         `toml:"cert_dir"`        // Where to find PEM bundles for TLS hostnames
+	TLSBindIP      string         `toml:"tls_bind_ip"`     // Where to serve TLS hostnames
+	AcmeChallenges bool           `toml:"acme_challenges"` // Route ACME challenges on port 80 to Sidecar
+	Zone           string         `toml:"zone"`            // Prefer backends in this zone when set
+	Namespace      string         `toml:"namespace"`       // Only proxy the services in this namespace
+	DryRun         bool           `toml:"dry_run"`         // Render the config, but don't write it or reload
+	Stagger        *ReloadStagger `toml:"-"`               // Spread reloads after large changes, when set
 	eventChannel   chan catalog.ChangeEvent
+	refreshChannel chan string
 	signalsHandled bool
 	sigLock        sync.Mutex
 	sigStopChan    chan struct{}
@@ -47,11 +61,12 @@ func New(configFile string, pidFile string) *HAproxy {
 	verifyCmd := "haproxy -c -f " + configFile
 
 	proxy := HAproxy{
-		ReloadCmd:  reloadCmd,
-		VerifyCmd:  verifyCmd,
-		Template:   "views/haproxy.cfg",
-		ConfigFile: configFile,
-		PidFile:    pidFile,
+		ReloadCmd:      reloadCmd,
+		VerifyCmd:      verifyCmd,
+		Template:       "views/haproxy.cfg",
+		ConfigFile:     configFile,
+		PidFile:        pidFile,
+		refreshChannel: make(chan string, 1),
 	}
 
 	return &proxy
@@ -82,6 +97,19 @@ func (h *HAproxy) makePortmap(services map[string][]*service.Service) portmap {
 	return ports
 }
 
+// Leave out the ServicePorts that another service owns, so we don't write
+// two frontends that bind the same port
+func dropConflictingPorts(ports portmap, conflicts map[int64]*catalog.PortConflict) {
+	for svcName, portset := range ports {
+		for svcPort := range portset {
+			port, _ := strconv.ParseInt(svcPort, 10, 64)
+			if catalog.ConflictLoser(conflicts, svcName, port) {
+				delete(portset, svcPort)
+			}
+		}
+	}
+}
+
 // Clean up image names for writing as HAproxy frontend and backend entries
 func sanitizeName(image string) string {
 	replace := regexp.MustCompile("[^a-z0-9-]")
@@ -133,6 +161,119 @@ func (h *HAproxy) findIpForService(svcPort string, svc *service.Service) string
 	return svc.Hostname
 }
 
+// When we know our zone, servers in other zones are only used as backups,
+// as long as there are servers in our own zone to prefer.
+func (h *HAproxy) backupFor(services []*service.Service, svc *service.Service) string {
+	if h.Zone == "" || svc.Zone == h.Zone {
+		return ""
+	}
+
+	for _, other := range services {
+		if other.Zone == h.Zone && other.IsProxied() {
+			return "backup"
+		}
+	}
+
+	return ""
+}
+
+// Render the circuit breaker settings for a server. HAproxy can only mark a
+// server down on errors if it is also health checking it, so that it can bring
+// the server back up again.
+func circuitBreakerFor(svc *service.Service) string {
+	var options []string
+
+	if svc.MaxConnections > 0 {
+		options = append(options, "maxconn "+strconv.Itoa(svc.MaxConnections))
+	}
+
+	if svc.MaxPendingRequests > 0 {
+		options = append(options, "maxqueue "+strconv.Itoa(svc.MaxPendingRequests))
+	}
+
+	if svc.MaxConsecutiveErrors > 0 {
+		layer := "layer4"
+		if svc.ProxyMode == "http" {
+			layer = "layer7"
+		}
+		options = append(options,
+			"check observe "+layer+" error-limit "+strconv.Itoa(svc.MaxConsecutiveErrors)+" on-error mark-down",
+		)
+	}
+
+	return strings.Join(options, " ")
+}
+
+// Render the balance algorithm for a backend. Instances of a service should all
+// agree on it. If they don't, the first one that sets one wins. Without one,
+// the HAproxy default from the template applies.
+func balanceFor(services []*service.Service) string {
+	for _, svc := range services {
+		if svc.BalanceAlgorithm != "" {
+			return svc.BalanceAlgorithm
+		}
+	}
+
+	return ""
+}
+
+// Look up the weight for each server of the services that have a traffic
+// split, or of every service when routing by host pressure. Servers without an
+// entry use the HAproxy default. The caller must hold the state lock.
+func trafficWeights(state *catalog.ServicesState, services map[string][]*service.Service) map[*service.Service]int {
+	weights := make(map[*service.Service]int)
+	for _, instances := range services {
+		for _, svc := range instances {
+			if weight := state.ProxyWeight(svc, MAX_SERVER_WEIGHT); weight >= 0 {
+				weights[svc] = weight
+			}
+		}
+	}
+
+	return weights
+}
+
+// Map each TLS hostname that we have a certificate for onto the backend for
+// the lowest service port of the HTTP service that asked for it.
+func (h *HAproxy) tlsBackends(services map[string][]*service.Service, ports portmap, modes map[string]string,
+	closed map[string]*catalog.ClosedService) map[string]string {
+
+	backends := make(map[string]string)
+	if h.CertDir == "" {
+		return backends
+	}
+
+	for svcName, svcList := range services {
+		if modes[svcName] != "http" || len(ports[svcName]) < 1 {
+			continue
+		}
+
+		var lowest int64
+		for svcPort := range ports[svcName] {
+			port, _ := strconv.ParseInt(svcPort, 10, 64)
+			if lowest == 0 || port < lowest {
+				lowest = port
+			}
+		}
+		backend := fmt.Sprintf("%s-%d", sanitizeName(svcName), lowest)
+		if closed[svcName] != nil {
+			backend = FAIL_CLOSED_BACKEND + "_http"
+		}
+
+		for _, svc := range svcList {
+			for _, host := range svc.TLSHosts {
+				host = strings.ToLower(host)
+				if _, err := os.Stat(filepath.Join(h.CertDir, host+".pem")); err != nil {
+					continue
+				}
+				backends[host] = backend
+			}
+		}
+	}
+
+	return backends
+}
+
 // Create an HAproxy config from the supplied ServicesState. Write it out to the
 // supplied io.Writer interface. This gets a list from servicesWithPorts() and
 // builds a list of unique ports for all services, then passes these to the
@@ -140,19 +281,43 @@ func (h *HAproxy) findIpForService(svcPort string, svc *service.Service) string
 func (h *HAproxy) WriteConfig(state *catalog.ServicesState, output io.Writer) error {
 
 	state.RLock()
-	services := servicesWithPorts(state)
+	services := servicesWithPorts(state, h.Namespace)
+	closed := state.ClosedServicesByName(h.Namespace)
+	idle := closedWithoutInstances(state, h.Namespace, services, closed)
 	ports := h.makePortmap(services)
-	modes := getModes(state)
+	for svcName, portset := range h.makePortmap(idle) {
+		ports[svcName] = portset
+	}
+	dropConflictingPorts(ports, state.PortConflictsIn(h.Namespace))
+	modes := getModes(state, h.Namespace)
+	tlsBackends := h.tlsBackends(services, ports, modes, closed)
+	for host, backend := range h.tlsBackends(idle, ports, modes, closed) {
+		tlsBackends[host] = backend
+	}
+	for svcName := range idle {
+		services[svcName] = []*service.Service{}
+	}
+	weights := trafficWeights(state, services)
 	state.RUnlock()
 
 	data := struct {
-		Services map[string][]*service.Service
-		User     string
-		Group    string
+		Services       map[string][]*service.Service
+		User           string
+		Group          string
+		TLSBackends    map[string]string
+		TLSBindIP      string
+		CertDir        string
+		AcmeChallenges bool
+		Closed         map[string]*catalog.ClosedService
 	}{
-		Services: services,
-		User:     h.User,
-		Group:    h.Group,
+		Services:       services,
+		User:           h.User,
+		Group:          h.Group,
+		TLSBackends:    tlsBackends,
+		TLSBindIP:      h.TLSBindIP,
+		CertDir:        h.CertDir,
+		AcmeChallenges: h.AcmeChallenges,
+		Closed:         closed,
 	}
 
 	funcMap := template.FuncMap{
@@ -167,6 +332,33 @@ func (h *HAproxy) WriteConfig(state *catalog.ServicesState, output io.Writer) er
 		"ipFor":        h.findIpForService,
 		"bindIP":       func() string { return h.BindIP },
 		"sanitizeName": sanitizeName,
+		"zoneAware":    func() bool { return h.Zone != "" },
+		"backupFor":    h.backupFor,
+		"circuitFor":   circuitBreakerFor,
+		"balanceFor":   balanceFor,
+		"proxyChecked": func(services []*service.Service) []*service.Service {
+			if len(services) < 1 || modes[services[0].Name] != "http" {
+				return nil
+			}
+			return proxyChecked(state, services)
+		},
+		"checkHeader":    func() string { return service.PROXY_CHECK_HEADER },
+		"checkerSources": h.checkerSources,
+		"backendFor": func(svcName string, svcPort string) string {
+			if closed[svcName] != nil {
+				return FAIL_CLOSED_BACKEND + "_" + modes[svcName]
+			}
+			return sanitizeName(svcName) + "-" + svcPort
+		},
+		"weightFor": func(svc *service.Service) string {
+			if weight, ok := weights[svc]; ok {
+				return "weight " + strconv.Itoa(weight)
+			}
+			return ""
+		},
+	}
+	for name, fn := range templateFuncs(weights) {
+		funcMap[name] = fn
 	}
 
 	t, err := template.New("haproxy").Funcs(funcMap).ParseFiles(h.Template)
@@ -213,7 +405,10 @@ func (h *HAproxy) swallowSignals() {
 		}
 	}()
 
-	signal.Notify(sigChan, syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU)
+	// Notify() with no signals would catch all of them
+	if len(jobControlSignals) > 0 {
+		signal.Notify(sigChan, jobControlSignals...)
+	}
 }
 
 // ResetSignals unhooks our signal handler from the signals the sub-commands
@@ -221,7 +416,9 @@ func (h *HAproxy) swallowSignals() {
 // hooked to the same signals! Affected signals are SIGTSTP, SIGTTIN, SIGTTOU.
 func (h *HAproxy) ResetSignals() {
 	h.sigLock.Lock()
-	signal.Reset(syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU)
+	if len(jobControlSignals) > 0 {
+		signal.Reset(jobControlSignals...)
+	}
 	select {
 	case h.sigStopChan <- struct{}{}: // nothing
 	default:
@@ -278,25 +475,115 @@ func (h *HAproxy) Verify() error {
 // the service that it needs to reload once the new file has been written
 // and verified.
 func (h *HAproxy) Watch(state *catalog.ServicesState) {
-	h.eventChannel = make(chan catalog.ChangeEvent, 2)
+	if h.Stagger != nil {
+		h.eventChannel = make(chan catalog.ChangeEvent, catalog.LISTENER_EVENT_BUFFER_SIZE)
+	} else {
+		h.eventChannel = make(chan catalog.ChangeEvent, 2)
+	}
 	state.AddListener(h)
 
-	for event := range h.eventChannel {
-		log.Println("State change event from " + event.Service.Hostname)
+	if h.Stagger != nil {
+		h.watchStaggered(state)
+	} else {
+		h.watchEach(state)
+	}
+
+	err := state.RemoveListener(h.Name())
+	if err != nil {
+		log.Warnf("Failed to remove HAProxy listener: %s", err)
+	}
+}
+
+// watchEach reloads for every state change, and every refresh
+func (h *HAproxy) watchEach(state *catalog.ServicesState) {
+	for {
+		select {
+		case event, ok := <-h.eventChannel:
+			if !ok {
+				return
+			}
+			log.Println("State change event from " + event.Service.Hostname)
+
+		case reason := <-h.refreshChannel:
+			log.Infof("Refreshing HAproxy for %s", reason)
+		}
+
 		err := h.WriteAndReload(state)
 		if err != nil {
 			log.Error(err.Error())
 		}
 	}
+}
 
-	err := state.RemoveListener(h.Name())
-	if err != nil {
-		log.Warnf("Failed to remove HAProxy listener: %s", err)
+// watchStaggered gathers up the changes that arrive together, and then
+// reloads once. After a large change it first waits for the delay the
+// Stagger gives it. Changes arriving in the meantime go in the same reload.
+func (h *HAproxy) watchStaggered(state *catalog.ServicesState) {
+	var (
+		changes  int
+		settling bool
+		timer    <-chan time.Time
+	)
+
+	for {
+		select {
+		case event, ok := <-h.eventChannel:
+			if !ok {
+				return
+			}
+			log.Println("State change event from " + event.Service.Hostname)
+			changes++
+			if timer == nil {
+				settling = true
+				timer = time.After(STAGGER_SETTLE_TIME)
+			}
+
+		case reason := <-h.refreshChannel:
+			// Refreshes aren't catalog changes, so they don't count
+			// towards the stagger, but they share its reload.
+			log.Infof("Refreshing HAproxy for %s", reason)
+			if timer == nil {
+				settling = true
+				timer = time.After(STAGGER_SETTLE_TIME)
+			}
+
+		case <-timer:
+			if settling {
+				settling = false
+				if delay := h.Stagger.Delay(changes); delay > 0 {
+					log.Infof("Staggering HAproxy reload for %d changes by %s", changes, delay)
+					timer = time.After(delay)
+					continue
+				}
+			}
+
+			changes = 0
+			timer = nil
+			err := h.WriteAndReload(state)
+			if err != nil {
+				log.Error(err.Error())
+			}
+		}
+	}
+}
+
+// Refresh asks the watcher to write out the config and reload. Changes from
+// outside the state, like new certificates, go through here so that there's
+// never more than one reload running. When a refresh is already queued, the
+// watcher is going to reload anyway.
+func (h *HAproxy) Refresh(reason string) {
+	select {
+	case h.refreshChannel <- reason:
+	default:
 	}
 }
 
 // Write out the the HAproxy config and reload the service.
 func (h *HAproxy) WriteAndReload(state *catalog.ServicesState) error {
+	if h.DryRun {
+		return h.dryRun(state)
+	}
+
 	if h.ConfigFile == "" {
 		return fmt.Errorf("Trying to write HAproxy config, but no filename specified!")
 	}
@@ -317,6 +604,20 @@ func (h *HAproxy) WriteAndReload(state *catalog.ServicesState) error {
 	return h.Reload()
 }
 
+// dryRun renders the config without writing it, and logs what it would have
+// written at debug level, so templates can be tried out safely.
+func (h *HAproxy) dryRun(state *catalog.ServicesState) error {
+	buf := bytes.NewBuffer(nil)
+	if err := h.WriteConfig(state, buf); err != nil {
+		return err
+	}
+
+	log.Infof("Dry run: not writing %d bytes of HAproxy config to %s or reloading", buf.Len(), h.ConfigFile)
+	log.Debugf("Dry run: HAproxy config would be:\n%s", buf.String())
+
+	return nil
+}
+
 // Name is part of the catalog.Listener interface. Returns the listener name.
 func (h *HAproxy) Name() string {
 	return "HAproxy"
@@ -333,30 +634,73 @@ func (h *HAproxy) Chan() chan catalog.ChangeEvent {
 	return h.eventChannel
 }
 
-func getModes(state *catalog.ServicesState) map[string]string {
+func getModes(state *catalog.ServicesState, namespace string) map[string]string {
 	modeMap := make(map[string]string)
 	state.EachService(
 		func(hostname *string, serviceId *string, svc *service.Service) {
+			if svc.Namespace != namespace {
+				return
+			}
 			modeMap[svc.Name] = svc.ProxyMode
 		},
 	)
 	return modeMap
 }
 
-// Like state.ByService() but only stores information for services which
+// awaitingProxyCheck reports whether an instance on this host that is
+// checked through the proxy is failing its checks. We keep it in the config,
+// disabled, so that the checks can still reach it through the proxy and
+// bring it back. Otherwise it could never pass them.
+func awaitingProxyCheck(state *catalog.ServicesState, svc *service.Service) bool {
+	return svc.ProxyChecked && !svc.ProxyDisabled && svc.Hostname == state.Hostname &&
+		(svc.Status == service.UNHEALTHY || svc.Status == service.UNKNOWN)
+}
+
+// checkerSources returns the addresses that checks run through the proxy
+// come from. The checker connects to the BindIP on this host, so that's the
+// source address, or the loopback when HAproxy binds all addresses. Nobody
+// else gets to route with the check header.
+func (h *HAproxy) checkerSources() string {
+	if h.BindIP == "" || h.BindIP == "0.0.0.0" || h.BindIP == "127.0.0.1" {
+		return "127.0.0.1"
+	}
+
+	return "127.0.0.1 " + h.BindIP
+}
+
+// proxyChecked returns the instances on this host that are checked through
+// the proxy, which the proxy routes checks to by the header they send
+func proxyChecked(state *catalog.ServicesState, services []*service.Service) []*service.Service {
+	var checked []*service.Service
+	for _, svc := range services {
+		if svc.ProxyChecked && svc.Hostname == state.Hostname {
+			checked = append(checked, svc)
+		}
+	}
+
+	return checked
+}
+
+// Like state.ByServiceIn() but only stores information for services which
 // actually have public ports. Only matches services that have the same name
 // and the same ports. Otherwise log an error.
-func servicesWithPorts(state *catalog.ServicesState) map[string][]*service.Service {
+func servicesWithPorts(state *catalog.ServicesState, namespace string) map[string][]*service.Service {
 	serviceMap := make(map[string][]*service.Service)
 
 	state.EachService(
 		func(hostname *string, serviceId *string, svc *service.Service) {
-			if len(svc.Ports) < 1 {
+			if len(svc.Ports) < 1 || svc.Namespace != namespace {
 				return
 			}
 
-			// We only want things that are alive and healthy!
-			if !svc.IsAlive() {
+			// We only want things that are alive, healthy, and want to be proxied,
+			// and our own instances waiting on a check through the proxy
+			if !svc.IsProxied() && !awaitingProxyCheck(state, svc) {
+				return
+			}
+
+			// Leave out instances other than the ones the service is pinned to
+			if state.PinnedOut(svc) {
 				return
 			}
 
@@ -394,6 +738,31 @@ func servicesWithPorts(state *catalog.ServicesState) map[string][]*service.Servi
 	return serviceMap
 }
 
+// closedWithoutInstances returns the instances of the closed services that
+// have none left to proxy, so that we still know their ports. We write those
+// services with no servers, so they get a frontend that fails closed instead
+// of refusing connections. The caller must hold the state lock.
+func closedWithoutInstances(state *catalog.ServicesState, namespace string,
+	services map[string][]*service.Service, closed map[string]*catalog.ClosedService) map[string][]*service.Service {
+
+	idle := make(map[string][]*service.Service)
+	state.EachService(
+		func(hostname *string, serviceId *string, svc *service.Service) {
+			if len(svc.Ports) < 1 || svc.Namespace != namespace || svc.IsTombstone() {
+				return
+			}
+
+			if closed[svc.Name] == nil || len(services[svc.Name]) > 0 {
+				return
+			}
+
+			idle[svc.Name] = append(idle[svc.Name], svc)
+		},
+	)
+
+	return idle
+}
+
 func getSortedServicePorts(svc *service.Service) []string {
 	// Allocate once, with exact length
 	portList := make([]string, len(svc.Ports))
@@ -106,7 +106,7 @@ func Test_HAproxy(t *testing.T) {
 		})
 
 		Convey("getModes() generates a correct mode map", func() {
-			result := getModes(state)
+			result := getModes(state, "")
 			fmt.Println(result)
 
 			So(len(result), ShouldEqual, 2)
@@ -143,16 +143,66 @@ func Test_HAproxy(t *testing.T) {
 			}
 
 			// It had 1 before
-			svcList := servicesWithPorts(state)
+			svcList := servicesWithPorts(state, "")
 			So(len(svcList[badSvc.Name]), ShouldEqual, 1)
 
 			// We add an entry with mismatching ports and should get no more added
 			state.AddServiceEntry(badSvc)
 
-			svcList = servicesWithPorts(state)
+			svcList = servicesWithPorts(state, "")
 			So(len(svcList[badSvc.Name]), ShouldEqual, 1)
 		})
 
+		Convey("servicesWithPorts() leaves out instances other than the pinned ones", func() {
+			state.SetPin("", "awesome-svc", &catalog.Pin{Instances: []string{svcId2}, Updated: time.Now().UTC()})
+
+			svcList := servicesWithPorts(state, "")
+			So(len(svcList["awesome-svc"]), ShouldEqual, 1)
+			So(svcList["awesome-svc"][0].ID, ShouldEqual, svcId2)
+		})
+
+		Convey("servicesWithPorts() and getModes() only see one namespace", func() {
+			other := services[0]
+			other.ID = "0000other000"
+			other.Hostname = "titanic"
+			other.Namespace = "staging"
+			other.ProxyMode = "tcp"
+			other.Updated = baseTime.Add(5 * time.Second)
+			state.AddServiceEntry(other)
+
+			So(len(servicesWithPorts(state, "")["awesome-svc"]), ShouldEqual, 2)
+			So(getModes(state, "")["awesome-svc"], ShouldEqual, "http")
+
+			svcList := servicesWithPorts(state, "staging")
+			So(len(svcList), ShouldEqual, 1)
+			So(svcList["awesome-svc"][0].ID, ShouldEqual, "0000other000")
+			So(getModes(state, "staging"), ShouldResemble, map[string]string{"awesome-svc": "tcp"})
+		})
+
+		Convey("WriteConfig() only writes a frontend for the owner of a ServicePort", func() {
+			state.AddServiceEntry(service.Service{
+				ID:        "0000rogue000",
+				Name:      "rogue-svc",
+				Image:     "rogue-svc",
+				Hostname:  hostname1,
+				Created:   baseTime,
+				Updated:   baseTime.Add(5 * time.Second),
+				ProxyMode: "tcp",
+				Ports: []service.Port{
+					{Type: "tcp", Port: 31000, ServicePort: 8090, IP: ip},
+					{Type: "tcp", Port: 31001, ServicePort: 8100, IP: ip},
+				},
+			})
+
+			buf := bytes.NewBuffer(make([]byte, 0, 2048))
+			So(proxy.WriteConfig(state, buf), ShouldBeNil)
+
+			output := buf.String()
+			So(output, ShouldContainSubstring, "frontend some-svc-8090")
+			So(output, ShouldNotContainSubstring, "frontend rogue-svc-8090")
+			So(output, ShouldContainSubstring, "frontend rogue-svc-8100")
+		})
+
 		Convey("WriteConfig() writes a template from a file", func() {
 			buf := bytes.NewBuffer(make([]byte, 0, 2048))
 			err := proxy.WriteConfig(state, buf)
@@ -171,6 +221,200 @@ func Test_HAproxy(t *testing.T) {
 			So(output, ShouldMatch, "server indefatigable-deadbeef105 127.0.0.3:9999 cookie indefatigable-9999")
 		})
 
+		Convey("WriteConfig() serves TLS hostnames that have certificates", func() {
+			certDir, _ := ioutil.TempDir("", "haproxy-certs")
+			defer os.RemoveAll(certDir)
+			_ = ioutil.WriteFile(certDir+"/awesome.example.com.pem", []byte("cert"), 0600)
+
+			state.Servers[hostname1].Services[svcId1].TLSHosts = []string{
+				"awesome.example.com", "missing.example.com",
+			}
+			proxy.CertDir = certDir
+			proxy.TLSBindIP = "0.0.0.0"
+			proxy.AcmeChallenges = true
+
+			buf := bytes.NewBuffer(make([]byte, 0, 2048))
+			err := proxy.WriteConfig(state, buf)
+			So(err, ShouldBeNil)
+
+			output := buf.Bytes()
+			So(output, ShouldMatch, "bind 0.0.0.0:443 ssl crt "+certDir)
+			So(output, ShouldMatch, "use_backend awesome-svc-8080 if .* -i awesome.example.com")
+			So(output, ShouldNotMatch, "missing.example.com")
+			So(output, ShouldMatch, "frontend acme_challenge")
+		})
+
+		Convey("WriteConfig() leaves out TLS without certificates", func() {
+			buf := bytes.NewBuffer(make([]byte, 0, 2048))
+			err := proxy.WriteConfig(state, buf)
+			So(err, ShouldBeNil)
+
+			output := buf.Bytes()
+			So(output, ShouldNotMatch, "frontend tls")
+			So(output, ShouldNotMatch, "frontend acme_challenge")
+		})
+
+		Convey("WriteConfig() makes servers in other zones backups", func() {
+			state.Servers[hostname1].Services[svcId1].Zone = "us-east-1a"
+			state.Servers[hostname2].Services[svcId2].Zone = "us-east-1b"
+			proxy.Zone = "us-east-1a"
+
+			buf := bytes.NewBuffer(make([]byte, 0, 2048))
+			err := proxy.WriteConfig(state, buf)
+			So(err, ShouldBeNil)
+
+			output := buf.Bytes()
+			So(output, ShouldMatch, "option allbackups")
+			So(output, ShouldMatch, "server.*127.0.0.1:10450 cookie [^ ]+ *\n")
+			So(output, ShouldMatch, "server.*127.0.0.3:32763 cookie .* backup")
+		})
+
+		Convey("WriteConfig() routes checks through the proxy to our own instances", func() {
+			local := state.Servers[hostname1].Services[svcId1]
+			local.ProxyChecked = true
+			local.Status = service.UNHEALTHY
+
+			buf := bytes.NewBuffer(make([]byte, 0, 2048))
+			err := proxy.WriteConfig(state, buf)
+			So(err, ShouldBeNil)
+
+			output := buf.Bytes()
+			So(output, ShouldMatch, "acl sidecar_checker src 127.0.0.1 192.168.168.168\n")
+			So(output, ShouldMatch, "http-request del-header X-Sidecar-Check unless sidecar_checker")
+			So(output, ShouldMatch, "use_backend awesome-svc-8080 if sidecar_checker { req.hdr\\(X-Sidecar-Check\\) -m found }")
+			So(output, ShouldMatch, "force-persist if sidecar_checker { req.hdr\\(X-Sidecar-Check\\) -m found }")
+			So(output, ShouldMatch, "use-server indomitable-deadbeef123 if sidecar_checker { req.hdr\\(X-Sidecar-Check\\) -m str deadbeef123 }")
+			So(output, ShouldMatch, "server.*127.0.0.1:10450 cookie .* disabled\n")
+			So(output, ShouldNotMatch, "server.*127.0.0.3:32763 cookie .* disabled")
+			So(output, ShouldNotMatch, "use-server.*deadbeef101")
+
+			Convey("but not to those on other hosts", func() {
+				local.Hostname = hostname2
+
+				So(awaitingProxyCheck(state, local), ShouldBeFalse)
+			})
+
+			Convey("only from the loopback when binding all addresses", func() {
+				proxy.BindIP = "0.0.0.0"
+				So(proxy.checkerSources(), ShouldEqual, "127.0.0.1")
+			})
+		})
+
+		Convey("WriteConfig() doesn't route checks for TCP services", func() {
+			state.Servers[hostname2].Services[svcId3].ProxyChecked = true
+			state.Hostname = hostname2
+
+			buf := bytes.NewBuffer(make([]byte, 0, 2048))
+			err := proxy.WriteConfig(state, buf)
+			So(err, ShouldBeNil)
+			So(buf.Bytes(), ShouldNotMatch, "X-Sidecar-Check")
+		})
+
+		Convey("WriteConfig() doesn't make backups without servers in our zone", func() {
+			state.Servers[hostname2].Services[svcId2].Zone = "us-east-1b"
+			proxy.Zone = "us-east-1a"
+
+			buf := bytes.NewBuffer(make([]byte, 0, 2048))
+			err := proxy.WriteConfig(state, buf)
+			So(err, ShouldBeNil)
+			So(buf.Bytes(), ShouldNotMatch, "server.* backup")
+		})
+
+		Convey("WriteConfig() weights servers by the traffic split", func() {
+			state.Servers[hostname1].Services[svcId1].SidecarVersion = "blue"
+			state.Servers[hostname2].Services[svcId2].SidecarVersion = "green"
+			state.SetTrafficSplit("", "awesome-svc", &catalog.TrafficSplit{
+				Weights: map[string]int{"blue": 3, "green": 1},
+				Updated: time.Now().UTC(),
+			})
+
+			buf := bytes.NewBuffer(make([]byte, 0, 2048))
+			err := proxy.WriteConfig(state, buf)
+			So(err, ShouldBeNil)
+
+			output := buf.Bytes()
+			So(output, ShouldMatch, "server.*127.0.0.1:10450 cookie .* weight 192")
+			So(output, ShouldMatch, "server.*127.0.0.3:32763 cookie .* weight 64")
+			So(output, ShouldNotMatch, "server.*127.0.0.3:9999 .*weight")
+		})
+
+		Convey("WriteConfig() weights servers on overloaded hosts less", func() {
+			state.SetPressureThreshold(0.8)
+			state.SetHostPressure(map[string]float64{hostname2: 1.6})
+
+			buf := bytes.NewBuffer(make([]byte, 0, 2048))
+			So(proxy.WriteConfig(state, buf), ShouldBeNil)
+
+			output := buf.Bytes()
+			So(output, ShouldMatch, "server.*127.0.0.1:10450 cookie .* weight 256")
+			So(output, ShouldMatch, "server.*127.0.0.3:32763 cookie .* weight 128")
+		})
+
+		Convey("WriteConfig() renders the circuit breaker settings", func() {
+			svc := state.Servers[hostname1].Services[svcId1]
+			svc.MaxConnections = 100
+			svc.MaxPendingRequests = 10
+			svc.MaxConsecutiveErrors = 5
+
+			buf := bytes.NewBuffer(make([]byte, 0, 2048))
+			err := proxy.WriteConfig(state, buf)
+			So(err, ShouldBeNil)
+
+			output := buf.Bytes()
+			So(output, ShouldMatch,
+				"server.*127.0.0.1:10450 .* maxconn 100 maxqueue 10 check observe layer7 error-limit 5 on-error mark-down",
+			)
+			So(output, ShouldNotMatch, "server.*127.0.0.3:32763 .*maxconn")
+		})
+
+		Convey("WriteConfig() renders the balance algorithm", func() {
+			state.Servers[hostname1].Services[svcId1].BalanceAlgorithm = "leastconn"
+
+			buf := bytes.NewBuffer(make([]byte, 0, 2048))
+			err := proxy.WriteConfig(state, buf)
+			So(err, ShouldBeNil)
+
+			output := buf.Bytes()
+			So(output, ShouldMatch, "backend awesome-svc-8080\n\tmode http\n\tbalance leastconn \n")
+			So(output, ShouldMatch, "backend awesome-svc-9000\n\tmode http\n\tbalance leastconn \n")
+			So(output, ShouldMatch, "backend some-svc-8090\n\tmode tcp \n")
+		})
+
+		Convey("WriteConfig() fails closed for services short of their minimum instances", func() {
+			state.Servers[hostname1].Services[svcId1].MinInstances = 5
+
+			buf := bytes.NewBuffer(make([]byte, 0, 2048))
+			err := proxy.WriteConfig(state, buf)
+			So(err, ShouldBeNil)
+
+			output := buf.Bytes()
+			So(output, ShouldMatch, "backend fail_closed_http\n\tmode http\n\thttp-request deny deny_status 503")
+			So(output, ShouldMatch, "frontend awesome-svc-8080\n\tmode http\n\tbind 192.168.168.168:8080\n\tdefault_backend fail_closed_http")
+			So(output, ShouldMatch, "default_backend some-svc-8090")
+		})
+
+		Convey("WriteConfig() fails closed for closed services with no instances left", func() {
+			state.Servers[hostname1].Services[svcId1].MinInstances = 1
+			state.Servers[hostname1].Services[svcId1].Status = service.UNHEALTHY
+			state.Servers[hostname2].Services[svcId2].Status = service.UNHEALTHY
+
+			buf := bytes.NewBuffer(make([]byte, 0, 2048))
+			err := proxy.WriteConfig(state, buf)
+			So(err, ShouldBeNil)
+
+			output := buf.Bytes()
+			So(output, ShouldMatch, "frontend awesome-svc-8080\n\tmode http\n\tbind 192.168.168.168:8080\n\tdefault_backend fail_closed_http")
+			So(output, ShouldMatch, "frontend awesome-svc-9000\n\tmode http\n\tbind 192.168.168.168:9000\n\tdefault_backend fail_closed_http")
+			So(string(output), ShouldNotContainSubstring, "server indomitable-"+svcId1)
+			So(string(output), ShouldNotContainSubstring, "-"+svcId2+" ")
+		})
+
+		Convey("circuitBreakerFor() observes TCP services at layer 4", func() {
+			svc := &service.Service{ProxyMode: "tcp", MaxConsecutiveErrors: 3}
+			So(circuitBreakerFor(svc), ShouldEqual, "check observe layer4 error-limit 3 on-error mark-down")
+			So(circuitBreakerFor(&service.Service{}), ShouldEqual, "")
+		})
+
 		Convey("WriteConfig() bubbles up templater errors", func() {
 			proxy.Template = "/"
 			buf := bytes.NewBuffer(make([]byte, 0, 2048))
@@ -179,7 +423,7 @@ func Test_HAproxy(t *testing.T) {
 			So(err, ShouldNotBeNil)
 		})
 
-		Convey("WriteConfig() only writes out healthy services", func() {
+		Convey("WriteConfig() only writes out healthy, proxied services", func() {
 			badSvc := service.Service{
 				ID:       "0000bad00000",
 				Name:     "some-svc-0155555789a",
@@ -202,8 +446,21 @@ func Test_HAproxy(t *testing.T) {
 					{Type: "tcp", Port: 666, ServicePort: 6666, IP: "127.0.0.1"},
 				},
 			}
+			hiddenSvc := service.Service{
+				ID:            "0000bad00002",
+				Name:          "some-svc-0155555789a",
+				Image:         "some-svc",
+				Hostname:      "titanic",
+				Status:        service.ALIVE,
+				Updated:       baseTime.Add(5 * time.Second),
+				ProxyDisabled: true,
+				Ports: []service.Port{
+					{Type: "tcp", Port: 666, ServicePort: 6666, IP: "127.0.0.1"},
+				},
+			}
 			state.AddServiceEntry(badSvc)
 			state.AddServiceEntry(badSvc2)
+			state.AddServiceEntry(hiddenSvc)
 
 			buf := bytes.NewBuffer(make([]byte, 0, 2048))
 			err := proxy.WriteConfig(state, buf)
@@ -213,6 +470,7 @@ func Test_HAproxy(t *testing.T) {
 			// Look for a few things we should NOT see
 			So(output, ShouldNotMatch, "0000bad00000")
 			So(output, ShouldNotMatch, "0000bad00001")
+			So(output, ShouldNotMatch, "0000bad00002")
 		})
 
 		Convey("Reload() doesn't return an error when it works", func() {
@@ -243,6 +501,45 @@ func Test_HAproxy(t *testing.T) {
 
 		})
 
+		Convey("WriteAndReload() doesn't write or reload on a dry run", func() {
+			tmpDir, _ := ioutil.TempDir("/tmp", "sidecar-test")
+			defer os.RemoveAll(tmpDir)
+
+			proxy.DryRun = true
+			proxy.ReloadCmd = "/usr/bin/false"
+			proxy.ConfigFile = fmt.Sprintf("%s/haproxy.cfg", tmpDir)
+
+			err := proxy.WriteAndReload(state)
+			So(err, ShouldBeNil)
+
+			_, err = os.Stat(proxy.ConfigFile)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("Refresh() queues a reload for the watcher", func() {
+			proxy.refreshChannel = make(chan string, 1)
+
+			proxy.Refresh("certificates")
+			So(len(proxy.refreshChannel), ShouldEqual, 1)
+			So(<-proxy.refreshChannel, ShouldEqual, "certificates")
+		})
+
+		Convey("Refresh() doesn't block when a reload is already queued", func() {
+			proxy.refreshChannel = make(chan string, 1)
+
+			proxy.Refresh("certificates")
+			proxy.Refresh("certificates")
+			So(len(proxy.refreshChannel), ShouldEqual, 1)
+		})
+
+		Convey("Refresh() doesn't queue a state change", func() {
+			proxy.eventChannel = make(chan catalog.ChangeEvent, 2)
+			proxy.refreshChannel = make(chan string, 1)
+
+			proxy.Refresh("certificates")
+			So(len(proxy.eventChannel), ShouldEqual, 0)
+		})
+
 		Convey("sanitizeName() fixes crazy image names", func() {
 			image := "public/something-longish:latest"
 			So(sanitizeName(image), ShouldEqual, "public-something-longish-latest")
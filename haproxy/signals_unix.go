@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package haproxy
+
+import (
+	"os"
+	"syscall"
+)
+
+// The job control signals HAproxy's sub-commands send us
+var jobControlSignals = []os.Signal{syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU}
@@ -0,0 +1,8 @@
+package haproxy
+
+import (
+	"os"
+)
+
+// Windows has no job control signals, so there's nothing to swallow
+var jobControlSignals = []os.Signal{}
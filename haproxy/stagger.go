@@ -0,0 +1,72 @@
+package haproxy
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	STAGGER_JITTER      = "jitter"        // Reload at a random point in the window
+	STAGGER_SLOTS       = "slots"         // Take turns, in order of hostname
+	STAGGER_SETTLE_TIME = 1 * time.Second // How long to gather changes before deciding
+)
+
+// A ReloadStagger spreads the HAproxy reloads that follow a large catalog
+// change over a window, so the whole edge tier doesn't drop its long-lived
+// connections at the same moment. Changes smaller than the Threshold are
+// reloaded right away, as usual.
+type ReloadStagger struct {
+	Mode      string
+	Window    time.Duration
+	Threshold int             // How many changes make a large one
+	Hostname  string          // Our name among the Peers
+	Peers     func() []string // The hosts taking turns, learned from gossip
+}
+
+// NewReloadStagger returns a ReloadStagger, or an error if the mode is not
+// one we know about.
+func NewReloadStagger(mode string, window time.Duration, threshold int) (*ReloadStagger, error) {
+	if mode != STAGGER_JITTER && mode != STAGGER_SLOTS {
+		return nil, fmt.Errorf("Unknown reload stagger mode %q! Must be one of %q or %q",
+			mode, STAGGER_JITTER, STAGGER_SLOTS)
+	}
+
+	if window <= 0 {
+		return nil, fmt.Errorf("Reload stagger window must be positive, got %s", window)
+	}
+
+	return &ReloadStagger{Mode: mode, Window: window, Threshold: threshold}, nil
+}
+
+// Delay returns how long to wait before reloading after this many changes.
+// In slots mode every host gets an equal slice of the window, in the order
+// of their sorted hostnames. Without any peers to take turns with, it falls
+// back to jitter.
+func (s *ReloadStagger) Delay(changes int) time.Duration {
+	if changes < s.Threshold {
+		return 0
+	}
+
+	if s.Mode == STAGGER_SLOTS && s.Peers != nil {
+		if slot, slots := s.slot(); slots > 1 {
+			return s.Window * time.Duration(slot) / time.Duration(slots)
+		}
+	}
+
+	return time.Duration(rand.Int63n(int64(s.Window)))
+}
+
+// slot returns our position among the peers, and how many there are
+func (s *ReloadStagger) slot() (int, int) {
+	hosts := []string{s.Hostname}
+	for _, peer := range s.Peers() {
+		if peer != s.Hostname {
+			hosts = append(hosts, peer)
+		}
+	}
+	sort.Strings(hosts)
+
+	return sort.SearchStrings(hosts, s.Hostname), len(hosts)
+}
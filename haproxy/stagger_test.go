@@ -0,0 +1,114 @@
+package haproxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ReloadStagger(t *testing.T) {
+	Convey("ReloadStagger", t, func() {
+		stagger, err := NewReloadStagger(STAGGER_SLOTS, 30*time.Second, 10)
+		So(err, ShouldBeNil)
+		stagger.Hostname = "edge-2"
+
+		Convey("refuses unknown modes and empty windows", func() {
+			_, err := NewReloadStagger("whenever", 30*time.Second, 10)
+			So(err, ShouldNotBeNil)
+
+			_, err = NewReloadStagger(STAGGER_JITTER, 0, 10)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("doesn't delay small changes", func() {
+			stagger.Peers = func() []string { return []string{"edge-1", "edge-3"} }
+			So(stagger.Delay(9), ShouldEqual, 0)
+		})
+
+		Convey("gives each host its own slot in the window", func() {
+			stagger.Peers = func() []string { return []string{"edge-3", "edge-1", "edge-2"} }
+			So(stagger.Delay(10), ShouldEqual, 10*time.Second)
+
+			stagger.Hostname = "edge-1"
+			So(stagger.Delay(10), ShouldEqual, 0)
+
+			stagger.Hostname = "edge-3"
+			So(stagger.Delay(10), ShouldEqual, 20*time.Second)
+		})
+
+		Convey("counts itself when gossip hasn't caught up", func() {
+			stagger.Peers = func() []string { return []string{"edge-1"} }
+			So(stagger.Delay(10), ShouldEqual, 15*time.Second)
+		})
+
+		Convey("falls back to jitter without peers", func() {
+			stagger.Peers = func() []string { return nil }
+			for i := 0; i < 10; i++ {
+				delay := stagger.Delay(10)
+				So(delay, ShouldBeGreaterThanOrEqualTo, 0)
+				So(delay, ShouldBeLessThan, 30*time.Second)
+			}
+		})
+
+		Convey("jitters within the window", func() {
+			stagger.Mode = STAGGER_JITTER
+			stagger.Peers = func() []string { return []string{"edge-1", "edge-3"} }
+			for i := 0; i < 10; i++ {
+				delay := stagger.Delay(10)
+				So(delay, ShouldBeGreaterThanOrEqualTo, 0)
+				So(delay, ShouldBeLessThan, 30*time.Second)
+			}
+		})
+	})
+}
+
+func Test_watchStaggered(t *testing.T) {
+	Convey("A staggered Watch() reloads once for changes arriving together", t, func() {
+		tmpDir, _ := ioutil.TempDir("", "sidecar-test")
+		Reset(func() { os.RemoveAll(tmpDir) })
+		reloads := filepath.Join(tmpDir, "reloads")
+
+		state := catalog.NewServicesState()
+		state.Hostname = hostname1
+
+		proxy := New(filepath.Join(tmpDir, "haproxy.cfg"), "tmpPid")
+		proxy.Template = "../views/haproxy.cfg"
+		proxy.VerifyCmd = "sh -c 'exit 0'"
+		proxy.ReloadCmd = "sh -c 'echo reload >> " + reloads + "'"
+		proxy.Stagger, _ = NewReloadStagger(STAGGER_JITTER, time.Second, 100)
+		proxy.ResetSignals()
+
+		go proxy.Watch(state)
+		for len(state.GetListeners()) < 1 {
+			time.Sleep(1 * time.Millisecond)
+		}
+
+		for i := 0; i < 3; i++ {
+			state.AddServiceEntry(service.Service{
+				ID:       fmt.Sprintf("abcdef12312312%d", i),
+				Name:     "some-svc-befede6789a",
+				Image:    "some-svc",
+				Hostname: hostname2,
+				Updated:  time.Now().UTC(),
+				Ports: []service.Port{
+					{Type: "tcp", Port: int64(1337 + i), ServicePort: 8090, IP: "127.0.0.1"},
+				},
+			})
+		}
+
+		time.Sleep(STAGGER_SETTLE_TIME + 500*time.Millisecond)
+		close(proxy.Chan())
+
+		data, err := ioutil.ReadFile(reloads)
+		So(err, ShouldBeNil)
+		So(strings.Count(string(data), "reload"), ShouldEqual, 1)
+	})
+}
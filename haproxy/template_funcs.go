@@ -0,0 +1,147 @@
+package haproxy
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/Nitro/sidecar/service"
+)
+
+const (
+	DEFAULT_SERVER_WEIGHT = 1 // The weight HAproxy gives servers without one
+)
+
+// templateFuncs returns the library of helpers available to every template,
+// for expressing routing in the template rather than in code. They don't
+// depend on the state lock, unlike the ones set up in WriteConfig().
+func templateFuncs(weights map[*service.Service]int) template.FuncMap {
+	return template.FuncMap{
+		"filterBy":       filterBy,
+		"groupByVersion": groupByVersion,
+		"sortByWeight": func(services []*service.Service) []*service.Service {
+			return sortByWeight(services, weights)
+		},
+		"imageTag": imageTag,
+		"env":      env,
+
+		"lower":     strings.ToLower,
+		"upper":     strings.ToUpper,
+		"join":      strings.Join,
+		"split":     strings.Split,
+		"replace":   strings.Replace,
+		"contains":  strings.Contains,
+		"hasPrefix": strings.HasPrefix,
+		"hasSuffix": strings.HasSuffix,
+	}
+}
+
+// serviceField returns the named field of a service, for filtering
+func serviceField(svc *service.Service, field string) (string, error) {
+	switch field {
+	case "Name":
+		return svc.Name, nil
+	case "Hostname":
+		return svc.Hostname, nil
+	case "Image":
+		return svc.Image, nil
+	case "ImageTag":
+		return imageTag(svc), nil
+	case "Version":
+		return svc.Version(), nil
+	case "Zone":
+		return svc.Zone, nil
+	case "ProxyMode":
+		return svc.ProxyMode, nil
+	default:
+		return "", fmt.Errorf("Can't filter services by unknown field '%s'", field)
+	}
+}
+
+// filterBy returns the services whose field has the given value, e.g.
+// {{ filterBy "Zone" "us-east-1a" $services }}
+func filterBy(field string, value string, services []*service.Service) ([]*service.Service, error) {
+	var result []*service.Service
+	for _, svc := range services {
+		fieldValue, err := serviceField(svc, field)
+		if err != nil {
+			return nil, err
+		}
+
+		if fieldValue == value {
+			result = append(result, svc)
+		}
+	}
+
+	return result, nil
+}
+
+// groupByVersion maps each version to the services running it. Templates
+// range over maps in key order, so the output is stable.
+func groupByVersion(services []*service.Service) map[string][]*service.Service {
+	result := make(map[string][]*service.Service)
+	for _, svc := range services {
+		version := svc.Version()
+		result[version] = append(result[version], svc)
+	}
+
+	return result
+}
+
+// sortByWeight returns a copy of the services ordered by their traffic split
+// weight, heaviest first. Ties are broken by hostname and ID so the config
+// doesn't change from one write to the next.
+func sortByWeight(services []*service.Service, weights map[*service.Service]int) []*service.Service {
+	weightOf := func(svc *service.Service) int {
+		if weight, ok := weights[svc]; ok {
+			return weight
+		}
+		return DEFAULT_SERVER_WEIGHT
+	}
+
+	result := make([]*service.Service, len(services))
+	copy(result, services)
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if weightOf(result[i]) != weightOf(result[j]) {
+			return weightOf(result[i]) > weightOf(result[j])
+		}
+		if result[i].Hostname != result[j].Hostname {
+			return result[i].Hostname < result[j].Hostname
+		}
+		return result[i].ID < result[j].ID
+	})
+
+	return result
+}
+
+// imageTag returns the tag of a service's image, or "latest" if it has none
+func imageTag(svc *service.Service) string {
+	image := svc.Image
+	// Don't mistake a registry port for a tag
+	if slash := strings.LastIndex(image, "/"); slash >= 0 {
+		image = image[slash+1:]
+	}
+
+	if colon := strings.LastIndex(image, ":"); colon >= 0 {
+		return image[colon+1:]
+	}
+
+	return "latest"
+}
+
+// env looks up an environment variable, returning the optional default when
+// it isn't set, e.g. {{ env "HAPROXY_MAXCONN" "4096" }}
+func env(name string, defaults ...string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+
+	if len(defaults) > 0 {
+		return defaults[0]
+	}
+
+	return ""
+}
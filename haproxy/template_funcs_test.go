@@ -0,0 +1,72 @@
+package haproxy
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"text/template"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_templateFuncs(t *testing.T) {
+	Convey("The template functions", t, func() {
+		svc1 := &service.Service{ID: "1", Hostname: hostname1, Image: "awesome:v1", Zone: "east"}
+		svc2 := &service.Service{ID: "2", Hostname: hostname2, Image: "awesome:v2", Zone: "west"}
+		svc3 := &service.Service{ID: "3", Hostname: hostname1, Image: "awesome", SidecarVersion: "v2", Zone: "east"}
+		services := []*service.Service{svc1, svc2, svc3}
+
+		Convey("filterBy() matches services on a field", func() {
+			result, err := filterBy("Zone", "east", services)
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, []*service.Service{svc1, svc3})
+
+			result, _ = filterBy("ImageTag", "v2", services)
+			So(result, ShouldResemble, []*service.Service{svc2})
+
+			_, err = filterBy("Color", "blue", services)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("groupByVersion() groups services by version", func() {
+			groups := groupByVersion(services)
+			So(groups["v1"], ShouldResemble, []*service.Service{svc1})
+			So(groups["v2"], ShouldResemble, []*service.Service{svc2, svc3})
+		})
+
+		Convey("sortByWeight() puts the heaviest first, without changing the input", func() {
+			weights := map[*service.Service]int{svc1: 0, svc2: 200}
+
+			result := sortByWeight(services, weights)
+			So(result, ShouldResemble, []*service.Service{svc2, svc3, svc1})
+			So(services[0], ShouldEqual, svc1)
+		})
+
+		Convey("imageTag() doesn't mistake a registry port for a tag", func() {
+			So(imageTag(&service.Service{Image: "registry:5000/awesome:v1"}), ShouldEqual, "v1")
+			So(imageTag(&service.Service{Image: "registry:5000/awesome"}), ShouldEqual, "latest")
+		})
+
+		Convey("env() falls back to the default", func() {
+			os.Setenv("SIDECAR_TEMPLATE_TEST", "set")
+			Reset(func() { os.Unsetenv("SIDECAR_TEMPLATE_TEST") })
+
+			So(env("SIDECAR_TEMPLATE_TEST", "default"), ShouldEqual, "set")
+			So(env("SIDECAR_TEMPLATE_UNSET", "default"), ShouldEqual, "default")
+			So(env("SIDECAR_TEMPLATE_UNSET"), ShouldBeEmpty)
+		})
+
+		Convey("can be used from a template", func() {
+			tmpl := `{{ range $version, $svcs := groupByVersion . }}{{ $version }}:` +
+				`{{ range filterBy "Zone" "east" $svcs }}{{ .ID }}{{ end }} {{ end }}`
+
+			t, err := template.New("test").Funcs(templateFuncs(nil)).Parse(tmpl)
+			So(err, ShouldBeNil)
+
+			buf := bytes.NewBuffer(nil)
+			So(t.Execute(buf, services), ShouldBeNil)
+			So(buf.String(), ShouldEqual, "v1:1 v2:3 ")
+		})
+	})
+}
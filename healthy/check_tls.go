@@ -0,0 +1,72 @@
+package healthy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/Nitro/sidecar/discovery"
+	"github.com/Nitro/sidecar/service"
+	log "github.com/sirupsen/logrus"
+)
+
+// NewCheckTLSConfig builds the client TLS config for an HTTPS health check.
+// It loads the CA bundle to verify the service against, and the client
+// certificate to present to services that require mTLS.
+func NewCheckTLSConfig(checkTLS *discovery.CheckTLS) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: checkTLS.SkipVerify,
+		ServerName:         checkTLS.ServerName,
+	}
+
+	if checkTLS.CAFile != "" {
+		pem, err := ioutil.ReadFile(checkTLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read CA bundle: %s", err)
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in CA bundle %s", checkTLS.CAFile)
+		}
+	}
+
+	if (checkTLS.CertFile == "") != (checkTLS.KeyFile == "") {
+		return nil, errors.New("Client certificate and key must be set together")
+	}
+
+	if checkTLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(checkTLS.CertFile, checkTLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// checkTLSConfigFor looks up the health check TLS settings for a service, if
+// the discovery mechanism supports them and some are configured. Settings
+// that can't be loaded are logged, and the check runs with the defaults.
+func checkTLSConfigFor(svc *service.Service, disco discovery.Discoverer) *tls.Config {
+	provider, ok := disco.(discovery.CheckTLSProvider)
+	if !ok {
+		return nil
+	}
+
+	checkTLS := provider.HealthCheckTLS(svc)
+	if checkTLS == nil {
+		return nil
+	}
+
+	config, err := NewCheckTLSConfig(checkTLS)
+	if err != nil {
+		log.Errorf("Ignoring health check TLS settings for %s (id: %s): %s", svc.Name, svc.ID, err)
+		return nil
+	}
+
+	return config
+}
@@ -0,0 +1,97 @@
+package healthy
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Nitro/sidecar/discovery"
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type tlsDiscoverer struct {
+	mockDiscoverer
+	checkTLS *discovery.CheckTLS
+}
+
+func (d *tlsDiscoverer) HealthCheckTLS(svc *service.Service) *discovery.CheckTLS {
+	return d.checkTLS
+}
+
+func Test_CheckTLS(t *testing.T) {
+	Convey("HTTPS health checks", t, func() {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("OK"))
+		}))
+		defer server.Close()
+
+		tmpDir, err := ioutil.TempDir("", "check-tls")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+
+		caFile := filepath.Join(tmpDir, "ca.pem")
+		caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		So(ioutil.WriteFile(caFile, caPEM, 0644), ShouldBeNil)
+
+		Convey("fail against an unknown CA by default", func() {
+			status, err := (&HttpGetCmd{}).Run(server.URL)
+			So(err, ShouldNotBeNil)
+			So(status, ShouldEqual, UNKNOWN)
+		})
+
+		Convey("pass when verifying against the service's CA bundle", func() {
+			config, err := NewCheckTLSConfig(&discovery.CheckTLS{CAFile: caFile, ServerName: "example.com"})
+			So(err, ShouldBeNil)
+
+			status, err := (&HttpGetCmd{TLS: config}).Run(server.URL)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+		})
+
+		Convey("pass when skipping verification", func() {
+			config, err := NewCheckTLSConfig(&discovery.CheckTLS{SkipVerify: true})
+			So(err, ShouldBeNil)
+
+			status, err := (&HttpGetCmd{TLS: config}).Run(server.URL)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+		})
+
+		Convey("reject bad settings", func() {
+			_, err := NewCheckTLSConfig(&discovery.CheckTLS{CAFile: filepath.Join(tmpDir, "missing.pem")})
+			So(err, ShouldNotBeNil)
+
+			_, err = NewCheckTLSConfig(&discovery.CheckTLS{CertFile: caFile})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "set together")
+
+			notPEM := filepath.Join(tmpDir, "junk.pem")
+			So(ioutil.WriteFile(notPEM, []byte("junk"), 0644), ShouldBeNil)
+			_, err = NewCheckTLSConfig(&discovery.CheckTLS{CAFile: notPEM})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("get their settings from discovery", func() {
+			monitor := NewMonitor(hostname, "/")
+			svc := service.Service{
+				ID:       "deadbeef123",
+				Name:     "hasCheck",
+				Hostname: hostname,
+				Ports:    []service.Port{{Type: "tcp", Port: 1234, ServicePort: 8081}},
+			}
+
+			disco := &tlsDiscoverer{checkTLS: &discovery.CheckTLS{SkipVerify: true}}
+			check := monitor.CheckForService(&svc, disco)
+			So(check.Command.(*HttpGetCmd).TLS, ShouldNotBeNil)
+			So(check.Command.(*HttpGetCmd).TLS.InsecureSkipVerify, ShouldBeTrue)
+
+			check = monitor.CheckForService(&svc, &mockDiscoverer{})
+			So(check.Command.(*HttpGetCmd).TLS, ShouldBeNil)
+		})
+	})
+}
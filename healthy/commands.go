@@ -4,32 +4,152 @@
 package healthy
 
 import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
 	"net/http"
-	"os/exec"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	DELEGATE_API_PORT = "7777"          // The Sidecar API port on delegate nodes
+	PING_TIMEOUT      = 1 * time.Second // How long to wait for an ICMP echo reply
+	CONNECT_TIMEOUT   = 2 * time.Second // How long to wait for a TCP connection
 )
 
 // A Checker that makes an HTTP get call and expects to get
 // a 200-299 back as success. Anything else is considered
 // a failure. The URL to hit is passed as the args to the
-// Run method.
-type HttpGetCmd struct{}
+// Run method. Connections are kept alive between checks
+// of the same target. TLS, when set, configures HTTPS checks.
+// HTTPS checks offer HTTP/2 with ALPN, and h2c:// URLs speak
+// HTTP/2 without TLS. A request that fails because the
+// connection was closed under us is retried once. Header,
+// when set, is sent with each request.
+type HttpGetCmd struct {
+	TLS    *tls.Config
+	Header http.Header
+
+	// Don't follow redirects, for checks we run on behalf of other nodes,
+	// so that they can't bounce us to an address we wouldn't check
+	NoRedirects bool
+}
 
 func (h *HttpGetCmd) Run(args string) (int, error) {
-	resp, err := http.Get(args)
+	target, err := url.Parse(args)
+	if err != nil {
+		return UNKNOWN, fmt.Errorf("Invalid URL for HTTP check: %s", err)
+	}
+
+	client := &http.Client{
+		Transport: httpTransports.Get(target.Host, h.TLS),
+		Timeout:   HTTP_CHECK_TIMEOUT,
+	}
+
+	if target.Scheme == "h2c" {
+		target.Scheme = "http"
+		client.Transport = httpTransports.GetH2C(target.Host)
+	}
+
+	if h.NoRedirects {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	req, err := http.NewRequest("GET", target.String(), nil)
+	if err != nil {
+		return UNKNOWN, fmt.Errorf("Invalid URL for HTTP check: %s", err)
+	}
+	for name, values := range h.Header {
+		req.Header[name] = values
+	}
+
+	resp, err := client.Do(req)
+	if err != nil && isTransient(err) {
+		log.Debugf("Retrying HTTP check of %s after: %s", args, err)
+		resp, err = client.Do(req)
+	}
+
 	if resp == nil {
+		if err != nil {
+			return UNKNOWN, fmt.Errorf("No body from HTTP response! (%s)", err)
+		}
 		return UNKNOWN, errors.New("No body from HTTP response!")
 	}
 	defer resp.Body.Close()
 
+	// The connection is only reused once the body has been read
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, MAX_BODY_DRAIN))
+
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
 		return HEALTHY, nil
 	}
 
-	return SICKLY, err
+	return SICKLY, Output(resp.Proto + " " + resp.Status)
+}
+
+func (h *HttpGetCmd) Targets(args string) ([]string, bool) {
+	target, err := url.Parse(strings.TrimSpace(args))
+	if err != nil || target.Hostname() == "" {
+		return nil, false
+	}
+
+	port := target.Port()
+	if port == "" {
+		port = "80"
+		if target.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	return []string{net.JoinHostPort(target.Hostname(), port)}, true
+}
+
+// isTransient tells us whether a failed HTTP request is worth trying again
+// straight away: the connection was reset or closed under us, usually because
+// the service dropped a kept-alive connection between checks, or an HTTP/2
+// server sent GOAWAY. Timeouts and refused connections are not.
+func isTransient(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		if urlErr.Timeout() {
+			return false
+		}
+		err = urlErr.Err
+	}
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+
+	if _, ok := err.(http2.GoAwayError); ok {
+		return true
+	}
+
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+
+	return err == syscall.ECONNRESET || err == syscall.EPIPE
 }
 
 // A Checker that works with Nagios checks or other simple
@@ -38,12 +158,12 @@ func (h *HttpGetCmd) Run(args string) (int, error) {
 // The command is passed as the args to the Run method. The
 // command will be executed without a shell wrapper to keep
 // the call as lean as possible in the majority case. If you
-// need a shell you must invoke it yourself.
+// need a shell you must invoke it yourself. On Windows, the
+// command is run by cmd.exe so that paths with spaces work.
 type ExternalCmd struct{}
 
 func (e *ExternalCmd) Run(args string) (int, error) {
-	cliArgs := strings.Split(args, " ")
-	cmd := exec.Command(cliArgs[0], cliArgs[1:]...)
+	cmd := externalCommand(args)
 
 	output, err := cmd.CombinedOutput()
 	if err == nil {
@@ -51,7 +171,7 @@ func (e *ExternalCmd) Run(args string) (int, error) {
 	}
 
 	log.Errorf("Error running command: %s (%s)\n", err.Error(), output)
-	return SICKLY, err
+	return SICKLY, fmt.Errorf("%s: %s", err, strings.TrimSpace(string(output)))
 }
 
 // A Checker that always returns success. Usually used in
@@ -62,3 +182,272 @@ type AlwaysSuccessfulCmd struct{}
 func (a *AlwaysSuccessfulCmd) Run(args string) (int, error) {
 	return HEALTHY, nil
 }
+
+func (a *AlwaysSuccessfulCmd) Targets(args string) ([]string, bool) {
+	return []string{}, true
+}
+
+// A Checker that connects to one or more TCP addresses and expects all of
+// them to accept the connection. It doesn't say anything once connected, so
+// it only tells us that something is listening. The addresses are passed in
+// host:port form as the args to the Run method, separated by spaces.
+type TcpConnectCmd struct{}
+
+func (t *TcpConnectCmd) Run(args string) (int, error) {
+	addrs := strings.Fields(args)
+	if len(addrs) < 1 {
+		return UNKNOWN, errors.New("No address to connect to!")
+	}
+
+	for _, addr := range addrs {
+		conn, err := net.DialTimeout("tcp", addr, CONNECT_TIMEOUT)
+		if err != nil {
+			return SICKLY, err
+		}
+		conn.Close()
+	}
+
+	return HEALTHY, nil
+}
+
+func (t *TcpConnectCmd) Targets(args string) ([]string, bool) {
+	return addrTargets(args)
+}
+
+// A Checker that fails at random, for services made up by simulated
+// discovery. The args are the probability of failing, from 0 to 1.
+type SimulatedCmd struct{}
+
+func (c *SimulatedCmd) Run(args string) (int, error) {
+	rate, err := strconv.ParseFloat(strings.TrimSpace(args), 64)
+	if err != nil {
+		return UNKNOWN, fmt.Errorf("Invalid failure rate for simulated check: '%s'", args)
+	}
+
+	if rand.Float64() < rate {
+		return SICKLY, errors.New("Simulated failure")
+	}
+
+	return HEALTHY, nil
+}
+
+func (c *SimulatedCmd) Targets(args string) ([]string, bool) {
+	return []string{}, true
+}
+
+// A DelegatedCheck is the request sent to another Sidecar node asking it to
+// run a check on our behalf.
+type DelegatedCheck struct {
+	Type string
+	Args string
+}
+
+// A DelegatedResult is the response from a node that ran a DelegatedCheck.
+type DelegatedResult struct {
+	Status int
+	Error  string
+}
+
+// A Targeter is a Checker that can tell which addresses it would connect to
+// with the given args, as host:port, or just the host for Ping checks. It
+// returns false when it can't tell from the args. Only the check types whose
+// Checker is a Targeter can be delegated to us, so that a node asked to run a
+// delegated check can make sure it only reaches services it knows about.
+type Targeter interface {
+	Targets(args string) ([]string, bool)
+}
+
+// CheckTargets returns the addresses a check of the given type would connect
+// to with these args. It returns false for check types that aren't
+// registered or aren't Targeters.
+func CheckTargets(checkType string, args string) ([]string, bool) {
+	checker, ok := NewChecker(checkType)
+	if !ok {
+		return nil, false
+	}
+
+	targeter, ok := checker.(Targeter)
+	if !ok {
+		return nil, false
+	}
+
+	return targeter.Targets(args)
+}
+
+// addrTargets returns the host:port addresses in args, separated by spaces
+func addrTargets(args string) ([]string, bool) {
+	addrs := strings.Fields(args)
+	for _, addr := range addrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, false
+		}
+	}
+
+	return addrs, len(addrs) > 0
+}
+
+// A Checker that asks another Sidecar node to run a check for us. This
+// catches cases where a service answers on the local host but isn't reachable
+// from the rest of the network. The args are the node to delegate to (with an
+// optional API port), the check type to run there, and that check's args:
+//
+//	node2 HttpGet http://{{ host }}:{{ tcp 8080 }}/
+//
+// The result becomes the status of our own check, so it is gossiped to the
+// cluster along with the service as usual.
+type DelegatedCmd struct {
+	Client   *http.Client
+	ApiToken string // Sent to the other node, which needs it to run the check
+}
+
+func (d *DelegatedCmd) Run(args string) (int, error) {
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		return UNKNOWN, fmt.Errorf("Delegated check needs a node and a check type: '%s'", args)
+	}
+
+	node := fields[0]
+	if _, _, err := net.SplitHostPort(node); err != nil {
+		node = net.JoinHostPort(node, DELEGATE_API_PORT)
+	}
+
+	data, err := json.Marshal(&DelegatedCheck{
+		Type: fields[1],
+		Args: strings.Join(fields[2:], " "),
+	})
+	if err != nil {
+		return UNKNOWN, err
+	}
+
+	client := d.Client
+	if client == nil {
+		client = &http.Client{Timeout: HEALTH_INTERVAL - 500*time.Millisecond}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+node+"/api/checks/run", bytes.NewReader(data))
+	if err != nil {
+		return UNKNOWN, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.ApiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+d.ApiToken)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return UNKNOWN, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return UNKNOWN, fmt.Errorf("Delegate %s returned status %d", node, resp.StatusCode)
+	}
+
+	var result DelegatedResult
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return UNKNOWN, fmt.Errorf("Unable to decode result from delegate %s: %s", node, err)
+	}
+
+	if result.Error != "" {
+		return result.Status, errors.New(result.Error)
+	}
+
+	return result.Status, nil
+}
+
+// A Checker that sends an ICMP echo request and expects a reply. This is for
+// hosts like routers, appliances, or VMs where there is no TCP or HTTP
+// endpoint that makes sense to check. The host to ping is passed as the args
+// to the Run method. It uses an unprivileged ICMP socket where the OS allows
+// it, and falls back to a raw socket, which requires privileges.
+type PingCmd struct {
+	Timeout time.Duration
+}
+
+func (p *PingCmd) Targets(args string) ([]string, bool) {
+	return []string{strings.TrimSpace(args)}, true
+}
+
+func (p *PingCmd) Run(args string) (int, error) {
+	host := strings.TrimSpace(args)
+	if host == "" {
+		return UNKNOWN, errors.New("No host to ping!")
+	}
+
+	addr, err := net.ResolveIPAddr("ip4", host)
+	if err != nil {
+		return UNKNOWN, fmt.Errorf("Unable to resolve '%s': %s", host, err)
+	}
+
+	// Unprivileged sockets are addressed as UDP, raw ones as IP
+	var dst net.Addr = &net.UDPAddr{IP: addr.IP}
+	conn, err := icmp.ListenPacket("udp4", "0.0.0.0")
+	if err != nil {
+		dst = addr
+		conn, err = icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+		if err != nil {
+			return UNKNOWN, fmt.Errorf("Unable to open ICMP socket: %s", err)
+		}
+	}
+	defer conn.Close()
+
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = PING_TIMEOUT
+	}
+
+	// The kernel rewrites the ID on unprivileged sockets, so we match
+	// replies on the sequence number and the peer address.
+	seq := int(time.Now().UnixNano() & 0xffff)
+	request := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: seq, Data: []byte("sidecar")},
+	}
+
+	data, err := request.Marshal(nil)
+	if err != nil {
+		return UNKNOWN, err
+	}
+
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return UNKNOWN, err
+	}
+
+	if _, err := conn.WriteTo(data, dst); err != nil {
+		return SICKLY, fmt.Errorf("Unable to ping '%s': %s", host, err)
+	}
+
+	reply := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(reply)
+		if err != nil {
+			return SICKLY, fmt.Errorf("No ping reply from '%s': %s", host, err)
+		}
+
+		// ICMP protocol number for IPv4 is 1
+		msg, err := icmp.ParseMessage(1, reply[:n])
+		if err != nil || msg.Type != ipv4.ICMPTypeEchoReply {
+			continue
+		}
+
+		echo, ok := msg.Body.(*icmp.Echo)
+		if !ok || echo.Seq != seq || !addr.IP.Equal(peerIP(peer)) {
+			continue
+		}
+
+		return HEALTHY, nil
+	}
+}
+
+func peerIP(peer net.Addr) net.IP {
+	switch addr := peer.(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.IPAddr:
+		return addr.IP
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,299 @@
+package healthy
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func Test_HttpGetCmd(t *testing.T) {
+	Convey("HttpGetCmd", t, func() {
+		code := 200
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		}))
+		defer server.Close()
+
+		cmd := &HttpGetCmd{}
+
+		Convey("Is healthy on a 2xx", func() {
+			status, err := cmd.Run(server.URL)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+		})
+
+		Convey("Returns the status line as Output when sickly", func() {
+			code = 503
+			status, err := cmd.Run(server.URL)
+			So(status, ShouldEqual, SICKLY)
+			So(err, ShouldEqual, Output("HTTP/1.1 503 Service Unavailable"))
+		})
+
+		Convey("Retries once when the connection is dropped", func() {
+			var requests int32
+			flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&requests, 1) == 1 {
+					conn, _, _ := w.(http.Hijacker).Hijack()
+					conn.Close()
+				}
+			}))
+			defer flaky.Close()
+
+			status, err := cmd.Run(flaky.URL)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+			So(atomic.LoadInt32(&requests), ShouldEqual, 2)
+		})
+
+		Convey("Speaks HTTP/2 over TLS", func() {
+			var proto string
+			tlsServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				proto = r.Proto
+			}))
+			tlsServer.EnableHTTP2 = true
+			tlsServer.StartTLS()
+			defer tlsServer.Close()
+
+			cmd.TLS = &tls.Config{InsecureSkipVerify: true}
+			status, err := cmd.Run(tlsServer.URL)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+			So(proto, ShouldEqual, "HTTP/2.0")
+		})
+
+		Convey("Speaks HTTP/2 without TLS to h2c:// URLs", func() {
+			var proto string
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				proto = r.Proto
+			})
+			h2cServer := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+			defer h2cServer.Close()
+
+			status, err := cmd.Run(strings.Replace(h2cServer.URL, "http://", "h2c://", 1))
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+			So(proto, ShouldEqual, "HTTP/2.0")
+		})
+	})
+}
+
+func Test_isTransient(t *testing.T) {
+	Convey("isTransient()", t, func() {
+		Convey("is true for dropped connections", func() {
+			So(isTransient(&url.Error{Op: "Get", Err: io.EOF}), ShouldBeTrue)
+			So(isTransient(&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), ShouldBeTrue)
+			So(isTransient(http2.GoAwayError{}), ShouldBeTrue)
+		})
+
+		Convey("is false for refused connections", func() {
+			So(isTransient(&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), ShouldBeFalse)
+		})
+	})
+}
+
+func Test_DelegatedCmd(t *testing.T) {
+	Convey("DelegatedCmd", t, func() {
+		var received DelegatedCheck
+		var authorization string
+		result := DelegatedResult{Status: HEALTHY}
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/checks/run" {
+				w.WriteHeader(404)
+				return
+			}
+			authorization = r.Header.Get("Authorization")
+			_ = json.NewDecoder(r.Body).Decode(&received)
+			_ = json.NewEncoder(w).Encode(&result)
+		}))
+		defer server.Close()
+
+		node := strings.TrimPrefix(server.URL, "http://")
+		cmd := &DelegatedCmd{}
+
+		Convey("Sends the check to the delegate node", func() {
+			status, err := cmd.Run(node + " HttpGet http://10.0.0.1:8080/status")
+
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+			So(received.Type, ShouldEqual, "HttpGet")
+			So(received.Args, ShouldEqual, "http://10.0.0.1:8080/status")
+		})
+
+		Convey("Sends the API token", func() {
+			cmd.ApiToken = "sekrit"
+			_, err := cmd.Run(node + " AlwaysSuccessful")
+
+			So(err, ShouldBeNil)
+			So(authorization, ShouldEqual, "Bearer sekrit")
+		})
+
+		Convey("Returns the delegate's status and error", func() {
+			result = DelegatedResult{Status: SICKLY, Error: "connection refused"}
+			status, err := cmd.Run(node + " HttpGet http://10.0.0.1:8080/status")
+
+			So(status, ShouldEqual, SICKLY)
+			So(err.Error(), ShouldEqual, "connection refused")
+		})
+
+		Convey("Returns UNKNOWN when the args are incomplete", func() {
+			status, err := cmd.Run(node)
+
+			So(status, ShouldEqual, UNKNOWN)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Returns UNKNOWN when the delegate fails", func() {
+			server.Close()
+			status, err := cmd.Run(node + " AlwaysSuccessful")
+
+			So(status, ShouldEqual, UNKNOWN)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func Test_CheckTargets(t *testing.T) {
+	Convey("CheckTargets()", t, func() {
+		Convey("finds the host and port of HTTP checks", func() {
+			targets, ok := CheckTargets("HttpGet", "http://10.0.0.1:8080/status")
+			So(ok, ShouldBeTrue)
+			So(targets, ShouldResemble, []string{"10.0.0.1:8080"})
+
+			targets, _ = CheckTargets("HttpGet", "https://10.0.0.1/status")
+			So(targets, ShouldResemble, []string{"10.0.0.1:443"})
+		})
+
+		Convey("finds every address of TCP checks", func() {
+			targets, ok := CheckTargets("TcpConnect", "10.0.0.1:8080 10.0.0.1:8081")
+			So(ok, ShouldBeTrue)
+			So(targets, ShouldResemble, []string{"10.0.0.1:8080", "10.0.0.1:8081"})
+		})
+
+		Convey("finds the host of Ping checks", func() {
+			targets, _ := CheckTargets("Ping", " 10.0.0.1 ")
+			So(targets, ShouldResemble, []string{"10.0.0.1"})
+		})
+
+		Convey("finds nothing for checks that don't connect anywhere", func() {
+			targets, ok := CheckTargets("AlwaysSuccessful", "")
+			So(ok, ShouldBeTrue)
+			So(targets, ShouldBeEmpty)
+		})
+
+		Convey("gives up on bad args and check types it doesn't know", func() {
+			_, ok := CheckTargets("Redis", "10.0.0.1")
+			So(ok, ShouldBeFalse)
+
+			_, ok = CheckTargets("Telepathy", "10.0.0.1:8080")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func Test_PingCmd(t *testing.T) {
+	Convey("PingCmd", t, func() {
+		cmd := &PingCmd{}
+
+		Convey("Returns UNKNOWN when there is no host", func() {
+			status, err := cmd.Run(" ")
+
+			So(status, ShouldEqual, UNKNOWN)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Returns UNKNOWN when the host doesn't resolve", func() {
+			status, err := cmd.Run("not a host")
+
+			So(status, ShouldEqual, UNKNOWN)
+			So(err, ShouldNotBeNil)
+		})
+
+		// Note that this needs either unprivileged ICMP sockets or root
+		Convey("Pings the loopback address", func() {
+			status, err := cmd.Run("127.0.0.1")
+			if err != nil && strings.Contains(err.Error(), "Unable to open ICMP socket") {
+				return
+			}
+
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+		})
+	})
+}
+
+func Test_TcpConnectCmd(t *testing.T) {
+	Convey("TcpConnectCmd", t, func() {
+		cmd := &TcpConnectCmd{}
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		addr := listener.Addr().String()
+		Reset(func() { listener.Close() })
+
+		Convey("Passes when every address accepts connections", func() {
+			status, err := cmd.Run(addr + " " + addr)
+
+			So(status, ShouldEqual, HEALTHY)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Fails when one of them doesn't", func() {
+			closed, _ := net.Listen("tcp", "127.0.0.1:0")
+			closedAddr := closed.Addr().String()
+			closed.Close()
+
+			status, err := cmd.Run(addr + " " + closedAddr)
+
+			So(status, ShouldEqual, SICKLY)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Returns UNKNOWN without an address", func() {
+			status, err := cmd.Run(" ")
+
+			So(status, ShouldEqual, UNKNOWN)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func Test_SimulatedCmd(t *testing.T) {
+	Convey("SimulatedCmd", t, func() {
+		cmd := &SimulatedCmd{}
+
+		Convey("Always passes at a rate of 0", func() {
+			status, err := cmd.Run("0")
+
+			So(status, ShouldEqual, HEALTHY)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Always fails at a rate of 1", func() {
+			status, err := cmd.Run("1")
+
+			So(status, ShouldEqual, SICKLY)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Returns UNKNOWN for a bad rate", func() {
+			status, err := cmd.Run("often")
+
+			So(status, ShouldEqual, UNKNOWN)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,264 @@
+// These are Checkers that speak just enough of the protocol of some
+// common datastores and brokers to know that they are actually serving requests,
+// rather than merely accepting TCP connections. Each is passed the
+// addresses to check in host:port form as the args to the Run method,
+// separated by spaces, and is only healthy when all of them are.
+package healthy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	DATASTORE_TIMEOUT = 2 * time.Second // Connect and response timeout for datastore checks
+)
+
+// checkDatastores runs the check against each of the addresses in the args,
+// stopping at the first that isn't healthy
+func checkDatastores(args string, check func(conn net.Conn) (int, error)) (int, error) {
+	addrs := strings.Fields(args)
+	if len(addrs) < 1 {
+		return SICKLY, errors.New("No address to check!")
+	}
+
+	for _, addr := range addrs {
+		status, err := checkDatastore(addr, check)
+		if status != HEALTHY {
+			return status, err
+		}
+	}
+
+	return HEALTHY, nil
+}
+
+// checkDatastore connects to one address and runs the check on the
+// connection
+func checkDatastore(addr string, check func(conn net.Conn) (int, error)) (int, error) {
+	conn, err := dialDatastore(addr)
+	if err != nil {
+		return SICKLY, err
+	}
+	defer conn.Close()
+
+	return check(conn)
+}
+
+// dialDatastore connects to the address and sets a deadline for the whole
+// conversation.
+func dialDatastore(addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, DATASTORE_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.SetDeadline(time.Now().Add(DATASTORE_TIMEOUT))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// A Checker that sends a Redis PING and expects a PONG. A server that
+// requires authentication is also considered healthy since it answered.
+type RedisCmd struct{}
+
+func (r *RedisCmd) Targets(args string) ([]string, bool) {
+	return addrTargets(args)
+}
+
+func (r *RedisCmd) Run(args string) (int, error) {
+	return checkDatastores(args, r.check)
+}
+
+func (r *RedisCmd) check(conn net.Conn) (int, error) {
+	_, err := conn.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+	if err != nil {
+		return SICKLY, err
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return SICKLY, err
+	}
+
+	if strings.HasPrefix(line, "+PONG") || strings.HasPrefix(line, "-NOAUTH") {
+		return HEALTHY, nil
+	}
+
+	return SICKLY, fmt.Errorf("Unexpected Redis reply: %s", strings.TrimSpace(line))
+}
+
+// A Checker that sends a PostgreSQL startup message and expects the server
+// to either ask us to authenticate or refuse us for some reason other than
+// that it is starting up, shutting down, or in recovery.
+type PostgresCmd struct{}
+
+func (p *PostgresCmd) Targets(args string) ([]string, bool) {
+	return addrTargets(args)
+}
+
+func (p *PostgresCmd) Run(args string) (int, error) {
+	return checkDatastores(args, p.check)
+}
+
+func (p *PostgresCmd) check(conn net.Conn) (int, error) {
+	params := "user\x00sidecar\x00database\x00postgres\x00\x00"
+	msg := make([]byte, 8, 8+len(params))
+	binary.BigEndian.PutUint32(msg[0:4], uint32(8+len(params)))
+	binary.BigEndian.PutUint32(msg[4:8], 196608) // Protocol version 3.0
+	msg = append(msg, params...)
+
+	_, err := conn.Write(msg)
+	if err != nil {
+		return SICKLY, err
+	}
+
+	header := make([]byte, 5)
+	_, err = io.ReadFull(conn, header)
+	if err != nil {
+		return SICKLY, err
+	}
+
+	switch header[0] {
+	case 'R': // Authentication request
+		return HEALTHY, nil
+	case 'E': // ErrorResponse
+		length := int(binary.BigEndian.Uint32(header[1:5])) - 4
+		if length < 0 || length > 4096 {
+			return SICKLY, errors.New("Invalid PostgreSQL error response")
+		}
+
+		body := make([]byte, length)
+		_, err = io.ReadFull(conn, body)
+		if err != nil {
+			return SICKLY, err
+		}
+
+		// SQLSTATE 57P03 is cannot_connect_now
+		if bytes.Contains(body, []byte("C57P03\x00")) {
+			return SICKLY, errors.New("PostgreSQL is not accepting connections yet")
+		}
+
+		return HEALTHY, nil
+	default:
+		return SICKLY, fmt.Errorf("Unexpected PostgreSQL message type '%c'", header[0])
+	}
+}
+
+// A Checker that reads the initial handshake packet MySQL sends on connect
+// and expects protocol version 10 rather than an error packet.
+type MySQLCmd struct{}
+
+func (m *MySQLCmd) Targets(args string) ([]string, bool) {
+	return addrTargets(args)
+}
+
+func (m *MySQLCmd) Run(args string) (int, error) {
+	return checkDatastores(args, m.check)
+}
+
+func (m *MySQLCmd) check(conn net.Conn) (int, error) {
+	// 3 byte length, 1 byte sequence, then the first byte of the payload
+	header := make([]byte, 5)
+	_, err := io.ReadFull(conn, header)
+	if err != nil {
+		return SICKLY, err
+	}
+
+	switch header[4] {
+	case 0x0a:
+		return HEALTHY, nil
+	case 0xff:
+		return SICKLY, errors.New("MySQL returned an error on connect")
+	default:
+		return SICKLY, fmt.Errorf("Unexpected MySQL protocol version %d", header[4])
+	}
+}
+
+// A Checker that asks Memcached for its version and expects an answer.
+type MemcachedCmd struct{}
+
+func (m *MemcachedCmd) Targets(args string) ([]string, bool) {
+	return addrTargets(args)
+}
+
+func (m *MemcachedCmd) Run(args string) (int, error) {
+	return checkDatastores(args, m.check)
+}
+
+func (m *MemcachedCmd) check(conn net.Conn) (int, error) {
+	_, err := conn.Write([]byte("version\r\n"))
+	if err != nil {
+		return SICKLY, err
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return SICKLY, err
+	}
+
+	if strings.HasPrefix(line, "VERSION ") {
+		return HEALTHY, nil
+	}
+
+	return SICKLY, fmt.Errorf("Unexpected Memcached reply: %s", strings.TrimSpace(line))
+}
+
+// A Checker that sends a Kafka ApiVersions request and expects the broker
+// to answer it without an error, so brokers are only advertised once they
+// actually speak the protocol.
+type KafkaCmd struct{}
+
+func (k *KafkaCmd) Targets(args string) ([]string, bool) {
+	return addrTargets(args)
+}
+
+func (k *KafkaCmd) Run(args string) (int, error) {
+	return checkDatastores(args, k.check)
+}
+
+func (k *KafkaCmd) check(conn net.Conn) (int, error) {
+	clientID := "sidecar"
+	correlationID := uint32(time.Now().UnixNano())
+
+	// ApiVersions (key 18) v0: api_key, api_version, correlation_id, client_id
+	req := make([]byte, 14, 14+len(clientID))
+	binary.BigEndian.PutUint32(req[0:4], uint32(10+len(clientID)))
+	binary.BigEndian.PutUint16(req[4:6], 18)
+	binary.BigEndian.PutUint16(req[6:8], 0)
+	binary.BigEndian.PutUint32(req[8:12], correlationID)
+	binary.BigEndian.PutUint16(req[12:14], uint16(len(clientID)))
+	req = append(req, clientID...)
+
+	_, err := conn.Write(req)
+	if err != nil {
+		return SICKLY, err
+	}
+
+	// Response size, correlation_id, error_code
+	resp := make([]byte, 10)
+	_, err = io.ReadFull(conn, resp)
+	if err != nil {
+		return SICKLY, err
+	}
+
+	if binary.BigEndian.Uint32(resp[4:8]) != correlationID {
+		return SICKLY, errors.New("Kafka response had the wrong correlation ID")
+	}
+
+	if errorCode := int16(binary.BigEndian.Uint16(resp[8:10])); errorCode != 0 {
+		return SICKLY, fmt.Errorf("Kafka ApiVersions returned error code %d", errorCode)
+	}
+
+	return HEALTHY, nil
+}
@@ -0,0 +1,197 @@
+package healthy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeDatastore listens on a local port and answers the first connection
+// by calling the handler. Returns the address to connect to.
+func fakeDatastore(handler func(conn net.Conn)) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handler(conn)
+	}()
+
+	return listener.Addr().String(), func() { listener.Close() }
+}
+
+// replyToLine waits for a line from the client and then sends the reply
+func replyToLine(reply string) func(net.Conn) {
+	return func(conn net.Conn) {
+		_, _ = bufio.NewReader(conn).ReadString('\n')
+		_, _ = conn.Write([]byte(reply))
+	}
+}
+
+func Test_DatastoreChecks(t *testing.T) {
+	Convey("Datastore checks", t, func() {
+		Convey("fail when nothing is listening", func() {
+			addr, stop := fakeDatastore(func(net.Conn) {})
+			stop()
+
+			for _, cmd := range []Checker{&RedisCmd{}, &PostgresCmd{}, &MySQLCmd{}, &MemcachedCmd{}, &KafkaCmd{}} {
+				status, err := cmd.Run(addr)
+				So(status, ShouldEqual, SICKLY)
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("check every address they are given", func() {
+			first, stopFirst := fakeDatastore(replyToLine("+PONG\r\n"))
+			defer stopFirst()
+			second, stopSecond := fakeDatastore(replyToLine("+PONG\r\n"))
+			defer stopSecond()
+			dead, stopDead := fakeDatastore(func(net.Conn) {})
+			stopDead()
+
+			status, err := (&RedisCmd{}).Run(first + " " + second)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+
+			status, err = (&RedisCmd{}).Run(dead + " " + first)
+			So(err, ShouldNotBeNil)
+			So(status, ShouldEqual, SICKLY)
+		})
+
+		Convey("RedisCmd", func() {
+			Convey("is healthy on PONG", func() {
+				addr, stop := fakeDatastore(replyToLine("+PONG\r\n"))
+				defer stop()
+
+				status, err := (&RedisCmd{}).Run(addr)
+				So(err, ShouldBeNil)
+				So(status, ShouldEqual, HEALTHY)
+			})
+
+			Convey("is sickly while loading", func() {
+				addr, stop := fakeDatastore(replyToLine("-LOADING Redis is loading the dataset\r\n"))
+				defer stop()
+
+				status, err := (&RedisCmd{}).Run(addr)
+				So(err, ShouldNotBeNil)
+				So(status, ShouldEqual, SICKLY)
+			})
+		})
+
+		Convey("PostgresCmd", func() {
+			readStartup := func(conn net.Conn) {
+				buf := make([]byte, 512)
+				_, _ = conn.Read(buf)
+			}
+
+			Convey("is healthy when asked to authenticate", func() {
+				addr, stop := fakeDatastore(func(conn net.Conn) {
+					readStartup(conn)
+					_, _ = conn.Write([]byte{'R', 0, 0, 0, 8, 0, 0, 0, 5})
+				})
+				defer stop()
+
+				status, err := (&PostgresCmd{}).Run(addr)
+				So(err, ShouldBeNil)
+				So(status, ShouldEqual, HEALTHY)
+			})
+
+			Convey("is sickly while starting up", func() {
+				body := "SFATAL\x00C57P03\x00Mthe database system is starting up\x00\x00"
+				addr, stop := fakeDatastore(func(conn net.Conn) {
+					readStartup(conn)
+					_, _ = conn.Write(append([]byte{'E', 0, 0, 0, byte(4 + len(body))}, body...))
+				})
+				defer stop()
+
+				status, err := (&PostgresCmd{}).Run(addr)
+				So(err, ShouldNotBeNil)
+				So(status, ShouldEqual, SICKLY)
+			})
+		})
+
+		Convey("MySQLCmd", func() {
+			Convey("is healthy on a version 10 handshake", func() {
+				addr, stop := fakeDatastore(func(conn net.Conn) {
+					_, _ = conn.Write([]byte{0x4a, 0, 0, 0, 0x0a, '8', '.', '0'})
+				})
+				defer stop()
+
+				status, err := (&MySQLCmd{}).Run(addr)
+				So(err, ShouldBeNil)
+				So(status, ShouldEqual, HEALTHY)
+			})
+
+			Convey("is sickly on an error packet", func() {
+				addr, stop := fakeDatastore(func(conn net.Conn) {
+					_, _ = conn.Write([]byte{0x17, 0, 0, 0, 0xff, 0x10, 0x04})
+				})
+				defer stop()
+
+				status, err := (&MySQLCmd{}).Run(addr)
+				So(err, ShouldNotBeNil)
+				So(status, ShouldEqual, SICKLY)
+			})
+		})
+
+		Convey("MemcachedCmd", func() {
+			Convey("is healthy when it returns a version", func() {
+				addr, stop := fakeDatastore(replyToLine("VERSION 1.6.9\r\n"))
+				defer stop()
+
+				status, err := (&MemcachedCmd{}).Run(addr)
+				So(err, ShouldBeNil)
+				So(status, ShouldEqual, HEALTHY)
+			})
+
+			Convey("is sickly on anything else", func() {
+				addr, stop := fakeDatastore(replyToLine("ERROR\r\n"))
+				defer stop()
+
+				status, err := (&MemcachedCmd{}).Run(addr)
+				So(err, ShouldNotBeNil)
+				So(status, ShouldEqual, SICKLY)
+			})
+		})
+
+		Convey("KafkaCmd", func() {
+			// Answer with the request's correlation ID and the error code
+			answer := func(errorCode byte) func(net.Conn) {
+				return func(conn net.Conn) {
+					req := make([]byte, 21)
+					_, _ = io.ReadFull(conn, req)
+					resp := []byte{0, 0, 0, 6}
+					resp = append(resp, req[8:12]...)
+					_, _ = conn.Write(append(resp, 0, errorCode))
+				}
+			}
+
+			Convey("is healthy when ApiVersions succeeds", func() {
+				addr, stop := fakeDatastore(answer(0))
+				defer stop()
+
+				status, err := (&KafkaCmd{}).Run(addr)
+				So(err, ShouldBeNil)
+				So(status, ShouldEqual, HEALTHY)
+			})
+
+			Convey("is sickly when the broker returns an error", func() {
+				addr, stop := fakeDatastore(answer(35))
+				defer stop()
+
+				status, err := (&KafkaCmd{}).Run(addr)
+				So(err, ShouldNotBeNil)
+				So(status, ShouldEqual, SICKLY)
+			})
+		})
+	})
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package healthy
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// externalCommand builds the command for an External check, split on spaces
+func externalCommand(args string) *exec.Cmd {
+	cliArgs := strings.Split(args, " ")
+	return exec.Command(cliArgs[0], cliArgs[1:]...)
+}
@@ -0,0 +1,15 @@
+package healthy
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// externalCommand builds the command for an External check. Windows paths
+// are full of spaces and backslashes, and programs parse their own command
+// lines, so we pass it through cmd.exe untouched rather than splitting it.
+func externalCommand(args string) *exec.Cmd {
+	cmd := exec.Command("cmd")
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: `cmd /S /C "` + args + `"`}
+	return cmd
+}
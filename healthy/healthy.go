@@ -9,6 +9,7 @@ import (
 	"errors"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Nitro/sidecar/service"
 	"github.com/relistan/go-director"
@@ -26,6 +27,7 @@ const (
 	FOREVER         = -1
 	WATCH_INTERVAL  = 500 * time.Millisecond
 	HEALTH_INTERVAL = 3 * time.Second
+	MAX_OUTPUT_LEN  = 256 // Longest check output we store on a service
 )
 
 // The Monitor is responsible for managing and running Checks.
@@ -38,6 +40,12 @@ type Monitor struct {
 	DefaultCheckHost     string
 	DiscoveryFn          func() []service.Service
 	DefaultCheckEndpoint string
+	DefaultCheckPolicy   string // How to check services without a check. See DEFAULT_CHECK_*
+	ProxyAddress         string // Where the local proxy listens, for checks run through it
+	ApiToken             string // Sent with the checks we delegate to other nodes
+
+	// Maintenance windows shared by all the services with a tag, by tag
+	MaintenanceWindows map[string]*MaintenanceWindow
 	sync.RWMutex
 }
 
@@ -71,12 +79,28 @@ type Check struct {
 
 	// The last recorded error on this check
 	LastError error
+
+	// The output from the most recent run of this check, if it failed
+	LastOutput string
+
+	// When the service is scheduled to be down for maintenance: its own
+	// window, and those of its maintenance tags
+	Maintenance []*MaintenanceWindow
 }
 
 type Checker interface {
 	Run(args string) (int, error)
 }
 
+// Output can be returned as the error from a Checker to describe what it saw
+// on a run that failed, without the check being marked UNKNOWN like it would
+// be for any other error.
+type Output string
+
+func (o Output) Error() string {
+	return string(o)
+}
+
 // NewCheck returns a properly configured default Check
 func NewCheck(id string) *Check {
 	check := Check{
@@ -93,7 +117,20 @@ func NewCheck(id string) *Check {
 // UpdateStatus take the status integer and error and applies them to the status
 // of the current Check.
 func (check *Check) UpdateStatus(status int, err error) {
+	check.LastOutput = ""
 	if err != nil {
+		check.LastOutput = err.Error()
+		if len(check.LastOutput) > MAX_OUTPUT_LEN {
+			// Back off to the start of a rune so we don't split a character
+			end := MAX_OUTPUT_LEN
+			for end > 0 && !utf8.RuneStart(check.LastOutput[end]) {
+				end--
+			}
+			check.LastOutput = check.LastOutput[:end]
+		}
+	}
+
+	if _, ok := err.(Output); err != nil && !ok {
 		log.Debugf("Error executing check, status UNKNOWN: (id %s)", check.ID)
 		check.Status = UNKNOWN
 		check.LastError = err
@@ -126,6 +163,17 @@ func (check *Check) ServiceStatus() int {
 	}
 }
 
+// InMaintenance reports whether any of the check's maintenance windows is
+// active at the time given.
+func (check *Check) InMaintenance(now time.Time) bool {
+	for _, window := range check.Maintenance {
+		if window.Active(now) {
+			return true
+		}
+	}
+	return false
+}
+
 // NewMonitor returns a properly configured default configuration of a Monitor.
 func NewMonitor(defaultCheckHost string, defaultCheckEndpoint string) *Monitor {
 	monitor := Monitor{
@@ -154,8 +202,15 @@ func (m *Monitor) MarkService(svc *service.Service) {
 	// this is the best signal we'll get that a check is no longer
 	// needed. Assumes we're only health checking _our own_ services.
 	m.RLock()
-	if _, ok := m.Checks[svc.ID]; ok {
-		svc.Status = m.Checks[svc.ID].ServiceStatus()
+	if check, ok := m.Checks[svc.ID]; ok {
+		svc.Status = check.ServiceStatus()
+		svc.CheckOutput = check.LastOutput
+
+		// Services in a maintenance window are pulled from the proxies
+		// whatever their health, and their failures aren't reported.
+		if check.InMaintenance(time.Now()) {
+			svc.Status = service.MAINTENANCE
+		}
 	} else {
 		svc.Status = service.UNKNOWN
 	}
@@ -2,8 +2,10 @@ package healthy
 
 import (
 	"errors"
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Nitro/sidecar/service"
 	"github.com/relistan/go-director"
@@ -176,6 +178,33 @@ func Test_RunningChecks(t *testing.T) {
 
 			So(check.Status, ShouldEqual, UNKNOWN)
 		})
+
+		Convey("Checks record their output but stay SICKLY when it is Output", func() {
+			check := NewCheck("test")
+			check.MaxCount = 3
+			check.UpdateStatus(SICKLY, Output("HTTP/1.1 503 Service Unavailable"))
+
+			So(check.Status, ShouldEqual, SICKLY)
+			So(check.LastOutput, ShouldEqual, "HTTP/1.1 503 Service Unavailable")
+
+			check.UpdateStatus(HEALTHY, nil)
+			So(check.LastOutput, ShouldEqual, "")
+		})
+
+		Convey("Checks truncate long output", func() {
+			check := NewCheck("test")
+			check.UpdateStatus(SICKLY, errors.New(strings.Repeat("x", MAX_OUTPUT_LEN+10)))
+
+			So(len(check.LastOutput), ShouldEqual, MAX_OUTPUT_LEN)
+		})
+
+		Convey("Checks don't split characters when truncating output", func() {
+			check := NewCheck("test")
+			check.UpdateStatus(SICKLY, errors.New("x"+strings.Repeat("é", MAX_OUTPUT_LEN)))
+
+			So(utf8.ValidString(check.LastOutput), ShouldBeTrue)
+			So(len(check.LastOutput), ShouldEqual, MAX_OUTPUT_LEN-1)
+		})
 	})
 }
 
@@ -256,5 +285,21 @@ func Test_MarkingServices(t *testing.T) {
 		Convey("Transitions services to healthy when they are", func() {
 			So(svcList[4].Status, ShouldEqual, service.ALIVE)
 		})
+
+		Convey("Marks services in a maintenance window as MAINTENANCE", func() {
+			window, _ := ParseMaintenanceWindow("* * * * * 1h")
+			monitor.Checks["bad"].Maintenance = []*MaintenanceWindow{window}
+			svcList = monitor.Services()
+
+			So(svcList[1].Status, ShouldEqual, service.MAINTENANCE)
+		})
+
+		Convey("Copies the check output onto the service", func() {
+			monitor.Checks["bad"].LastOutput = "HTTP/1.1 500 Internal Server Error"
+			svcList = monitor.Services()
+
+			So(svcList[1].CheckOutput, ShouldEqual, "HTTP/1.1 500 Internal Server Error")
+			So(svcList[0].CheckOutput, ShouldEqual, "")
+		})
 	})
 }
@@ -0,0 +1,244 @@
+package healthy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MAX_MAINTENANCE_DURATION = 7 * 24 * time.Hour // Longest window we'll accept
+)
+
+// A MaintenanceWindow is a recurring period during which a service is
+// expected to be unavailable. It is described with a cron-like schedule for
+// the start of the window, followed by how long the window lasts:
+//
+//	30 2 * * Sun 2h
+//
+// That is 02:30 every Sunday, for two hours. The schedule fields are minute,
+// hour, day of month, month, and day of week, and each supports '*', lists,
+// ranges, and steps as in cron, where a step from a single value, like 5/15,
+// runs to the end of the range. As in cron, when both the day of month and
+// the day of week are restricted, a day matching either will do. Times are
+// in the host's local time zone.
+type MaintenanceWindow struct {
+	Spec     string
+	Duration time.Duration
+
+	minutes   map[int]bool
+	hours     map[int]bool
+	days      map[int]bool
+	months    map[int]bool
+	weekdays  map[int]bool
+	eitherDay bool // Both days and weekdays are restricted
+}
+
+var weekdayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// ParseMaintenanceWindow parses a window spec as described on the
+// MaintenanceWindow type.
+func ParseMaintenanceWindow(spec string) (*MaintenanceWindow, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("Maintenance window needs 5 schedule fields and a duration: '%s'", spec)
+	}
+
+	window := &MaintenanceWindow{Spec: spec}
+
+	var err error
+	window.Duration, err = time.ParseDuration(fields[5])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid maintenance window duration '%s': %s", fields[5], err)
+	}
+	if window.Duration <= 0 || window.Duration > MAX_MAINTENANCE_DURATION {
+		return nil, fmt.Errorf("Maintenance window duration must be between 0 and %s", MAX_MAINTENANCE_DURATION)
+	}
+
+	if window.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if window.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if window.days, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if window.months, err = parseCronField(fields[3], 1, 12, nil); err != nil {
+		return nil, err
+	}
+	if window.weekdays, err = parseCronField(fields[4], 0, 7, weekdayNames); err != nil {
+		return nil, err
+	}
+
+	// Both 0 and 7 mean Sunday
+	if window.weekdays[7] {
+		window.weekdays[0] = true
+	}
+
+	window.eitherDay = !strings.HasPrefix(fields[2], "*") && !strings.HasPrefix(fields[4], "*")
+
+	return window, nil
+}
+
+// ParseMaintenanceWindows parses windows shared by every service carrying a
+// tag, as a semicolon separated list of tag=spec pairs:
+//
+//	databases=30 2 * * Sun 2h;batch=0 4 * * * 30m
+func ParseMaintenanceWindows(config string) (map[string]*MaintenanceWindow, error) {
+	windows := make(map[string]*MaintenanceWindow)
+
+	for _, entry := range strings.Split(config, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		tag := strings.TrimSpace(parts[0])
+		if len(parts) < 2 || tag == "" {
+			return nil, fmt.Errorf("Maintenance windows must be given as tag=spec: '%s'", entry)
+		}
+
+		if _, ok := windows[tag]; ok {
+			return nil, fmt.Errorf("Maintenance window for tag '%s' given twice", tag)
+		}
+
+		window, err := ParseMaintenanceWindow(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("Tag '%s': %s", tag, err)
+		}
+		windows[tag] = window
+	}
+
+	return windows, nil
+}
+
+// parseCronField expands one field of the schedule into the set of values
+// it matches.
+func parseCronField(field string, min int, max int, names map[string]int) (map[int]bool, error) {
+	values := make(map[int]bool)
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		stepped := false
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[idx+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("Invalid step in maintenance window field '%s'", field)
+			}
+			part = part[:idx]
+			stepped = true
+		}
+
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+
+			var err error
+			low, err = cronValue(bounds[0], names)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid maintenance window field '%s'", field)
+			}
+
+			high = low
+			if stepped {
+				high = max
+			}
+			if len(bounds) == 2 {
+				high, err = cronValue(bounds[1], names)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid maintenance window field '%s'", field)
+				}
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return nil, fmt.Errorf("Maintenance window field '%s' out of range %d-%d", field, min, max)
+		}
+
+		for i := low; i <= high; i += step {
+			values[i] = true
+		}
+	}
+
+	return values, nil
+}
+
+func cronValue(value string, names map[string]int) (int, error) {
+	if named, ok := names[strings.ToLower(value)]; ok {
+		return named, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// startsOn reports whether a window can start on the day containing t.
+func (w *MaintenanceWindow) startsOn(t time.Time) bool {
+	if !w.months[int(t.Month())] {
+		return false
+	}
+
+	if w.eitherDay {
+		return w.days[t.Day()] || w.weekdays[int(t.Weekday())]
+	}
+
+	return w.days[t.Day()] && w.weekdays[int(t.Weekday())]
+}
+
+// latest returns the highest of the values that is no more than max.
+func latest(values map[int]bool, max int) (int, bool) {
+	for i := max; i >= 0; i-- {
+		if values[i] {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// lastStart returns the most recent start of the window at or before t. It
+// only looks back over as many days as the window can last.
+func (w *MaintenanceWindow) lastStart(t time.Time) (time.Time, bool) {
+	lookback := int(w.Duration/(24*time.Hour)) + 1
+
+	for i := 0; i <= lookback; i++ {
+		day := time.Date(t.Year(), t.Month(), t.Day()-i, 0, 0, 0, 0, t.Location())
+		if !w.startsOn(day) {
+			continue
+		}
+
+		// Today we can only go back from the current time
+		maxHour := 23
+		if i == 0 {
+			maxHour = t.Hour()
+		}
+
+		for hour, ok := latest(w.hours, maxHour); ok; hour, ok = latest(w.hours, hour-1) {
+			maxMinute := 59
+			if i == 0 && hour == t.Hour() {
+				maxMinute = t.Minute()
+			}
+
+			if minute, found := latest(w.minutes, maxMinute); found {
+				return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, t.Location()), true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// Active reports whether the time given falls within the window, which is
+// the case when its most recent start was less than its duration ago.
+func (w *MaintenanceWindow) Active(now time.Time) bool {
+	if w == nil {
+		return false
+	}
+
+	now = now.Truncate(time.Minute)
+	start, ok := w.lastStart(now)
+
+	return ok && now.Sub(start) < w.Duration
+}
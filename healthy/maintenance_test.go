@@ -0,0 +1,123 @@
+package healthy
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_MaintenanceWindow(t *testing.T) {
+	Convey("MaintenanceWindow", t, func() {
+		// Sunday
+		sunday := time.Date(2019, 6, 2, 2, 30, 0, 0, time.Local)
+
+		Convey("Parses a valid spec", func() {
+			window, err := ParseMaintenanceWindow("30 2 * * Sun 2h")
+			So(err, ShouldBeNil)
+			So(window.Duration, ShouldEqual, 2*time.Hour)
+		})
+
+		Convey("Parses the windows for each tag", func() {
+			windows, err := ParseMaintenanceWindows("databases=30 2 * * Sun 2h; batch=0 4 * * Mon,Fri 30m")
+			So(err, ShouldBeNil)
+			So(windows, ShouldHaveLength, 2)
+			So(windows["databases"].Spec, ShouldEqual, "30 2 * * Sun 2h")
+			So(windows["batch"].Duration, ShouldEqual, 30*time.Minute)
+
+			windows, err = ParseMaintenanceWindows("")
+			So(err, ShouldBeNil)
+			So(windows, ShouldBeEmpty)
+
+			for _, config := range []string{
+				"30 2 * * Sun 2h",
+				"=30 2 * * Sun 2h",
+				"databases=30 2 * * Sun",
+				"databases=30 2 * * Sun 2h;databases=0 4 * * * 1h",
+			} {
+				_, err := ParseMaintenanceWindows(config)
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("Rejects bad specs", func() {
+			for _, spec := range []string{
+				"30 2 * * Sun",
+				"30 2 * * Sun forever",
+				"61 2 * * * 1h",
+				"30 2 * * Funday 1h",
+				"*/0 * * * * 1h",
+				"30 2 * * * 0s",
+			} {
+				_, err := ParseMaintenanceWindow(spec)
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("Is active for the duration after it starts", func() {
+			window, _ := ParseMaintenanceWindow("30 2 * * Sun 2h")
+
+			So(window.Active(sunday.Add(-1*time.Minute)), ShouldBeFalse)
+			So(window.Active(sunday), ShouldBeTrue)
+			So(window.Active(sunday.Add(119*time.Minute)), ShouldBeTrue)
+			So(window.Active(sunday.Add(2*time.Hour)), ShouldBeFalse)
+			So(window.Active(sunday.Add(24*time.Hour)), ShouldBeFalse)
+		})
+
+		Convey("Handles lists, ranges, and steps", func() {
+			window, err := ParseMaintenanceWindow("0 */6 * 5-6 Mon,Sun 30m")
+			So(err, ShouldBeNil)
+
+			So(window.Active(time.Date(2019, 6, 2, 12, 10, 0, 0, time.Local)), ShouldBeTrue)
+			So(window.Active(time.Date(2019, 6, 2, 13, 10, 0, 0, time.Local)), ShouldBeFalse)
+			So(window.Active(time.Date(2019, 6, 4, 12, 10, 0, 0, time.Local)), ShouldBeFalse)
+			So(window.Active(time.Date(2019, 7, 7, 12, 10, 0, 0, time.Local)), ShouldBeFalse)
+		})
+
+		Convey("Steps from a single value to the end of the range", func() {
+			window, err := ParseMaintenanceWindow("5/20 3 * * * 10m")
+			So(err, ShouldBeNil)
+
+			So(window.Active(time.Date(2019, 6, 2, 3, 5, 0, 0, time.Local)), ShouldBeTrue)
+			So(window.Active(time.Date(2019, 6, 2, 3, 25, 0, 0, time.Local)), ShouldBeTrue)
+			So(window.Active(time.Date(2019, 6, 2, 3, 45, 0, 0, time.Local)), ShouldBeTrue)
+			So(window.Active(time.Date(2019, 6, 2, 3, 15, 0, 0, time.Local)), ShouldBeFalse)
+		})
+
+		Convey("Starts on either day when both the day of month and week are given", func() {
+			window, err := ParseMaintenanceWindow("0 12 1-7 * Mon 30m")
+			So(err, ShouldBeNil)
+
+			// The 1st to the 7th, and every Monday
+			So(window.Active(time.Date(2019, 6, 2, 12, 10, 0, 0, time.Local)), ShouldBeTrue)
+			So(window.Active(time.Date(2019, 6, 10, 12, 10, 0, 0, time.Local)), ShouldBeTrue)
+			So(window.Active(time.Date(2019, 6, 9, 12, 10, 0, 0, time.Local)), ShouldBeFalse)
+
+			// Only one restricted means both must match
+			window, _ = ParseMaintenanceWindow("0 12 */2 * Mon 30m")
+			So(window.Active(time.Date(2019, 6, 3, 12, 10, 0, 0, time.Local)), ShouldBeTrue)
+			So(window.Active(time.Date(2019, 6, 10, 12, 10, 0, 0, time.Local)), ShouldBeFalse)
+		})
+
+		Convey("Accepts 7 as Sunday", func() {
+			window, _ := ParseMaintenanceWindow("30 2 * * 7 1h")
+			So(window.Active(sunday), ShouldBeTrue)
+		})
+
+		Convey("Stays active across midnight and for windows of several days", func() {
+			window, _ := ParseMaintenanceWindow("30 23 * * Sat 4h")
+			So(window.Active(sunday), ShouldBeTrue)
+			So(window.Active(sunday.Add(time.Hour)), ShouldBeFalse)
+
+			window, _ = ParseMaintenanceWindow("0 18 * * Fri 60h")
+			So(window.Active(sunday), ShouldBeTrue)
+			So(window.Active(sunday.Add(27*time.Hour)), ShouldBeTrue)
+			So(window.Active(sunday.Add(28*time.Hour)), ShouldBeFalse)
+		})
+
+		Convey("A nil window is never active", func() {
+			var window *MaintenanceWindow
+			So(window.Active(sunday), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,107 @@
+package healthy
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Nitro/sidecar/discovery"
+)
+
+// A CheckFactory returns a new Checker for each check of its type
+type CheckFactory func() Checker
+
+var (
+	checkTypes     = make(map[string]CheckFactory)
+	checkTypesLock sync.RWMutex
+)
+
+func init() {
+	builtIn := []struct {
+		name      string
+		factory   CheckFactory
+		needsArgs bool
+	}{
+		{"HttpGet", func() Checker { return &HttpGetCmd{} }, true},
+		{"External", func() Checker { return &ExternalCmd{} }, true},
+		{"AlwaysSuccessful", func() Checker { return &AlwaysSuccessfulCmd{} }, false},
+		{"Delegated", func() Checker { return &DelegatedCmd{} }, true},
+		{"Ping", func() Checker { return &PingCmd{} }, true},
+		{"Simulated", func() Checker { return &SimulatedCmd{} }, false},
+		{"Redis", func() Checker { return &RedisCmd{} }, true},
+		{"Postgres", func() Checker { return &PostgresCmd{} }, true},
+		{"MySQL", func() Checker { return &MySQLCmd{} }, true},
+		{"Memcached", func() Checker { return &MemcachedCmd{} }, true},
+		{"Kafka", func() Checker { return &KafkaCmd{} }, true},
+		{"TcpConnect", func() Checker { return &TcpConnectCmd{} }, true},
+	}
+
+	for _, checkType := range builtIn {
+		err := registerCheckType(checkType.name, checkType.factory, checkType.needsArgs)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterCheckType adds a check type that services can then ask for in their
+// HealthCheck label, without changing the Monitor. It's meant to be called
+// from an init() function, or at least before discovery and the Monitor are
+// started. Names must be unique, so built in types can't be replaced. We
+// can't tell whether the type needs any arguments, so label validation
+// doesn't complain when it has none. Checks of the new type can only be
+// delegated to us when its Checker is a Targeter.
+func RegisterCheckType(name string, factory CheckFactory) error {
+	return registerCheckType(name, factory, false)
+}
+
+func registerCheckType(name string, factory CheckFactory, needsArgs bool) error {
+	if name == "" {
+		return errors.New("Can't register a check type without a name")
+	}
+
+	if factory == nil {
+		return fmt.Errorf("Can't register check type %s without a factory", name)
+	}
+
+	checkTypesLock.Lock()
+	defer checkTypesLock.Unlock()
+
+	if _, ok := checkTypes[name]; ok {
+		return fmt.Errorf("Check type %s is already registered", name)
+	}
+
+	checkTypes[name] = factory
+	discovery.AddHealthCheckType(name, needsArgs)
+
+	return nil
+}
+
+// CheckTypes returns the names of all the check types we can run, sorted
+func CheckTypes() []string {
+	checkTypesLock.RLock()
+	defer checkTypesLock.RUnlock()
+
+	names := make([]string, 0, len(checkTypes))
+	for name := range checkTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// NewChecker returns a new Checker of the named type, and false if there is
+// no such type.
+func NewChecker(name string) (Checker, bool) {
+	checkTypesLock.RLock()
+	factory, ok := checkTypes[name]
+	checkTypesLock.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	return factory(), true
+}
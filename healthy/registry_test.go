@@ -0,0 +1,63 @@
+package healthy
+
+import (
+	"testing"
+
+	"github.com/Nitro/sidecar/discovery"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type gopherCmd struct{}
+
+func (g *gopherCmd) Run(args string) (int, error) {
+	return HEALTHY, nil
+}
+
+func Test_RegisterCheckType(t *testing.T) {
+	Convey("RegisterCheckType()", t, func() {
+		factory := func() Checker { return &gopherCmd{} }
+
+		Convey("adds a check type the monitor can run", func() {
+			So(RegisterCheckType("Gopher", factory), ShouldBeNil)
+
+			monitor := NewMonitor("localhost", "/")
+			So(monitor.GetCommandNamed("Gopher"), ShouldResemble, &gopherCmd{})
+			So(CheckTypes(), ShouldContain, "Gopher")
+			So(discovery.HealthCheckTypes, ShouldContain, "Gopher")
+
+			Convey("and won't register it twice", func() {
+				So(RegisterCheckType("Gopher", factory), ShouldNotBeNil)
+			})
+		})
+
+		Convey("won't replace a built in check type", func() {
+			So(RegisterCheckType("HttpGet", factory), ShouldNotBeNil)
+			checker, _ := NewChecker("HttpGet")
+			So(checker, ShouldResemble, &HttpGetCmd{})
+		})
+
+		Convey("lists every check type for label validation", func() {
+			So(discovery.HealthCheckTypes, ShouldResemble, CheckTypes())
+		})
+
+		Convey("only lets check types that are Targeters be delegated", func() {
+			_, ok := CheckTargets("Gopher", "10.0.0.1:8080")
+			So(ok, ShouldBeFalse)
+
+			So(RegisterCheckType("Mole", func() Checker { return &TcpConnectCmd{} }), ShouldBeNil)
+			targets, ok := CheckTargets("Mole", "10.0.0.1:8080")
+			So(ok, ShouldBeTrue)
+			So(targets, ShouldResemble, []string{"10.0.0.1:8080"})
+		})
+
+		Convey("needs a name and a factory", func() {
+			So(RegisterCheckType("", factory), ShouldNotBeNil)
+			So(RegisterCheckType("Badger", nil), ShouldNotBeNil)
+		})
+	})
+
+	Convey("NewChecker() returns false for unknown check types", t, func() {
+		_, ok := NewChecker("Awesome-sauce")
+		So(ok, ShouldBeFalse)
+	})
+}
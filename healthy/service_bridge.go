@@ -3,6 +3,7 @@ package healthy
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"text/template"
 
 	"github.com/Nitro/sidecar/discovery"
@@ -13,6 +14,9 @@ import (
 
 const (
 	DEFAULT_STATUS_ENDPOINT = "/"
+	DEFAULT_CHECK_HTTP      = "http" // HTTP GET on the first TCP port
+	DEFAULT_CHECK_TCP       = "tcp"  // Connect to every TCP port
+	DEFAULT_CHECK_AUTO      = "auto" // HTTP for services proxied as HTTP, TCP for the rest
 )
 
 func (m *Monitor) Services() []service.Service {
@@ -45,14 +49,21 @@ func findFirstTCPPort(svc *service.Service) *service.Port {
 	return nil
 }
 
-// Configure a default check for a service. The default is to return an HTTP
-// check on the first TCP port on the endpoint set in DEFAULT_STATUS_ENDPOINT.
+// Configure a default check for a service, for when discovery has none. By
+// default it is an HTTP check on the first TCP port, on the endpoint set in
+// DEFAULT_STATUS_ENDPOINT. The DefaultCheckPolicy can ask for TCP connections
+// to every TCP port instead, for services that don't speak HTTP.
 func (m *Monitor) defaultCheckForService(svc *service.Service) *Check {
 	port := findFirstTCPPort(svc)
 	if port == nil {
 		return &Check{ID: svc.ID, Command: &AlwaysSuccessfulCmd{}}
 	}
 
+	if m.DefaultCheckPolicy == DEFAULT_CHECK_TCP ||
+		(m.DefaultCheckPolicy == DEFAULT_CHECK_AUTO && svc.ProxyMode != "http") {
+		return m.tcpCheckForService(svc)
+	}
+
 	// Use the const default unless we've been provided something else
 	defaultCheckEndpoint := DEFAULT_STATUS_ENDPOINT
 	if len(m.DefaultCheckEndpoint) != 0 {
@@ -69,17 +80,48 @@ func (m *Monitor) defaultCheckForService(svc *service.Service) *Check {
 	}
 }
 
+// tcpCheckForService returns a check that connects to every TCP port
+func (m *Monitor) tcpCheckForService(svc *service.Service) *Check {
+	var addrs []string
+	for _, port := range svc.Ports {
+		if port.Type == "tcp" {
+			addrs = append(addrs, fmt.Sprintf("%v:%v", m.DefaultCheckHost, port.Port))
+		}
+	}
+
+	return &Check{
+		ID:      svc.ID,
+		Type:    "TcpConnect",
+		Args:    strings.Join(addrs, " "),
+		Status:  FAILED,
+		Command: &TcpConnectCmd{},
+	}
+}
+
+// SetDefaultCheckPolicy sets how services without a check of their own
+// are checked. It returns an error for policies we don't know about.
+func (m *Monitor) SetDefaultCheckPolicy(policy string) error {
+	switch policy {
+	case DEFAULT_CHECK_HTTP, DEFAULT_CHECK_TCP, DEFAULT_CHECK_AUTO:
+		m.DefaultCheckPolicy = policy
+		return nil
+	}
+
+	return fmt.Errorf("Unknown default check policy %q! Must be one of %q, %q, or %q",
+		policy, DEFAULT_CHECK_HTTP, DEFAULT_CHECK_TCP, DEFAULT_CHECK_AUTO)
+}
+
+// GetCommandNamed returns a Checker for the named check type, falling back
+// to HttpGet for types that haven't been registered.
 func (m *Monitor) GetCommandNamed(name string) Checker {
-	switch name {
-	case "HttpGet":
-		return &HttpGetCmd{}
-	case "External":
-		return &ExternalCmd{}
-	case "AlwaysSuccessful":
-		return &AlwaysSuccessfulCmd{}
-	default:
-		return &HttpGetCmd{}
+	if checker, ok := NewChecker(name); ok {
+		if delegated, ok := checker.(*DelegatedCmd); ok {
+			delegated.ApiToken = m.ApiToken
+		}
+		return checker
 	}
+
+	return &HttpGetCmd{}
 }
 
 // Talks to a Discoverer and returns the configured check
@@ -136,10 +178,50 @@ func (m *Monitor) CheckForService(svc *service.Service, disco discovery.Discover
 	}
 
 	check.Args = m.templateCheckArgs(check, svc)
+	check.Maintenance = m.maintenanceWindowsFor(svc, disco)
+
+	if httpCmd, ok := check.Command.(*HttpGetCmd); ok {
+		httpCmd.TLS = checkTLSConfigFor(svc, disco)
+	}
+
+	if svc.ProxyChecked {
+		m.routeThroughProxy(check, svc)
+	}
 
 	return check
 }
 
+// maintenanceWindowsFor looks up the maintenance windows for a service, if
+// the discovery mechanism supports them: its own window if it has one, and
+// the shared windows for each of its maintenance tags.
+func (m *Monitor) maintenanceWindowsFor(svc *service.Service, disco discovery.Discoverer) []*MaintenanceWindow {
+	var windows []*MaintenanceWindow
+
+	if scheduler, ok := disco.(discovery.MaintenanceScheduler); ok {
+		if spec := scheduler.MaintenanceWindow(svc); spec != "" {
+			window, err := ParseMaintenanceWindow(spec)
+			if err != nil {
+				log.Warnf("Ignoring maintenance window for %s (id: %s): %s", svc.Name, svc.ID, err)
+			} else {
+				windows = append(windows, window)
+			}
+		}
+	}
+
+	if tagger, ok := disco.(discovery.MaintenanceTagger); ok {
+		for _, tag := range tagger.MaintenanceTags(svc) {
+			window, ok := m.MaintenanceWindows[tag]
+			if !ok {
+				log.Warnf("No maintenance window for tag '%s' of %s (id: %s)", tag, svc.Name, svc.ID)
+				continue
+			}
+			windows = append(windows, window)
+		}
+	}
+
+	return windows
+}
+
 // Watch loops over a list of services and adds checks for services we don't already
 // know about. It then removes any checks for services which have gone away. All
 // services are expected to be local to this node.
@@ -205,6 +205,73 @@ func Test_CheckForService(t *testing.T) {
 			check := monitor.CheckForService(&service1, &mockDiscoverer{})
 			So(check.Args, ShouldEqual, "http://indefatigable:1234/something/else")
 		})
+
+		Convey("Connects to every TCP port with the tcp policy", func() {
+			monitor := NewMonitor(hostname, "/")
+			So(monitor.SetDefaultCheckPolicy(DEFAULT_CHECK_TCP), ShouldBeNil)
+			service1.Ports = append(service1.Ports, service.Port{Type: "tcp", Port: 1235})
+
+			check := monitor.CheckForService(&service1, &mockDiscoverer{})
+			So(check.Type, ShouldEqual, "TcpConnect")
+			So(check.Args, ShouldEqual, "indefatigable:1234 indefatigable:1235")
+			So(check.Command, ShouldHaveSameTypeAs, &TcpConnectCmd{})
+		})
+
+		Convey("Picks by proxy mode with the auto policy", func() {
+			monitor := NewMonitor(hostname, "/")
+			So(monitor.SetDefaultCheckPolicy(DEFAULT_CHECK_AUTO), ShouldBeNil)
+
+			service1.ProxyMode = "http"
+			check := monitor.CheckForService(&service1, &mockDiscoverer{})
+			So(check.Type, ShouldEqual, "HttpGet")
+
+			service1.ProxyMode = "tcp"
+			check = monitor.CheckForService(&service1, &mockDiscoverer{})
+			So(check.Type, ShouldEqual, "TcpConnect")
+			So(check.Args, ShouldEqual, "indefatigable:1234")
+		})
+
+		Convey("Refuses unknown policies", func() {
+			monitor := NewMonitor(hostname, "/")
+			So(monitor.SetDefaultCheckPolicy("hope"), ShouldNotBeNil)
+			So(monitor.DefaultCheckPolicy, ShouldBeEmpty)
+		})
+	})
+}
+
+func Test_maintenanceWindowsFor(t *testing.T) {
+	Convey("maintenanceWindowsFor()", t, func() {
+		svc := service.Service{ID: "deadbeef123", Name: "bocaccio"}
+		monitor := NewMonitor(hostname, "/")
+		monitor.MaintenanceWindows, _ = ParseMaintenanceWindows("databases=0 3 * * * 1h;batch=0 4 * * * 1h")
+
+		target := &discovery.Target{Service: svc}
+		disco := &discovery.StaticDiscovery{Targets: []*discovery.Target{target}}
+
+		Convey("returns nothing for services without windows", func() {
+			So(monitor.maintenanceWindowsFor(&svc, disco), ShouldBeEmpty)
+			So(monitor.maintenanceWindowsFor(&svc, &mockDiscoverer{}), ShouldBeEmpty)
+		})
+
+		Convey("returns the service's own window and those of its tags", func() {
+			target.MaintenanceWindow = "0 2 * * * 1h"
+			target.MaintenanceTags = []string{"databases", "batch"}
+
+			windows := monitor.maintenanceWindowsFor(&svc, disco)
+			So(windows, ShouldHaveLength, 3)
+			So(windows[0].Spec, ShouldEqual, "0 2 * * * 1h")
+			So(windows[1], ShouldEqual, monitor.MaintenanceWindows["databases"])
+			So(windows[2], ShouldEqual, monitor.MaintenanceWindows["batch"])
+		})
+
+		Convey("skips bad windows and unknown tags", func() {
+			target.MaintenanceWindow = "whenever"
+			target.MaintenanceTags = []string{"nightly", "batch"}
+
+			windows := monitor.maintenanceWindowsFor(&svc, disco)
+			So(windows, ShouldHaveLength, 1)
+			So(windows[0], ShouldEqual, monitor.MaintenanceWindows["batch"])
+		})
 	})
 }
 
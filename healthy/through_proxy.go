@@ -0,0 +1,126 @@
+package healthy
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Nitro/sidecar/service"
+	log "github.com/sirupsen/logrus"
+)
+
+// routeThroughProxy points a check at the local proxy's frontend for the
+// service, instead of at the instance itself, so that it covers the proxy
+// config on the way. HTTP checks carry the instance ID in a header so the
+// proxy sends them to this instance and not another. TCP checks can't, so
+// they only show that the proxy is listening for the service. Checks that
+// can't go through the proxy are left as they were.
+func (m *Monitor) routeThroughProxy(check *Check, svc *service.Service) {
+	if m.ProxyAddress == "" {
+		log.Warnf("Service %s (id: %s) wants to be checked through the proxy, but there is no proxy here",
+			svc.Name, svc.ID)
+		return
+	}
+
+	var args string
+	var err error
+
+	switch cmd := check.Command.(type) {
+	case *HttpGetCmd:
+		var host string
+		args, host, err = m.proxyURL(check.Args, svc)
+		if err == nil {
+			cmd.Header = http.Header{service.PROXY_CHECK_HEADER: {svc.ID}}
+			cmd.TLS = withServerName(cmd.TLS, host)
+		}
+	case *TcpConnectCmd:
+		args, err = m.proxyAddrs(check.Args, svc)
+	default:
+		err = fmt.Errorf("%s checks can't be run through the proxy", check.Type)
+	}
+
+	if err != nil {
+		log.Warnf("Checking service %s (id: %s) directly: %s", svc.Name, svc.ID, err)
+		return
+	}
+
+	check.Args = args
+}
+
+// proxyURL rewrites an HTTP check URL to go through the proxy, and returns
+// the host it was for.
+func (m *Monitor) proxyURL(args string, svc *service.Service) (string, string, error) {
+	target, err := url.Parse(strings.TrimSpace(args))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid URL for HTTP check: %s", err)
+	}
+
+	if target.Port() == "" {
+		return "", "", errors.New("the check URL has no port to find the proxy frontend by")
+	}
+
+	host := target.Hostname()
+	target.Host, err = m.proxyFrontend(target.Host, svc)
+	if err != nil {
+		return "", "", err
+	}
+
+	return target.String(), host, nil
+}
+
+// proxyAddrs rewrites the addresses for a TCP check to go through the proxy
+func (m *Monitor) proxyAddrs(args string, svc *service.Service) (string, error) {
+	var addrs []string
+	for _, addr := range strings.Fields(args) {
+		frontend, err := m.proxyFrontend(addr, svc)
+		if err != nil {
+			return "", err
+		}
+		addrs = append(addrs, frontend)
+	}
+
+	return strings.Join(addrs, " "), nil
+}
+
+// proxyFrontend returns the address of the proxy frontend that serves the
+// port in a host:port address to the rest of the cluster
+func (m *Monitor) proxyFrontend(hostPort string, svc *service.Service) (string, error) {
+	_, portStr, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return "", err
+	}
+
+	port, err := strconv.ParseInt(portStr, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid port %q", portStr)
+	}
+
+	for _, svcPort := range svc.Ports {
+		if svcPort.Type == "tcp" && svcPort.Port == port && svcPort.ServicePort > 0 {
+			return net.JoinHostPort(m.ProxyAddress, strconv.FormatInt(svcPort.ServicePort, 10)), nil
+		}
+	}
+
+	return "", fmt.Errorf("port %d has no ServicePort for the proxy to serve it on", port)
+}
+
+// withServerName makes sure an HTTPS check through the proxy still verifies
+// the certificate for the host it was meant for, not the proxy's address
+func withServerName(config *tls.Config, host string) *tls.Config {
+	if config == nil {
+		return &tls.Config{ServerName: host}
+	}
+
+	if config.ServerName != "" {
+		return config
+	}
+
+	config = config.Clone()
+	config.ServerName = host
+	return config
+}
@@ -0,0 +1,87 @@
+package healthy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ThroughProxy(t *testing.T) {
+	Convey("Checks run through the proxy", t, func() {
+		monitor := NewMonitor("127.0.0.1", "/")
+		monitor.ProxyAddress = "192.168.168.168"
+
+		svc := service.Service{
+			ID:           "deadbeef123",
+			Name:         "hasCheck",
+			ProxyChecked: true,
+			Ports:        []service.Port{{Type: "tcp", Port: 32001, ServicePort: 8081}},
+		}
+
+		Convey("go to the proxy frontend for the service, with the instance ID", func() {
+			check := monitor.CheckForService(&svc, &mockDiscoverer{})
+
+			So(check.Args, ShouldEqual, "http://192.168.168.168:8081/status/check")
+			cmd := check.Command.(*HttpGetCmd)
+			So(cmd.Header.Get(service.PROXY_CHECK_HEADER), ShouldEqual, "deadbeef123")
+			So(cmd.TLS.ServerName, ShouldEqual, "127.0.0.1")
+		})
+
+		Convey("go directly to the instance without a proxy", func() {
+			monitor.ProxyAddress = ""
+			check := monitor.CheckForService(&svc, &mockDiscoverer{})
+
+			So(check.Args, ShouldEqual, "http://127.0.0.1:32001/status/check")
+			So(check.Command.(*HttpGetCmd).Header, ShouldBeNil)
+		})
+
+		Convey("go directly to the instance when the port isn't proxied", func() {
+			svc.Ports[0].ServicePort = 0
+			check := &Check{Type: "TcpConnect", Command: &TcpConnectCmd{}, Args: "127.0.0.1:32001"}
+			monitor.routeThroughProxy(check, &svc)
+
+			So(check.Args, ShouldEqual, "127.0.0.1:32001")
+		})
+
+		Convey("connect to the proxy for TCP checks", func() {
+			check := &Check{Type: "TcpConnect", Command: &TcpConnectCmd{}, Args: "127.0.0.1:32001"}
+			monitor.routeThroughProxy(check, &svc)
+
+			So(check.Args, ShouldEqual, "192.168.168.168:8081")
+		})
+
+		Convey("leave other kinds of checks alone", func() {
+			check := &Check{Type: "Ping", Command: &PingCmd{}, Args: "127.0.0.1"}
+			monitor.routeThroughProxy(check, &svc)
+
+			So(check.Args, ShouldEqual, "127.0.0.1")
+		})
+
+		Convey("send the header the proxy routes by", func() {
+			var received string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = r.Header.Get(service.PROXY_CHECK_HEADER)
+			}))
+			defer server.Close()
+
+			serverURL, _ := url.Parse(server.URL)
+			host, port, _ := net.SplitHostPort(serverURL.Host)
+			monitor.ProxyAddress = host
+			svc.Ports[0].ServicePort, _ = strconv.ParseInt(port, 10, 64)
+
+			check := &Check{Type: "HttpGet", Command: &HttpGetCmd{}, Args: "http://127.0.0.1:32001/"}
+			monitor.routeThroughProxy(check, &svc)
+
+			status, err := check.Command.Run(check.Args)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+			So(received, ShouldEqual, "deadbeef123")
+		})
+	})
+}
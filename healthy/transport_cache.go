@@ -0,0 +1,151 @@
+package healthy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+)
+
+const (
+	HTTP_CHECK_TIMEOUT     = HEALTH_INTERVAL // Give up on an HTTP check after this long
+	HTTP_IDLE_CONN_TIMEOUT = 1 * time.Minute // Close kept-alive connections idle this long
+	TRANSPORT_MAX_UNUSED   = 5 * time.Minute // Drop transports for targets we stopped checking
+	TRANSPORT_PRUNE_EVERY  = 1 * time.Minute // How often we look for those
+	MAX_BODY_DRAIN         = 64 * 1024       // Most of a response body we'll read to reuse a connection
+)
+
+// httpTransports is shared by all the HttpGet checks on this host
+var httpTransports = newTransportCache()
+
+// A transportCache keeps one keep-alive http.Transport per check target, so
+// that probing the same endpoint every few seconds reuses its connection
+// instead of opening a new one and leaving the old one in TIME_WAIT.
+type transportCache struct {
+	transports map[string]*cachedTransport
+	lastPrune  time.Time
+	sync.Mutex
+}
+
+// A checkTransport is an http.Transport, or an http2.Transport for h2c
+type checkTransport interface {
+	http.RoundTripper
+	CloseIdleConnections()
+}
+
+type cachedTransport struct {
+	transport checkTransport
+	lastUsed  time.Time
+}
+
+func newTransportCache() *transportCache {
+	return &transportCache{
+		transports: make(map[string]*cachedTransport),
+		lastPrune:  time.Now(),
+	}
+}
+
+// Get returns the transport for a target host:port, creating it the first
+// time we see the target. Checks with their own TLS settings get their own
+// transport for the target.
+func (c *transportCache) Get(target string, tlsConfig *tls.Config) http.RoundTripper {
+	key := target
+	if tlsConfig != nil {
+		key = fmt.Sprintf("%s/%p", target, tlsConfig)
+	}
+
+	return c.get(key, func() checkTransport { return newCheckTransport(tlsConfig) })
+}
+
+// GetH2C returns the transport for a target host:port that speaks HTTP/2
+// without TLS.
+func (c *transportCache) GetH2C(target string) http.RoundTripper {
+	return c.get("h2c://"+target, newH2CTransport)
+}
+
+func (c *transportCache) get(key string, newFn func() checkTransport) http.RoundTripper {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastPrune) > TRANSPORT_PRUNE_EVERY {
+		c.prune(now)
+	}
+
+	cached, ok := c.transports[key]
+	if !ok {
+		cached = &cachedTransport{transport: newFn()}
+		c.transports[key] = cached
+	}
+	cached.lastUsed = now
+
+	return cached.transport
+}
+
+// Len returns how many targets we're holding transports for
+func (c *transportCache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return len(c.transports)
+}
+
+// prune closes and drops the transports of targets that haven't been checked
+// in a while, usually because the service went away. Not synchronized!
+func (c *transportCache) prune(now time.Time) {
+	for target, cached := range c.transports {
+		if now.Sub(cached.lastUsed) > TRANSPORT_MAX_UNUSED {
+			cached.transport.CloseIdleConnections()
+			delete(c.transports, target)
+		}
+	}
+	c.lastPrune = now
+}
+
+// newCheckTransport returns a transport that keeps a single connection open
+// to its target between checks. A nil tlsConfig uses the defaults. Over TLS
+// it offers HTTP/2 with ALPN, falling back to HTTP/1.1, since some services
+// turn away HTTP/1.1 clients.
+func newCheckTransport(tlsConfig *tls.Config) checkTransport {
+	// ConfigureTransport() adds to the NextProtos of the config, which the
+	// check may share
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   HTTP_CHECK_TIMEOUT,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          1,
+		MaxIdleConnsPerHost:   1,
+		IdleConnTimeout:       HTTP_IDLE_CONN_TIMEOUT,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   HTTP_CHECK_TIMEOUT,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	if err := http2.ConfigureTransport(transport); err != nil {
+		log.Warnf("Unable to enable HTTP/2 for health checks, using HTTP/1.1: %s", err)
+	}
+
+	return transport
+}
+
+// newH2CTransport returns a transport that speaks HTTP/2 over plain TCP with
+// prior knowledge, for h2c:// checks.
+func newH2CTransport() checkTransport {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network string, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.DialTimeout(network, addr, HTTP_CHECK_TIMEOUT)
+		},
+	}
+}
@@ -0,0 +1,61 @@
+package healthy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_transportCache(t *testing.T) {
+	Convey("transportCache", t, func() {
+		cache := newTransportCache()
+
+		Convey("returns the same transport for the same target", func() {
+			So(cache.Get("10.0.0.1:8080", nil), ShouldEqual, cache.Get("10.0.0.1:8080", nil))
+			So(cache.Len(), ShouldEqual, 1)
+		})
+
+		Convey("returns a different transport for each target", func() {
+			So(cache.Get("10.0.0.1:8080", nil), ShouldNotEqual, cache.Get("10.0.0.2:8080", nil))
+			So(cache.Len(), ShouldEqual, 2)
+		})
+
+		Convey("drops the transports of targets we stopped checking", func() {
+			cache.Get("10.0.0.1:8080", nil)
+			cache.transports["10.0.0.1:8080"].lastUsed = time.Now().Add(-2 * TRANSPORT_MAX_UNUSED)
+			cache.lastPrune = time.Now().Add(-2 * TRANSPORT_PRUNE_EVERY)
+
+			cache.Get("10.0.0.2:8080", nil)
+			So(cache.Len(), ShouldEqual, 1)
+			So(cache.transports, ShouldContainKey, "10.0.0.2:8080")
+		})
+	})
+
+	Convey("HttpGetCmd reuses its connection between checks", t, func() {
+		var connections int32
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("OK"))
+		}))
+		server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&connections, 1)
+			}
+		}
+		server.Start()
+		defer server.Close()
+
+		cmd := &HttpGetCmd{}
+		for i := 0; i < 3; i++ {
+			status, err := cmd.Run(server.URL)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+		}
+
+		So(atomic.LoadInt32(&connections), ShouldEqual, 1)
+	})
+}
@@ -2,7 +2,8 @@ package main // import "github.com/Nitro/sidecar"
 
 import (
 	"context"
-	"net"
+	"encoding/json"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime/pprof"
@@ -10,6 +11,8 @@ import (
 
 	"github.com/Nitro/memberlist"
 	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/certs"
+	"github.com/Nitro/sidecar/cluster"
 	"github.com/Nitro/sidecar/config"
 	"github.com/Nitro/sidecar/discovery"
 	"github.com/Nitro/sidecar/envoy"
@@ -17,18 +20,27 @@ import (
 	"github.com/Nitro/sidecar/healthy"
 	"github.com/Nitro/sidecar/service"
 	"github.com/Nitro/sidecar/sidecarhttp"
+	"github.com/Nitro/sidecar/snapshot"
 	"github.com/armon/go-metrics"
 	"github.com/relistan/go-director"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/relistan/rubberneck.v1"
 )
 
+const (
+	RoleServer = "server" // Holds the full catalog, serves the API, drives the proxy
+	RoleAgent  = "agent"  // Only discovers and health checks, forwards to servers
+	RoleProxy  = "proxy"  // Only consumes the catalog to drive the proxy
+)
+
 func announceMembers(list *memberlist.Memberlist, state *catalog.ServicesState) {
 	for {
-		// Ask for members of the cluster
-		for _, member := range list.Members() {
-			log.Debugf("Member: %s %s", member.Name, member.Addr)
-			log.Debugf("Meta: %s", string(member.Meta))
+		// Ask for members of the cluster, if we're part of one
+		if list != nil {
+			for _, member := range list.Members() {
+				log.Debugf("Member: %s %s", member.Name, member.Addr)
+				log.Debugf("Meta: %s", string(member.Meta))
+			}
 		}
 
 		state.RLock()
@@ -45,6 +57,9 @@ func configureOverrides(config *config.Config, opts *CliOpts) {
 	if len(*opts.AdvertiseIP) > 0 {
 		config.Sidecar.AdvertiseIP = *opts.AdvertiseIP
 	}
+	if len(*opts.AdvertiseHostname) > 0 {
+		config.Sidecar.AdvertiseHostname = *opts.AdvertiseHostname
+	}
 	if len(*opts.ClusterIPs) > 0 {
 		config.Sidecar.Seeds = *opts.ClusterIPs
 	}
@@ -57,6 +72,9 @@ func configureOverrides(config *config.Config, opts *CliOpts) {
 	if len(*opts.LoggingLevel) > 0 {
 		config.Sidecar.LoggingLevel = *opts.LoggingLevel
 	}
+	if *opts.DryRun {
+		config.Sidecar.DryRun = true
+	}
 }
 
 func configureHAproxy(config *config.Config) *haproxy.HAproxy {
@@ -87,10 +105,102 @@ func configureHAproxy(config *config.Config) *haproxy.HAproxy {
 	}
 
 	proxy.UseHostnames = config.HAproxy.UseHostnames
+	proxy.DryRun = config.Sidecar.DryRun
+	proxy.TLSBindIP = config.HAproxy.TLSBindIP
+	proxy.Zone = config.Sidecar.Zone
+	proxy.Namespace = config.Sidecar.Namespace
+
+	if config.Acme.Enable {
+		proxy.CertDir = config.Acme.CertDir
+		proxy.AcmeChallenges = true
+	}
 
 	return proxy
 }
 
+// configureStagger sets up staggered HAproxy reloads, when they are enabled.
+// Hosts in slots mode take turns with the cluster members that announce
+// they do the same in their node metadata.
+func configureStagger(config *config.Config, state *catalog.ServicesState, list *memberlist.Memberlist) *haproxy.ReloadStagger {
+	if config.HAproxy.StaggerMode == "" {
+		return nil
+	}
+
+	stagger, err := haproxy.NewReloadStagger(
+		config.HAproxy.StaggerMode, config.HAproxy.StaggerWindow, config.HAproxy.StaggerThreshold,
+	)
+	exitWithError(err, "Can't stagger HAproxy reloads")
+
+	stagger.Hostname = state.Hostname
+	if list != nil {
+		stagger.Peers = func() []string {
+			var peers []string
+			for _, member := range list.Members() {
+				var meta cluster.NodeMetadata
+				if json.Unmarshal(member.Meta, &meta) == nil && meta.StaggersReloads {
+					peers = append(peers, member.Name)
+				}
+			}
+			return peers
+		}
+	}
+
+	return stagger
+}
+
+// configureAcme makes sure just one Sidecar requests certificates. With more
+// than one issuer, they race each other for the same hostnames and run into
+// the provider's rate limits. The other edge nodes read the certificates and
+// the HTTP-01 challenge tokens from the issuer's ACME_CACHE_DIR, which they
+// must share.
+func configureAcme(config *config.Config, hostname string) {
+	if !config.Acme.Enable {
+		return
+	}
+
+	if config.Acme.Issuer == "" {
+		log.Fatal("ACME_ENABLE requires ACME_ISSUER, the hostname of the one Sidecar that requests certificates")
+	}
+
+	if config.Acme.Issuer != hostname {
+		log.Infof("TLS certificates are requested by %s, reading them from %s", config.Acme.Issuer, config.Acme.CacheDir)
+		return
+	}
+
+	if config.Sidecar.Role == RoleAgent || config.HAproxy.Disable {
+		log.Fatal("ACME_ISSUER must be a Sidecar that runs HAproxy")
+	}
+}
+
+// configureCertManager sets up ACME certificates for the TLS hostnames in
+// the catalog, and has the proxy watcher pick them up as they are written.
+func configureCertManager(config *config.Config, state *catalog.ServicesState, proxy *haproxy.HAproxy) *certs.Manager {
+	manager := certs.NewManager(
+		state, config.Acme.CacheDir, config.Acme.CertDir,
+		config.Acme.Email, config.Acme.DirectoryURL,
+	)
+	manager.Issuer = config.Acme.Issuer == state.Hostname
+
+	manager.OnChange = func() {
+		proxy.Refresh("certificates")
+	}
+
+	return manager
+}
+
+// configureSnapshotter sets up catalog snapshots to S3. Credentials come
+// from the standard AWS environment variables.
+func configureSnapshotter(config *config.Config, state *catalog.ServicesState) *snapshot.Snapshotter {
+	store := snapshot.NewS3Store(
+		config.Snapshot.S3Endpoint, config.Snapshot.S3Region,
+		config.Snapshot.S3Bucket, config.Snapshot.S3Key,
+		os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		os.Getenv("AWS_SESSION_TOKEN"),
+	)
+
+	return snapshot.NewSnapshotter(state, store)
+}
+
 func configureDiscovery(config *config.Config, publishedIP string) discovery.Discoverer {
 	disco := new(discovery.MultiDiscovery)
 
@@ -113,6 +223,10 @@ func configureDiscovery(config *config.Config, publishedIP string) discovery.Dis
 		svcNamer = &discovery.DockerLabelNamer{
 			Label: config.Services.NameLabel,
 		}
+	case "network_alias":
+		svcNamer = &discovery.NetworkAliasNamer{
+			Network: config.Services.AliasNetwork,
+		}
 	case "regex":
 		svcNamer, err = discovery.NewRegexpNamer(config.Services.NameMatch)
 		if err != nil {
@@ -127,15 +241,51 @@ func configureDiscovery(config *config.Config, publishedIP string) discovery.Dis
 	for _, method := range config.Sidecar.Discovery {
 		switch method {
 		case "docker":
-			disco.Discoverers = append(
-				disco.Discoverers,
-				discovery.NewDockerDiscovery(config.DockerDiscovery.DockerURL, svcNamer, publishedIP),
+			dockerURL := dockerEndpoint(config.DockerDiscovery.DockerURL)
+			dockerDisco := discovery.NewDockerDiscovery(dockerURL, svcNamer, publishedIP)
+			dockerDisco.AdvertiseNetworks = config.DockerDiscovery.AdvertiseNetworks
+			dockerDisco.UseEnvConfig = config.DockerDiscovery.UseEnvConfig
+			dockerDisco.Hostname = config.Sidecar.AdvertiseHostname
+			dockerDisco.ExcludeInfra = config.DockerDiscovery.ExcludeInfra
+			exitWithError(
+				discovery.ValidateImagePatterns(config.DockerDiscovery.ExcludeImages),
+				"Invalid DOCKER_EXCLUDE_IMAGES pattern",
 			)
+			dockerDisco.ExcludeImages = config.DockerDiscovery.ExcludeImages
+			dockerDisco.APIVersion = config.DockerDiscovery.APIVersion
+			if id := ownContainerID(); id != "" {
+				log.Infof("Running in container %s, excluding it from discovery", id[:12])
+				dockerDisco.SelfID = id
+			}
+			disco.Discoverers = append(disco.Discoverers, dockerDisco)
 		case "static":
-			disco.Discoverers = append(
-				disco.Discoverers,
-				discovery.NewStaticDiscovery(config.StaticDiscovery.ConfigFile, publishedIP),
-			)
+			staticDisco := discovery.NewStaticDiscovery(config.StaticDiscovery.ConfigFile, publishedIP)
+			if config.Sidecar.AdvertiseHostname != "" {
+				staticDisco.Hostname = config.Sidecar.AdvertiseHostname
+			}
+			disco.Discoverers = append(disco.Discoverers, staticDisco)
+		case "external":
+			if config.External.Source == "" {
+				log.Fatal("Can't use external discovery without EXTERNAL_SOURCE")
+			}
+			externalDisco := discovery.NewExternalDiscovery(config.External.Source, publishedIP)
+			externalDisco.RefreshInterval = config.External.RefreshInterval
+			if config.Sidecar.AdvertiseHostname != "" {
+				externalDisco.Hostname = config.Sidecar.AdvertiseHostname
+			}
+			disco.Discoverers = append(disco.Discoverers, externalDisco)
+		case "simulated":
+			hostname := config.Sidecar.AdvertiseHostname
+			if hostname == "" {
+				hostname, _ = os.Hostname()
+			}
+			simDisco := discovery.NewSimulatedDiscovery(hostname, publishedIP)
+			simDisco.ServiceCount = config.Simulation.Services
+			simDisco.HostCount = config.Simulation.Hosts
+			simDisco.ServiceChurn = config.Simulation.ServiceChurn
+			simDisco.HostChurn = config.Simulation.HostChurn
+			simDisco.FlapRate = config.Simulation.FlapRate
+			disco.Discoverers = append(disco.Discoverers, simDisco)
 		default:
 		}
 	}
@@ -155,19 +305,6 @@ func configureMetrics(config *config.Config) {
 	}
 }
 
-// configureDelegate sets up the Memberlist delegate we'll use
-func configureDelegate(state *catalog.ServicesState, config *config.Config) *servicesDelegate {
-	delegate := NewServicesDelegate(state)
-	delegate.Metadata = NodeMetadata{
-		ClusterName: config.Sidecar.ClusterName,
-		State:       "Running",
-	}
-
-	delegate.Start()
-
-	return delegate
-}
-
 // configureCpuProfiler sets of the CPU profiler and a signal handler to
 // stop it if we have been told to run the CPU profiler.
 func configureCpuProfiler(opts *CliOpts) {
@@ -226,39 +363,39 @@ func configureLoggingFormat(config *config.Config) {
 	}
 }
 
-func configureMemberlist(config *config.Config, state *catalog.ServicesState) *memberlist.Config {
-	delegate := configureDelegate(state, config)
-
-	// Use a LAN config but add our delegate
-	mlConfig := memberlist.DefaultLANConfig()
-	mlConfig.Delegate = delegate
-	mlConfig.Events = delegate
-
-	// Set some memberlist settings
-	mlConfig.LogOutput = &LoggingBridge{} // Use logrus as backend for Memberlist
-	mlConfig.PreferTCPDNS = false
+// configureMemberlist sets up gossip for the state, announcing what the
+// other nodes need to know about us.
+func configureMemberlist(config *config.Config, state *catalog.ServicesState, publishedIP string) (*memberlist.Config, *cluster.Delegate) {
+	return cluster.NewMemberlistConfig(state, cluster.Config{
+		ClusterName:      config.Sidecar.ClusterName,
+		BindPort:         config.Sidecar.BindPort,
+		AdvertiseAddr:    publishedIP,
+		PushPullInterval: config.Sidecar.PushPullInterval,
+		GossipMessages:   config.Sidecar.GossipMessages,
+		Metadata: cluster.NodeMetadata{
+			ClusterName: config.Sidecar.ClusterName,
+			State:       "Running",
+			DisplayName: config.Sidecar.DisplayName,
+			Metadata:    config.Sidecar.HostMetadata,
+
+			StaggersReloads: !config.HAproxy.Disable && config.HAproxy.StaggerMode == haproxy.STAGGER_SLOTS,
+			ReportsOutages:  len(config.Webhooks.OutageUrls) > 0,
+		},
+	})
+}
 
-	// Set up the push pull interval for Memberlist
-	if config.Sidecar.PushPullInterval == 0 {
-		mlConfig.PushPullInterval = catalog.ALIVE_LIFESPAN - 1*time.Second
-	} else {
-		mlConfig.PushPullInterval = config.Sidecar.PushPullInterval
-	}
-	if config.Sidecar.GossipMessages != 0 {
-		mlConfig.GossipMessages = config.Sidecar.GossipMessages
+// configureForwarder sets up the forwarder that sends our services on to the
+// Sidecar servers when we're running in the agent role.
+func configureForwarder(config *config.Config, state *catalog.ServicesState) *serversForwarder {
+	if len(config.Sidecar.Servers) < 1 {
+		log.Fatal("Running in the agent role, but no SIDECAR_SERVERS configured!")
 	}
 
-	// Make sure we pass on the cluster name to Memberlist
-	mlConfig.ClusterName = config.Sidecar.ClusterName
-
-	// Figure out our IP address from the CLI or by inspecting the network interfaces
-	publishedIP, err := getPublishedIP(config.Sidecar.ExcludeIPs, config.Sidecar.AdvertiseIP)
-	exitWithError(err, "Failed to find private IP address")
-	mlConfig.BindPort = config.Sidecar.BindPort
-	mlConfig.AdvertiseAddr = publishedIP
-	mlConfig.AdvertisePort = config.Sidecar.BindPort
+	if config.Sidecar.ApiToken == "" {
+		log.Fatal("Running in the agent role, but no SIDECAR_API_TOKEN configured for the servers!")
+	}
 
-	return mlConfig
+	return NewServersForwarder(state, config.Sidecar.Servers, string(config.Sidecar.ApiToken))
 }
 
 // configureListeners sets up any statically configured state change event listeners.
@@ -267,38 +404,194 @@ func configureListeners(config *config.Config, state *catalog.ServicesState) {
 		listener := catalog.NewUrlListener(url, false)
 		listener.Watch(state)
 	}
+
+	for _, url := range config.Webhooks.HealthUrls {
+		webhook := catalog.NewHealthWebhook(url)
+		webhook.LocalOnly = config.Webhooks.LocalOnly
+		webhook.Watch(state)
+	}
+
+	for _, url := range config.Webhooks.OutageUrls {
+		catalog.NewOutageWebhook(url).Watch(state)
+	}
 }
 
 func main() {
 	config := config.ParseConfig()
 	opts := parseCommandLine()
+	if opts.Command == "events" {
+		err := runEvents(*opts.EventsAddr, *opts.EventsFollow, *opts.EventsJson, *opts.EventsSince)
+		exitWithError(err, "Failed to get events")
+		return
+	}
+
 	configureOverrides(config, opts)
 	configureCpuProfiler(opts)
 	configureLoggingLevel(config)
 	configureLoggingFormat(config)
 	configureMetrics(config)
 
+	isAgent := config.Sidecar.Role == RoleAgent
+	isProxy := config.Sidecar.Role == RoleProxy
+	if !isAgent && !isProxy && config.Sidecar.Role != RoleServer {
+		log.Fatalf("Unknown role %q! Must be one of %q, %q, or %q",
+			config.Sidecar.Role, RoleServer, RoleAgent, RoleProxy)
+	}
+
+	// Proxy hosts run no services of their own
+	if isProxy && len(config.Sidecar.Discovery) > 0 {
+		log.Infof("Running in the proxy role, disabling discovery")
+		config.Sidecar.Discovery = nil
+	}
+
 	// Create a new state instance and fire up the processor. We need
 	// this to happen early in the startup.
 	state := catalog.NewServicesState()
+	if config.Sidecar.AdvertiseHostname != "" {
+		state.Hostname = config.Sidecar.AdvertiseHostname
+	}
+	configureAcme(config, state.Hostname)
 	svcMsgLooper := director.NewFreeLooper(
 		director.FOREVER, make(chan error),
 	)
 	go state.ProcessServiceMsgs(svcMsgLooper)
 
-	configureListeners(config, state)
-
-	mlConfig := configureMemberlist(config, state)
+	// Figure out our IP address from the CLI, the cloud instance metadata, or
+	// by inspecting the network interfaces
+	var err error
+	advertiseIP := config.Sidecar.AdvertiseIP
+	if advertiseIP == "" && config.Sidecar.AdvertiseInterface == "" && config.Sidecar.AdvertiseCloud != "" {
+		advertiseIP, err = newMetadataClient().PrivateIP(
+			config.Sidecar.AdvertiseCloud, config.Sidecar.AdvertiseCloudIface,
+		)
+		exitWithError(err, "Failed to find the address to advertise in the cloud instance metadata")
+	}
+	advertiseIP, err = advertiseAddress(
+		advertiseIP, config.Sidecar.AdvertiseInterface, config.Sidecar.AdvertiseDefaultRoute,
+	)
+	exitWithError(err, "Failed to find the address to advertise")
+	publishedIP, err := getPublishedIP(config.Sidecar.ExcludeIPs, advertiseIP)
+	exitWithError(err, "Failed to find private IP address")
 
 	printer := rubberneck.NewPrinter(log.Infof, rubberneck.NoAddLineFeed)
 	printer.PrintWithLabel("Sidecar", config)
 
-	list, err := memberlist.Create(mlConfig)
-	exitWithError(err, "Failed to create memberlist")
+	if config.Sidecar.DryRun {
+		log.Warn("Dry run! Not writing proxy config, reloading HAproxy, or talking to the cluster")
+	}
+
+	// Keep a copy of the catalog on disk, if asked, and pick up from it on
+	// restart. This runs before any snapshot is restored since it's newer.
+	// When we're taking over from another Sidecar, it holds the file until
+	// it exits, so we wait for it in the background.
+	if config.Sidecar.StateStore != "" {
+		opened := make(chan *catalog.BoltStore, 1)
+		defer func() {
+			select {
+			case store := <-opened:
+				state.FlushStore()
+				store.Close()
+			default:
+			}
+		}()
+
+		attachStore := func() {
+			store, err := openStateStore(
+				config.Sidecar.StateStore, config.Sidecar.ReusePort, config.Sidecar.HandoffTimeout,
+			)
+			exitWithError(err, "Failed to open the state store")
 
-	// Join an existing cluster by specifying at least one known member.
-	_, err = list.Join(config.Sidecar.Seeds)
-	exitWithError(err, "Failed to join cluster")
+			state.SetStore(store)
+			_, err = state.LoadStore()
+			if err != nil {
+				log.Errorf("Failed to load services from the state store: %s", err)
+			}
+			opened <- store
+
+			go state.SweepStore(
+				director.NewTimedLooper(director.FOREVER, catalog.STORE_SWEEP_INTERVAL, nil))
+		}
+
+		if config.Sidecar.ReusePort {
+			go attachStore()
+		} else {
+			attachStore()
+		}
+	}
+
+	// Servers can save snapshots of the catalog, and seed it from the last
+	// one when the whole cluster is starting up cold
+	if !isAgent && !isProxy && config.Snapshot.S3Bucket != "" {
+		snapshotter := configureSnapshotter(config, state)
+
+		if config.Snapshot.RestoreOnStart {
+			_, err := snapshotter.Restore()
+			if err != nil {
+				log.Errorf("Failed to restore catalog snapshot: %s", err)
+			}
+		}
+
+		if config.Snapshot.Interval > 0 && !config.Sidecar.DryRun {
+			go snapshotter.Run(director.NewTimedLooper(director.FOREVER, config.Snapshot.Interval, nil))
+		}
+	}
+
+	// Agents don't hold the cluster state, they just forward their own
+	// services on to the servers in place of gossiping them.
+	var list *memberlist.Memberlist
+	var delegate *cluster.Delegate
+	if isAgent && !config.Sidecar.DryRun {
+		log.Infof("Running in the agent role, forwarding to %v", config.Sidecar.Servers)
+		forwarder := configureForwarder(config, state)
+		go forwarder.Run(director.NewFreeLooper(director.FOREVER, nil))
+	} else if isProxy && len(config.Sidecar.Servers) > 0 {
+		// Proxy hosts can poll the servers rather than join the cluster
+		log.Infof("Running in the proxy role, polling %v", config.Sidecar.Servers)
+		if !config.Sidecar.DryRun {
+			configureListeners(config, state)
+		}
+
+		poller := NewStatePoller(state, config.Sidecar.Servers)
+		go poller.DiscardBroadcasts(director.NewFreeLooper(director.FOREVER, nil))
+		go poller.Run(director.NewTimedLooper(director.FOREVER, STATE_POLL_INTERVAL, nil))
+	} else if config.Sidecar.DryRun {
+		// Nothing we discover leaves this host
+		go discardBroadcasts(state, director.NewFreeLooper(director.FOREVER, nil))
+	} else {
+		configureListeners(config, state)
+
+		var mlConfig *memberlist.Config
+		mlConfig, delegate = configureMemberlist(config, state, publishedIP)
+
+		list, err = createMemberlist(mlConfig, config.Sidecar.ReusePort)
+		exitWithError(err, "Failed to create memberlist")
+
+		// Join an existing cluster by specifying at least one known member.
+		_, err = list.Join(config.Sidecar.Seeds)
+		exitWithError(err, "Failed to join cluster")
+
+		// Just one of the servers with outage webhooks posts to them
+		state.SetOutageReporter(func() bool {
+			return cluster.OutageReporter(list.Members()) == list.LocalNode().Name
+		})
+
+		// Tombstone and purge the nodes that leave, under the reaping policy
+		go state.TrackDepartedHosts(
+			director.NewTimedLooper(director.FOREVER, catalog.REAP_INTERVAL, nil))
+
+		// Let the other proxies know how loaded we are
+		if config.Sidecar.ReportPressure {
+			go cluster.ReportPressure(list, delegate, config.Sidecar.ProcDir,
+				director.NewTimedLooper(director.FOREVER, cluster.PRESSURE_INTERVAL, nil))
+		}
+
+		// And route around the hosts that are overloaded
+		if config.Sidecar.PressureThreshold > 0 {
+			state.SetPressureThreshold(config.Sidecar.PressureThreshold)
+			go cluster.TrackPressure(list, state,
+				director.NewTimedLooper(director.FOREVER, cluster.PRESSURE_INTERVAL, nil))
+		}
+	}
 
 	// Set up a bunch of go-director Loopers to run our
 	// background goroutines
@@ -311,6 +604,9 @@ func main() {
 	trackingLooper := director.NewTimedLooper(
 		director.FOREVER, catalog.ALIVE_SLEEP_INTERVAL, nil,
 	)
+	reconcileLooper := director.NewTimedLooper(
+		director.FOREVER, catalog.RECONCILE_INTERVAL, nil,
+	)
 	discoLooper := director.NewTimedLooper(
 		director.FOREVER, discovery.DefaultSleepInterval, make(chan error),
 	)
@@ -323,52 +619,150 @@ func main() {
 	healthLooper := director.NewTimedLooper(
 		director.FOREVER, healthy.HEALTH_INTERVAL, make(chan error),
 	)
+	availabilityLooper := director.NewTimedLooper(
+		director.FOREVER, catalog.AVAILABILITY_INTERVAL, make(chan error),
+	)
+	outageLooper := director.NewTimedLooper(
+		director.FOREVER, catalog.OUTAGE_INTERVAL, make(chan error),
+	)
+	conflictLooper := director.NewTimedLooper(
+		director.FOREVER, catalog.PORT_CONFLICT_INTERVAL, make(chan error),
+	)
 
 	// Register the cluster name with the state object
 	state.ClusterName = config.Sidecar.ClusterName
+	state.SetServiceLimit(config.Sidecar.MaxServicesPerHost)
+	state.SetChangeLimit(config.Sidecar.MaxChangesPerSecond, config.Sidecar.MaxChangeBurst)
+	state.SetOutageGracePeriod(config.Sidecar.OutageGracePeriod)
+
+	reapPolicy := catalog.ReapPolicy{
+		LeftGrace:   config.Sidecar.LeftNodeGrace,
+		FailedGrace: config.Sidecar.FailedNodeGrace,
+		LeftPurge:   config.Sidecar.LeftNodePurge,
+		FailedPurge: config.Sidecar.FailedNodePurge,
+	}
+	err = reapPolicy.Validate()
+	exitWithError(err, "Invalid node reaping policy")
+	state.SetReapPolicy(reapPolicy)
 
-	disco := configureDiscovery(config, mlConfig.AdvertiseAddr)
+	disco := configureDiscovery(config, publishedIP)
 	go disco.Run(discoLooper)
 
 	// Configure the monitor and use the public address as the default
 	// check address.
-	monitor := healthy.NewMonitor(mlConfig.AdvertiseAddr, config.Sidecar.DefaultCheckEndpoint)
+	monitor := healthy.NewMonitor(publishedIP, config.Sidecar.DefaultCheckEndpoint)
+	err = monitor.SetDefaultCheckPolicy(config.Sidecar.DefaultCheckPolicy)
+	exitWithError(err, "Can't set the default check policy")
+	monitor.ApiToken = string(config.Sidecar.ApiToken)
+	monitor.MaintenanceWindows, err = healthy.ParseMaintenanceWindows(config.Sidecar.MaintenanceWindows)
+	exitWithError(err, "Invalid SIDECAR_MAINTENANCE_WINDOWS")
+
+	// Services can ask to be checked through the local HAproxy
+	if !isAgent && !config.HAproxy.Disable {
+		monitor.ProxyAddress = config.HAproxy.BindIP
+	}
 
-	// Wrap the monitor Services function as a simple func without the receiver
-	serviceFunc := func() []service.Service { return monitor.Services() }
+	if !service.IsValidNamespace(config.Sidecar.Namespace) {
+		log.Fatalf("Invalid SIDECAR_NAMESPACE %q! Only letters, digits, dashes, underscores, and dots are allowed",
+			config.Sidecar.Namespace)
+	}
 
-	// Wrap the discovery Listeners output in something the state can handle
-	listenFunc := func() []catalog.Listener {
-		listeners := disco.Listeners()
-		var result []catalog.Listener
-		for _, discovered := range listeners {
-			newLstnr := catalog.NewUrlListener(discovered.Url, true)
-			newLstnr.SetName(discovered.Name)
-			result = append(result, newLstnr)
+	// Wrap the monitor Services function as a simple func without the receiver,
+	// and stamp our services with the zone we're running in, and our namespace
+	// unless they have their own
+	serviceFunc := func() []service.Service {
+		services := monitor.Services()
+		for i := range services {
+			services[i].Zone = config.Sidecar.Zone
+			if services[i].Namespace == "" {
+				services[i].Namespace = config.Sidecar.Namespace
+			}
 		}
-		return result
+		return services
 	}
 
 	// Need to call HAproxy first, otherwise won't see first events from
 	// discovered services, and then won't write them out.
 	var proxy *haproxy.HAproxy
 
-	if !config.HAproxy.Disable {
+	if !isAgent && !config.HAproxy.Disable {
 		proxy = configureHAproxy(config)
+		proxy.Stagger = configureStagger(config, state, list)
 		go proxy.Watch(state)
 	}
 
-	go announceMembers(list, state)
 	go state.BroadcastServices(serviceFunc, servicesLooper)
 	go state.BroadcastTombstones(serviceFunc, tombstoneLooper)
 	go state.TrackNewServices(serviceFunc, trackingLooper)
-	go state.TrackLocalListeners(listenFunc, listenLooper)
+	go state.ReconcileServices(serviceFunc, reconcileLooper)
 	go monitor.Watch(disco, healthWatchLooper)
 	go monitor.Run(healthLooper)
 
-	go sidecarhttp.ServeHttp(list, state, &sidecarhttp.HttpConfig{
+	// Everything else needs the full cluster state, which only servers have.
+	// Agents only run the checks other nodes delegate to them.
+	if isAgent {
+		httpListener, err := listen(sidecarhttp.HTTP_ADDRESS, config.Sidecar.ReusePort)
+		exitWithError(err, "Can't start HTTP server")
+
+		sidecarhttp.ServeAgentHttp(monitor, &sidecarhttp.HttpConfig{
+			ReadOnly: config.Sidecar.ReadOnlyAPI || config.Sidecar.DryRun,
+			Listener: httpListener,
+			ApiToken: string(config.Sidecar.ApiToken),
+			Catalog:  newServerCatalog(config.Sidecar.Servers).Get,
+		})
+
+		select {}
+	}
+
+	// Wrap the discovery Listeners output in something the state can handle
+	listenFunc := func() []catalog.Listener {
+		listeners := disco.Listeners()
+		var result []catalog.Listener
+		for _, discovered := range listeners {
+			newLstnr := catalog.NewUrlListener(discovered.Url, true)
+			newLstnr.SetName(discovered.Name)
+			result = append(result, newLstnr)
+		}
+		return result
+	}
+
+	// Listeners that platform services subscribe through the API
+	registry := catalog.NewListenerRegistry(state, config.Sidecar.ListenerRegistry)
+	if !config.Sidecar.DryRun {
+		err := registry.Load()
+		exitWithError(err, "Failed to load registered listeners")
+	}
+
+	go announceMembers(list, state)
+	go state.TrackAvailability(availabilityLooper)
+	go state.TrackOutages(outageLooper)
+	go state.TrackPortConflicts(conflictLooper)
+	if !config.Sidecar.DryRun {
+		go state.TrackLocalListeners(listenFunc, listenLooper)
+	}
+
+	if config.Acme.Enable && proxy != nil && !config.Sidecar.DryRun {
+		certManager := configureCertManager(config, state, proxy)
+		http.Handle("/.well-known/acme-challenge/", certManager.HTTPHandler())
+
+		certLooper := director.NewTimedLooper(
+			director.FOREVER, config.Acme.CheckInterval, make(chan error),
+		)
+		go certManager.Run(certLooper)
+	}
+
+	httpListener, err := listen(sidecarhttp.HTTP_ADDRESS, config.Sidecar.ReusePort)
+	exitWithError(err, "Can't start HTTP server")
+
+	httpServer := sidecarhttp.ServeHttp(list, state, monitor, disco, &sidecarhttp.HttpConfig{
 		BindIP:       config.HAproxy.BindIP,
 		UseHostnames: config.HAproxy.UseHostnames,
+		ReadOnly:     config.Sidecar.ReadOnlyAPI || config.Sidecar.DryRun,
+		Listener:     httpListener,
+		ApiToken:     string(config.Sidecar.ApiToken),
+		Registry:     registry,
+		Namespace:    config.Sidecar.Namespace,
+		Assets:       uiAssets(config.Sidecar.UIAssetDir),
 	})
 
 	if !config.HAproxy.Disable {
@@ -376,15 +770,19 @@ func main() {
 		exitWithError(err, "Failed to reload HAProxy config")
 	}
 
-	if config.Envoy.UseGRPCAPI {
-		ctx := context.Background()
+	var stopEnvoy context.CancelFunc
+	if config.Envoy.UseGRPCAPI && !config.Sidecar.DryRun {
+		var ctx context.Context
+		ctx, stopEnvoy = context.WithCancel(context.Background())
 		envoyServer := envoy.NewServer(ctx, state, config.Envoy)
+		envoyServer.Zone = config.Sidecar.Zone
+		envoyServer.Namespace = config.Sidecar.Namespace
 		envoyServerLooper := director.NewTimedLooper(
 			director.FOREVER, envoy.LooperUpdateInterval, make(chan error),
 		)
 
 		// This listener will be owned and managed by the gRPC server
-		grpcListener, err := net.Listen("tcp", ":"+config.Envoy.GRPCPort)
+		grpcListener, err := listen(":"+config.Envoy.GRPCPort, config.Sidecar.ReusePort)
 		if err != nil {
 			log.Fatalf("Failed to listen on port %q: %s", config.Envoy.GRPCPort, err)
 		}
@@ -392,5 +790,10 @@ func main() {
 		go envoyServer.Run(ctx, envoyServerLooper, grpcListener)
 	}
 
-	select {}
+	if config.Sidecar.ReusePort {
+		waitForHandoff(list, httpServer, stopEnvoy, config.Sidecar.HandoffTimeout)
+		return
+	}
+
+	waitForLeave(list, delegate)
 }
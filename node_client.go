@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+)
+
+const (
+	NODE_CLIENT_MAX_IDLE     = 4                // Idle connections we keep open to each server
+	NODE_CLIENT_IDLE_TIMEOUT = 90 * time.Second // How long idle connections are kept open
+	NODE_CLIENT_KEEPALIVE    = 30 * time.Second // TCP keep-alive period for open connections
+)
+
+// newNodeClient returns an HTTP client for talking to other Sidecar nodes.
+// It keeps connections to the servers open between requests, so that an
+// agent or proxy polling every few seconds doesn't pay for a new connection
+// each time, and speaks HTTP/2 to servers reached over TLS. The state comes
+// back gzipped, which the client asks for and unpacks transparently.
+func newNodeClient(timeout time.Duration) *http.Client {
+	transport := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: NODE_CLIENT_KEEPALIVE,
+		}).DialContext,
+		MaxIdleConnsPerHost: NODE_CLIENT_MAX_IDLE,
+		IdleConnTimeout:     NODE_CLIENT_IDLE_TIMEOUT,
+		TLSHandshakeTimeout: timeout,
+	}
+
+	err := http2.ConfigureTransport(transport)
+	if err != nil {
+		log.Warnf("Unable to enable HTTP/2 for requests to other nodes: %s", err)
+	}
+
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
@@ -59,6 +59,8 @@ func ShouldNotify(oldStatus int, newStatus int) bool {
 		}
 	case service.DRAINING:
 		return true
+	case service.MAINTENANCE:
+		return true
 	default:
 		log.Errorf("Got unknown service change status: %d", newStatus)
 		return false
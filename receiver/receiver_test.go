@@ -7,6 +7,7 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 	"gopkg.in/jarcoal/httpmock.v1"
 	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
 )
 
 func Test_FetchState(t *testing.T) {
@@ -63,6 +64,24 @@ func Test_FetchState(t *testing.T) {
 	})
 }
 
+func Test_ShouldNotify(t *testing.T) {
+	Convey("ShouldNotify()", t, func() {
+		Convey("notifies when a service goes into maintenance", func() {
+			So(ShouldNotify(service.ALIVE, service.MAINTENANCE), ShouldBeTrue)
+			So(ShouldNotify(service.UNHEALTHY, service.MAINTENANCE), ShouldBeTrue)
+		})
+
+		Convey("notifies when a service comes out of maintenance", func() {
+			So(ShouldNotify(service.MAINTENANCE, service.ALIVE), ShouldBeTrue)
+		})
+
+		Convey("doesn't notify for services that were already down", func() {
+			So(ShouldNotify(service.MAINTENANCE, service.UNHEALTHY), ShouldBeFalse)
+			So(ShouldNotify(service.MAINTENANCE, service.UNKNOWN), ShouldBeFalse)
+		})
+	})
+}
+
 func Test_IsSubscribed(t *testing.T) {
 	Convey("IsSubscribed()", t, func() {
 		rcvr := &Receiver{}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_listen(t *testing.T) {
+	Convey("listen()", t, func() {
+		first, err := listen("127.0.0.1:0", true)
+		So(err, ShouldBeNil)
+		Reset(func() { first.Close() })
+
+		address := first.Addr().String()
+
+		Convey("lets another listener share the port with SO_REUSEPORT", func() {
+			second, err := listen(address, true)
+			So(err, ShouldBeNil)
+			second.Close()
+		})
+
+		Convey("doesn't without it", func() {
+			_, err := listen(address, false)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
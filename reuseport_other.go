@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePort is only supported on Linux, where the kernel spreads connections
+// across all the sockets sharing the port.
+func reusePort(network string, address string, conn syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is only supported on Linux")
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/armon/go-metrics"
+	"github.com/pquerna/ffjson/ffjson"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	FORWARDER_TIMEOUT = 3 * time.Second // HTTP timeout when posting to a server
+)
+
+// A serversForwarder stands in for the Memberlist delegate when we run in the
+// agent role. Rather than gossiping the broadcasts from our local state, it
+// posts them to one of the Sidecar servers, which gossip them on our behalf.
+// We stick with one server until it fails, then move on to the next.
+type serversForwarder struct {
+	state    *catalog.ServicesState
+	servers  []string
+	current  int
+	client   *http.Client
+	apiToken string // The servers only take updates that carry it
+}
+
+func NewServersForwarder(state *catalog.ServicesState, servers []string, apiToken string) *serversForwarder {
+	return &serversForwarder{
+		state:    state,
+		servers:  serverURLs(servers, "/api/services/update"),
+		client:   newNodeClient(FORWARDER_TIMEOUT),
+		apiToken: apiToken,
+	}
+}
+
+// serverURLs turns the configured server addresses, which may or may not
+// include a scheme, into URLs for the API path given.
+func serverURLs(servers []string, path string) []string {
+	var urls []string
+	for _, server := range servers {
+		if !strings.HasPrefix(server, "http://") && !strings.HasPrefix(server, "https://") {
+			server = "http://" + server
+		}
+		urls = append(urls, strings.TrimRight(server, "/")+path)
+	}
+
+	return urls
+}
+
+// Run consumes the state's broadcasts and forwards them to the servers. It
+// must be running for the state's broadcast loops not to block.
+func (f *serversForwarder) Run(looper director.Looper) {
+	looper.Loop(func() error {
+		broadcast := <-f.state.Broadcasts
+		if len(broadcast) < 1 {
+			return nil
+		}
+
+		err := f.forward(broadcast)
+		if err != nil {
+			log.Warnf("Unable to forward %d services: %s", len(broadcast), err)
+		}
+
+		for i := 0; i < len(broadcast); i++ {
+			ffjson.Pool(broadcast[i])
+		}
+
+		return nil
+	})
+}
+
+// forward posts the encoded services to the current server as a JSON array,
+// falling back to each of the other servers in turn.
+func (f *serversForwarder) forward(broadcast [][]byte) error {
+	defer metrics.MeasureSince([]string{"forwarder", "forward"}, time.Now())
+
+	if len(f.servers) < 1 {
+		return errors.New("no servers configured")
+	}
+
+	var body bytes.Buffer
+	body.WriteByte('[')
+	body.Write(bytes.Join(broadcast, []byte(",")))
+	body.WriteByte(']')
+
+	for i := 0; i < len(f.servers); i++ {
+		server := f.servers[f.current]
+
+		err := f.post(server, body.Bytes())
+		if err == nil {
+			return nil
+		}
+
+		log.Warnf("Failed forwarding services to %s: %s", server, err)
+		f.current = (f.current + 1) % len(f.servers)
+	}
+
+	return errors.New("no servers accepted the update")
+}
+
+func (f *serversForwarder) post(url string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+f.apiToken)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Read the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode > 299 || resp.StatusCode < 200 {
+		return fmt.Errorf("bad status code returned (%d)", resp.StatusCode)
+	}
+
+	return nil
+}
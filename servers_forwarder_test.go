@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ServersForwarder(t *testing.T) {
+	Convey("When forwarding services to the servers", t, func() {
+		state := catalog.NewServicesState()
+
+		var received []string
+		var paths []string
+		var authorization string
+		goodServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			received = append(received, string(body))
+			paths = append(paths, r.URL.Path)
+			authorization = r.Header.Get("Authorization")
+			w.WriteHeader(202)
+		}))
+		defer goodServer.Close()
+
+		badServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(500)
+		}))
+		defer badServer.Close()
+
+		bCast := [][]byte{
+			[]byte(`{"ID":"d419fa7ad1a7","Hostname":"docker2"}`),
+			[]byte(`{"ID":"deadbeefabba","Hostname":"docker2"}`),
+		}
+
+		Convey("NewServersForwarder() builds the update URLs", func() {
+			forwarder := NewServersForwarder(state, []string{"10.0.0.1:7777", "https://sidecar.example.com/"}, "sekrit")
+			So(forwarder.servers, ShouldResemble, []string{
+				"http://10.0.0.1:7777/api/services/update",
+				"https://sidecar.example.com/api/services/update",
+			})
+		})
+
+		Convey("forward() posts the services as a JSON array", func() {
+			forwarder := NewServersForwarder(state, []string{goodServer.URL}, "sekrit")
+			err := forwarder.forward(bCast)
+
+			So(err, ShouldBeNil)
+			So(paths, ShouldResemble, []string{"/api/services/update"})
+			So(authorization, ShouldEqual, "Bearer sekrit")
+			So(received[0], ShouldEqual,
+				`[{"ID":"d419fa7ad1a7","Hostname":"docker2"},{"ID":"deadbeefabba","Hostname":"docker2"}]`,
+			)
+		})
+
+		Convey("forward() fails over to the next server", func() {
+			forwarder := NewServersForwarder(state, []string{badServer.URL, goodServer.URL}, "sekrit")
+			err := forwarder.forward(bCast)
+
+			So(err, ShouldBeNil)
+			So(len(received), ShouldEqual, 1)
+			So(forwarder.current, ShouldEqual, 1)
+		})
+
+		Convey("forward() returns an error when all servers fail", func() {
+			forwarder := NewServersForwarder(state, []string{badServer.URL}, "sekrit")
+			So(forwarder.forward(bCast), ShouldNotBeNil)
+		})
+
+		Convey("Run() consumes the state broadcasts", func() {
+			forwarder := NewServersForwarder(state, []string{goodServer.URL}, "sekrit")
+			go func() { state.Broadcasts <- bCast }()
+			forwarder.Run(director.NewFreeLooper(director.ONCE, nil))
+
+			So(len(received), ShouldEqual, 1)
+		})
+	})
+}
@@ -15,11 +15,18 @@ import (
 )
 
 const (
-	ALIVE     = iota
-	TOMBSTONE = iota
-	UNHEALTHY = iota
-	UNKNOWN   = iota
-	DRAINING  = iota
+	ALIVE       = iota
+	TOMBSTONE   = iota
+	UNHEALTHY   = iota
+	UNKNOWN     = iota
+	DRAINING    = iota
+	MAINTENANCE = iota
+)
+
+const (
+	// Sent on health checks run through the proxy, with the instance ID, so
+	// that the proxy routes them to that instance. See ProxyChecked.
+	PROXY_CHECK_HEADER = "X-Sidecar-Check"
 )
 
 type Port struct {
@@ -30,15 +37,65 @@ type Port struct {
 }
 
 type Service struct {
-	ID        string
-	Name      string
-	Image     string
-	Created   time.Time
-	Hostname  string
-	Ports     []Port
-	Updated   time.Time
-	ProxyMode string
-	Status    int
+	ID             string
+	Name           string
+	Image          string
+	Created        time.Time
+	Hostname       string
+	Ports          []Port
+	Updated        time.Time
+	ProxyMode      string
+	Status         int
+	CheckOutput    string   `json:",omitempty"`
+	TLSHosts       []string `json:",omitempty"`
+	Zone           string   `json:",omitempty"`
+	SidecarVersion string   `json:",omitempty"`
+
+	// Circuit breaking, applied by the proxy. Zero means no limit.
+	MaxConnections       int `json:",omitempty"`
+	MaxPendingRequests   int `json:",omitempty"`
+	MaxConsecutiveErrors int `json:",omitempty"`
+
+	// Discovered and health checked, but left out of the proxy config
+	ProxyDisabled bool `json:",omitempty"`
+
+	// How the proxy spreads requests across instances. Empty means round robin.
+	BalanceAlgorithm string `json:",omitempty"`
+
+	// With fewer alive instances than this, the proxy fails closed rather than
+	// sending all the traffic to the survivors. Zero means no minimum.
+	MinInstances int `json:",omitempty"`
+
+	// Keeps teams or environments sharing a cluster from colliding on service
+	// names. Empty is the default namespace.
+	Namespace string `json:",omitempty"`
+
+	// Health checked through the local proxy rather than directly, so that
+	// the check covers the whole route to the instance. The proxy keeps the
+	// instance in its config while it fails, but sends it nothing else.
+	ProxyChecked bool `json:",omitempty"`
+}
+
+// IsValidNamespace reports whether a namespace is made up only of letters,
+// digits, dashes, underscores, and dots. The empty default namespace is valid.
+func IsValidNamespace(namespace string) bool {
+	for _, char := range namespace {
+		switch {
+		case char >= 'a' && char <= 'z', char >= 'A' && char <= 'Z', char >= '0' && char <= '9':
+		case char == '-', char == '_', char == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// BalanceAlgorithms are the load balancing algorithms the proxies support
+var BalanceAlgorithms = map[string]bool{
+	"roundrobin": true,
+	"leastconn":  true,
+	"source":     true,
 }
 
 func (svc *Service) Encode() ([]byte, error) {
@@ -61,6 +118,11 @@ func (svc *Service) IsDraining() bool {
 	return svc.Status == DRAINING
 }
 
+// IsProxied tells whether the proxy should route traffic to this service
+func (svc *Service) IsProxied() bool {
+	return svc.IsAlive() && !svc.ProxyDisabled
+}
+
 func (svc *Service) Invalidates(otherSvc *Service) bool {
 	return otherSvc != nil && svc.Updated.After(otherSvc.Updated)
 }
@@ -105,9 +167,13 @@ func (svc *Service) ListenerName() string {
 	return "Service(" + svc.Name + "-" + svc.ID + ")"
 }
 
-// Version attempts to extract a version from the image. Otherwise it returns
-// the full image name.
+// Version returns the version from the SidecarVersion label, or attempts to
+// extract one from the image. Otherwise it returns the full image name.
 func (svc *Service) Version() string {
+	if svc.SidecarVersion != "" {
+		return svc.SidecarVersion
+	}
+
 	parts := strings.Split(svc.Image, ":")
 	if len(parts) > 1 {
 		return parts[1]
@@ -148,6 +214,42 @@ func ToService(container *docker.APIContainers, ip string) Service {
 		svc.ProxyMode = "http"
 	}
 
+	// Public hostnames the proxy should serve this service on over TLS
+	for _, host := range strings.Split(container.Labels["TLSHosts"], ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			svc.TLSHosts = append(svc.TLSHosts, host)
+		}
+	}
+
+	// The deployed version, when the image tag doesn't tell us
+	svc.SidecarVersion = container.Labels["SidecarVersion"]
+
+	// Services only meant to be seen in the catalog, not reached via the proxy
+	svc.ProxyDisabled = container.Labels["SidecarProxy"] == "false"
+
+	if algorithm, ok := container.Labels["BalanceAlgorithm"]; ok {
+		algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+		if BalanceAlgorithms[algorithm] {
+			svc.BalanceAlgorithm = algorithm
+		} else {
+			log.Errorf("Unknown BalanceAlgorithm %q, using the proxy default", algorithm)
+		}
+	}
+
+	// Circuit breaker settings for the proxy
+	svc.MaxConnections = intLabel(container, "MaxConnections")
+	svc.MaxPendingRequests = intLabel(container, "MaxPendingRequests")
+	svc.MaxConsecutiveErrors = intLabel(container, "MaxConsecutiveErrors")
+
+	// How many instances it takes to serve any traffic at all
+	svc.MinInstances = intLabel(container, "MinInstances")
+
+	// Left empty, the host's namespace is filled in later
+	svc.Namespace = strings.TrimSpace(container.Labels["Namespace"])
+
+	// Health checks go through the proxy, to cover the route to the instance
+	svc.ProxyChecked = container.Labels["HealthCheckThroughProxy"] == "true"
+
 	svc.Ports = make([]Port, 0)
 
 	for _, port := range container.Ports {
@@ -159,6 +261,32 @@ func ToService(container *docker.APIContainers, ip string) Service {
 	return svc
 }
 
+// ToServiceOnNetwork is like ToService but advertises the container's own
+// address on a Docker network instead of the host's. That address is reached
+// directly, so we advertise the container's private ports, whether or not
+// they are also published on the host.
+func ToServiceOnNetwork(container *docker.APIContainers, ip string) Service {
+	svc := ToService(container, ip)
+	svc.Ports = make([]Port, 0)
+
+	// Docker lists published ports once per host binding, so de-dupe them
+	seen := make(map[string]bool, len(container.Ports))
+	for _, port := range container.Ports {
+		key := fmt.Sprintf("%d/%s", port.PrivatePort, port.Type)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		svcPort := buildPortFor(&port, container, ip)
+		svcPort.Port = port.PrivatePort
+		svcPort.IP = ip
+		svc.Ports = append(svc.Ports, svcPort)
+	}
+
+	return svc
+}
+
 func StatusString(status int) string {
 	switch status {
 	case ALIVE:
@@ -169,11 +297,30 @@ func StatusString(status int) string {
 		return "Unknown"
 	case DRAINING:
 		return "Draining"
+	case MAINTENANCE:
+		return "Maintenance"
 	default:
 		return "Tombstone"
 	}
 }
 
+// Look up a label that must be a positive integer, returning 0 when it's
+// missing or invalid
+func intLabel(container *docker.APIContainers, label string) int {
+	value, ok := container.Labels[label]
+	if !ok {
+		return 0
+	}
+
+	valueInt, err := strconv.Atoi(value)
+	if err != nil || valueInt < 0 {
+		log.Errorf("Error converting label value for %s to a positive integer: %q", label, value)
+		return 0
+	}
+
+	return valueInt
+}
+
 // Figure out the correct port configuration for a service
 func buildPortFor(port *docker.APIPort, container *docker.APIContainers, ip string) Port {
 	// We look up service port labels by convention in the format "ServicePort_80=8080"
@@ -7,6 +7,7 @@ package service
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	fflib "github.com/pquerna/ffjson/fflib/v1"
 )
@@ -431,6 +432,71 @@ func (mj *Service) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
 	fflib.WriteJsonString(buf, string(mj.ProxyMode))
 	buf.WriteString(`,"Status":`)
 	fflib.FormatBits2(buf, uint64(mj.Status), 10, mj.Status < 0)
+	if len(mj.CheckOutput) != 0 {
+		buf.WriteString(`,"CheckOutput":`)
+		fflib.WriteJsonString(buf, string(mj.CheckOutput))
+	}
+	if len(mj.TLSHosts) != 0 {
+		buf.WriteString(`,"TLSHosts":`)
+		if mj.TLSHosts != nil {
+			buf.WriteString(`[`)
+			for i, v := range mj.TLSHosts {
+				if i != 0 {
+					buf.WriteString(`,`)
+				}
+				fflib.WriteJsonString(buf, string(v))
+			}
+			buf.WriteString(`]`)
+		} else {
+			buf.WriteString(`null`)
+		}
+	}
+	if len(mj.Zone) != 0 {
+		buf.WriteString(`,"Zone":`)
+		fflib.WriteJsonString(buf, string(mj.Zone))
+	}
+	if len(mj.SidecarVersion) != 0 {
+		buf.WriteString(`,"SidecarVersion":`)
+		fflib.WriteJsonString(buf, string(mj.SidecarVersion))
+	}
+	if mj.MaxConnections != 0 {
+		buf.WriteString(`,"MaxConnections":`)
+		fflib.FormatBits2(buf, uint64(mj.MaxConnections), 10, mj.MaxConnections < 0)
+	}
+	if mj.MaxPendingRequests != 0 {
+		buf.WriteString(`,"MaxPendingRequests":`)
+		fflib.FormatBits2(buf, uint64(mj.MaxPendingRequests), 10, mj.MaxPendingRequests < 0)
+	}
+	if mj.MaxConsecutiveErrors != 0 {
+		buf.WriteString(`,"MaxConsecutiveErrors":`)
+		fflib.FormatBits2(buf, uint64(mj.MaxConsecutiveErrors), 10, mj.MaxConsecutiveErrors < 0)
+	}
+	if mj.ProxyDisabled != false {
+		if mj.ProxyDisabled {
+			buf.WriteString(`,"ProxyDisabled":true`)
+		} else {
+			buf.WriteString(`,"ProxyDisabled":false`)
+		}
+	}
+	if len(mj.BalanceAlgorithm) != 0 {
+		buf.WriteString(`,"BalanceAlgorithm":`)
+		fflib.WriteJsonString(buf, string(mj.BalanceAlgorithm))
+	}
+	if mj.MinInstances != 0 {
+		buf.WriteString(`,"MinInstances":`)
+		fflib.FormatBits2(buf, uint64(mj.MinInstances), 10, mj.MinInstances < 0)
+	}
+	if len(mj.Namespace) != 0 {
+		buf.WriteString(`,"Namespace":`)
+		fflib.WriteJsonString(buf, string(mj.Namespace))
+	}
+	if mj.ProxyChecked != false {
+		if mj.ProxyChecked {
+			buf.WriteString(`,"ProxyChecked":true`)
+		} else {
+			buf.WriteString(`,"ProxyChecked":false`)
+		}
+	}
 	buf.WriteByte('}')
 	return nil
 }
@@ -456,6 +522,30 @@ const (
 	ffj_t_Service_ProxyMode
 
 	ffj_t_Service_Status
+
+	ffj_t_Service_CheckOutput
+
+	ffj_t_Service_TLSHosts
+
+	ffj_t_Service_Zone
+
+	ffj_t_Service_SidecarVersion
+
+	ffj_t_Service_MaxConnections
+
+	ffj_t_Service_MaxPendingRequests
+
+	ffj_t_Service_MaxConsecutiveErrors
+
+	ffj_t_Service_ProxyDisabled
+
+	ffj_t_Service_BalanceAlgorithm
+
+	ffj_t_Service_MinInstances
+
+	ffj_t_Service_Namespace
+
+	ffj_t_Service_ProxyChecked
 )
 
 var ffj_key_Service_ID = []byte("ID")
@@ -476,6 +566,30 @@ var ffj_key_Service_ProxyMode = []byte("ProxyMode")
 
 var ffj_key_Service_Status = []byte("Status")
 
+var ffj_key_Service_CheckOutput = []byte("CheckOutput")
+
+var ffj_key_Service_TLSHosts = []byte("TLSHosts")
+
+var ffj_key_Service_Zone = []byte("Zone")
+
+var ffj_key_Service_SidecarVersion = []byte("SidecarVersion")
+
+var ffj_key_Service_MaxConnections = []byte("MaxConnections")
+
+var ffj_key_Service_MaxPendingRequests = []byte("MaxPendingRequests")
+
+var ffj_key_Service_MaxConsecutiveErrors = []byte("MaxConsecutiveErrors")
+
+var ffj_key_Service_ProxyDisabled = []byte("ProxyDisabled")
+
+var ffj_key_Service_BalanceAlgorithm = []byte("BalanceAlgorithm")
+
+var ffj_key_Service_MinInstances = []byte("MinInstances")
+
+var ffj_key_Service_Namespace = []byte("Namespace")
+
+var ffj_key_Service_ProxyChecked = []byte("ProxyChecked")
+
 func (uj *Service) UnmarshalJSON(input []byte) error {
 	fs := fflib.NewFFLexer(input)
 	return uj.UnmarshalJSONFFLexer(fs, fflib.FFParse_map_start)
@@ -535,12 +649,25 @@ mainparse:
 			} else {
 				switch kn[0] {
 
+				case 'B':
+
+					if bytes.Equal(ffj_key_Service_BalanceAlgorithm, kn) {
+						currentKey = ffj_t_Service_BalanceAlgorithm
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
 				case 'C':
 
 					if bytes.Equal(ffj_key_Service_Created, kn) {
 						currentKey = ffj_t_Service_Created
 						state = fflib.FFParse_want_colon
 						goto mainparse
+
+					} else if bytes.Equal(ffj_key_Service_CheckOutput, kn) {
+						currentKey = ffj_t_Service_CheckOutput
+						state = fflib.FFParse_want_colon
+						goto mainparse
 					}
 
 				case 'H':
@@ -564,12 +691,40 @@ mainparse:
 						goto mainparse
 					}
 
+				case 'M':
+
+					if bytes.Equal(ffj_key_Service_MaxConnections, kn) {
+						currentKey = ffj_t_Service_MaxConnections
+						state = fflib.FFParse_want_colon
+						goto mainparse
+
+					} else if bytes.Equal(ffj_key_Service_MaxPendingRequests, kn) {
+						currentKey = ffj_t_Service_MaxPendingRequests
+						state = fflib.FFParse_want_colon
+						goto mainparse
+
+					} else if bytes.Equal(ffj_key_Service_MaxConsecutiveErrors, kn) {
+						currentKey = ffj_t_Service_MaxConsecutiveErrors
+						state = fflib.FFParse_want_colon
+						goto mainparse
+
+					} else if bytes.Equal(ffj_key_Service_MinInstances, kn) {
+						currentKey = ffj_t_Service_MinInstances
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
 				case 'N':
 
 					if bytes.Equal(ffj_key_Service_Name, kn) {
 						currentKey = ffj_t_Service_Name
 						state = fflib.FFParse_want_colon
 						goto mainparse
+
+					} else if bytes.Equal(ffj_key_Service_Namespace, kn) {
+						currentKey = ffj_t_Service_Namespace
+						state = fflib.FFParse_want_colon
+						goto mainparse
 					}
 
 				case 'P':
@@ -583,6 +738,16 @@ mainparse:
 						currentKey = ffj_t_Service_ProxyMode
 						state = fflib.FFParse_want_colon
 						goto mainparse
+
+					} else if bytes.Equal(ffj_key_Service_ProxyDisabled, kn) {
+						currentKey = ffj_t_Service_ProxyDisabled
+						state = fflib.FFParse_want_colon
+						goto mainparse
+
+					} else if bytes.Equal(ffj_key_Service_ProxyChecked, kn) {
+						currentKey = ffj_t_Service_ProxyChecked
+						state = fflib.FFParse_want_colon
+						goto mainparse
 					}
 
 				case 'S':
@@ -591,6 +756,19 @@ mainparse:
 						currentKey = ffj_t_Service_Status
 						state = fflib.FFParse_want_colon
 						goto mainparse
+
+					} else if bytes.Equal(ffj_key_Service_SidecarVersion, kn) {
+						currentKey = ffj_t_Service_SidecarVersion
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'T':
+
+					if bytes.Equal(ffj_key_Service_TLSHosts, kn) {
+						currentKey = ffj_t_Service_TLSHosts
+						state = fflib.FFParse_want_colon
+						goto mainparse
 					}
 
 				case 'U':
@@ -601,6 +779,86 @@ mainparse:
 						goto mainparse
 					}
 
+				case 'Z':
+
+					if bytes.Equal(ffj_key_Service_Zone, kn) {
+						currentKey = ffj_t_Service_Zone
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				}
+
+				if fflib.EqualFoldRight(ffj_key_Service_ProxyChecked, kn) {
+					currentKey = ffj_t_Service_ProxyChecked
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffj_key_Service_Namespace, kn) {
+					currentKey = ffj_t_Service_Namespace
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffj_key_Service_MinInstances, kn) {
+					currentKey = ffj_t_Service_MinInstances
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.SimpleLetterEqualFold(ffj_key_Service_BalanceAlgorithm, kn) {
+					currentKey = ffj_t_Service_BalanceAlgorithm
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffj_key_Service_ProxyDisabled, kn) {
+					currentKey = ffj_t_Service_ProxyDisabled
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffj_key_Service_MaxConsecutiveErrors, kn) {
+					currentKey = ffj_t_Service_MaxConsecutiveErrors
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffj_key_Service_MaxPendingRequests, kn) {
+					currentKey = ffj_t_Service_MaxPendingRequests
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffj_key_Service_MaxConnections, kn) {
+					currentKey = ffj_t_Service_MaxConnections
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffj_key_Service_SidecarVersion, kn) {
+					currentKey = ffj_t_Service_SidecarVersion
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.SimpleLetterEqualFold(ffj_key_Service_Zone, kn) {
+					currentKey = ffj_t_Service_Zone
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffj_key_Service_TLSHosts, kn) {
+					currentKey = ffj_t_Service_TLSHosts
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffj_key_Service_CheckOutput, kn) {
+					currentKey = ffj_t_Service_CheckOutput
+					state = fflib.FFParse_want_colon
+					goto mainparse
 				}
 
 				if fflib.EqualFoldRight(ffj_key_Service_Status, kn) {
@@ -701,6 +959,42 @@ mainparse:
 				case ffj_t_Service_Status:
 					goto handle_Status
 
+				case ffj_t_Service_CheckOutput:
+					goto handle_CheckOutput
+
+				case ffj_t_Service_TLSHosts:
+					goto handle_TLSHosts
+
+				case ffj_t_Service_Zone:
+					goto handle_Zone
+
+				case ffj_t_Service_SidecarVersion:
+					goto handle_SidecarVersion
+
+				case ffj_t_Service_MaxConnections:
+					goto handle_MaxConnections
+
+				case ffj_t_Service_MaxPendingRequests:
+					goto handle_MaxPendingRequests
+
+				case ffj_t_Service_MaxConsecutiveErrors:
+					goto handle_MaxConsecutiveErrors
+
+				case ffj_t_Service_ProxyDisabled:
+					goto handle_ProxyDisabled
+
+				case ffj_t_Service_BalanceAlgorithm:
+					goto handle_BalanceAlgorithm
+
+				case ffj_t_Service_MinInstances:
+					goto handle_MinInstances
+
+				case ffj_t_Service_Namespace:
+					goto handle_Namespace
+
+				case ffj_t_Service_ProxyChecked:
+					goto handle_ProxyChecked
+
 				case ffj_t_Serviceno_such_key:
 					err = fs.SkipField(tok)
 					if err != nil {
@@ -996,6 +1290,400 @@ handle_Status:
 	state = fflib.FFParse_after_value
 	goto mainparse
 
+handle_CheckOutput:
+
+	/* handler: uj.CheckOutput type=string kind=string quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			outBuf := fs.Output.Bytes()
+
+			uj.CheckOutput = string(string(outBuf))
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_TLSHosts:
+
+	/* handler: uj.TLSHosts type=[]string kind=slice quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_left_brace && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for ", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+			uj.TLSHosts = nil
+		} else {
+
+			uj.TLSHosts = []string{}
+
+			wantVal := true
+
+			for {
+
+				var tmp_uj__TLSHosts string
+
+				tok = fs.Scan()
+				if tok == fflib.FFTok_error {
+					goto tokerror
+				}
+				if tok == fflib.FFTok_right_brace {
+					break
+				}
+
+				if tok == fflib.FFTok_comma {
+					if wantVal == true {
+						// TODO(pquerna): this isn't an ideal error message, this handles
+						// things like [,,,] as an array value.
+						return fs.WrapErr(fmt.Errorf("wanted value token, but got token: %v", tok))
+					}
+					continue
+				} else {
+					wantVal = true
+				}
+
+				/* handler: tmp_uj__TLSHosts type=string kind=string quoted=false*/
+
+				{
+
+					{
+						if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+							return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+						}
+					}
+
+					if tok == fflib.FFTok_null {
+
+					} else {
+
+						outBuf := fs.Output.Bytes()
+
+						tmp_uj__TLSHosts = string(string(outBuf))
+
+					}
+				}
+
+				uj.TLSHosts = append(uj.TLSHosts, tmp_uj__TLSHosts)
+
+				wantVal = false
+			}
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_Zone:
+
+	/* handler: uj.Zone type=string kind=string quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			outBuf := fs.Output.Bytes()
+
+			uj.Zone = string(string(outBuf))
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_SidecarVersion:
+
+	/* handler: uj.SidecarVersion type=string kind=string quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			outBuf := fs.Output.Bytes()
+
+			uj.SidecarVersion = string(string(outBuf))
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_MaxConnections:
+
+	/* handler: uj.MaxConnections type=int kind=int quoted=false*/
+
+	{
+		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int", tok))
+		}
+	}
+
+	{
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
+
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			uj.MaxConnections = int(tval)
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_MaxPendingRequests:
+
+	/* handler: uj.MaxPendingRequests type=int kind=int quoted=false*/
+
+	{
+		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int", tok))
+		}
+	}
+
+	{
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
+
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			uj.MaxPendingRequests = int(tval)
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_MaxConsecutiveErrors:
+
+	/* handler: uj.MaxConsecutiveErrors type=int kind=int quoted=false*/
+
+	{
+		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int", tok))
+		}
+	}
+
+	{
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
+
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			uj.MaxConsecutiveErrors = int(tval)
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_ProxyDisabled:
+
+	/* handler: uj.ProxyDisabled type=bool kind=bool quoted=false*/
+
+	{
+		if tok != fflib.FFTok_bool && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for bool", tok))
+		}
+	}
+
+	{
+		if tok == fflib.FFTok_null {
+
+		} else {
+			tmpb := fs.Output.Bytes()
+
+			if bytes.Compare([]byte{'t', 'r', 'u', 'e'}, tmpb) == 0 {
+
+				uj.ProxyDisabled = true
+
+			} else if bytes.Compare([]byte{'f', 'a', 'l', 's', 'e'}, tmpb) == 0 {
+
+				uj.ProxyDisabled = false
+
+			} else {
+				err = errors.New("unexpected bytes for true/false value")
+				return fs.WrapErr(err)
+			}
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_BalanceAlgorithm:
+
+	/* handler: uj.BalanceAlgorithm type=string kind=string quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			outBuf := fs.Output.Bytes()
+
+			uj.BalanceAlgorithm = string(string(outBuf))
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_MinInstances:
+
+	/* handler: uj.MinInstances type=int kind=int quoted=false*/
+
+	{
+		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int", tok))
+		}
+	}
+
+	{
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
+
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			uj.MinInstances = int(tval)
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_Namespace:
+
+	/* handler: uj.Namespace type=string kind=string quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			outBuf := fs.Output.Bytes()
+
+			uj.Namespace = string(string(outBuf))
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_ProxyChecked:
+
+	/* handler: uj.ProxyChecked type=bool kind=bool quoted=false*/
+
+	{
+		if tok != fflib.FFTok_bool && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for bool", tok))
+		}
+	}
+
+	{
+		if tok == fflib.FFTok_null {
+
+		} else {
+			tmpb := fs.Output.Bytes()
+
+			if bytes.Compare([]byte{'t', 'r', 'u', 'e'}, tmpb) == 0 {
+
+				uj.ProxyChecked = true
+
+			} else if bytes.Compare([]byte{'f', 'a', 'l', 's', 'e'}, tmpb) == 0 {
+
+				uj.ProxyChecked = false
+
+			} else {
+				err = errors.New("unexpected bytes for true/false value")
+				return fs.WrapErr(err)
+			}
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
 wantedvalue:
 	return fs.WrapErr(fmt.Errorf("wanted value token, but got token: %v", tok))
 wrongtokenerror:
@@ -136,5 +136,160 @@ func Test_ToService(t *testing.T) {
 			So(service.ProxyMode, ShouldEqual, "tcp")
 			So(service.Status, ShouldEqual, 0)
 		})
+
+		Convey("Reads the TLS hostnames", func() {
+			sampleAPIContainer.Labels["TLSHosts"] = "fabulous.example.com, www.fabulous.example.com"
+			defer delete(sampleAPIContainer.Labels, "TLSHosts")
+
+			service := ToService(sampleAPIContainer, "127.0.0.1")
+			So(service.TLSHosts, ShouldResemble, []string{"fabulous.example.com", "www.fabulous.example.com"})
+		})
+
+		Convey("Reads the circuit breaker settings", func() {
+			sampleAPIContainer.Labels["MaxConnections"] = "100"
+			sampleAPIContainer.Labels["MaxPendingRequests"] = "bogus"
+			sampleAPIContainer.Labels["MaxConsecutiveErrors"] = "5"
+			defer func() {
+				delete(sampleAPIContainer.Labels, "MaxConnections")
+				delete(sampleAPIContainer.Labels, "MaxPendingRequests")
+				delete(sampleAPIContainer.Labels, "MaxConsecutiveErrors")
+			}()
+
+			service := ToService(sampleAPIContainer, "127.0.0.1")
+			So(service.MaxConnections, ShouldEqual, 100)
+			So(service.MaxPendingRequests, ShouldEqual, 0)
+			So(service.MaxConsecutiveErrors, ShouldEqual, 5)
+		})
+
+		Convey("Reads the minimum instances", func() {
+			So(ToService(sampleAPIContainer, "127.0.0.1").MinInstances, ShouldEqual, 0)
+
+			sampleAPIContainer.Labels["MinInstances"] = "3"
+			defer delete(sampleAPIContainer.Labels, "MinInstances")
+
+			So(ToService(sampleAPIContainer, "127.0.0.1").MinInstances, ShouldEqual, 3)
+		})
+
+		Convey("Reads the namespace", func() {
+			So(ToService(sampleAPIContainer, "127.0.0.1").Namespace, ShouldEqual, "")
+
+			sampleAPIContainer.Labels["Namespace"] = " staging "
+			defer delete(sampleAPIContainer.Labels, "Namespace")
+
+			So(ToService(sampleAPIContainer, "127.0.0.1").Namespace, ShouldEqual, "staging")
+		})
+
+		Convey("Reads whether it's health checked through the proxy", func() {
+			So(ToService(sampleAPIContainer, "127.0.0.1").ProxyChecked, ShouldBeFalse)
+
+			sampleAPIContainer.Labels["HealthCheckThroughProxy"] = "true"
+			defer delete(sampleAPIContainer.Labels, "HealthCheckThroughProxy")
+
+			So(ToService(sampleAPIContainer, "127.0.0.1").ProxyChecked, ShouldBeTrue)
+		})
+
+		Convey("Reads whether the service is proxied", func() {
+			So(ToService(sampleAPIContainer, "127.0.0.1").ProxyDisabled, ShouldBeFalse)
+
+			sampleAPIContainer.Labels["SidecarProxy"] = "false"
+			defer delete(sampleAPIContainer.Labels, "SidecarProxy")
+
+			service := ToService(sampleAPIContainer, "127.0.0.1")
+			So(service.ProxyDisabled, ShouldBeTrue)
+			So(service.IsProxied(), ShouldBeFalse)
+		})
+
+		Convey("Reads the balance algorithm", func() {
+			sampleAPIContainer.Labels["BalanceAlgorithm"] = "LeastConn"
+			defer delete(sampleAPIContainer.Labels, "BalanceAlgorithm")
+
+			So(ToService(sampleAPIContainer, "127.0.0.1").BalanceAlgorithm, ShouldEqual, "leastconn")
+
+			sampleAPIContainer.Labels["BalanceAlgorithm"] = "random"
+			So(ToService(sampleAPIContainer, "127.0.0.1").BalanceAlgorithm, ShouldBeEmpty)
+		})
+	})
+
+	Convey("ToServiceOnNetwork()", t, func() {
+		Convey("Advertises private ports on the network address", func() {
+			service := ToServiceOnNetwork(sampleAPIContainer, "10.0.9.4")
+			So(service.ID, ShouldEqual, sampleAPIContainer.ID[:12])
+			So(service.ProxyMode, ShouldEqual, "tcp")
+			So(service.Ports, ShouldResemble, []Port{
+				{Type: "tcp", Port: 9990, ServicePort: 0, IP: "10.0.9.4"},
+				{Type: "tcp", Port: 8080, ServicePort: 17010, IP: "10.0.9.4"},
+			})
+		})
+	})
+}
+
+func Test_EncodeDecode(t *testing.T) {
+	Convey("Encode() and Decode()", t, func() {
+		svc := &Service{
+			ID:          "deadbeef001",
+			Name:        "fabulous",
+			Ports:       []Port{{"tcp", 8173, 8080, "127.0.0.1"}},
+			CheckOutput: "HTTP/1.1 503 Service Unavailable",
+			TLSHosts:    []string{"fabulous.example.com"},
+			Zone:        "us-east-1a",
+
+			SidecarVersion:       "blue",
+			MaxConnections:       100,
+			MaxPendingRequests:   10,
+			MaxConsecutiveErrors: 5,
+			ProxyDisabled:        true,
+			BalanceAlgorithm:     "leastconn",
+			MinInstances:         2,
+			Namespace:            "staging",
+			ProxyChecked:         true,
+		}
+
+		Convey("Round trip the optional fields", func() {
+			encoded, err := svc.Encode()
+			So(err, ShouldBeNil)
+
+			decoded, err := Decode(encoded)
+			So(err, ShouldBeNil)
+			So(decoded.CheckOutput, ShouldEqual, svc.CheckOutput)
+			So(decoded.TLSHosts, ShouldResemble, svc.TLSHosts)
+			So(decoded.Zone, ShouldEqual, svc.Zone)
+			So(decoded.SidecarVersion, ShouldEqual, svc.SidecarVersion)
+			So(decoded.MaxConnections, ShouldEqual, svc.MaxConnections)
+			So(decoded.MaxPendingRequests, ShouldEqual, svc.MaxPendingRequests)
+			So(decoded.MaxConsecutiveErrors, ShouldEqual, svc.MaxConsecutiveErrors)
+			So(decoded.ProxyDisabled, ShouldBeTrue)
+			So(decoded.BalanceAlgorithm, ShouldEqual, svc.BalanceAlgorithm)
+			So(decoded.MinInstances, ShouldEqual, svc.MinInstances)
+			So(decoded.Namespace, ShouldEqual, svc.Namespace)
+			So(decoded.ProxyChecked, ShouldBeTrue)
+		})
+
+		Convey("Leave out the optional fields when empty", func() {
+			svc.CheckOutput = ""
+			svc.TLSHosts = nil
+			svc.Zone = ""
+			svc.SidecarVersion = ""
+			svc.MaxConnections = 0
+			svc.MaxPendingRequests = 0
+			svc.MaxConsecutiveErrors = 0
+			svc.ProxyDisabled = false
+			svc.BalanceAlgorithm = ""
+			svc.MinInstances = 0
+			svc.Namespace = ""
+			svc.ProxyChecked = false
+
+			encoded, err := svc.Encode()
+			So(err, ShouldBeNil)
+			So(string(encoded), ShouldNotContainSubstring, "CheckOutput")
+			So(string(encoded), ShouldNotContainSubstring, "TLSHosts")
+			So(string(encoded), ShouldNotContainSubstring, "Zone")
+			So(string(encoded), ShouldNotContainSubstring, "SidecarVersion")
+			So(string(encoded), ShouldNotContainSubstring, "Max")
+			So(string(encoded), ShouldNotContainSubstring, "ProxyDisabled")
+			So(string(encoded), ShouldNotContainSubstring, "BalanceAlgorithm")
+			So(string(encoded), ShouldNotContainSubstring, "MinInstances")
+			So(string(encoded), ShouldNotContainSubstring, "Namespace")
+			So(string(encoded), ShouldNotContainSubstring, "ProxyChecked")
+		})
 	})
 }
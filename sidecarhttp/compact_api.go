@@ -0,0 +1,132 @@
+package sidecarhttp
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/Nitro/sidecar/service"
+	log "github.com/sirupsen/logrus"
+)
+
+// This file implements a compact view of the catalog for proxy controllers
+// that poll it often: just where to send the traffic for each service.
+
+const (
+	COMPACT_MAX_WEIGHT = 1000 // The weight we scale traffic splits to
+)
+
+// A CompactEndpoint is one healthy instance to send a service port's traffic to
+type CompactEndpoint struct {
+	IP          string `json:"ip"`
+	Port        int64  `json:"port"`
+	ServicePort int64  `json:"service_port"`
+	Weight      int    `json:"weight"`
+}
+
+// compactState maps each service name in a namespace to the endpoints a
+// proxy should send its traffic to. Instances that are unhealthy, not
+// proxied, pinned out, or that a traffic split sends nothing to are left
+// out. Instances without a traffic split all get a weight of 1, unless we
+// route by host pressure. Services with fewer instances than their
+// MinInstances have no endpoints, so the proxy fails closed. Endpoints are
+// sorted so that the output only changes when the endpoints do.
+func (s *SidecarApi) compactState(namespace string) map[string][]CompactEndpoint {
+	result := make(map[string][]CompactEndpoint)
+
+	s.state.RLock()
+	defer s.state.RUnlock()
+
+	s.state.EachService(func(hostname *string, id *string, svc *service.Service) {
+		if svc.Namespace != namespace || !svc.IsProxied() || s.state.PinnedOut(svc) {
+			return
+		}
+
+		weight := s.state.ProxyWeight(svc, COMPACT_MAX_WEIGHT)
+		if weight == 0 {
+			return
+		}
+		if weight < 0 {
+			weight = 1
+		}
+
+		for _, port := range svc.Ports {
+			if port.ServicePort < 1 {
+				continue
+			}
+
+			result[svc.Name] = append(result[svc.Name], CompactEndpoint{
+				IP:          port.IP,
+				Port:        port.Port,
+				ServicePort: port.ServicePort,
+				Weight:      weight,
+			})
+		}
+	})
+
+	// Closed services are listed even with no instances left to proxy, so
+	// the proxy keeps failing them closed
+	for name := range s.state.ClosedServicesByName(namespace) {
+		result[name] = []CompactEndpoint{}
+	}
+
+	for _, endpoints := range result {
+		sort.Slice(endpoints, func(i, j int) bool {
+			if endpoints[i].ServicePort != endpoints[j].ServicePort {
+				return endpoints[i].ServicePort < endpoints[j].ServicePort
+			}
+			if endpoints[i].IP != endpoints[j].IP {
+				return endpoints[i].IP < endpoints[j].IP
+			}
+			return endpoints[i].Port < endpoints[j].Port
+		})
+	}
+
+	return result
+}
+
+// compactStateHandler returns the healthy endpoints of every service, for
+// proxy controllers. It serves this node's namespace unless the request asks
+// for another in the "namespace" GET parameter. It sends an ETag, and a 304
+// with no body when the client already has the current version, so polling
+// it is cheap.
+func (s *SidecarApi) compactStateHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	namespace, ok := namespaceParam(req)
+	if !ok && s.config != nil {
+		namespace = s.config.Namespace
+	}
+
+	jsonBytes, err := json.Marshal(s.compactState(namespace))
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	// Weak, because the body may be gzipped
+	etag := fmt.Sprintf(`W/"%x"`, sha1.Sum(jsonBytes))
+
+	response.Header().Set("ETag", etag)
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Access-Control-Allow-Methods", "GET")
+
+	if req.Header.Get("If-None-Match") == etag {
+		response.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	err = writeCompressed(response, req, jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing compact state response to client: %s", err)
+	}
+}
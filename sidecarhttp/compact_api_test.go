@@ -0,0 +1,123 @@
+package sidecarhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_compactStateHandler(t *testing.T) {
+	Convey("When invoking the compactState handler", t, func() {
+		state := catalog.NewServicesState()
+		state.Broadcasts = make(chan [][]byte, 10)
+		api := &SidecarApi{state: state}
+
+		addService := func(id string, image string, ip string, port int64, status int) {
+			state.AddServiceEntry(service.Service{
+				ID: id, Name: "bocaccio", Image: image, Hostname: "chaucer-" + id,
+				Status: status, Updated: time.Now().UTC(),
+				Ports: []service.Port{{IP: ip, Port: port, ServicePort: 10100, Type: "tcp"}},
+			})
+		}
+		addService("deadbeef001", "bocaccio:v1", "10.0.0.2", 31000, service.ALIVE)
+		addService("deadbeef002", "bocaccio:v1", "10.0.0.1", 31001, service.ALIVE)
+		addService("deadbeef003", "bocaccio:v1", "10.0.0.3", 31002, service.UNHEALTHY)
+
+		getCompactFrom := func(url string, etag string) (int, http.Header, map[string][]CompactEndpoint) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, url, nil)
+			if etag != "" {
+				req.Header.Set("If-None-Match", etag)
+			}
+			api.compactStateHandler(recorder, req, nil)
+
+			status, headers, body := getResult(recorder)
+			var result map[string][]CompactEndpoint
+			_ = json.Unmarshal([]byte(body), &result)
+			return status, *headers, result
+		}
+
+		getCompact := func(etag string) (int, http.Header, map[string][]CompactEndpoint) {
+			return getCompactFrom("/state/compact", etag)
+		}
+
+		Convey("Returns the healthy endpoints of each service, sorted", func() {
+			status, headers, result := getCompact("")
+			So(status, ShouldEqual, 200)
+			So(headers.Get("ETag"), ShouldNotBeEmpty)
+			So(result["bocaccio"], ShouldResemble, []CompactEndpoint{
+				{IP: "10.0.0.1", Port: 31001, ServicePort: 10100, Weight: 1},
+				{IP: "10.0.0.2", Port: 31000, ServicePort: 10100, Weight: 1},
+			})
+		})
+
+		Convey("Returns a 304 when the client is up to date", func() {
+			_, headers, _ := getCompact("")
+			status, _, _ := getCompact(headers.Get("ETag"))
+			So(status, ShouldEqual, 304)
+
+			Convey("and the new version when something changed", func() {
+				addService("deadbeef003", "bocaccio:v1", "10.0.0.3", 31002, service.ALIVE)
+
+				status, newHeaders, result := getCompact(headers.Get("ETag"))
+				So(status, ShouldEqual, 200)
+				So(newHeaders.Get("ETag"), ShouldNotEqual, headers.Get("ETag"))
+				So(len(result["bocaccio"]), ShouldEqual, 3)
+			})
+		})
+
+		Convey("Fails closed for services short of their minimum instances", func() {
+			state.Servers["chaucer-deadbeef001"].Services["deadbeef001"].MinInstances = 3
+
+			_, _, result := getCompact("")
+			So(result["bocaccio"], ShouldBeEmpty)
+			So(result, ShouldContainKey, "bocaccio")
+
+			Convey("even with no instances left to proxy", func() {
+				state.EachService(func(hostname *string, id *string, svc *service.Service) {
+					svc.Status = service.UNHEALTHY
+				})
+
+				_, _, result := getCompact("")
+				So(result["bocaccio"], ShouldBeEmpty)
+				So(result, ShouldContainKey, "bocaccio")
+			})
+		})
+
+		Convey("Only returns the endpoints in one namespace", func() {
+			state.Servers["chaucer-deadbeef001"].Services["deadbeef001"].Namespace = "staging"
+
+			_, _, result := getCompact("")
+			So(result["bocaccio"], ShouldResemble, []CompactEndpoint{
+				{IP: "10.0.0.1", Port: 31001, ServicePort: 10100, Weight: 1},
+			})
+
+			_, _, result = getCompactFrom("/state/compact?namespace=staging", "")
+			So(result["bocaccio"], ShouldResemble, []CompactEndpoint{
+				{IP: "10.0.0.2", Port: 31000, ServicePort: 10100, Weight: 1},
+			})
+
+			api.config = &HttpConfig{Namespace: "staging"}
+			_, _, result = getCompact("")
+			So(result["bocaccio"], ShouldHaveLength, 1)
+			So(result["bocaccio"][0].IP, ShouldEqual, "10.0.0.2")
+		})
+
+		Convey("Weights the endpoints by the traffic split", func() {
+			addService("deadbeef004", "bocaccio:v2", "10.0.0.4", 31003, service.ALIVE)
+			state.SetTrafficSplit("", "bocaccio", &catalog.TrafficSplit{
+				Weights: map[string]int{"v1": 1, "v2": 0}, Updated: time.Now().UTC(),
+			})
+
+			_, _, result := getCompact("")
+			So(len(result["bocaccio"]), ShouldEqual, 2)
+			So(result["bocaccio"][0].Weight, ShouldEqual, COMPACT_MAX_WEIGHT/2)
+		})
+	})
+}
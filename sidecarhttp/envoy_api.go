@@ -17,7 +17,8 @@ import (
 )
 
 // This file implements the Envoy proxy V1 API on top of a Sidecar
-// service discovery cluster.
+// service discovery cluster. It only serves the services in the namespace
+// set in the HttpConfig.
 
 // Envoy API definitions --------------------------------------------------
 
@@ -135,8 +136,15 @@ func (s *EnvoyApi) registrationHandler(response http.ResponseWriter, req *http.R
 	func() {
 		s.state.RLock()
 		defer s.state.RUnlock()
+
+		// Fail closed for services short of their MinInstances
+		if s.state.ClosedServicesByName(s.config.Namespace)[svcName] != nil {
+			return
+		}
+
 		s.state.EachService(func(hostname *string, id *string, svc *service.Service) {
-			if svc.Name == svcName && svc.IsAlive() {
+			if svc.Name == svcName && svc.Namespace == s.config.Namespace &&
+				svc.IsProxied() && !s.state.PinnedOut(svc) {
 				newInstance := s.EnvoyServiceFromService(svc, svcPort)
 				if newInstance != nil {
 					instances = append(instances, newInstance)
@@ -270,6 +278,16 @@ func (s *EnvoyApi) EnvoyServiceFromService(svc *service.Service, svcPort int64)
 	return nil
 }
 
+// lbTypeFor returns the V1 API load balancer type for a service. The V1 API
+// can't hash on the client address without a route hash policy, so services
+// balanced by source get round robin here.
+func lbTypeFor(svc *service.Service) string {
+	if svc.BalanceAlgorithm == "leastconn" {
+		return "least_request"
+	}
+	return "round_robin"
+}
+
 // EnvoyClustersFromState genenerates a set of Envoy API cluster
 // definitions from Sidecar state
 func (s *EnvoyApi) EnvoyClustersFromState() []*EnvoyCluster {
@@ -278,20 +296,14 @@ func (s *EnvoyApi) EnvoyClustersFromState() []*EnvoyCluster {
 	s.state.RLock()
 	defer s.state.RUnlock()
 
-	svcs := s.state.ByService()
+	svcs := s.state.ByServiceIn(s.config.Namespace)
+	closed := s.state.ClosedServicesByName(s.config.Namespace)
 	for svcName, endpoints := range svcs {
 		if len(endpoints) < 1 {
 			continue
 		}
 
-		var svc *service.Service
-		for _, endpoint := range endpoints {
-			if endpoint.IsAlive() {
-				svc = endpoint
-				break
-			}
-		}
-
+		svc := definingInstance(endpoints, closed[svcName] != nil)
 		if svc == nil {
 			continue
 		}
@@ -305,7 +317,7 @@ func (s *EnvoyApi) EnvoyClustersFromState() []*EnvoyCluster {
 				Name:             adapter.SvcName(svcName, port.ServicePort),
 				Type:             "sds", // use Sidecar's SDS endpoint for the hosts
 				ConnectTimeoutMs: 500,
-				LBType:           "round_robin", // TODO figure this out!
+				LBType:           lbTypeFor(svc),
 				ServiceName:      adapter.SvcName(svcName, port.ServicePort),
 			})
 		}
@@ -314,6 +326,30 @@ func (s *EnvoyApi) EnvoyClustersFromState() []*EnvoyCluster {
 	return clusters
 }
 
+// definingInstance finds the first proxied instance of a service, to use as
+// the definition of its clusters and listeners. Closed services fall back to
+// any instance that isn't tombstoned, so they keep failing closed with no
+// instances left to proxy. Returns nil when there's nothing to define.
+func definingInstance(endpoints []*service.Service, isClosed bool) *service.Service {
+	for _, endpoint := range endpoints {
+		if endpoint.IsProxied() {
+			return endpoint
+		}
+	}
+
+	if !isClosed {
+		return nil
+	}
+
+	for _, endpoint := range endpoints {
+		if !endpoint.IsTombstone() {
+			return endpoint
+		}
+	}
+
+	return nil
+}
+
 // EnvoyListenerFromService takes a Sidecar service and formats it into
 // the API format for an Envoy proxy listener (LDS API v1)
 func (s *EnvoyApi) EnvoyListenerFromService(svc *service.Service, port int64) *EnvoyListener {
@@ -384,23 +420,17 @@ func (s *EnvoyApi) EnvoyListenersFromState() []*EnvoyListener {
 	s.state.RLock()
 	defer s.state.RUnlock()
 
-	svcs := s.state.ByService()
+	svcs := s.state.ByServiceIn(s.config.Namespace)
+	conflicts := s.state.PortConflictsIn(s.config.Namespace)
+	closed := s.state.ClosedServicesByName(s.config.Namespace)
 	// Loop over all the services by service name
-	for _, endpoints := range svcs {
+	for svcName, endpoints := range svcs {
 		if len(endpoints) < 1 {
 			continue
 		}
 
-		var svc *service.Service
-		// Find the first alive service and use that as the definition.
-		// If none are alive, we won't open the port.
-		for _, endpoint := range endpoints {
-			if endpoint.IsAlive() {
-				svc = endpoint
-				break
-			}
-		}
-
+		// If none are alive, we won't open the port
+		svc := definingInstance(endpoints, closed[svcName] != nil)
 		if svc == nil {
 			continue
 		}
@@ -408,8 +438,8 @@ func (s *EnvoyApi) EnvoyListenersFromState() []*EnvoyListener {
 		// Loop over the ports and generate a named listener for
 		// each port.
 		for _, port := range svc.Ports {
-			// Only listen on ServicePorts
-			if port.ServicePort < 1 {
+			// Only listen on ServicePorts, and only once on each of them
+			if port.ServicePort < 1 || catalog.ConflictLoser(conflicts, svc.Name, port.ServicePort) {
 				continue
 			}
 
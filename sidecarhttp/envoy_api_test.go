@@ -95,6 +95,26 @@ func Test_clustersHandler(t *testing.T) {
 			So(body, ShouldNotContainSubstring, "dante")
 		})
 
+		Convey("uses the service's balance algorithm", func() {
+			balanced := svc
+			balanced.BalanceAlgorithm = "leastconn"
+			api.state = catalog.NewServicesState()
+			api.state.AddServiceEntry(balanced)
+
+			api.clustersHandler(recorder, req, nil)
+			_, _, body := getResult(recorder)
+
+			So(body, ShouldContainSubstring, `"lb_type":"least_request"`)
+		})
+
+		Convey("only includes the services in its namespace", func() {
+			api.config.Namespace = "staging"
+			api.clustersHandler(recorder, req, nil)
+			_, _, body := getResult(recorder)
+
+			So(body, ShouldNotContainSubstring, "bocaccio")
+		})
+
 		Convey("returns empty clusters for empty state", func() {
 			api := &EnvoyApi{state: catalog.NewServicesState(), config: &HttpConfig{BindIP: bindIP}}
 			api.clustersHandler(recorder, req, nil)
@@ -222,6 +242,18 @@ func Test_listenersHandler(t *testing.T) {
 			So(body, ShouldNotContainSubstring, "shakespeare")
 		})
 
+		Convey("returns listeners for closed services with no instances left", func() {
+			closed := state.Servers[hostname].Services[svcId2]
+			closed.MinInstances = 2
+			Reset(func() { closed.MinInstances = 0 })
+
+			api.listenersHandler(recorder, req, nil)
+			status, _, body := getResult(recorder)
+
+			So(status, ShouldEqual, 200)
+			So(body, ShouldContainSubstring, "shakespeare")
+		})
+
 		Convey("returns empty listeners for empty state", func() {
 			api := &EnvoyApi{state: catalog.NewServicesState(), config: &HttpConfig{BindIP: bindIP}}
 			api.listenersHandler(recorder, req, nil)
@@ -1,12 +1,17 @@
 package sidecarhttp
 
 import (
+	"io/fs"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
 	"time"
 
 	"github.com/Nitro/memberlist"
 	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/discovery"
+	"github.com/Nitro/sidecar/healthy"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 )
@@ -14,8 +19,26 @@ import (
 type HttpConfig struct {
 	BindIP       string
 	UseHostnames bool
+	ReadOnly     bool         // Refuse requests that would modify the catalog
+	Listener     net.Listener // Serve on this rather than on HTTP_ADDRESS
+	Registry     *catalog.ListenerRegistry
+	Namespace    string // The namespace the proxy endpoints serve by default
+	Assets       fs.FS  // Has the UI in ui/app and views/static. Defaults to the working directory.
+
+	// Protects the endpoints that change the catalog or run things for
+	// other nodes: service updates, delegated checks, restores, traffic
+	// splits, pins, and listeners. They are refused when it isn't set.
+	ApiToken string
+
+	// Returns the catalog that delegated checks may reach into, when we
+	// don't hold it ourselves, as on agents
+	Catalog func() (*catalog.ServicesState, error)
 }
 
+const (
+	HTTP_ADDRESS = "0.0.0.0:7777" // Where we serve the UI and the API by default
+)
+
 func makeHandler(fn func(http.ResponseWriter, *http.Request,
 	*memberlist.Memberlist, *catalog.ServicesState, map[string]string),
 	list *memberlist.Memberlist, state *catalog.ServicesState) http.HandlerFunc {
@@ -53,12 +76,29 @@ func uiRedirectHandler(response http.ResponseWriter, req *http.Request) {
 	http.Redirect(response, req, "/ui/", 301)
 }
 
-func ServeHttp(list *memberlist.Memberlist, state *catalog.ServicesState, config *HttpConfig) {
+// subAssets returns the part of the assets under dir
+func subAssets(assets fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(assets, dir)
+	if err != nil {
+		log.Fatalf("Can't serve the UI from %s: %s", dir, err)
+	}
+
+	return sub
+}
+
+// ServeHttp starts serving the UI and the API in the background. The server
+// is returned so that it can be shut down gracefully.
+func ServeHttp(list *memberlist.Memberlist, state *catalog.ServicesState, monitor *healthy.Monitor, disco discovery.Discoverer, config *HttpConfig) *http.Server {
 	srvrsHandle := makeHandler(serversHandler, list, state)
-	staticFs := http.FileServer(http.Dir("views/static"))
-	uiFs := http.FileServer(http.Dir("ui/app"))
 
-	api := &SidecarApi{state: state, list: list}
+	assets := config.Assets
+	if assets == nil {
+		assets = os.DirFS(".")
+	}
+	staticFs := http.FileServer(http.FS(subAssets(assets, "views/static")))
+	uiFs := http.FileServer(http.FS(subAssets(assets, "ui/app")))
+
+	api := &SidecarApi{state: state, list: list, monitor: monitor, disco: disco, config: config}
 	envoyApi := &EnvoyApi{state: state, list: list, config: config}
 
 	router := mux.NewRouter()
@@ -77,8 +117,39 @@ func ServeHttp(list *memberlist.Memberlist, state *catalog.ServicesState, config
 
 	http.Handle("/", router)
 
-	err := http.ListenAndServe("0.0.0.0:7777", nil)
-	if err != nil {
-		log.Fatalf("Can't start HTTP server: %s", err)
+	return serve(&http.Server{}, config)
+}
+
+// ServeAgentHttp starts serving the API of the agent role in the background.
+// Agents don't hold the catalog, so they only run the checks that other
+// nodes delegate to them, against the catalog from config.Catalog.
+func ServeAgentHttp(monitor *healthy.Monitor, config *HttpConfig) *http.Server {
+	api := &SidecarApi{monitor: monitor, config: config}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/checks/run", wrap(api.mutating(api.authenticated(api.runCheckHandler)))).Methods("POST")
+
+	return serve(&http.Server{Handler: router}, config)
+}
+
+// serve runs the server in the background, on the configured listener or
+// HTTP_ADDRESS
+func serve(server *http.Server, config *HttpConfig) *http.Server {
+	listener := config.Listener
+	if listener == nil {
+		var err error
+		listener, err = net.Listen("tcp", HTTP_ADDRESS)
+		if err != nil {
+			log.Fatalf("Can't start HTTP server: %s", err)
+		}
 	}
+
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Can't start HTTP server: %s", err)
+		}
+	}()
+
+	return server
 }
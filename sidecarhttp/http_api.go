@@ -1,26 +1,46 @@
 package sidecarhttp
 
 import (
+	"compress/gzip"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Nitro/memberlist"
 	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/discovery"
+	"github.com/Nitro/sidecar/healthy"
 	"github.com/Nitro/sidecar/service"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	MIN_COMPRESS_SIZE = 1024 // Responses smaller than this aren't worth compressing
+)
+
 type ApiServer struct {
 	Name         string
+	DisplayName  string            `json:",omitempty"`
+	Metadata     map[string]string `json:",omitempty"`
 	LastUpdated  time.Time
 	ServiceCount int
 }
 
+// The parts of a cluster member's node metadata we pass on in the API
+type memberMetadata struct {
+	DisplayName string
+	Metadata    map[string]string
+}
+
 type ApiServices struct {
 	Services       map[string][]*service.Service
 	ClusterMembers map[string]*ApiServer `json:",omitempty"`
@@ -28,22 +48,92 @@ type ApiServices struct {
 }
 
 type SidecarApi struct {
-	list  *memberlist.Memberlist
-	state *catalog.ServicesState
+	list    *memberlist.Memberlist
+	state   *catalog.ServicesState
+	monitor *healthy.Monitor
+	disco   discovery.Discoverer
+	config  *HttpConfig
 }
 
 func (s *SidecarApi) HttpMux() http.Handler {
 	router := mux.NewRouter()
 	router.HandleFunc("/services/{name}.{extension}", wrap(s.oneServiceHandler)).Methods("GET")
-	router.HandleFunc("/services/{id}/drain", wrap(s.drainServiceHandler)).Methods("POST")
+	router.HandleFunc("/services/{id}/drain", wrap(s.mutating(s.drainServiceHandler))).Methods("POST")
+	router.HandleFunc("/services/{name}/traffic", wrap(s.trafficHandler)).Methods("GET")
+	router.HandleFunc("/services/{name}/traffic", wrap(s.mutating(s.authenticated(s.setTrafficHandler)))).Methods("POST")
+	router.HandleFunc("/services/{name}/pin", wrap(s.pinHandler)).Methods("GET")
+	router.HandleFunc("/services/{name}/pin", wrap(s.mutating(s.authenticated(s.setPinHandler)))).Methods("POST")
+	router.HandleFunc("/services/update", wrap(s.mutating(s.authenticated(s.updateServicesHandler)))).Methods("POST")
+	router.HandleFunc("/checks/run", wrap(s.mutating(s.authenticated(s.runCheckHandler)))).Methods("POST")
+	router.HandleFunc("/admin/snapshot", wrap(s.snapshotHandler)).Methods("GET")
+	router.HandleFunc("/admin/restore", wrap(s.mutating(s.authenticated(s.restoreHandler)))).Methods("POST")
 	router.HandleFunc("/services.{extension}", wrap(s.servicesHandler)).Methods("GET")
 	router.HandleFunc("/state.{extension}", wrap(s.stateHandler)).Methods("GET")
+	router.HandleFunc("/state/compact", wrap(s.compactStateHandler)).Methods("GET")
 	router.HandleFunc("/watch", wrap(s.watchHandler)).Methods("GET")
+	router.HandleFunc("/events", wrap(s.eventsHandler)).Methods("GET")
+	router.HandleFunc("/v1/warnings", wrap(s.warningsHandler)).Methods("GET")
+	router.HandleFunc("/v1/diagnostics", wrap(s.diagnosticsHandler)).Methods("GET")
+	router.HandleFunc("/v1/departures", wrap(s.departuresHandler)).Methods("GET")
+	router.HandleFunc("/v1/availability", wrap(s.availabilityHandler)).Methods("GET")
+	router.HandleFunc("/v1/outages", wrap(s.outagesHandler)).Methods("GET")
+	router.HandleFunc("/v1/closed", wrap(s.closedHandler)).Methods("GET")
+	router.HandleFunc("/v1/conflicts", wrap(s.conflictsHandler)).Methods("GET")
+	router.HandleFunc("/v1/inventory", wrap(s.inventoryHandler)).Methods("GET")
+	router.HandleFunc("/v1/checks/types", wrap(s.checkTypesHandler)).Methods("GET")
+	router.HandleFunc("/v1/listeners", wrap(s.authenticated(s.listenersHandler))).Methods("GET")
+	router.HandleFunc("/v1/listeners", wrap(s.mutating(s.authenticated(s.addListenerHandler)))).Methods("POST")
+	router.HandleFunc("/v1/listeners/{name}", wrap(s.mutating(s.authenticated(s.removeListenerHandler)))).Methods("DELETE")
 	router.HandleFunc("/{path}", s.optionsHandler).Methods("OPTIONS")
 
 	return router
 }
 
+// mutating wraps handlers that modify the catalog so that they are refused
+// when the API is configured to be read-only.
+func (s *SidecarApi) mutating(fn func(http.ResponseWriter, *http.Request, map[string]string)) func(http.ResponseWriter, *http.Request, map[string]string) {
+	return func(response http.ResponseWriter, req *http.Request, params map[string]string) {
+		if s.config != nil && s.config.ReadOnly {
+			sendJsonError(response, 403, "Forbidden - API is in read-only mode")
+			return
+		}
+
+		fn(response, req, params)
+	}
+}
+
+// authenticated wraps handlers that need the API token, sent as a bearer
+// token in the Authorization header. Without a token configured, they are
+// refused altogether.
+func (s *SidecarApi) authenticated(fn func(http.ResponseWriter, *http.Request, map[string]string)) func(http.ResponseWriter, *http.Request, map[string]string) {
+	return func(response http.ResponseWriter, req *http.Request, params map[string]string) {
+		if s.config == nil || s.config.ApiToken == "" {
+			sendJsonError(response, 403, "Forbidden - No API token is configured")
+			return
+		}
+
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.ApiToken)) != 1 {
+			sendJsonError(response, 401, "Unauthorized - Missing or bad API token")
+			return
+		}
+
+		fn(response, req, params)
+	}
+}
+
+// namespaceParam returns the namespace passed in the "namespace" GET
+// parameter, and whether there was one. An empty one is the default
+// namespace.
+func namespaceParam(req *http.Request) (string, bool) {
+	values, ok := req.URL.Query()["namespace"]
+	if !ok || len(values) < 1 {
+		return "", false
+	}
+
+	return values[0], true
+}
+
 // optionsHandler sends CORS headers
 func (s *SidecarApi) optionsHandler(response http.ResponseWriter, req *http.Request) {
 	response.Header().Set("Access-Control-Allow-Origin", "*")
@@ -53,6 +143,7 @@ func (s *SidecarApi) optionsHandler(response http.ResponseWriter, req *http.Requ
 // watchHandler takes an optional GET parameter, "by_service"
 // By default, watchHandler returns `json.Marshal(state.ByService())` payloads
 // If the client passes "by_service=false", watchHandler returns `json.Marshal(state)` payloads
+// With a "namespace" parameter, the by service payloads only have the services in that namespace
 func (s *SidecarApi) watchHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
 	defer req.Body.Close()
 
@@ -74,13 +165,18 @@ func (s *SidecarApi) watchHandler(response http.ResponseWriter, req *http.Reques
 	if req.URL.Query().Get("by_service") == "false" {
 		byService = false
 	}
+	namespace, filtered := namespaceParam(req)
 
 	pushUpdate := func() error {
 		var jsonBytes []byte
 		if byService {
 			s.state.RLock()
 			var err error
-			jsonBytes, err = json.Marshal(s.state.ByService())
+			if filtered {
+				jsonBytes, err = json.Marshal(s.state.ByServiceIn(namespace))
+			} else {
+				jsonBytes, err = json.Marshal(s.state.ByService())
+			}
 			s.state.RUnlock()
 
 			if err != nil {
@@ -130,8 +226,373 @@ func (s *SidecarApi) watchHandler(response http.ResponseWriter, req *http.Reques
 	}
 }
 
+// ApiEvents is the response from the events endpoint. When Complete is false
+// the client missed events that are no longer available, and should fetch
+// the whole state again.
+type ApiEvents struct {
+	Events       []catalog.ChangeEvent
+	LastSequence uint64
+	Complete     bool
+}
+
+// eventsHandler replays the change events after the sequence number passed
+// in the "since" GET parameter, so a listener that missed some can catch up.
+func (s *SidecarApi) eventsHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	var since uint64
+	if param := req.URL.Query().Get("since"); param != "" {
+		var err error
+		since, err = strconv.ParseUint(param, 10, 64)
+		if err != nil {
+			sendJsonError(response, 400, fmt.Sprintf("Bad Request - Invalid sequence number %q", param))
+			return
+		}
+	}
+
+	events, last, complete := s.state.EventsSince(since)
+	result := ApiEvents{
+		Events:       events,
+		LastSequence: last,
+		Complete:     complete,
+	}
+
+	jsonBytes, err := json.Marshal(&result)
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Access-Control-Allow-Methods", "GET")
+
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing events response to client: %s", err)
+	}
+}
+
+// ApiWarnings is the response from the warnings endpoint
+type ApiWarnings struct {
+	Warnings []discovery.LabelWarning
+}
+
+// warningsHandler returns the malformed Sidecar labels that discovery found
+// on the containers running on this host.
+func (s *SidecarApi) warningsHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	result := ApiWarnings{Warnings: []discovery.LabelWarning{}}
+	if reporter, ok := s.disco.(discovery.WarningReporter); ok {
+		if warnings := reporter.Warnings(); len(warnings) > 0 {
+			result.Warnings = warnings
+		}
+	}
+
+	jsonBytes, err := json.Marshal(&result)
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Access-Control-Allow-Methods", "GET")
+
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing warnings response to client: %s", err)
+	}
+}
+
+// ApiDiagnostics is the response from the diagnostics endpoint
+type ApiDiagnostics struct {
+	Docker *discovery.DockerVersion `json:",omitempty"`
+}
+
+// diagnosticsHandler returns what we know about the environment Sidecar is
+// running in that may explain problems with discovery, like the version of
+// the Docker daemon and whether we can work with it.
+func (s *SidecarApi) diagnosticsHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	var result ApiDiagnostics
+	if reporter, ok := s.disco.(discovery.DockerVersionReporter); ok {
+		result.Docker = reporter.DockerVersion()
+	}
+
+	jsonBytes, err := json.Marshal(&result)
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Access-Control-Allow-Methods", "GET")
+
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing diagnostics response to client: %s", err)
+	}
+}
+
+// ApiDepartures is the response from the departures endpoint
+type ApiDepartures struct {
+	Departures []catalog.Departure
+}
+
+// departuresHandler returns the instances that recently left the proxies'
+// rotation, and why, most recent first. Takes an optional "name" parameter
+// to only return those of one service.
+func (s *SidecarApi) departuresHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	result := ApiDepartures{Departures: s.state.Departures(req.URL.Query().Get("name"))}
+
+	jsonBytes, err := json.Marshal(&result)
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Access-Control-Allow-Methods", "GET")
+
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing departures response to client: %s", err)
+	}
+}
+
+// ApiOutages is the response from the outages endpoint
+type ApiOutages struct {
+	Outages []catalog.Outage
+}
+
+// outagesHandler returns the services that have no alive instances anywhere
+// in the cluster, longest running first.
+func (s *SidecarApi) outagesHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	jsonBytes, err := json.Marshal(&ApiOutages{Outages: s.state.Outages()})
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Access-Control-Allow-Methods", "GET")
+
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing outages response to client: %s", err)
+	}
+}
+
+// ApiClosed is the response from the closed services endpoint
+type ApiClosed struct {
+	Services []catalog.ClosedService
+}
+
+// closedHandler returns the services the proxies are failing closed for,
+// because they have fewer instances than their MinInstances. It takes an
+// optional "namespace" GET parameter.
+func (s *SidecarApi) closedHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	closed := s.state.ClosedServices()
+	if namespace, ok := namespaceParam(req); ok {
+		inNamespace := make([]catalog.ClosedService, 0, len(closed))
+		for _, svc := range closed {
+			if svc.Namespace == namespace {
+				inNamespace = append(inNamespace, svc)
+			}
+		}
+		closed = inNamespace
+	}
+
+	jsonBytes, err := json.Marshal(&ApiClosed{Services: closed})
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Access-Control-Allow-Methods", "GET")
+
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing closed services response to client: %s", err)
+	}
+}
+
+// ApiConflicts is the response from the port conflicts endpoint
+type ApiConflicts struct {
+	Conflicts []catalog.PortConflict
+}
+
+// conflictsHandler returns the ServicePorts that more than one service
+// advertises, which the proxies only serve for the service that owns them.
+// It takes an optional "namespace" GET parameter.
+func (s *SidecarApi) conflictsHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	conflicts := s.state.PortConflicts()
+	if namespace, ok := namespaceParam(req); ok {
+		inNamespace := make([]catalog.PortConflict, 0, len(conflicts))
+		for _, conflict := range conflicts {
+			if conflict.Namespace == namespace {
+				inNamespace = append(inNamespace, conflict)
+			}
+		}
+		conflicts = inNamespace
+	}
+
+	jsonBytes, err := json.Marshal(&ApiConflicts{Conflicts: conflicts})
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Access-Control-Allow-Methods", "GET")
+
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing port conflicts response to client: %s", err)
+	}
+}
+
+// ApiCheckTypes is the response from the check types endpoint
+type ApiCheckTypes struct {
+	Types []string
+}
+
+// checkTypesHandler returns the health check types this node can run,
+// including any registered by plugins, so tools can validate labels.
+func (s *SidecarApi) checkTypesHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	jsonBytes, err := json.Marshal(&ApiCheckTypes{Types: healthy.CheckTypes()})
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Access-Control-Allow-Methods", "GET")
+
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing check types response to client: %s", err)
+	}
+}
+
+// ApiAvailability is the response from the availability endpoint. It maps
+// each service name to its availability percentage over each window. The
+// services in the default namespace are in Services, and those in any other
+// namespace are in Namespaces, by namespace.
+type ApiAvailability struct {
+	Services   map[string]map[string]float64
+	Namespaces map[string]map[string]map[string]float64 `json:",omitempty"`
+}
+
+// availabilityHandler returns the percentage of health samples each service
+// passed over the last hour, day, and week. Takes an optional "name"
+// parameter to only return that of one service, and an optional "namespace"
+// parameter for the namespace to look in.
+func (s *SidecarApi) availabilityHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	namespace, filtered := namespaceParam(req)
+
+	var names []catalog.ServiceName
+	if name := req.URL.Query().Get("name"); name != "" {
+		names = []catalog.ServiceName{{Namespace: namespace, Name: name}}
+	} else {
+		for _, name := range s.state.AvailabilityServices() {
+			if !filtered || name.Namespace == namespace {
+				names = append(names, name)
+			}
+		}
+	}
+
+	result := ApiAvailability{Services: make(map[string]map[string]float64, len(names))}
+	for _, name := range names {
+		availability := s.state.Availability(name.Namespace, name.Name)
+		if len(availability) == 0 {
+			continue
+		}
+
+		if name.Namespace == "" {
+			result.Services[name.Name] = availability
+			continue
+		}
+
+		if result.Namespaces == nil {
+			result.Namespaces = make(map[string]map[string]map[string]float64)
+		}
+		if result.Namespaces[name.Namespace] == nil {
+			result.Namespaces[name.Namespace] = make(map[string]map[string]float64)
+		}
+		result.Namespaces[name.Namespace][name.Name] = availability
+	}
+
+	jsonBytes, err := json.Marshal(&result)
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Access-Control-Allow-Methods", "GET")
+
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing availability response to client: %s", err)
+	}
+}
+
 // oneServiceHandler takes the name of a single service and returns results for just
-// that service.
+// that service. It takes an optional "namespace" GET parameter.
 func (s *SidecarApi) oneServiceHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
 	defer req.Body.Close()
 
@@ -155,12 +616,14 @@ func (s *SidecarApi) oneServiceHandler(response http.ResponseWriter, req *http.R
 		return
 	}
 
+	namespace, filtered := namespaceParam(req)
+
 	var instances []*service.Service
 	// Enter critical section
 	s.state.RLock()
 	defer s.state.RUnlock()
 	s.state.EachService(func(hostname *string, id *string, svc *service.Service) {
-		if svc.Name == name {
+		if svc.Name == name && (!filtered || svc.Namespace == namespace) {
 			instances = append(instances, svc)
 		}
 	})
@@ -198,7 +661,8 @@ func (s *SidecarApi) oneServiceHandler(response http.ResponseWriter, req *http.R
 	}
 }
 
-// serviceHandler returns the results for all the services we know about
+// serviceHandler returns the results for all the services we know about, or
+// just the ones in the namespace passed in the "namespace" GET parameter
 func (s *SidecarApi) servicesHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
 	defer req.Body.Close()
 
@@ -244,6 +708,13 @@ func (s *SidecarApi) servicesHandler(response http.ResponseWriter, req *http.Req
 					ServiceCount: 0,
 				}
 			}
+
+			// Older nodes, or ones with nothing set, don't send these
+			var meta memberMetadata
+			if json.Unmarshal(member.Meta, &meta) == nil {
+				members[member.Name].DisplayName = meta.DisplayName
+				members[member.Name].Metadata = meta.Metadata
+			}
 		}
 
 		result := ApiServices{
@@ -251,6 +722,9 @@ func (s *SidecarApi) servicesHandler(response http.ResponseWriter, req *http.Req
 			ClusterMembers: members,
 			ClusterName:    clusterName,
 		}
+		if namespace, ok := namespaceParam(req); ok {
+			result.Services = s.state.ByServiceIn(namespace)
+		}
 
 		jsonBytes, err = json.MarshalIndent(&result, "", "  ")
 	}()
@@ -261,7 +735,7 @@ func (s *SidecarApi) servicesHandler(response http.ResponseWriter, req *http.Req
 		return
 	}
 
-	_, err = response.Write(jsonBytes)
+	err = writeCompressed(response, req, jsonBytes)
 	if err != nil {
 		log.Errorf("Error writing services response to client: %s", err)
 	}
@@ -272,28 +746,94 @@ func (s *SidecarApi) servicesHandler(response http.ResponseWriter, req *http.Req
 func (s *SidecarApi) stateHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
 	defer req.Body.Close()
 
-	s.state.RLock()
-	defer s.state.RUnlock()
-
 	if params["extension"] != "json" {
 		sendJsonError(response, 404, "Not Found - Invalid content type extension")
 		return
 	}
 
+	s.state.RLock()
+	data := s.state.Encode()
+	s.state.RUnlock()
+
 	response.Header().Set("Content-Type", "application/json")
 	response.Header().Set("Access-Control-Allow-Origin", "*")
 	response.Header().Set("Access-Control-Allow-Methods", "GET")
 
-	_, err := response.Write(s.state.Encode())
+	err := writeCompressed(response, req, data)
 	if err != nil {
 		log.Errorf("Error writing state response to client: %s", err)
 	}
 }
 
+// snapshotHandler sends a snapshot of the whole catalog as a file download.
+// It can be fed back to restoreHandler to seed a cluster after an outage.
+func (s *SidecarApi) snapshotHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	s.state.RLock()
+	defer s.state.RUnlock()
+
+	filename := fmt.Sprintf("sidecar-%s-%s.json", s.state.ClusterName, time.Now().UTC().Format("20060102T150405Z"))
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	_, err := response.Write(s.state.Encode())
+	if err != nil {
+		log.Errorf("Error writing snapshot to client: %s", err)
+	}
+}
+
+// restoreHandler seeds the catalog from a snapshot taken by snapshotHandler.
+// Only services that we don't know about are restored. See
+// catalog.ServicesState.Restore() for the details.
+func (s *SidecarApi) restoreHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad Request - Unable to read body: %s", err))
+		return
+	}
+
+	snapshot, err := catalog.Decode(data)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad Request - Unable to decode snapshot: %s", err))
+		return
+	}
+
+	restored := s.state.Restore(snapshot)
+
+	jsonBytes, err := json.Marshal(map[string]int{"Restored": restored})
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing restore response to client: %s", err)
+	}
+}
+
 // drainServiceHandler instructs Sidecar to set the status of a given service
 // instance to DRAINING. This allows us to decomission the given service
 // instance and let it sit around for a short amount of time, so it can finish
-// processing the requests that are still in flight.
+// processing the requests that are still in flight. If the service has a
+// pre-stop hook configured, we run it first. Hooks can take up to
+// discovery.PreStopTimeout, so that happens in the background and we reply
+// right away.
 func (s *SidecarApi) drainServiceHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
 	defer req.Body.Close()
 
@@ -319,15 +859,27 @@ func (s *SidecarApi) drainServiceHandler(response http.ResponseWriter, req *http
 		return
 	}
 
-	svc.Updated = time.Now()
-	svc.Status = service.DRAINING
-	s.state.UpdateService(svc)
-
 	result := struct {
 		Message string
-	}{
-		Message: fmt.Sprintf("Service %q instance %q set to DRAINING", svc.Name, svc.ID),
+	}{}
+
+	if stopper, ok := s.disco.(discovery.PreStopper); ok {
+		result.Message = fmt.Sprintf(
+			"Service %q instance %q will be set to DRAINING once its pre-stop hook has run", svc.Name, svc.ID,
+		)
+
+		go func() {
+			err := stopper.PreStop(&svc)
+			if err != nil {
+				log.Warnf("Pre-stop hook failed for %s (id: %s), draining anyway: %s", svc.Name, svc.ID, err)
+			}
+			s.drain(svc)
+		}()
+	} else {
+		result.Message = fmt.Sprintf("Service %q instance %q set to DRAINING", svc.Name, svc.ID)
+		s.drain(svc)
 	}
+
 	jsonBytes, err := json.MarshalIndent(&result, "", "  ")
 	if err != nil {
 		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
@@ -342,6 +894,326 @@ func (s *SidecarApi) drainServiceHandler(response http.ResponseWriter, req *http
 	}
 }
 
+// drain sets a service instance to DRAINING
+func (s *SidecarApi) drain(svc service.Service) {
+	svc.Updated = time.Now()
+	svc.Status = service.DRAINING
+	s.state.UpdateService(svc)
+}
+
+// trafficHandler returns the current traffic split for a service. It takes
+// an optional "namespace" GET parameter, and otherwise looks in the default
+// namespace.
+func (s *SidecarApi) trafficHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	name := params["name"]
+	namespace, _ := namespaceParam(req)
+	split := s.state.GetTrafficSplit(namespace, name)
+	if split == nil {
+		sendJsonError(response, 404, fmt.Sprintf("Not Found - No traffic split for service %q", name))
+		return
+	}
+
+	sendTrafficSplit(response, 200, split)
+}
+
+// setTrafficHandler sets the traffic split between the versions of a
+// service. It takes a JSON object like {"Weights": {"v1": 90, "v2": 10}}.
+// Sending no weights clears the split. It applies to the default namespace
+// unless another is passed in the "namespace" GET parameter.
+func (s *SidecarApi) setTrafficHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	namespace, _ := namespaceParam(req)
+	if !service.IsValidNamespace(namespace) {
+		sendJsonError(response, 400, fmt.Sprintf("Bad Request - Invalid namespace %q", namespace))
+		return
+	}
+
+	var split catalog.TrafficSplit
+	err := json.NewDecoder(req.Body).Decode(&split)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad Request - Unable to decode traffic split: %s", err))
+		return
+	}
+
+	var total int
+	for version, weight := range split.Weights {
+		if weight < 0 {
+			sendJsonError(response, 400, fmt.Sprintf("Bad Request - Negative weight for version %q", version))
+			return
+		}
+		total += weight
+	}
+
+	if len(split.Weights) > 0 && total == 0 {
+		sendJsonError(response, 400, "Bad Request - At least one version must have a weight")
+		return
+	}
+
+	split.Updated = time.Now().UTC()
+	s.state.SetTrafficSplit(namespace, params["name"], &split)
+
+	sendTrafficSplit(response, 202, &split)
+}
+
+func sendTrafficSplit(response http.ResponseWriter, status int, split *catalog.TrafficSplit) {
+	jsonBytes, err := json.MarshalIndent(split, "", "  ")
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing traffic split response to client: %s", err)
+	}
+}
+
+// pinHandler returns the current pin for a service. It takes an optional
+// "namespace" GET parameter, and otherwise looks in the default namespace.
+func (s *SidecarApi) pinHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	name := params["name"]
+	namespace, _ := namespaceParam(req)
+	pin := s.state.GetPin(namespace, name)
+	if pin == nil {
+		sendJsonError(response, 404, fmt.Sprintf("Not Found - No pin for service %q", name))
+		return
+	}
+
+	sendPin(response, 200, pin)
+}
+
+// setPinHandler pins a service to some of its instances. It takes a JSON
+// object like {"Instances": ["deadbeef1234"], "Reason": "INC-42"}. Sending
+// no instances clears the pin. It applies to the default namespace unless
+// another is passed in the "namespace" GET parameter.
+func (s *SidecarApi) setPinHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	namespace, _ := namespaceParam(req)
+	if !service.IsValidNamespace(namespace) {
+		sendJsonError(response, 400, fmt.Sprintf("Bad Request - Invalid namespace %q", namespace))
+		return
+	}
+
+	var pin catalog.Pin
+	err := json.NewDecoder(req.Body).Decode(&pin)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad Request - Unable to decode pin: %s", err))
+		return
+	}
+
+	name := params["name"]
+
+	// Only pin instances we know about, so a typo can't go unnoticed
+	known := make(map[string]bool)
+	s.state.RLock()
+	s.state.EachService(func(hostname *string, id *string, svc *service.Service) {
+		if svc.Name == name && svc.Namespace == namespace {
+			known[svc.ID] = true
+		}
+	})
+	s.state.RUnlock()
+
+	for _, id := range pin.Instances {
+		if !known[id] {
+			sendJsonError(response, 400, fmt.Sprintf("Bad Request - Unknown instance %q of service %q", id, name))
+			return
+		}
+	}
+
+	pin.Updated = time.Now().UTC()
+	s.state.SetPin(namespace, name, &pin)
+
+	sendPin(response, 202, &pin)
+}
+
+func sendPin(response http.ResponseWriter, status int, pin *catalog.Pin) {
+	jsonBytes, err := json.MarshalIndent(pin, "", "  ")
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing pin response to client: %s", err)
+	}
+}
+
+// updateServicesHandler accepts a JSON array of service records from a
+// Sidecar running in the agent role. They are merged into the state exactly
+// as if they had been received over gossip.
+func (s *SidecarApi) updateServicesHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad Request - Unable to read body: %s", err))
+		return
+	}
+
+	var services []service.Service
+	err = json.Unmarshal(data, &services)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad Request - Unable to decode services: %s", err))
+		return
+	}
+
+	for _, svc := range services {
+		if svc.ID == "" || svc.Hostname == "" {
+			log.Warnf("Skipping forwarded service with no ID or Hostname: %s", svc.Name)
+			continue
+		}
+		s.state.UpdateService(svc)
+	}
+
+	response.WriteHeader(202)
+}
+
+// runCheckHandler runs a health check on behalf of another Sidecar node that
+// delegated it to us, and returns the result. We never run External or
+// Delegated checks for other nodes: the first would let anyone run commands
+// on this host, and the second could loop.
+func (s *SidecarApi) runCheckHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.monitor == nil || (s.state == nil && (s.config == nil || s.config.Catalog == nil)) {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	var check healthy.DelegatedCheck
+	err := json.NewDecoder(req.Body).Decode(&check)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad Request - Unable to decode check: %s", err))
+		return
+	}
+
+	if check.Type == "External" || check.Type == "Delegated" {
+		sendJsonError(response, 400, fmt.Sprintf("Bad Request - Check type %q can't be delegated", check.Type))
+		return
+	}
+
+	checker, ok := healthy.NewChecker(check.Type)
+	if !ok {
+		sendJsonError(response, 400, fmt.Sprintf("Bad Request - Unknown check type %q", check.Type))
+		return
+	}
+
+	// Only reach out to the services in the catalog, so we can't be used to
+	// probe anything else on the network
+	targets, ok := healthy.CheckTargets(check.Type, check.Args)
+	if !ok {
+		sendJsonError(response, 400, fmt.Sprintf("Bad Request - Can't tell what a %q check connects to", check.Type))
+		return
+	}
+
+	state := s.state
+	if s.config != nil && s.config.Catalog != nil {
+		state, err = s.config.Catalog()
+		if err != nil {
+			sendJsonError(response, 503, fmt.Sprintf("Service Unavailable - Unable to get the catalog: %s", err))
+			return
+		}
+	}
+
+	for _, target := range targets {
+		if !isServiceAddress(state, target) {
+			sendJsonError(response, 403, fmt.Sprintf("Forbidden - %s is not the address of a known service", target))
+			return
+		}
+	}
+
+	// A redirect could send us anywhere, so a 3xx is the result
+	if httpCmd, ok := checker.(*healthy.HttpGetCmd); ok {
+		httpCmd.NoRedirects = true
+	}
+
+	status, err := checker.Run(check.Args)
+	result := healthy.DelegatedResult{Status: status}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	jsonBytes, err := json.Marshal(&result)
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing check result to client: %s", err)
+	}
+}
+
+// isServiceAddress tells whether a host:port is a port of a service in the
+// catalog, by IP or by the hostname it runs on. A bare host matches any of
+// its services.
+func isServiceAddress(state *catalog.ServicesState, target string) bool {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		host, portStr = target, ""
+	}
+
+	var found bool
+	state.RLock()
+	defer state.RUnlock()
+
+	state.EachService(func(hostname *string, id *string, svc *service.Service) {
+		if found || svc.IsTombstone() {
+			return
+		}
+
+		for _, port := range svc.Ports {
+			if port.IP != host && svc.Hostname != host {
+				continue
+			}
+			if portStr == "" || strconv.FormatInt(port.Port, 10) == portStr {
+				found = true
+				return
+			}
+		}
+	})
+
+	return found
+}
+
 // Send back a JSON encoded error and message
 func sendJsonError(response http.ResponseWriter, status int, message string) {
 	output := map[string]string{
@@ -364,6 +1236,27 @@ func sendJsonError(response http.ResponseWriter, status int, message string) {
 	}
 }
 
+// writeCompressed writes a response body, gzipped if the client accepts that
+// and it's big enough to be worth it. The whole state of a large cluster
+// compresses very well, which speeds up nodes fetching it from each other.
+func writeCompressed(response http.ResponseWriter, req *http.Request, data []byte) error {
+	response.Header().Add("Vary", "Accept-Encoding")
+
+	if len(data) < MIN_COMPRESS_SIZE || !strings.Contains(req.Header.Get("Accept-Encoding"), "gzip") {
+		_, err := response.Write(data)
+		return err
+	}
+
+	response.Header().Set("Content-Encoding", "gzip")
+	writer := gzip.NewWriter(response)
+	_, err := writer.Write(data)
+	if err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
 func wrap(fn func(http.ResponseWriter, *http.Request, map[string]string)) http.HandlerFunc {
 	return func(response http.ResponseWriter, req *http.Request) {
 		fn(response, req, mux.Vars(req))
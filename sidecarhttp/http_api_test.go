@@ -1,16 +1,22 @@
 package sidecarhttp
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/discovery"
+	"github.com/Nitro/sidecar/healthy"
 	"github.com/Nitro/sidecar/service"
 	director "github.com/relistan/go-director"
 	. "github.com/smartystreets/goconvey/convey"
@@ -185,6 +191,35 @@ func Test_stateHandler(t *testing.T) {
 			So(decoded.Servers, ShouldResemble, state.Servers)
 		})
 
+		Convey("compresses a large state for clients that accept it", func() {
+			for i := 0; i < 20; i++ {
+				extra := svc
+				extra.ID = fmt.Sprintf("deadbeef%03d", i)
+				state.AddServiceEntry(extra)
+			}
+
+			req.Header.Set("Accept-Encoding", "gzip")
+			api.stateHandler(recorder, req, params)
+			resp := recorder.Result()
+
+			So(resp.StatusCode, ShouldEqual, 200)
+			So(resp.Header.Get("Content-Encoding"), ShouldEqual, "gzip")
+
+			reader, err := gzip.NewReader(resp.Body)
+			So(err, ShouldBeNil)
+			bodyBytes, _ := ioutil.ReadAll(reader)
+
+			decoded, err := catalog.Decode(bodyBytes)
+			So(err, ShouldBeNil)
+			So(len(decoded.Servers[hostname].Services), ShouldEqual, 22)
+		})
+
+		Convey("doesn't compress small responses", func() {
+			req.Header.Set("Accept-Encoding", "gzip")
+			api.stateHandler(recorder, req, params)
+
+			So(recorder.Result().Header.Get("Content-Encoding"), ShouldBeEmpty)
+		})
 	})
 }
 
@@ -255,6 +290,19 @@ func Test_servicesHandler(t *testing.T) {
 			So(err, ShouldBeNil)
 			So(len(result.Services), ShouldEqual, 2)
 		})
+
+		Convey("returns only the services in the namespace asked for", func() {
+			state.Servers[hostname].Services[svcId2].Namespace = "staging"
+
+			req = httptest.NewRequest("GET", "/services.json?namespace=staging", nil)
+			api.servicesHandler(recorder, req, params)
+			_, _, body := getResult(recorder)
+
+			var result ApiServices
+			So(json.Unmarshal([]byte(body), &result), ShouldBeNil)
+			So(len(result.Services), ShouldEqual, 1)
+			So(result.Services, ShouldContainKey, "shakespeare")
+		})
 	})
 }
 
@@ -314,6 +362,20 @@ func Test_watchHandler(t *testing.T) {
 	})
 }
 
+// preStopDiscoverer sends on stopped the service it was asked to pre-stop,
+// and then holds the hook until release is closed
+type preStopDiscoverer struct {
+	discovery.StaticDiscovery
+	stopped chan string
+	release chan struct{}
+}
+
+func (d *preStopDiscoverer) PreStop(svc *service.Service) error {
+	d.stopped <- svc.ID
+	<-d.release
+	return nil
+}
+
 func Test_drainServiceHandler(t *testing.T) {
 	Convey("When invoking the drainService handler", t, func() {
 		hostname := "chaucer"
@@ -371,6 +433,33 @@ func Test_drainServiceHandler(t *testing.T) {
 			})
 		})
 
+		Convey("Runs the pre-stop hook in the background before draining", func() {
+			disco := &preStopDiscoverer{stopped: make(chan string, 1), release: make(chan struct{})}
+			api.disco = disco
+			api.drainServiceHandler(recorder, req, params)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 202)
+			So(body, ShouldContainSubstring, "once its pre-stop hook has run")
+
+			So(<-disco.stopped, ShouldEqual, svcId)
+			So(state.Servers[hostname].Services[svcId].Status, ShouldEqual, service.ALIVE)
+
+			close(disco.release)
+			state.ProcessServiceMsgs(director.NewFreeLooper(director.ONCE, nil))
+			So(state.Servers[hostname].Services[svcId].Status, ShouldEqual, service.DRAINING)
+		})
+
+		Convey("Refuses to drain when the API is read-only", func() {
+			api.config = &HttpConfig{ReadOnly: true}
+			api.mutating(api.drainServiceHandler)(recorder, req, params)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 403)
+			So(body, ShouldContainSubstring, "read-only")
+			So(state.Servers[hostname].Services[svcId].Status, ShouldEqual, service.ALIVE)
+		})
+
 		Convey("Returns an error for non-POST requests", func() {
 			req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/services/%s/drain", svcId), nil)
 
@@ -409,3 +498,785 @@ func Test_drainServiceHandler(t *testing.T) {
 		})
 	})
 }
+
+func Test_trafficHandlers(t *testing.T) {
+	Convey("When invoking the traffic handlers", t, func() {
+		state := catalog.NewServicesState()
+		api := &SidecarApi{state: state}
+		recorder := httptest.NewRecorder()
+		params := map[string]string{"name": "bocaccio"}
+
+		setSplit := func(body string) {
+			req := httptest.NewRequest(http.MethodPost, "/services/bocaccio/traffic", bytes.NewBufferString(body))
+			api.setTrafficHandler(recorder, req, params)
+		}
+
+		Convey("Stores the traffic split in the state", func() {
+			setSplit(`{"Weights": {"v1": 90, "v2": 10}}`)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 202)
+			So(body, ShouldContainSubstring, `"v2": 10`)
+
+			split := state.GetTrafficSplit("", "bocaccio")
+			So(split, ShouldNotBeNil)
+			So(split.Weights, ShouldResemble, map[string]int{"v1": 90, "v2": 10})
+
+			Convey("and returns it", func() {
+				recorder = httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, "/services/bocaccio/traffic", nil)
+				api.trafficHandler(recorder, req, params)
+
+				status, _, body := getResult(recorder)
+				So(status, ShouldEqual, 200)
+				So(body, ShouldContainSubstring, `"v1": 90`)
+			})
+
+			Convey("and clears it when sent no weights", func() {
+				recorder = httptest.NewRecorder()
+				setSplit(`{}`)
+
+				status, _, _ := getResult(recorder)
+				So(status, ShouldEqual, 202)
+				So(state.GetTrafficSplit("", "bocaccio"), ShouldBeNil)
+			})
+		})
+
+		Convey("Returns a 404 when there is no split", func() {
+			req := httptest.NewRequest(http.MethodGet, "/services/bocaccio/traffic", nil)
+			api.trafficHandler(recorder, req, params)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 404)
+			So(body, ShouldContainSubstring, "No traffic split")
+		})
+
+		Convey("Returns an error for negative weights", func() {
+			setSplit(`{"Weights": {"v1": -1, "v2": 10}}`)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 400)
+			So(body, ShouldContainSubstring, "Negative weight")
+			So(state.GetTrafficSplit("", "bocaccio"), ShouldBeNil)
+		})
+
+		Convey("Returns an error when all the weights are zero", func() {
+			setSplit(`{"Weights": {"v1": 0}}`)
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 400)
+		})
+
+		Convey("Refuses to change the split when the API is read-only", func() {
+			api.config = &HttpConfig{ReadOnly: true}
+			req := httptest.NewRequest(http.MethodPost, "/services/bocaccio/traffic",
+				bytes.NewBufferString(`{"Weights": {"v1": 1}}`))
+			api.mutating(api.setTrafficHandler)(recorder, req, params)
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 403)
+			So(state.GetTrafficSplit("", "bocaccio"), ShouldBeNil)
+		})
+	})
+}
+
+func Test_pinHandlers(t *testing.T) {
+	Convey("When invoking the pin handlers", t, func() {
+		state := catalog.NewServicesState()
+		state.Broadcasts = make(chan [][]byte, 10)
+		api := &SidecarApi{state: state}
+		recorder := httptest.NewRecorder()
+		params := map[string]string{"name": "bocaccio"}
+
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer",
+			Status: service.ALIVE, Updated: time.Now().UTC(),
+		})
+
+		setPin := func(body string) {
+			req := httptest.NewRequest(http.MethodPost, "/services/bocaccio/pin", bytes.NewBufferString(body))
+			api.setPinHandler(recorder, req, params)
+		}
+
+		Convey("Stores the pin in the state", func() {
+			setPin(`{"Instances": ["deadbeef123"], "Reason": "INC-42"}`)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 202)
+			So(body, ShouldContainSubstring, `"INC-42"`)
+
+			pin := state.GetPin("", "bocaccio")
+			So(pin, ShouldNotBeNil)
+			So(pin.Instances, ShouldResemble, []string{"deadbeef123"})
+
+			Convey("and returns it", func() {
+				recorder = httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, "/services/bocaccio/pin", nil)
+				api.pinHandler(recorder, req, params)
+
+				status, _, body := getResult(recorder)
+				So(status, ShouldEqual, 200)
+				So(body, ShouldContainSubstring, `"deadbeef123"`)
+			})
+
+			Convey("and clears it when sent no instances", func() {
+				recorder = httptest.NewRecorder()
+				setPin(`{}`)
+
+				status, _, _ := getResult(recorder)
+				So(status, ShouldEqual, 202)
+				So(state.GetPin("", "bocaccio"), ShouldBeNil)
+			})
+		})
+
+		Convey("Returns a 404 when there is no pin", func() {
+			req := httptest.NewRequest(http.MethodGet, "/services/bocaccio/pin", nil)
+			api.pinHandler(recorder, req, params)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 404)
+			So(body, ShouldContainSubstring, "No pin")
+		})
+
+		Convey("Pins the service in the namespace asked for", func() {
+			state.AddServiceEntry(service.Service{
+				ID: "deadbeef456", Name: "bocaccio", Hostname: "chaucer", Namespace: "staging",
+				Status: service.ALIVE, Updated: time.Now().UTC(),
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/services/bocaccio/pin?namespace=staging",
+				bytes.NewBufferString(`{"Instances": ["deadbeef456"]}`))
+			api.setPinHandler(recorder, req, params)
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 202)
+			So(state.GetPin("staging", "bocaccio"), ShouldNotBeNil)
+			So(state.GetPin("", "bocaccio"), ShouldBeNil)
+		})
+
+		Convey("Doesn't pin instances from another namespace", func() {
+			req := httptest.NewRequest(http.MethodPost, "/services/bocaccio/pin?namespace=staging",
+				bytes.NewBufferString(`{"Instances": ["deadbeef123"]}`))
+			api.setPinHandler(recorder, req, params)
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 400)
+		})
+
+		Convey("Returns an error for unknown instances", func() {
+			setPin(`{"Instances": ["deadbeef123", "cafebabe456"]}`)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 400)
+			So(body, ShouldContainSubstring, "cafebabe456")
+			So(state.GetPin("", "bocaccio"), ShouldBeNil)
+		})
+	})
+}
+
+func Test_snapshotHandlers(t *testing.T) {
+	Convey("When invoking the snapshot handlers", t, func() {
+		state := catalog.NewServicesState()
+		state.Broadcasts = make(chan [][]byte, 10)
+		state.ClusterName = "default"
+		api := &SidecarApi{state: state}
+		recorder := httptest.NewRecorder()
+
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer",
+			Status: service.ALIVE, Updated: time.Now().UTC(),
+		})
+
+		Convey("Downloads the catalog", func() {
+			req := httptest.NewRequest(http.MethodGet, "/admin/snapshot", nil)
+			api.snapshotHandler(recorder, req, nil)
+
+			status, headers, body := getResult(recorder)
+			So(status, ShouldEqual, 200)
+			So(headers.Get("Content-Disposition"), ShouldStartWith, `attachment; filename="sidecar-default-`)
+			So(body, ShouldContainSubstring, "deadbeef123")
+
+			Convey("which restores into an empty catalog", func() {
+				newState := catalog.NewServicesState()
+				newState.Broadcasts = make(chan [][]byte, 10)
+				api.state = newState
+				recorder = httptest.NewRecorder()
+
+				req := httptest.NewRequest(http.MethodPost, "/admin/restore", bytes.NewBufferString(body))
+				api.restoreHandler(recorder, req, nil)
+
+				status, _, body := getResult(recorder)
+				So(status, ShouldEqual, 200)
+				So(body, ShouldEqual, `{"Restored":1}`)
+				So(newState.HasServer("chaucer"), ShouldBeTrue)
+			})
+		})
+
+		Convey("Returns an error for a bad snapshot", func() {
+			req := httptest.NewRequest(http.MethodPost, "/admin/restore", bytes.NewBufferString("{"))
+			api.restoreHandler(recorder, req, nil)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 400)
+			So(body, ShouldContainSubstring, "Unable to decode")
+		})
+
+		Convey("Refuses to restore when the API is read-only", func() {
+			api.config = &HttpConfig{ReadOnly: true}
+			req := httptest.NewRequest(http.MethodPost, "/admin/restore", bytes.NewBufferString("{}"))
+			api.mutating(api.restoreHandler)(recorder, req, nil)
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 403)
+		})
+	})
+}
+
+func Test_eventsHandler(t *testing.T) {
+	Convey("When invoking the events handler", t, func() {
+		state := catalog.NewServicesState()
+		state.Broadcasts = make(chan [][]byte, 10)
+		api := &SidecarApi{state: state}
+		recorder := httptest.NewRecorder()
+
+		for _, id := range []string{"deadbeef123", "deadbeef456"} {
+			state.AddServiceEntry(service.Service{
+				ID: id, Name: "bocaccio", Hostname: "chaucer",
+				Status: service.ALIVE, Updated: time.Now().UTC(),
+			})
+		}
+
+		getEvents := func(query string) (int, ApiEvents, string) {
+			req := httptest.NewRequest(http.MethodGet, "/events"+query, nil)
+			api.eventsHandler(recorder, req, nil)
+
+			status, _, body := getResult(recorder)
+			var result ApiEvents
+			_ = json.Unmarshal([]byte(body), &result)
+			return status, result, body
+		}
+
+		Convey("Replays the events since a sequence number", func() {
+			status, result, _ := getEvents("?since=1")
+
+			So(status, ShouldEqual, 200)
+			So(result.Complete, ShouldBeTrue)
+			So(result.LastSequence, ShouldEqual, 2)
+			So(len(result.Events), ShouldEqual, 1)
+			So(result.Events[0].Service.ID, ShouldEqual, "deadbeef456")
+		})
+
+		Convey("Replays everything without a sequence number", func() {
+			_, result, _ := getEvents("")
+
+			So(result.Complete, ShouldBeTrue)
+			So(len(result.Events), ShouldEqual, 2)
+		})
+
+		Convey("Returns an error for a bad sequence number", func() {
+			status, _, body := getEvents("?since=bocaccio")
+
+			So(status, ShouldEqual, 400)
+			So(body, ShouldContainSubstring, "Invalid sequence number")
+		})
+	})
+}
+
+// warningDiscoverer reports a fixed set of label warnings
+type warningDiscoverer struct {
+	discovery.StaticDiscovery
+	warnings []discovery.LabelWarning
+}
+
+func (d *warningDiscoverer) Warnings() []discovery.LabelWarning {
+	return d.warnings
+}
+
+type versionDiscoverer struct {
+	discovery.StaticDiscovery
+	version *discovery.DockerVersion
+}
+
+func (d *versionDiscoverer) DockerVersion() *discovery.DockerVersion {
+	return d.version
+}
+
+func Test_diagnosticsHandler(t *testing.T) {
+	Convey("When invoking the diagnostics handler", t, func() {
+		api := &SidecarApi{}
+		recorder := httptest.NewRecorder()
+
+		getDiagnostics := func() (int, string) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/diagnostics", nil)
+			api.diagnosticsHandler(recorder, req, nil)
+
+			status, _, body := getResult(recorder)
+			return status, body
+		}
+
+		Convey("Returns the Docker version from discovery", func() {
+			disco := &versionDiscoverer{version: &discovery.DockerVersion{
+				EngineVersion: "1.11.2", APIVersion: "1.23", Problem: "Too old",
+			}}
+			api.disco = &discovery.MultiDiscovery{Discoverers: []discovery.Discoverer{disco}}
+
+			status, body := getDiagnostics()
+			So(status, ShouldEqual, 200)
+
+			var result ApiDiagnostics
+			_ = json.Unmarshal([]byte(body), &result)
+			So(result.Docker, ShouldNotBeNil)
+			So(result.Docker.Compatible, ShouldBeFalse)
+			So(result.Docker.Problem, ShouldEqual, "Too old")
+		})
+
+		Convey("Leaves out Docker when discovery doesn't know about it", func() {
+			api.disco = &discovery.StaticDiscovery{}
+
+			status, body := getDiagnostics()
+			So(status, ShouldEqual, 200)
+			So(body, ShouldEqual, "{}")
+		})
+	})
+}
+
+func Test_warningsHandler(t *testing.T) {
+	Convey("When invoking the warnings handler", t, func() {
+		api := &SidecarApi{}
+		recorder := httptest.NewRecorder()
+
+		getWarnings := func() (int, ApiWarnings) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/warnings", nil)
+			api.warningsHandler(recorder, req, nil)
+
+			status, _, body := getResult(recorder)
+			var result ApiWarnings
+			_ = json.Unmarshal([]byte(body), &result)
+			return status, result
+		}
+
+		Convey("Returns the warnings from discovery", func() {
+			disco := &warningDiscoverer{warnings: []discovery.LabelWarning{
+				{ContainerID: "deadbeef123", Label: "HealthCheck", Value: "HttpGte"},
+			}}
+			api.disco = &discovery.MultiDiscovery{Discoverers: []discovery.Discoverer{disco}}
+
+			status, result := getWarnings()
+			So(status, ShouldEqual, 200)
+			So(len(result.Warnings), ShouldEqual, 1)
+			So(result.Warnings[0].Value, ShouldEqual, "HttpGte")
+		})
+
+		Convey("Returns an empty list when discovery doesn't report any", func() {
+			api.disco = &discovery.StaticDiscovery{}
+
+			status, result := getWarnings()
+			So(status, ShouldEqual, 200)
+			So(result.Warnings, ShouldNotBeNil)
+			So(result.Warnings, ShouldBeEmpty)
+		})
+	})
+}
+
+func Test_departuresHandler(t *testing.T) {
+	Convey("When invoking the departures handler", t, func() {
+		state := catalog.NewServicesState()
+		state.Broadcasts = make(chan [][]byte, 10)
+		api := &SidecarApi{state: state}
+		recorder := httptest.NewRecorder()
+
+		baseTime := time.Now().UTC()
+		for _, name := range []string{"bocaccio", "chaucer"} {
+			svc := service.Service{
+				ID: name + "123", Name: name, Hostname: "dante",
+				Status: service.ALIVE, Updated: baseTime,
+			}
+			state.AddServiceEntry(svc)
+
+			svc.Status = service.UNHEALTHY
+			svc.Updated = baseTime.Add(time.Second)
+			state.AddServiceEntry(svc)
+		}
+
+		getDepartures := func(url string) (int, ApiDepartures) {
+			req := httptest.NewRequest(http.MethodGet, url, nil)
+			api.departuresHandler(recorder, req, nil)
+
+			status, _, body := getResult(recorder)
+			var result ApiDepartures
+			_ = json.Unmarshal([]byte(body), &result)
+			return status, result
+		}
+
+		Convey("Returns the recent departures, newest first", func() {
+			status, result := getDepartures("/v1/departures")
+			So(status, ShouldEqual, 200)
+			So(len(result.Departures), ShouldEqual, 2)
+			So(result.Departures[0].Name, ShouldEqual, "chaucer")
+			So(result.Departures[0].Reason, ShouldEqual, "Failed health checks")
+			So(result.Departures[0].PreviousStatus, ShouldEqual, "Alive")
+		})
+
+		Convey("Returns the departures of one service", func() {
+			status, result := getDepartures("/v1/departures?name=bocaccio")
+			So(status, ShouldEqual, 200)
+			So(len(result.Departures), ShouldEqual, 1)
+			So(result.Departures[0].ID, ShouldEqual, "bocaccio123")
+		})
+	})
+}
+
+func Test_availabilityHandler(t *testing.T) {
+	Convey("When invoking the availability handler", t, func() {
+		state := catalog.NewServicesState()
+		state.Broadcasts = make(chan [][]byte, 10)
+		api := &SidecarApi{state: state}
+		recorder := httptest.NewRecorder()
+
+		for _, name := range []string{"bocaccio", "chaucer"} {
+			state.AddServiceEntry(service.Service{
+				ID: name + "123", Name: name, Hostname: "dante",
+				Status: service.ALIVE, Updated: time.Now().UTC(),
+			})
+		}
+		state.SampleAvailability(time.Now().UTC())
+
+		getAvailability := func(url string) (int, ApiAvailability) {
+			req := httptest.NewRequest(http.MethodGet, url, nil)
+			api.availabilityHandler(recorder, req, nil)
+
+			status, _, body := getResult(recorder)
+			var result ApiAvailability
+			_ = json.Unmarshal([]byte(body), &result)
+			return status, result
+		}
+
+		Convey("Returns the availability of every service", func() {
+			status, result := getAvailability("/v1/availability")
+			So(status, ShouldEqual, 200)
+			So(len(result.Services), ShouldEqual, 2)
+			So(result.Services["bocaccio"]["24h"], ShouldEqual, 100)
+		})
+
+		Convey("Returns the availability of one service", func() {
+			status, result := getAvailability("/v1/availability?name=chaucer")
+			So(status, ShouldEqual, 200)
+			So(len(result.Services), ShouldEqual, 1)
+			So(result.Services["chaucer"]["1h"], ShouldEqual, 100)
+		})
+	})
+}
+
+func Test_updateServicesHandler(t *testing.T) {
+	Convey("When invoking the updateServices handler", t, func() {
+		state := catalog.NewServicesState()
+		api := &SidecarApi{state: state}
+		recorder := httptest.NewRecorder()
+
+		Convey("Merges the forwarded services into the state", func() {
+			body := bytes.NewBufferString(
+				`[{"ID":"deadbeef123","Name":"bocaccio","Hostname":"chaucer","Status":0}]`,
+			)
+			req := httptest.NewRequest(http.MethodPost, "/services/update", body)
+			api.updateServicesHandler(recorder, req, nil)
+
+			state.ProcessServiceMsgs(director.NewFreeLooper(director.ONCE, nil))
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 202)
+			So(state.HasServer("chaucer"), ShouldBeTrue)
+			So(state.Servers["chaucer"].HasService("deadbeef123"), ShouldBeTrue)
+		})
+
+		Convey("Returns an error for bad JSON", func() {
+			req := httptest.NewRequest(http.MethodPost, "/services/update", bytes.NewBufferString("{"))
+			api.updateServicesHandler(recorder, req, nil)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 400)
+			So(body, ShouldContainSubstring, "Unable to decode")
+		})
+	})
+}
+
+func Test_runCheckHandler(t *testing.T) {
+	Convey("When invoking the runCheck handler", t, func() {
+		state := catalog.NewServicesState()
+		state.Broadcasts = make(chan [][]byte, 10)
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer",
+			Status: service.ALIVE, Updated: time.Now().UTC(),
+			Ports: []service.Port{{IP: "10.0.0.1", Port: 32001, ServicePort: 10100, Type: "tcp"}},
+		})
+
+		api := &SidecarApi{monitor: healthy.NewMonitor("127.0.0.1", "/"), state: state}
+		recorder := httptest.NewRecorder()
+
+		runCheck := func(check string) (int, string) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/checks/run", bytes.NewBufferString(check))
+			api.runCheckHandler(recorder, req, nil)
+
+			status, _, body := getResult(recorder)
+			return status, body
+		}
+
+		Convey("Runs the check and returns the result", func() {
+			body := bytes.NewBufferString(`{"Type":"AlwaysSuccessful","Args":""}`)
+			req := httptest.NewRequest(http.MethodPost, "/checks/run", body)
+			api.runCheckHandler(recorder, req, nil)
+
+			status, _, body2 := getResult(recorder)
+			So(status, ShouldEqual, 200)
+
+			var result healthy.DelegatedResult
+			So(json.Unmarshal([]byte(body2), &result), ShouldBeNil)
+			So(result.Status, ShouldEqual, healthy.HEALTHY)
+			So(result.Error, ShouldBeEmpty)
+		})
+
+		Convey("Refuses to run External checks", func() {
+			body := bytes.NewBufferString(`{"Type":"External","Args":"rm -rf /"}`)
+			req := httptest.NewRequest(http.MethodPost, "/checks/run", body)
+			api.runCheckHandler(recorder, req, nil)
+
+			status, _, body2 := getResult(recorder)
+			So(status, ShouldEqual, 400)
+			So(body2, ShouldContainSubstring, "can't be delegated")
+		})
+
+		Convey("Refuses to run unknown check types", func() {
+			body := bytes.NewBufferString(`{"Type":"Telepathy","Args":""}`)
+			req := httptest.NewRequest(http.MethodPost, "/checks/run", body)
+			api.runCheckHandler(recorder, req, nil)
+
+			status, _, body2 := getResult(recorder)
+			So(status, ShouldEqual, 400)
+			So(body2, ShouldContainSubstring, "Unknown check type")
+		})
+
+		Convey("Only checks the services in the catalog", func() {
+			status, body := runCheck(`{"Type":"TcpConnect","Args":"10.0.0.1:32001 10.0.0.1:22"}`)
+			So(status, ShouldEqual, 403)
+			So(body, ShouldContainSubstring, "10.0.0.1:22")
+
+			status, _ = runCheck(`{"Type":"HttpGet","Args":"http://169.254.169.254/latest/meta-data/"}`)
+			So(status, ShouldEqual, 403)
+		})
+
+		Convey("Runs checks against the services in the catalog", func() {
+			status, body := runCheck(`{"Type":"HttpGet","Args":"http://chaucer:32001/"}`)
+			So(status, ShouldEqual, 200)
+			So(body, ShouldContainSubstring, "Status")
+		})
+
+		Convey("Doesn't follow redirects away from the services in the catalog", func() {
+			var bounced bool
+			internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				bounced = true
+			}))
+			defer internal.Close()
+
+			redirector := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusFound))
+			defer redirector.Close()
+
+			addr := redirector.Listener.Addr().(*net.TCPAddr)
+			state.AddServiceEntry(service.Service{
+				ID: "deadbeef456", Name: "bocaccio", Hostname: "chaucer",
+				Status: service.ALIVE, Updated: time.Now().UTC(),
+				Ports: []service.Port{{IP: "127.0.0.1", Port: int64(addr.Port), ServicePort: 10100, Type: "tcp"}},
+			})
+
+			status, body := runCheck(`{"Type":"HttpGet","Args":"` + redirector.URL + `"}`)
+			So(status, ShouldEqual, 200)
+
+			var result healthy.DelegatedResult
+			So(json.Unmarshal([]byte(body), &result), ShouldBeNil)
+			So(result.Status, ShouldEqual, healthy.SICKLY)
+			So(result.Error, ShouldContainSubstring, "302")
+			So(bounced, ShouldBeFalse)
+		})
+
+		Convey("Refuses checks when it can't tell what they connect to", func() {
+			status, body := runCheck(`{"Type":"Redis","Args":"10.0.0.1"}`)
+			So(status, ShouldEqual, 400)
+			So(body, ShouldContainSubstring, "connects to")
+		})
+
+		Convey("Checks against the catalog it is given, as on agents", func() {
+			api.state = nil
+			api.config = &HttpConfig{Catalog: func() (*catalog.ServicesState, error) { return state, nil }}
+
+			status, _ := runCheck(`{"Type":"HttpGet","Args":"http://chaucer:32001/"}`)
+			So(status, ShouldEqual, 200)
+
+			status, _ = runCheck(`{"Type":"TcpConnect","Args":"10.0.0.1:22"}`)
+			So(status, ShouldEqual, 403)
+
+			api.config.Catalog = func() (*catalog.ServicesState, error) { return nil, errors.New("no servers") }
+			status, body := runCheck(`{"Type":"HttpGet","Args":"http://chaucer:32001/"}`)
+			So(status, ShouldEqual, 503)
+			So(body, ShouldContainSubstring, "no servers")
+		})
+
+		Convey("Returns an error when there is no monitor", func() {
+			api.monitor = nil
+			req := httptest.NewRequest(http.MethodPost, "/checks/run", bytes.NewBufferString("{}"))
+			api.runCheckHandler(recorder, req, nil)
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 500)
+		})
+	})
+}
+
+func Test_authenticatedRoutes(t *testing.T) {
+	Convey("The API refuses requests without the token", t, func() {
+		api := &SidecarApi{
+			state:   catalog.NewServicesState(),
+			monitor: healthy.NewMonitor("127.0.0.1", "/"),
+			config:  &HttpConfig{ApiToken: "sekrit"},
+		}
+		mux := api.HttpMux()
+
+		for _, path := range []string{
+			"/checks/run", "/services/update", "/admin/restore", "/services/bocaccio/traffic",
+			"/services/bocaccio/pin",
+		} {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString("{}"))
+			mux.ServeHTTP(recorder, req)
+
+			So(recorder.Code, ShouldEqual, 401)
+		}
+	})
+}
+
+func Test_checkTypesHandler(t *testing.T) {
+	Convey("When invoking the checkTypes handler", t, func() {
+		api := &SidecarApi{}
+		recorder := httptest.NewRecorder()
+
+		Convey("Returns the check types we can run", func() {
+			req := httptest.NewRequest(http.MethodGet, "/v1/checks/types", nil)
+			api.checkTypesHandler(recorder, req, nil)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 200)
+
+			var result ApiCheckTypes
+			So(json.Unmarshal([]byte(body), &result), ShouldBeNil)
+			So(result.Types, ShouldContain, "HttpGet")
+			So(result.Types, ShouldContain, "AlwaysSuccessful")
+		})
+	})
+}
+
+func Test_outagesHandler(t *testing.T) {
+	Convey("When invoking the outages handler", t, func() {
+		state := catalog.NewServicesState()
+		state.Broadcasts = make(chan [][]byte, 10)
+		state.SetOutageGracePeriod(0)
+		api := &SidecarApi{state: state}
+		recorder := httptest.NewRecorder()
+
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "dante",
+			Status: service.UNHEALTHY, Updated: time.Now().UTC(),
+		})
+		state.EvaluateOutages(time.Now().UTC())
+
+		Convey("Returns the services that are down", func() {
+			req := httptest.NewRequest(http.MethodGet, "/v1/outages", nil)
+			api.outagesHandler(recorder, req, nil)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 200)
+
+			var result ApiOutages
+			So(json.Unmarshal([]byte(body), &result), ShouldBeNil)
+			So(len(result.Outages), ShouldEqual, 1)
+			So(result.Outages[0].Name, ShouldEqual, "bocaccio")
+		})
+	})
+}
+
+func Test_closedHandler(t *testing.T) {
+	Convey("When invoking the closed services handler", t, func() {
+		state := catalog.NewServicesState()
+		state.Broadcasts = make(chan [][]byte, 10)
+		api := &SidecarApi{state: state}
+		recorder := httptest.NewRecorder()
+
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "dante",
+			Status: service.ALIVE, Updated: time.Now().UTC(), MinInstances: 2,
+		})
+
+		Convey("Returns the services short of their minimum instances", func() {
+			req := httptest.NewRequest(http.MethodGet, "/v1/closed", nil)
+			api.closedHandler(recorder, req, nil)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 200)
+
+			var result ApiClosed
+			So(json.Unmarshal([]byte(body), &result), ShouldBeNil)
+			So(result.Services, ShouldResemble, []catalog.ClosedService{
+				{Name: "bocaccio", Alive: 1, MinInstances: 2},
+			})
+		})
+
+		Convey("Filters them by namespace", func() {
+			req := httptest.NewRequest(http.MethodGet, "/v1/closed?namespace=staging", nil)
+			api.closedHandler(recorder, req, nil)
+
+			_, _, body := getResult(recorder)
+			var result ApiClosed
+			So(json.Unmarshal([]byte(body), &result), ShouldBeNil)
+			So(result.Services, ShouldBeEmpty)
+		})
+	})
+}
+
+func Test_conflictsHandler(t *testing.T) {
+	Convey("When invoking the port conflicts handler", t, func() {
+		state := catalog.NewServicesState()
+		state.Broadcasts = make(chan [][]byte, 10)
+		api := &SidecarApi{state: state}
+		recorder := httptest.NewRecorder()
+		baseTime := time.Now().UTC()
+
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer", Status: service.ALIVE,
+			Created: baseTime, Updated: baseTime,
+			Ports: []service.Port{{Type: "tcp", Port: 31000, ServicePort: 10100}},
+		})
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef456", Name: "dante", Hostname: "chaucer", Status: service.ALIVE,
+			Created: baseTime.Add(time.Minute), Updated: baseTime,
+			Ports: []service.Port{{Type: "tcp", Port: 31001, ServicePort: 10100}},
+		})
+
+		getConflicts := func(url string) ApiConflicts {
+			api.conflictsHandler(recorder, httptest.NewRequest(http.MethodGet, url, nil), nil)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 200)
+
+			var result ApiConflicts
+			So(json.Unmarshal([]byte(body), &result), ShouldBeNil)
+			return result
+		}
+
+		Convey("Returns the ServicePorts claimed by more than one service", func() {
+			So(getConflicts("/v1/conflicts").Conflicts, ShouldResemble, []catalog.PortConflict{
+				{ServicePort: 10100, Owner: "bocaccio", Others: []string{"dante"}},
+			})
+		})
+
+		Convey("Filters them by namespace", func() {
+			So(getConflicts("/v1/conflicts?namespace=staging").Conflicts, ShouldBeEmpty)
+		})
+	})
+}
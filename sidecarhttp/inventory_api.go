@@ -0,0 +1,215 @@
+package sidecarhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Nitro/sidecar/service"
+	log "github.com/sirupsen/logrus"
+)
+
+// This file implements an inventory of the hosts in the cluster, grouped by
+// what they are running, for configuration management and other automation.
+
+const (
+	INVENTORY_FORMAT_JSON    = "json"
+	INVENTORY_FORMAT_ANSIBLE = "ansible"
+)
+
+var invalidGroupChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// An InventoryService is one service instance running on an InventoryHost
+type InventoryService struct {
+	ID        string
+	Name      string
+	Version   string
+	Namespace string `json:",omitempty"`
+	Status    string
+	Ports     []service.Port
+}
+
+// An InventoryHost is a host running at least one service
+type InventoryHost struct {
+	Name     string
+	IP       string `json:",omitempty"`
+	Zone     string `json:",omitempty"`
+	Services []InventoryService
+}
+
+// ApiInventory is the response from the inventory endpoint in the generic
+// JSON format. Groups maps each group name to the hosts in it.
+type ApiInventory struct {
+	Hosts  map[string]*InventoryHost
+	Groups map[string][]string
+}
+
+// ansibleGroup is a group in the Ansible dynamic inventory format
+type ansibleGroup struct {
+	Hosts []string `json:"hosts"`
+}
+
+// ansibleMeta carries the host variables in the Ansible format, so that
+// Ansible doesn't have to call us once per host for them
+type ansibleMeta struct {
+	HostVars map[string]map[string]interface{} `json:"hostvars"`
+}
+
+// groupName joins the parts into a group name that Ansible accepts, with
+// anything but letters, digits, and underscores replaced by underscores
+func groupName(parts ...string) string {
+	return invalidGroupChars.ReplaceAllString(strings.Join(parts, "_"), "_")
+}
+
+// inventory lists the hosts running services in the namespace, or in all
+// namespaces when filter is false, along with the groups they belong to:
+// service_<name> for each service, service_<name>_<version> for each version
+// of it, zone_<zone> for each zone, and namespace_<namespace> for each
+// namespace other than the default. Tombstoned services are left out, but
+// unhealthy, draining, and maintenance ones are still running, so they count.
+func (s *SidecarApi) inventory(namespace string, filter bool) *ApiInventory {
+	result := &ApiInventory{
+		Hosts:  make(map[string]*InventoryHost),
+		Groups: make(map[string][]string),
+	}
+
+	members := make(map[string]map[string]bool)
+	addToGroup := func(group string, hostname string) {
+		if members[group] == nil {
+			members[group] = make(map[string]bool)
+		}
+		members[group][hostname] = true
+	}
+
+	s.state.RLock()
+	defer s.state.RUnlock()
+
+	s.state.EachService(func(hostname *string, id *string, svc *service.Service) {
+		if svc.IsTombstone() || (filter && svc.Namespace != namespace) {
+			return
+		}
+
+		host, ok := result.Hosts[*hostname]
+		if !ok {
+			host = &InventoryHost{Name: *hostname, Zone: svc.Zone}
+			result.Hosts[*hostname] = host
+		}
+
+		for _, port := range svc.Ports {
+			if host.IP == "" && port.IP != "" {
+				host.IP = port.IP
+			}
+		}
+
+		host.Services = append(host.Services, InventoryService{
+			ID:        svc.ID,
+			Name:      svc.Name,
+			Version:   svc.Version(),
+			Namespace: svc.Namespace,
+			Status:    svc.StatusString(),
+			Ports:     svc.Ports,
+		})
+
+		addToGroup(groupName("service", svc.Name), *hostname)
+		addToGroup(groupName("service", svc.Name, svc.Version()), *hostname)
+		if svc.Zone != "" {
+			addToGroup(groupName("zone", svc.Zone), *hostname)
+		}
+		if svc.Namespace != "" {
+			addToGroup(groupName("namespace", svc.Namespace), *hostname)
+		}
+	})
+
+	for _, host := range result.Hosts {
+		sort.Slice(host.Services, func(i, j int) bool {
+			if host.Services[i].Name != host.Services[j].Name {
+				return host.Services[i].Name < host.Services[j].Name
+			}
+			return host.Services[i].ID < host.Services[j].ID
+		})
+	}
+
+	for group, hosts := range members {
+		for hostname := range hosts {
+			result.Groups[group] = append(result.Groups[group], hostname)
+		}
+		sort.Strings(result.Groups[group])
+	}
+
+	return result
+}
+
+// ansible returns the inventory in the Ansible dynamic inventory format. The
+// IP of each host is passed as ansible_host, and its services as
+// sidecar_services.
+func (inv *ApiInventory) ansible() map[string]interface{} {
+	result := make(map[string]interface{}, len(inv.Groups)+1)
+	for group, hosts := range inv.Groups {
+		result[group] = ansibleGroup{Hosts: hosts}
+	}
+
+	meta := ansibleMeta{HostVars: make(map[string]map[string]interface{}, len(inv.Hosts))}
+	for hostname, host := range inv.Hosts {
+		vars := map[string]interface{}{"sidecar_services": host.Services}
+		if host.IP != "" {
+			vars["ansible_host"] = host.IP
+		}
+		if host.Zone != "" {
+			vars["sidecar_zone"] = host.Zone
+		}
+		meta.HostVars[hostname] = vars
+	}
+	result["_meta"] = meta
+
+	return result
+}
+
+// inventoryHandler returns the hosts in the cluster grouped by the services
+// they run. The "format" GET parameter picks the generic JSON format (the
+// default) or "ansible" for an Ansible dynamic inventory. It covers all the
+// namespaces, unless one is passed in the "namespace" GET parameter.
+func (s *SidecarApi) inventoryHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = INVENTORY_FORMAT_JSON
+	}
+
+	if format != INVENTORY_FORMAT_JSON && format != INVENTORY_FORMAT_ANSIBLE {
+		sendJsonError(response, 400, "Bad Request - Format must be one of 'json' or 'ansible'")
+		return
+	}
+
+	namespace, filter := namespaceParam(req)
+	inventory := s.inventory(namespace, filter)
+
+	var jsonBytes []byte
+	var err error
+	if format == INVENTORY_FORMAT_ANSIBLE {
+		jsonBytes, err = json.MarshalIndent(inventory.ansible(), "", "  ")
+	} else {
+		jsonBytes, err = json.MarshalIndent(inventory, "", "  ")
+	}
+
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Access-Control-Allow-Methods", "GET")
+
+	err = writeCompressed(response, req, jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing inventory response to client: %s", err)
+	}
+}
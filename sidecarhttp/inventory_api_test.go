@@ -0,0 +1,98 @@
+package sidecarhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_inventoryHandler(t *testing.T) {
+	Convey("When invoking the inventory handler", t, func() {
+		state := catalog.NewServicesState()
+		state.Broadcasts = make(chan [][]byte, 10)
+		api := &SidecarApi{state: state}
+
+		addService := func(id string, name string, image string, hostname string, ip string, status int) {
+			state.AddServiceEntry(service.Service{
+				ID: id, Name: name, Image: image, Hostname: hostname, Zone: "us-east-1a",
+				Status: status, Updated: time.Now().UTC(),
+				Ports: []service.Port{{IP: ip, Port: 31000, ServicePort: 10100, Type: "tcp"}},
+			})
+		}
+		addService("deadbeef001", "bocaccio", "bocaccio:v1", "chaucer", "10.0.0.1", service.ALIVE)
+		addService("deadbeef002", "bocaccio", "bocaccio:v1.2", "dante", "10.0.0.2", service.UNHEALTHY)
+		addService("deadbeef003", "petrarch", "petrarch:latest", "dante", "10.0.0.2", service.ALIVE)
+		addService("deadbeef004", "petrarch", "petrarch:latest", "chaucer", "10.0.0.1", service.TOMBSTONE)
+
+		getInventory := func(url string) (int, string) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, url, nil)
+			api.inventoryHandler(recorder, req, nil)
+
+			status, _, body := getResult(recorder)
+			return status, body
+		}
+
+		Convey("Groups the hosts by service and version", func() {
+			status, body := getInventory("/v1/inventory")
+			So(status, ShouldEqual, 200)
+
+			var result ApiInventory
+			So(json.Unmarshal([]byte(body), &result), ShouldBeNil)
+
+			So(result.Groups["service_bocaccio"], ShouldResemble, []string{"chaucer", "dante"})
+			So(result.Groups["service_bocaccio_v1_2"], ShouldResemble, []string{"dante"})
+			So(result.Groups["service_petrarch"], ShouldResemble, []string{"dante"})
+			So(result.Groups["zone_us_east_1a"], ShouldResemble, []string{"chaucer", "dante"})
+
+			So(result.Hosts["dante"].IP, ShouldEqual, "10.0.0.2")
+			So(len(result.Hosts["dante"].Services), ShouldEqual, 2)
+			So(result.Hosts["dante"].Services[0].Status, ShouldEqual, "Unhealthy")
+			So(len(result.Hosts["chaucer"].Services), ShouldEqual, 1)
+		})
+
+		Convey("Returns an Ansible dynamic inventory", func() {
+			status, body := getInventory("/v1/inventory?format=ansible")
+			So(status, ShouldEqual, 200)
+
+			var result map[string]json.RawMessage
+			So(json.Unmarshal([]byte(body), &result), ShouldBeNil)
+
+			var group ansibleGroup
+			So(json.Unmarshal(result["service_bocaccio_v1"], &group), ShouldBeNil)
+			So(group.Hosts, ShouldResemble, []string{"chaucer"})
+
+			var meta ansibleMeta
+			So(json.Unmarshal(result["_meta"], &meta), ShouldBeNil)
+			So(meta.HostVars["chaucer"]["ansible_host"], ShouldEqual, "10.0.0.1")
+			So(meta.HostVars["chaucer"]["sidecar_zone"], ShouldEqual, "us-east-1a")
+		})
+
+		Convey("Only has the services in the namespace asked for", func() {
+			state.AddServiceEntry(service.Service{
+				ID: "deadbeef005", Name: "bocaccio", Hostname: "boethius", Namespace: "staging",
+				Status: service.ALIVE, Updated: time.Now().UTC(),
+			})
+
+			_, body := getInventory("/v1/inventory?namespace=staging")
+
+			var result ApiInventory
+			So(json.Unmarshal([]byte(body), &result), ShouldBeNil)
+			So(len(result.Hosts), ShouldEqual, 1)
+			So(result.Groups["service_bocaccio"], ShouldResemble, []string{"boethius"})
+			So(result.Groups["namespace_staging"], ShouldResemble, []string{"boethius"})
+		})
+
+		Convey("Refuses unknown formats", func() {
+			status, body := getInventory("/v1/inventory?format=yaml")
+			So(status, ShouldEqual, 400)
+			So(body, ShouldContainSubstring, "Format must be one of")
+		})
+	})
+}
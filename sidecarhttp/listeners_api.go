@@ -0,0 +1,99 @@
+package sidecarhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Nitro/sidecar/catalog"
+	log "github.com/sirupsen/logrus"
+)
+
+// This file implements the endpoints that platform services use to subscribe
+// listeners to state changes at runtime.
+
+// ApiListeners is the response from the listeners endpoint
+type ApiListeners struct {
+	Listeners []catalog.RegisteredListener
+}
+
+// registry returns the listener registry, or sends an error if there isn't one
+func (s *SidecarApi) registry(response http.ResponseWriter) *catalog.ListenerRegistry {
+	if s.config == nil || s.config.Registry == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return nil
+	}
+
+	return s.config.Registry
+}
+
+// listenersHandler returns the listeners registered through the API
+func (s *SidecarApi) listenersHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	registry := s.registry(response)
+	if registry == nil {
+		return
+	}
+
+	sendListeners(response, 200, &ApiListeners{Listeners: registry.List()})
+}
+
+// addListenerHandler registers a listener from a JSON object with its Name
+// and Url, and starts sending it state changes.
+func (s *SidecarApi) addListenerHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	registry := s.registry(response)
+	if registry == nil {
+		return
+	}
+
+	var request catalog.RegisteredListener
+	err := json.NewDecoder(req.Body).Decode(&request)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad Request - Unable to decode listener: %s", err))
+		return
+	}
+
+	listener, err := registry.Add(request.Name, request.Url)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad Request - %s", err))
+		return
+	}
+
+	sendListeners(response, 201, listener)
+}
+
+// removeListenerHandler stops and forgets a registered listener
+func (s *SidecarApi) removeListenerHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	registry := s.registry(response)
+	if registry == nil {
+		return
+	}
+
+	err := registry.Remove(params["name"])
+	if err != nil {
+		sendJsonError(response, 404, fmt.Sprintf("Not Found - %s", err))
+		return
+	}
+
+	sendListeners(response, 200, &ApiListeners{Listeners: registry.List()})
+}
+
+func sendListeners(response http.ResponseWriter, status int, result interface{}) {
+	jsonBytes, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing listeners response to client: %s", err)
+	}
+}
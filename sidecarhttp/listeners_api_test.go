@@ -0,0 +1,99 @@
+package sidecarhttp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Nitro/sidecar/catalog"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_listenersHandlers(t *testing.T) {
+	Convey("When managing listeners through the API", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-listeners")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+
+		state := catalog.NewServicesState()
+		registry := catalog.NewListenerRegistry(state, filepath.Join(dir, "listeners.json"))
+		api := &SidecarApi{
+			state:  state,
+			config: &HttpConfig{ApiToken: "sekrit", Registry: registry},
+		}
+
+		call := func(handler func(http.ResponseWriter, *http.Request, map[string]string),
+			method string, body string, token string, params map[string]string) (int, string) {
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(method, "/v1/listeners", strings.NewReader(body))
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			api.authenticated(handler)(recorder, req, params)
+
+			status, _, result := getResult(recorder)
+			return status, result
+		}
+
+		Convey("Refuses requests without the API token", func() {
+			status, _ := call(api.listenersHandler, http.MethodGet, "", "", nil)
+			So(status, ShouldEqual, 401)
+
+			status, _ = call(api.listenersHandler, http.MethodGet, "", "wrong", nil)
+			So(status, ShouldEqual, 401)
+		})
+
+		Convey("Refuses all requests when no API token is configured", func() {
+			api.config.ApiToken = ""
+
+			status, _ := call(api.listenersHandler, http.MethodGet, "", "sekrit", nil)
+			So(status, ShouldEqual, 403)
+		})
+
+		Convey("Adds a listener", func() {
+			status, body := call(api.addListenerHandler, http.MethodPost,
+				`{"Name": "billing", "Url": "http://billing.example.com/events"}`, "sekrit", nil)
+			So(status, ShouldEqual, 201)
+			So(body, ShouldContainSubstring, "billing")
+			So(len(state.GetListeners()), ShouldEqual, 1)
+
+			Convey("and lists it", func() {
+				status, body := call(api.listenersHandler, http.MethodGet, "", "sekrit", nil)
+				So(status, ShouldEqual, 200)
+
+				var result ApiListeners
+				So(json.Unmarshal([]byte(body), &result), ShouldBeNil)
+				So(len(result.Listeners), ShouldEqual, 1)
+				So(result.Listeners[0].Url, ShouldEqual, "http://billing.example.com/events")
+			})
+
+			Convey("and removes it", func() {
+				status, _ := call(api.removeListenerHandler, http.MethodDelete, "", "sekrit",
+					map[string]string{"name": "billing"})
+				So(status, ShouldEqual, 200)
+				So(state.GetListeners(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("Rejects bad listeners", func() {
+			status, _ := call(api.addListenerHandler, http.MethodPost, `{"Name": "billing"`, "sekrit", nil)
+			So(status, ShouldEqual, 400)
+
+			status, _ = call(api.addListenerHandler, http.MethodPost,
+				`{"Name": "billing", "Url": "not a url"}`, "sekrit", nil)
+			So(status, ShouldEqual, 400)
+		})
+
+		Convey("Returns a 404 when removing an unknown listener", func() {
+			status, _ := call(api.removeListenerHandler, http.MethodDelete, "", "sekrit",
+				map[string]string{"name": "nobody"})
+			So(status, ShouldEqual, 404)
+		})
+	})
+}
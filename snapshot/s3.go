@@ -0,0 +1,193 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	S3_REQUEST_TIMEOUT = 30 * time.Second // Give up on an S3 request after this long
+)
+
+// An S3Store keeps the snapshot as a single object in an S3 bucket. Requests
+// are signed with AWS Signature Version 4, so any S3-compatible service that
+// supports it works too, including Google Cloud Storage through its XML API
+// with HMAC keys, by setting the Endpoint.
+type S3Store struct {
+	Endpoint     string // e.g. https://s3.us-east-1.amazonaws.com
+	Region       string
+	Bucket       string
+	Key          string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Client       *http.Client
+}
+
+// NewS3Store returns a store for the object at bucket/key. The endpoint
+// defaults to the AWS one for the region.
+func NewS3Store(endpoint, region, bucket, key, accessKey, secretKey, sessionToken string) *S3Store {
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+
+	return &S3Store{
+		Endpoint:     strings.TrimSuffix(endpoint, "/"),
+		Region:       region,
+		Bucket:       bucket,
+		Key:          strings.TrimPrefix(key, "/"),
+		AccessKey:    accessKey,
+		SecretKey:    secretKey,
+		SessionToken: sessionToken,
+		Client:       &http.Client{Timeout: S3_REQUEST_TIMEOUT},
+	}
+}
+
+// Save uploads the snapshot, replacing the previous one
+func (s *S3Store) Save(data []byte) error {
+	resp, err := s.do(http.MethodPut, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("Unable to save snapshot to s3://%s/%s: %s", s.Bucket, s.Key, s3Error(resp))
+	}
+
+	return nil
+}
+
+// Load downloads the last saved snapshot
+func (s *S3Store) Load() ([]byte, error) {
+	resp, err := s.do(http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Unable to load snapshot from s3://%s/%s: %s", s.Bucket, s.Key, s3Error(resp))
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (s *S3Store) do(method string, body []byte) (*http.Response, error) {
+	if s.Bucket == "" || s.Key == "" {
+		return nil, errors.New("No S3 bucket or key configured for snapshots")
+	}
+
+	objectURL := fmt.Sprintf("%s/%s/%s", s.Endpoint, s.Bucket, escapePath(s.Key))
+	req, err := http.NewRequest(method, objectURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if method == http.MethodPut {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	s.sign(req, body, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: S3_REQUEST_TIMEOUT}
+	}
+
+	return client.Do(req)
+}
+
+// sign adds the AWS Signature Version 4 Authorization header to the request.
+// All the headers already set on the request are signed.
+func (s *S3Store) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+// escapePath URI-encodes each segment of an object key, keeping the slashes
+func escapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = strings.Replace(url.PathEscape(segment), "+", "%2B", -1)
+	}
+	return strings.Join(segments, "/")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Error returns the status and as much of the error body as is useful
+func s3Error(resp *http.Response) string {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return strings.TrimSpace(resp.Status + " " + string(body))
+}
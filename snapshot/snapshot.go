@@ -0,0 +1,78 @@
+// The snapshot package saves copies of the whole catalog to durable storage
+// so that a cluster that went down entirely can be seeded from the last
+// known-good state when it comes back.
+package snapshot
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/armon/go-metrics"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+)
+
+// A Store holds the most recent snapshot
+type Store interface {
+	Save(data []byte) error
+	Load() ([]byte, error)
+}
+
+// A Snapshotter periodically saves the state to a Store, and can restore
+// it from there.
+type Snapshotter struct {
+	state *catalog.ServicesState
+	store Store
+}
+
+func NewSnapshotter(state *catalog.ServicesState, store Store) *Snapshotter {
+	return &Snapshotter{state: state, store: store}
+}
+
+// Save writes a snapshot of the current state to the store. An empty catalog
+// is never saved, so that a node that just started can't overwrite the last
+// good snapshot.
+func (s *Snapshotter) Save() error {
+	defer metrics.MeasureSince([]string{"snapshot", "Save"}, time.Now())
+
+	s.state.RLock()
+	if len(s.state.Servers) == 0 {
+		s.state.RUnlock()
+		return errors.New("Not saving a snapshot of an empty catalog")
+	}
+	data := s.state.Encode()
+	s.state.RUnlock()
+
+	return s.store.Save(data)
+}
+
+// Restore seeds the state from the snapshot in the store. Returns the
+// number of services restored.
+func (s *Snapshotter) Restore() (int, error) {
+	data, err := s.store.Load()
+	if err != nil {
+		return 0, err
+	}
+
+	snapshot, err := catalog.Decode(data)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.state.Restore(snapshot), nil
+}
+
+// Run saves a snapshot on every pass of the looper. Failures are logged and
+// we try again next time.
+func (s *Snapshotter) Run(looper director.Looper) {
+	looper.Loop(func() error {
+		err := s.Save()
+		if err != nil {
+			log.Errorf("Failed to save catalog snapshot: %s", err)
+			metrics.IncrCounter([]string{"snapshot", "errors"}, 1)
+		}
+
+		return nil
+	})
+}
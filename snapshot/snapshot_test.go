@@ -0,0 +1,64 @@
+package snapshot
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
+	"github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type memoryStore struct {
+	data []byte
+}
+
+func (m *memoryStore) Save(data []byte) error {
+	m.data = data
+	return nil
+}
+
+func (m *memoryStore) Load() ([]byte, error) {
+	if m.data == nil {
+		return nil, errors.New("No snapshot")
+	}
+	return m.data, nil
+}
+
+func Test_Snapshotter(t *testing.T) {
+	Convey("Snapshotter", t, func() {
+		state := catalog.NewServicesState()
+		state.Broadcasts = make(chan [][]byte, 10)
+		store := &memoryStore{}
+		snapshotter := NewSnapshotter(state, store)
+
+		Convey("won't save an empty catalog", func() {
+			So(snapshotter.Save(), ShouldNotBeNil)
+			So(store.data, ShouldBeNil)
+		})
+
+		Convey("saves the catalog on each run and restores it", func() {
+			state.AddServiceEntry(service.Service{
+				ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer",
+				Status: service.ALIVE, Updated: time.Now().UTC(),
+			})
+
+			snapshotter.Run(director.NewFreeLooper(director.ONCE, nil))
+			So(string(store.data), ShouldContainSubstring, "deadbeef123")
+
+			newState := catalog.NewServicesState()
+			newState.Broadcasts = make(chan [][]byte, 10)
+			restored, err := NewSnapshotter(newState, store).Restore()
+			So(err, ShouldBeNil)
+			So(restored, ShouldEqual, 1)
+			So(newState.HasServer("chaucer"), ShouldBeTrue)
+		})
+
+		Convey("returns errors from the store", func() {
+			_, err := snapshotter.Restore()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/armon/go-metrics"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	STATE_POLL_INTERVAL = 5 * time.Second // How often to fetch the state from a server
+	STATE_POLL_TIMEOUT  = 5 * time.Second // HTTP timeout when fetching the state
+)
+
+// A statePoller is used in the proxy role when we don't join the gossip
+// cluster. It fetches the whole state from one of the Sidecar servers on a
+// timed basis and merges it into our own, just as on a gossip push/pull.
+// Like the serversForwarder, it sticks with one server until it fails.
+type statePoller struct {
+	state   *catalog.ServicesState
+	servers []string
+	current int
+	client  *http.Client
+}
+
+func NewStatePoller(state *catalog.ServicesState, servers []string) *statePoller {
+	return &statePoller{
+		state:   state,
+		servers: serverURLs(servers, "/api/state.json"),
+		client:  newNodeClient(STATE_POLL_TIMEOUT),
+	}
+}
+
+// Run polls the servers for the state on each iteration of the looper
+func (p *statePoller) Run(looper director.Looper) {
+	looper.Loop(func() error {
+		err := p.poll()
+		if err != nil {
+			log.Warnf("Unable to fetch state from servers: %s", err)
+		}
+		return nil
+	})
+}
+
+// DiscardBroadcasts drains the state's broadcasts, which we have no one to
+// send to. Otherwise the broadcast loops would block.
+func (p *statePoller) DiscardBroadcasts(looper director.Looper) {
+	discardBroadcasts(p.state, looper)
+}
+
+// discardBroadcasts drains the broadcasts of a state that isn't gossiping
+func discardBroadcasts(state *catalog.ServicesState, looper director.Looper) {
+	looper.Loop(func() error {
+		<-state.Broadcasts
+		return nil
+	})
+}
+
+// poll fetches the state from the servers and merges it in
+func (p *statePoller) poll() error {
+	defer metrics.MeasureSince([]string{"poller", "poll"}, time.Now())
+
+	otherState, err := p.fetchAny()
+	if err != nil {
+		return err
+	}
+
+	p.state.Merge(otherState)
+	return nil
+}
+
+// fetchAny fetches the state from the current server, falling back to each
+// of the other servers in turn
+func (p *statePoller) fetchAny() (*catalog.ServicesState, error) {
+	if len(p.servers) < 1 {
+		return nil, errors.New("no servers configured")
+	}
+
+	for i := 0; i < len(p.servers); i++ {
+		server := p.servers[p.current]
+
+		otherState, err := p.fetch(server)
+		if err == nil {
+			return otherState, nil
+		}
+
+		log.Warnf("Failed fetching state from %s: %s", server, err)
+		p.current = (p.current + 1) % len(p.servers)
+	}
+
+	return nil, errors.New("no servers returned the state")
+}
+
+// A serverCatalog fetches the state from the servers when it's asked for
+// it, for agents, which don't keep one. A copy is kept for the
+// STATE_POLL_INTERVAL, so that a burst of delegated checks only fetches it
+// once.
+type serverCatalog struct {
+	poller  *statePoller
+	state   *catalog.ServicesState
+	fetched time.Time
+	sync.Mutex
+}
+
+func newServerCatalog(servers []string) *serverCatalog {
+	return &serverCatalog{poller: NewStatePoller(nil, servers)}
+}
+
+// Get returns the state from the servers, fetching it if our copy is too old
+func (c *serverCatalog) Get() (*catalog.ServicesState, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.state != nil && time.Since(c.fetched) < STATE_POLL_INTERVAL {
+		return c.state, nil
+	}
+
+	state, err := c.poller.fetchAny()
+	if err != nil {
+		return nil, err
+	}
+
+	c.state, c.fetched = state, time.Now()
+	return state, nil
+}
+
+func (p *statePoller) fetch(url string) (*catalog.ServicesState, error) {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 || resp.StatusCode < 200 {
+		return nil, fmt.Errorf("bad status code returned (%d)", resp.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return catalog.Decode(data)
+}
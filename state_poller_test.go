@@ -0,0 +1,127 @@
+package main
+
+import (
+	"compress/gzip"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
+	"github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_StatePoller(t *testing.T) {
+	Convey("When polling the servers for state", t, func() {
+		state := catalog.NewServicesState()
+
+		remoteState := catalog.NewServicesState()
+		remoteState.AddServiceEntry(service.Service{
+			ID:       "deadbeef123",
+			Name:     "bocaccio",
+			Hostname: "chaucer",
+			Updated:  time.Now().UTC(),
+			Status:   service.ALIVE,
+		})
+
+		goodServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/state.json" {
+				w.WriteHeader(404)
+				return
+			}
+			_, _ = w.Write(remoteState.Encode())
+		}))
+		defer goodServer.Close()
+
+		badServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(500)
+		}))
+		defer badServer.Close()
+
+		Convey("poll() merges the remote state", func() {
+			poller := NewStatePoller(state, []string{goodServer.URL})
+			err := poller.poll()
+			state.ProcessServiceMsgs(director.NewFreeLooper(director.ONCE, nil))
+
+			So(err, ShouldBeNil)
+			So(state.HasServer("chaucer"), ShouldBeTrue)
+			So(state.Servers["chaucer"].HasService("deadbeef123"), ShouldBeTrue)
+		})
+
+		Convey("poll() unpacks a gzipped state and reuses the connection", func() {
+			var connections int32
+			gzipServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+					w.WriteHeader(406)
+					return
+				}
+				w.Header().Set("Content-Encoding", "gzip")
+				writer := gzip.NewWriter(w)
+				_, _ = writer.Write(remoteState.Encode())
+				_ = writer.Close()
+			}))
+			gzipServer.Config.ConnState = func(conn net.Conn, connState http.ConnState) {
+				if connState == http.StateNew {
+					atomic.AddInt32(&connections, 1)
+				}
+			}
+			gzipServer.Start()
+			defer gzipServer.Close()
+
+			poller := NewStatePoller(state, []string{gzipServer.URL})
+			So(poller.poll(), ShouldBeNil)
+			So(poller.poll(), ShouldBeNil)
+			state.ProcessServiceMsgs(director.NewFreeLooper(director.ONCE, nil))
+
+			So(state.HasServer("chaucer"), ShouldBeTrue)
+			So(atomic.LoadInt32(&connections), ShouldEqual, 1)
+		})
+
+		Convey("poll() fails over to the next server", func() {
+			poller := NewStatePoller(state, []string{badServer.URL, goodServer.URL})
+
+			So(poller.poll(), ShouldBeNil)
+			So(poller.current, ShouldEqual, 1)
+		})
+
+		Convey("poll() returns an error when all servers fail", func() {
+			poller := NewStatePoller(state, []string{badServer.URL})
+			So(poller.poll(), ShouldNotBeNil)
+		})
+
+		Convey("a serverCatalog fetches the state once for a burst of requests", func() {
+			var fetches int32
+			countingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&fetches, 1)
+				_, _ = w.Write(remoteState.Encode())
+			}))
+			defer countingServer.Close()
+
+			servers := newServerCatalog([]string{badServer.URL, countingServer.URL})
+			for i := 0; i < 3; i++ {
+				fetched, err := servers.Get()
+				So(err, ShouldBeNil)
+				So(fetched.Servers["chaucer"].HasService("deadbeef123"), ShouldBeTrue)
+			}
+			So(atomic.LoadInt32(&fetches), ShouldEqual, 1)
+
+			servers.fetched = time.Now().Add(-STATE_POLL_INTERVAL)
+			_, err := servers.Get()
+			So(err, ShouldBeNil)
+			So(atomic.LoadInt32(&fetches), ShouldEqual, 2)
+		})
+
+		Convey("DiscardBroadcasts() drains the broadcasts", func() {
+			poller := NewStatePoller(state, nil)
+			go func() { state.Broadcasts <- nil }()
+			poller.DiscardBroadcasts(director.NewFreeLooper(director.ONCE, nil))
+
+			So(len(state.Broadcasts), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,118 @@
+'use strict';
+
+// Pushes a summary of the cluster's health into the page title and favicon,
+// so a dashboard tab left open in the background still shows when services
+// are failing.
+angular.module('sidecar.health-badge', [])
+
+.factory('healthBadge', function($document) {
+	var UNHEALTHY = 2;
+	var UNKNOWN = 3;
+
+	var colors = {
+		ok: '#3fb618',
+		unknown: '#ff7518',
+		failing: '#ff0039'
+	};
+
+	var lastIcon = null;
+
+	// Count the instances in a services.json response by health
+	function summarize(servicesResponse) {
+		var summary = { failing: 0, unknown: 0 };
+		var services = (servicesResponse && servicesResponse.Services) || {};
+
+		for (var svcName in services) {
+			services[svcName].forEach(function(svc) {
+				if (svc.Status == UNHEALTHY) {
+					summary.failing++;
+				} else if (svc.Status == UNKNOWN) {
+					summary.unknown++;
+				}
+			});
+		}
+
+		return summary;
+	};
+
+	function titleFor(summary, clusterName) {
+		var title = 'Sidecar';
+		if (clusterName) {
+			title += ' - ' + clusterName;
+		}
+
+		var problems = [];
+		if (summary.failing > 0) {
+			problems.push(summary.failing + ' failing');
+		}
+		if (summary.unknown > 0) {
+			problems.push(summary.unknown + ' unknown');
+		}
+
+		if (problems.length > 0) {
+			title = '(' + problems.join(', ') + ') ' + title;
+		}
+
+		return title;
+	};
+
+	function stateFor(summary) {
+		if (summary.failing > 0) return 'failing';
+		if (summary.unknown > 0) return 'unknown';
+		return 'ok';
+	};
+
+	// Draw a colored dot, with the count of failing instances on it
+	function drawIcon(summary) {
+		var canvas = $document[0].createElement('canvas');
+		canvas.width = canvas.height = 32;
+		var ctx = canvas.getContext && canvas.getContext('2d');
+		if (!ctx) return null;
+
+		ctx.fillStyle = colors[stateFor(summary)];
+		ctx.beginPath();
+		ctx.arc(16, 16, 15, 0, 2 * Math.PI);
+		ctx.fill();
+
+		var count = summary.failing || summary.unknown;
+		if (count > 0) {
+			ctx.fillStyle = '#ffffff';
+			ctx.font = 'bold 18px sans-serif';
+			ctx.textAlign = 'center';
+			ctx.textBaseline = 'middle';
+			ctx.fillText(count > 99 ? '99+' : String(count), 16, 17);
+		}
+
+		return canvas.toDataURL('image/png');
+	};
+
+	function setIcon(summary) {
+		var key = stateFor(summary) + (summary.failing || summary.unknown);
+		if (key == lastIcon) return;
+
+		var href = drawIcon(summary);
+		if (!href) return;
+
+		var doc = $document[0];
+		var link = doc.getElementById('favicon');
+		if (!link) {
+			link = doc.createElement('link');
+			link.id = 'favicon';
+			link.rel = 'icon';
+			doc.head.appendChild(link);
+		}
+		link.href = href;
+		lastIcon = key;
+	};
+
+	return {
+		summarize: summarize,
+		titleFor: titleFor,
+
+		update: function(servicesResponse) {
+			var summary = summarize(servicesResponse);
+			$document[0].title = titleFor(summary, servicesResponse && servicesResponse.ClusterName);
+			setIcon(summary);
+		}
+	};
+});
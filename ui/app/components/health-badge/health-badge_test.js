@@ -0,0 +1,33 @@
+'use strict';
+
+describe('sidecar.health-badge module', function() {
+  beforeEach(module('sidecar.health-badge'));
+
+  var response = {
+    ClusterName: 'default',
+    Services: {
+      bocaccio: [{ Status: 0 }, { Status: 2 }, { Status: 2 }],
+      chaucer: [{ Status: 3 }, { Status: 1 }]
+    }
+  };
+
+  describe('healthBadge service', function() {
+    it('should count failing and unknown instances', inject(function(healthBadge) {
+      expect(healthBadge.summarize(response)).toEqual({ failing: 2, unknown: 1 });
+    }));
+
+    it('should put the problems in the title', inject(function(healthBadge) {
+      var summary = healthBadge.summarize(response);
+      expect(healthBadge.titleFor(summary, 'default')).toEqual('(2 failing, 1 unknown) Sidecar - default');
+    }));
+
+    it('should leave the title plain when all is well', inject(function(healthBadge) {
+      expect(healthBadge.titleFor({ failing: 0, unknown: 0 }, 'default')).toEqual('Sidecar - default');
+    }));
+
+    it('should update the document title', inject(function(healthBadge, $document) {
+      healthBadge.update(response);
+      expect($document[0].title).toEqual('(2 failing, 1 unknown) Sidecar - default');
+    }));
+  });
+});
@@ -1,6 +1,6 @@
 'use strict';
 
-angular.module('sidecar.services', ['ngRoute', 'ui.bootstrap'])
+angular.module('sidecar.services', ['ngRoute', 'ui.bootstrap', 'sidecar.health-badge'])
 
 .config(['$routeProvider', function($routeProvider) {
   $routeProvider.when('/services', {
@@ -70,7 +70,7 @@ angular.module('sidecar.services', ['ngRoute', 'ui.bootstrap'])
     return state;
 })
 
-.controller('servicesCtrl', function($scope, $interval, stateService) {
+.controller('servicesCtrl', function($scope, $interval, stateService, healthBadge) {
     $scope.serverList = {};
 	$scope.clusterName = "";
 	$scope.servicesList = {};
@@ -120,6 +120,7 @@ angular.module('sidecar.services', ['ngRoute', 'ui.bootstrap'])
 
 		$scope.clusterName = servicesResponse.ClusterName;
 		$scope.serverList = servicesResponse.ClusterMembers;
+		healthBadge.update(servicesResponse);
 
 		// Haproxy
 		var haproxyResponse = stateService.getHaproxy();
@@ -201,6 +202,8 @@ angular.module('sidecar.services', ['ngRoute', 'ui.bootstrap'])
 	        return "Unknown"
 	    case 4:
 	        return "Draining"
+	    case 5:
+	        return "Maintenance"
 	    default:
 	        return "Tombstone"
 	    }